@@ -1,14 +1,17 @@
 package antidote
 
 import (
-	"encoding/base64"
 	"errors"
 	"fmt"
-	"log"
+	"html"
+	"io"
+	"net"
+	"net/http"
 	"net/url"
-	"regexp"
+	"path"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 )
@@ -16,27 +19,293 @@ import (
 // Ingredients object represents options for Antidote.
 type Ingredients struct {
 	URL string
+
+	// Source is the HTML of the page to cure instead of fetching it from URL, e.g. the DOM of a page as rendered
+	// by a browser, which holds the content added by its scripts and that of pages behind a login. URL is still
+	// the base its relative references are resolved against.
+	Source string
+
+	// Renderer renders the page at URL into the HTML that is cured instead of its response, e.g. in a headless
+	// browser, so that the content added by its scripts is kept. It is not used when Source is set.
+	Renderer Renderer
+
+	// Profile is a named preset of ingredients for a common configuration, e.g. ProfileStatic, applied on top of
+	// the other fields: the ingredients it turns on are set whatever their value.
+	Profile Profile
+
+	// StripJS removes every <script> element from the website instead of inlining external scripts.
+	StripJS bool
+
+	// StripEventHandlers removes the event handler attributes of every element, e.g. onclick or onload, which
+	// run scripts and would break or contact the original site once the scripts are stripped.
+	StripEventHandlers bool
+
+	// UnwrapNoscript replaces every <noscript> element with its content before the cure, as browsers render it
+	// when scripts are disabled, so that the stylesheets and images of the fallbacks are inlined as well.
+	UnwrapNoscript bool
+
+	// StripIframes removes every <iframe> element, e.g. embedded videos and ads, which email clients do not
+	// render.
+	StripIframes bool
+
+	// InlineStyles moves the rules of the stylesheets into the style attributes of the elements they match, as
+	// most email clients ignore <style> elements, see InlineStyles(). The rules that can not be inlined, e.g.
+	// those of @media or of a:hover, are kept in a <style> element.
+	InlineStyles bool
+
+	// DropStyles removes every <style> element once InlineStyles has inlined their rules, with those that could
+	// not be, for consumers that strip <style> elements anyway.
+	DropStyles bool
+
+	// ImageAttachments references the images of <img> elements as the attachments of an email, with cid: URL's,
+	// instead of inlining them as data URL's, which most email clients do not render. The images are stored in
+	// Snapshot.Files, see Snapshot.WriteEmail().
+	ImageAttachments bool
+
+	// CriticalCSS drops the rules of every stylesheet whose selectors match no element of the document, which
+	// shrinks the output of pages pulling in full CSS frameworks. Rules of state dependent selectors such as
+	// ":hover" are kept if they could apply to an element, as are at-rules like @font-face and @keyframes.
+	CriticalCSS bool
+
+	// Selector is a CSS selector, e.g. "#main-article", that only keeps the matching elements of the body, with
+	// their ancestors, to make an embeddable snippet of a page instead of a whole copy. The <head> is kept, and
+	// the rules of the stylesheets that match none of the remaining elements are dropped as with CriticalCSS.
+	// Curing fails if no element matches.
+	Selector string
+
+	// Inject is HTML inserted into the cured document, e.g. print styles, dark mode overrides, scripts or
+	// <meta> tags, see InjectCSS(), InjectJS() and InjectMeta(). It is inserted as is, once the page is cured.
+	Inject []Injection
+
+	// Media emulates a media type or a color scheme, e.g. a dark mode or a print variant of the page, by
+	// evaluating the media queries of its stylesheets accordingly.
+	Media MediaEmulation
+
+	// Prune are rules removing the scripts and stylesheets that can not affect a static snapshot, e.g.
+	// DefaultPruneRules. The first matching rule is used, and the removals are listed in Snapshot.Pruned.
+	Prune []PruneRule
+
+	// StructuredData rewrites the URL's of the JSON-LD and microdata of the page, e.g. the URL's of its logo and
+	// images, which would otherwise be left relative or remote. Empty leaves them as they are.
+	StructuredData StructuredData
+
+	// Quarantine neutralizes what would contact the original site once the cured page is opened: forms submit to
+	// the page itself, the ping of links and the <link rel="preconnect"> (and prefetch) hints are removed, as are
+	// refreshes, and a Content-Security-Policy blocks the requests of scripts, e.g. beacons and analytics, and the
+	// referrer is not sent. Combine it with Prune to also remove the trackers themselves.
+	Quarantine bool
+
+	// CustomProperties inlines the resources referenced by the custom properties declared in the style attributes
+	// of elements, e.g. <section style="--hero: url(/img/hero.jpg)">, which stylesheets consume with
+	// var(--hero) and would otherwise miss. Those declared in stylesheets are always inlined.
+	CustomProperties bool
+
+	// SkipImages leaves the src of <img> elements untouched instead of converting them to data URL's.
+	SkipImages bool
+
+	// Concurrency is the maximum number of assets fetched at the same time. Defaults to 16.
+	Concurrency int
+
+	// Timeout is the time limit of each HTTP request made while curing. Zero means no timeout.
+	Timeout time.Duration
+
+	// ExtractAssets stores assets as separate files named by the SHA-256 hash of their content instead of
+	// inlining them into the HTML. The files are available through Snapshot.Files, and are written along
+	// with an integrity manifest by Snapshot.WriteDir() and Snapshot.WriteZip().
+	ExtractAssets bool
+
+	// InlineLimit is the size in bytes above which assets are left as references to their absolute URL
+	// instead of being inlined (or extracted), balancing self-containment against the size of the output.
+	// Zero inlines every asset.
+	InlineLimit int64
+
+	// CrossOrigin is the crossorigin attribute set on the <link>, <script> and <img> elements left
+	// referencing assets larger than InlineLimit, e.g. "anonymous". Empty leaves it unset.
+	CrossOrigin string
+
+	// MaxAssetSize is the maximum size in bytes of the body of every response, the page included. Larger
+	// bodies fail with a TruncatedError as soon as the limit is reached. Zero means no limit.
+	MaxAssetSize int64
+
+	// ResumeAttempts is the maximum number of times the download of a response cut short, e.g. a large image or
+	// font, is resumed with a Range request from where it stopped instead of failing with a TruncatedError.
+	// Downloads are only resumed if the response has a Content-Length and a validator, so that the rest of the
+	// same version of the body is requested. Zero never resumes downloads.
+	ResumeAttempts int
+
+	// RateLimitRetries is the maximum number of times a request answered with 429 Too Many Requests or 503
+	// Service Unavailable is retried, after the delay of its Retry-After header (up to a minute), or an
+	// exponential backoff from one second without one. Every such response also halves the number of requests
+	// made to its host at the same time for the rest of the cure, and pauses them for the delay, so that a
+	// throttling origin does not fail dozens of assets at once. Zero never retries.
+	RateLimitRetries int
+
+	// Deadline and MaxTotalSize are the time limit of the cure, from its start, and the maximum total size in
+	// bytes of the assets it fetches. Once either is exceeded, the assets not fetched yet are left as references
+	// to their URL (see Asset.Remote) instead of failing the cure. Assets are fetched in the order of the critical
+	// rendering path, stylesheets then fonts, scripts, images and frames, so the most critical ones are inlined.
+	// The limits are checked before every fetch, so the fetches in progress may exceed them. Zero means no limit.
+	Deadline     time.Duration
+	MaxTotalSize int64
+
+	// Degradation is how the cure degrades once Deadline or MaxTotalSize is exceeded.
+	Degradation Degradation
+
+	// SpillThreshold is the size in bytes above which assets inlined as data URL's are streamed to temporary
+	// files instead of being held in memory. See Snapshot.WriteHTML(). Zero disables spilling.
+	SpillThreshold int64
+
+	// SpillDir is the directory temporary files are created in. Defaults to os.TempDir().
+	SpillDir string
+
+	// Output is how the cured HTML is serialized, e.g. pretty-printed for human-readable archives or minified
+	// for size. The zero value serializes it the same way as goquery.
+	Output OutputFormat
+
+	// ConditionalComments is how the conditional comments of Internet Explorer are handled, e.g. the stylesheets
+	// they serve to older versions of it. Empty means ConditionalCommentsKeep.
+	ConditionalComments ConditionalComments
+
+	// PreserveProlog keeps everything up to and including the <html> start tag exactly as it was in the
+	// source: the doctype, the attributes of <html> and the conditional comments around it. This is a
+	// compatibility mode for legacy pages, as parsing normalizes them, e.g. the case of the doctype, or the
+	// <html> start tags of the conditional comments commonly used to target versions of Internet Explorer.
+	PreserveProlog bool
+
+	// Cache stores the bytes of every fetched asset when set, which allows Antidote.Recure() to reuse them.
+	Cache Cache
+
+	// TransformCache stores the cured stylesheets, keyed by the hash of their CSS and of the ingredients they
+	// were cured with, so the stylesheets shared by many pages (e.g. those of a CSS framework) are only cured
+	// once, and the resources they reference only fetched once, when the same cache is used by every cure of a
+	// batch. The assets of a reused stylesheet are recorded as Asset.Reused. Inline stylesheets, and those of
+	// cures with CriticalCSS, Selector, ExtractAssets, Deadline or MaxTotalSize, depend on their page and are
+	// never cached.
+	TransformCache Cache
+
+	// Canonicalizer rewrites the URL of the snapshot (see Snapshot.URL), and the URL's the prior assets of
+	// Antidote.Recure() are matched by, into their canonical form, e.g. DefaultCanonicalizer, so that the same
+	// page is indexed and cached under a single URL however it was linked. The URL's fetched are left as they
+	// are. Nil keeps every URL as it is.
+	Canonicalizer *Canonicalizer
+
+	// Hosts are rules that only apply to assets served from matching hosts. The first matching rule is used.
+	Hosts []HostRule
+
+	// UpgradeInsecure fetches every asset referenced with an http URL over https instead, which avoids
+	// mixed content in pages served over https. Relative and protocol-relative URL's always inherit the
+	// scheme of the page.
+	UpgradeInsecure bool
+
+	// RepairMixedContent fetches the assets of a page served over https that are referenced with an http URL
+	// over https instead, falling back to http for those only reachable over plaintext, which are marked
+	// Asset.Insecure (see Snapshot.MixedContent()) for security reviews of legacy pages. Unlike with
+	// UpgradeInsecure, pages served over http are left as they are.
+	RepairMixedContent bool
+
+	// Integrity is how the scripts and stylesheets with an integrity attribute are checked against its hashes
+	// before being inlined. Empty means IntegrityIgnore.
+	Integrity IntegrityPolicy
+
+	// Scheduler caps the fetches of every cure sharing it, e.g. those of the jobs of the daemon, so that a cure
+	// fetching thousands of assets can not starve the others. Nil only limits the fetches with Concurrency.
+	Scheduler *FetchScheduler
+
+	// Transport is used to make every HTTP request of the cure. Defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// Jar holds the cookies sent with every HTTP request of the cure, e.g. those of the session of a browser to
+	// cure a page behind a login, and those set by the responses. Nil sends no cookies.
+	Jar http.CookieJar
+
+	// HostRewrites maps hostnames to the address connections are made to instead, e.g. mapping
+	// "www.example.com" to "10.0.0.5:8443" cures a staging server as if it was production. A key with a
+	// port only applies to that port, and a value without a port keeps the port of the URL. Requests keep
+	// their original Host header and TLS server name. Requires Transport to be an *http.Transport.
+	HostRewrites map[string]string
+
+	// Resolver looks up the hostnames connections are made to instead of the system resolver. Requires
+	// Transport to be an *http.Transport.
+	Resolver *net.Resolver
+
+	// OnAsset is called every time an asset has been cured (or failed to be cured), which allows
+	// callers to report progress. It may be called concurrently from multiple goroutines.
+	OnAsset func(asset *Asset)
+
+	// Preflight probes the assets of the page with HEAD requests in parallel before fetching any of them, so the
+	// smallest ones are fetched first, and the total number and size of the assets are known up front, see
+	// OnPreflight. The resources referenced by stylesheets are discovered while curing them, so they are not
+	// included.
+	Preflight bool
+
+	// OnPreflight is called with the assets probed by Preflight, before any of them is fetched, which allows
+	// callers to report progress against accurate totals.
+	OnPreflight func(assets []*PreflightAsset)
+
+	// Prefetch starts fetching the stylesheets, scripts and images of the page as soon as their elements are
+	// read while the page is downloading, instead of once it is downloaded and parsed, which shortens the cure
+	// of large pages. The resources the page preloads with Link headers (rel=preload), e.g. the fonts of its
+	// stylesheets, are fetched as soon as its response starts. It is ignored along with Source, Renderer,
+	// Selector, Preflight, Deadline, MaxTotalSize and by Antidote.Recure(), which decide which assets are fetched
+	// and how once the page is parsed.
+	Prefetch bool
+
+	// ScanAsset is called with the URL, Content-Type and body of every asset before it is embedded, e.g. to
+	// check it with ClamAV or a custom detector. Assets for which it returns an error fail with a ScanError
+	// instead of being embedded. It may be called concurrently from multiple goroutines.
+	ScanAsset func(url string, contentType string, body io.Reader) error
+
+	// Logger receives the messages logged during the cure. Defaults to the standard logger at LogWarn, which
+	// only logs the assets that could not be cured.
+	Logger *Logger
 }
 
 // Antidote object provides the APi operation methods for curing a site.
 type Antidote struct {
-	ingredients *Ingredients
-	parsedUrl   *url.URL
-	website     *goquery.Document
-	curedHtml   string
+	ingredients   *Ingredients
+	defaults      *defaults
+	parsedUrl     *url.URL
+	website       *goquery.Document
+	curedHtml     string
+	snapshot      *Snapshot
+	client        *http.Client
+	hostLimits    map[*HostRule]chan struct{}
+	matcher       *selectorMatcher
+	deferred      []func(p *pipeline)
+	frameChain    map[string]bool
+	prior         map[string]*Asset
+	fetchedSize   int64
+	probed        map[string]int64
+	hostStats     map[string]*HostStats
+	throttles     map[string]*throttle
+	fetchJob      *fetchJob
+	prefetched    map[string]*prefetch
+	prefetchSlots chan struct{}
+	prefetching   sync.WaitGroup
+	mu            sync.Mutex
 }
 
-// New creates a new instance of an Antidote pointer.
-func New() *Antidote {
-	return new(Antidote)
+// New creates a new instance of an Antidote pointer, whose cures default to the options given, see Option.
+func New(options ...Option) *Antidote {
+	a := new(Antidote)
+	if len(options) > 0 {
+		a.defaults = new(defaults)
+		for _, option := range options {
+			option(a)
+		}
+	}
+
+	return a
 }
 
-// Mix sets the options of Antidote.
+// Mix sets the options of Antidote. With the options of New(), a copy of the ingredients is used, with their
+// unset fields defaulting to the options.
 func (a *Antidote) Mix(ingredients *Ingredients) {
-	a.ingredients = ingredients
+	a.ingredients = a.withDefaults(ingredients).withProfile()
 }
 
-// Html retrieves the cured HTML (it will be empty if called before Antidote.Cure() has been called).
+// Html retrieves the cured HTML (it will be empty if called before Antidote.Cure() has been called). After
+// Antidote.CureToSnapshot() the data of assets spilled to disk are placeholders, see Snapshot.WriteHTML().
 func (a *Antidote) Html() string {
 	return a.curedHtml
 }
@@ -44,217 +313,525 @@ func (a *Antidote) Html() string {
 // Cure will begin running the algorithms to cure a websites source of any CORS
 // restrictions enforced by browsers.
 func (a *Antidote) Cure() (string, error) {
-	var err error
+	snapshot, err := a.CureToSnapshot()
+	if err != nil {
+		return "", err
+	}
+
+	if err := snapshot.Expand(); err != nil {
+		return "", err
+	}
+	a.curedHtml = snapshot.HTML
 
+	return snapshot.HTML, nil
+}
+
+// CureToSnapshot cures a website the same way as Antidote.Cure(), but returns a structured Snapshot
+// containing the cured HTML along with metadata about every asset and the timing of the cure.
+func (a *Antidote) CureToSnapshot() (*Snapshot, error) {
 	if a.ingredients == nil {
-		return "", errors.New("Antidote.Mix() must be called before Antidote.Cure().")
+		return nil, errors.New("Antidote.Mix() must be called before Antidote.Cure().")
+	}
+
+	if err := a.ingredients.Profile.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := a.ingredients.Output.validate(); err != nil {
+		return nil, err
 	}
 
-	a.parsedUrl, err = url.Parse(a.ingredients.URL)
+	selector, err := a.ingredients.compileSelector()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	if err := a.ingredients.Media.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := a.ingredients.Integrity.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := a.ingredients.Canonicalizer.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := a.ingredients.StructuredData.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := a.ingredients.ConditionalComments.validate(); err != nil {
+		return nil, err
+	}
+
+	for _, injection := range a.ingredients.Inject {
+		if err := injection.validate(); err != nil {
+			return nil, err
+		}
 	}
 
-	a.website, err = goquery.NewDocument(a.ingredients.URL)
+	a.fetchedSize = 0
+	a.probed = make(map[string]int64)
+	a.hostStats = make(map[string]*HostStats)
+	a.throttles = make(map[string]*throttle)
+	a.snapshot = &Snapshot{
+		FormatHeader: a.ingredients.header(),
+		URL:          a.ingredients.URL,
+		Assets:       []*Asset{},
+		Files:        make(map[string][]byte),
+		StartedAt:    time.Now(),
+		spills:       make(map[string]*spillFile),
+		sources:      make(map[string]string),
+	}
+
+	a.parsedUrl, err = url.Parse(strings.TrimSpace(a.ingredients.URL))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	a.cureAssets()
+	if err := encodeURL(a.parsedUrl); err != nil {
+		return nil, err
+	}
 
-	a.curedHtml, err = a.website.Html()
+	transport, err := a.transport()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	a.client = &http.Client{Timeout: a.ingredients.Timeout, Transport: transport, Jar: a.ingredients.Jar}
+
+	a.hostLimits = make(map[*HostRule]chan struct{})
+
+	a.log(LogInfo, "curing", "url", a.parsedUrl)
+
+	defer a.stopPrefetching()
+
+	page, err := a.page()
+	if err != nil {
+		return nil, err
+	}
+
+	body := page.body
+	var xmlDeclaration string
+	if a.snapshot.XHTML = page.isXHTML(); a.snapshot.XHTML {
+		xmlDeclaration, body = prepareXHTML(body)
+	}
+
+	a.website, err = goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	a.snapshot.Metadata = a.extractMetadata(page.url)
+	a.snapshot.URL = a.canonicalURL()
+
+	if err := a.resolveBase(page); err != nil {
+		return nil, err
+	}
+	a.revealConditionalComments()
+
+	if selector != nil {
+		if err := a.selectFragment(selector); err != nil {
+			return nil, err
+		}
+	}
+
+	a.cureAssets()
+	a.inlineStyles()
+	a.stripEventHandlers()
+	a.quarantine()
+	a.hideConditionalComments()
+
+	if err := a.inject(); err != nil {
+		return nil, err
+	}
+
+	a.curedHtml = xmlDeclaration + serialize(a.website.Nodes[0], a.ingredients.Output, a.snapshot.XHTML)
+	if a.ingredients.PreserveProlog {
+		a.curedHtml = restoreProlog(a.curedHtml, page.body)
+	}
+
+	a.snapshot.HTML = a.curedHtml
+	a.snapshot.Hosts = a.hostStatsList()
+	a.snapshot.Duration = time.Since(a.snapshot.StartedAt)
+
+	a.log(LogInfo, "cured", "url", a.parsedUrl, "assets", len(a.snapshot.Assets), "errors", len(a.snapshot.Errors()), "duration", a.snapshot.Duration.Round(time.Millisecond))
+
+	return a.snapshot, nil
+}
+
+// page returns the page to cure: Ingredients.Source if it is set, the page rendered by the Renderer of its host
+// rule or Ingredients.Renderer, or else the response of Ingredients.URL.
+func (a *Antidote) page() (*response, error) {
+	if a.ingredients.Source != "" {
+		return &response{url: a.parsedUrl.String(), body: a.ingredients.Source, contentType: "text/html"}, nil
+	}
+
+	if renderer := a.renderer(); renderer != nil {
+		body, err := renderer.Render(a.parsedUrl.String())
+		if err != nil {
+			return nil, fmt.Errorf("rendering %s: %v", a.parsedUrl, err)
+		}
+
+		return &response{url: a.parsedUrl.String(), body: body, contentType: "text/html"}, nil
+	}
+
+	if a.prefetches() {
+		return a.prefetchPage()
+	}
+
+	return a.fetch(a.parsedUrl.String(), nil, false)
+}
+
+// Recure cures a website again after a prior cure produced the given snapshot. Assets whose validators
+// (ETag or Last-Modified) show they have not changed since the prior cure are not downloaded again, their
+// bytes are reused from Ingredients.Cache instead, which must be the cache the prior cure was made with.
+func (a *Antidote) Recure(prior *Snapshot) (*Snapshot, error) {
+	if a.ingredients == nil {
+		return nil, errors.New("Antidote.Mix() must be called before Antidote.Recure().")
+	}
+
+	if a.ingredients.Cache == nil {
+		return nil, errors.New("Ingredients.Cache must be set to use Antidote.Recure().")
 	}
 
-	return a.curedHtml, nil
+	a.prior = make(map[string]*Asset)
+	for _, asset := range prior.Assets {
+		if asset.URL != "" && asset.Hash != "" && asset.Error == "" {
+			a.prior[a.canonicalKey(asset.URL)] = asset
+		}
+	}
+	defer func() { a.prior = nil }()
+
+	return a.CureToSnapshot()
 }
 
-// cureAssets will run all cure methods concurrently and wait for them to be complete.
+// cureAssets will schedule the cure of every asset on a single pipeline and wait for them to be complete.
+// Stylesheets run first, and the resources they reference (imported stylesheets, fonts, background images)
+// are scheduled on the same pipeline as they are discovered, ahead of scripts and images. The document is
+// only read until every asset has been fetched, and written afterwards, as it is not safe for concurrent use.
+// The assets whose pruning depends on the outcome of the cure are dealt with last, see Antidote.prune().
 func (a *Antidote) cureAssets() {
-	var wg sync.WaitGroup
-	wg.Add(3)
+	p := newPipeline()
+
+	a.unwrapNoscript()
+	a.stripIframes()
+
+	a.matcher = nil
+	if a.ingredients.CriticalCSS || a.ingredients.Selector != "" {
+		a.matcher = newSelectorMatcher(a.website.Nodes[0])
+	}
 
-	go (func() {
-		defer wg.Done()
-		a.cureCSS()
-	})()
+	a.cureCSS(p)
+	a.cureCustomProperties(p)
+	a.cureJS(p)
+	a.cureImages(p)
+	a.cureStructuredData(p)
+	a.cureFrames(p)
 
-	go (func() {
-		defer wg.Done()
-		a.cureJS()
-	})()
+	workers := a.ingredients.Concurrency
+	if workers <= 0 {
+		workers = defaultConcurrency
+	}
 
-	go (func() {
-		defer wg.Done()
-		a.cureImages()
-	})()
+	if a.ingredients.Preflight {
+		a.preflight(p, workers)
+	}
 
-	wg.Wait()
+	p.run(workers)
+
+	a.cureDeferred(workers)
+	a.degrade()
 }
 
-// cureCSS will fetch the CSS source of all <link> elements concurrently and wait for them to be complete.
-// Then it will append a <style> node in the <head> with the raw CSS as the content, and remove the
-// pre-existing <link> referencing the external so the browser doesn't throw any errors.
-func (a *Antidote) cureCSS() {
-	links := a.website.Find("link")
+// cureCSS will schedule fetching the CSS source of all <link> elements. Then it will append a <style> node
+// in the <head> with the cured CSS as the content, and remove the pre-existing <link> referencing the
+// external CSS so the browser doesn't throw any errors. The resources referenced by the CSS of <link> and
+// <style> elements are inlined as well.
+func (a *Antidote) cureCSS(p *pipeline) {
+	a.website.Find("link").Each(func(index int, link *goquery.Selection) {
+		href, ok := link.Attr("href")
+		if !ok {
+			return
+		}
+
+		matchedExtension, err := hasExtension(href, ".css")
+		if err != nil {
+			a.warn(err)
+			return
+		}
+		if matchedExtension == "" {
+			return
+		}
 
-	var wg sync.WaitGroup
-	wg.Add(links.Length())
+		if !a.emulateElementMedia(link) {
+			return
+		}
 
-	links.Each(func(index int, link *goquery.Selection) {
-		go (func() {
-			defer wg.Done()
+		if a.prune(link, AssetCSS, href, "", func(p *pipeline) { a.cureLink(p, link, href) }) {
+			return
+		}
 
-			if href, ok := link.Attr("href"); ok {
-				matchedExtension, err := hasExtension(href, ".css")
-				if err != nil {
-					log.Println(err)
-					return
-				}
+		a.cureLink(p, link, href)
+	})
 
-				if matchedExtension != "" {
-					normalizedHref, err := normalizeSourceUrl(href, a.parsedUrl)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-
-					source, err := fetch(normalizedHref)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-
-					link.AfterHtml(fmt.Sprintf(`<style>%s</style>`, source))
-					link.Remove()
-				}
-			}
-		})()
+	a.website.Find("style").Each(func(index int, style *goquery.Selection) {
+		if !a.emulateElementMedia(style) {
+			return
+		}
+
+		css := style.Text()
+
+		p.schedule(priorityStylesheet, func() {
+			a.cureStylesheet(p, css, nil, nil, assetsDir, func(cured string) {
+				p.mutate(func() {
+					style.SetText(cured)
+				})
+			})
+		})
 	})
+}
+
+// cureLink will schedule fetching the CSS source of a <link> element, and replacing the element with a
+// <style> node holding the cured CSS.
+func (a *Antidote) cureLink(p *pipeline, link *goquery.Selection, href string) {
+	integrity, _ := link.Attr("integrity")
+
+	p.scheduleFetch(priorityStylesheet, AssetCSS, href, func() {
+		resp, err := a.fetchAssetResponse(AssetCSS, nil, href, false, integrity)
+		var integrityErr *IntegrityError
+		if errors.As(err, &integrityErr) {
+			a.warn(err)
+			a.keepRemote(p, link, "href", href)
+			return
+		}
+		if err != nil {
+			a.warn(err)
+			return
+		}
+		if resp.remote {
+			a.keepRemote(p, link, "href", href)
+			return
+		}
+
+		// The stylesheet has been fetched, so its URL is known to normalize.
+		normalizedHref, _ := a.assetURL(nil, href)
+
+		a.cureStylesheet(p, resp.body, resp.baseURL(), map[string]bool{normalizedHref: true}, "", func(cured string) {
+			if a.ingredients.ExtractAssets {
+				// The cured stylesheet no longer matches the integrity attribute of the original.
+				name := a.extract(cured, ".css", resp.url)
+				p.mutate(func() {
+					link.SetAttr("href", assetsDir+name)
+					link.RemoveAttr("integrity")
+				})
+				return
+			}
+
+			// The media the stylesheet applies to is kept, e.g. for print styles.
+			attributes := ""
+			if media, ok := link.Attr("media"); ok {
+				attributes = fmt.Sprintf(` media="%s"`, html.EscapeString(media))
+			}
 
-	wg.Wait()
+			p.mutate(func() {
+				link.AfterHtml(fmt.Sprintf(`<style%s>%s</style>`, attributes, cured))
+				link.Remove()
+			})
+		})
+	})
 }
 
-// cureJS will fetch the JS source of all <script> elements concurrently and wait for them to be complete.
-// Then it will append a <script> node in the <head> with the raw JS as the content, and remove the
-// pre-existing <script> referencing the external JS so the browser doesn't throw any errors.
-func (a *Antidote) cureJS() {
+// cureJS will schedule fetching the JS source of all <script> elements. Then it will inline the raw JS into
+// every <script> in place of the reference to the external JS, see Antidote.cureScript(). If
+// Ingredients.StripJS is set, every <script> is removed instead, and the images of <noscript> fallbacks
+// promoted in their place, see Antidote.promoteNoscript(). The scripts stripped by a HostRule and the
+// ones pruned by Ingredients.Prune are removed as well.
+//
+// Although the scripts are fetched concurrently, the cured scripts run in the exact same order as the
+// original ones, at the same position relative to the content of the document: every <script> is cured in
+// place, keeping its attributes (e.g. type="module" or nomodule), and the async and deferred ones stay so, see
+// Antidote.cureScript(). antidotetest.CheckScriptOrder() checks this guarantee against a fixture.
+func (a *Antidote) cureJS(p *pipeline) {
 	scripts := a.website.Find("script")
 
-	var wg sync.WaitGroup
-	wg.Add(scripts.Length())
+	if a.ingredients.StripJS {
+		scripts.Remove()
+		a.promoteNoscript(true)
+		return
+	}
+
+	scripts = scripts.FilterFunction(func(index int, script *goquery.Selection) bool {
+		if a.stripsScript(script) {
+			script.Remove()
+			return false
+		}
+
+		src, ok := script.Attr("src")
+		if !ok {
+			return !a.prune(script, AssetJS, "", script.Text(), nil)
+		}
+
+		return !a.prune(script, AssetJS, src, "", func(p *pipeline) { a.cureScript(p, script, src) })
+	})
 
 	scripts.Each(func(index int, script *goquery.Selection) {
-		go (func() {
-			defer wg.Done()
-
-			if src, ok := script.Attr("src"); ok {
-				matchedExtension, err := hasExtension(src, ".js")
-				if err != nil {
-					log.Println(err)
-					return
-				}
+		if src, ok := script.Attr("src"); ok {
+			a.cureScript(p, script, src)
+		}
+	})
+}
 
-				if matchedExtension != "" {
-					normalizedSrc, err := normalizeSourceUrl(src, a.parsedUrl)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-
-					source, err := fetch(normalizedSrc)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-
-					script.AfterHtml(fmt.Sprintf(`<script>%s</script>`, source))
-					script.Remove()
-				}
-			}
-		})()
+// cureScript will schedule fetching the JS source of a <script> element with the .js extension, and
+// inlining the raw JS into the element in place of its src, keeping its other attributes. The async and
+// deferred classic scripts keep their src as a data URL instead, so that they still run once fetched or once
+// the document is parsed, as inline classic scripts run as soon as they are parsed.
+func (a *Antidote) cureScript(p *pipeline, script *goquery.Selection, src string) {
+	matchedExtension, err := hasExtension(src, ".js")
+	if err != nil {
+		a.warn(err)
+		return
+	}
+	if matchedExtension == "" {
+		return
+	}
+
+	integrity, _ := script.Attr("integrity")
+
+	p.scheduleFetch(priorityScript, AssetJS, src, func() {
+		resp, err := a.fetchAssetResponse(AssetJS, nil, src, false, integrity)
+		var integrityErr *IntegrityError
+		if errors.As(err, &integrityErr) {
+			a.warn(err)
+			a.keepRemote(p, script, "src", src)
+			return
+		}
+		if err != nil {
+			a.warn(err)
+			return
+		}
+		if resp.remote {
+			a.keepRemote(p, script, "src", src)
+			return
+		}
+
+		if a.ingredients.ExtractAssets {
+			name := a.extract(resp.body, ".js", resp.url)
+			p.mutate(func() {
+				script.SetAttr("src", assetsDir+name)
+			})
+			return
+		}
+
+		// Inline classic scripts run as soon as they are parsed, so the async and deferred ones keep their src
+		// as a data URL instead.
+		if !isModuleScript(script) && !isParserBlocking(script) {
+			dataURL := resp.dataURL("text/javascript")
+			p.mutate(func() {
+				script.SetAttr("src", dataURL)
+			})
+			return
+		}
+
+		p.mutate(func() {
+			script.RemoveAttr("src")
+			script.RemoveAttr("integrity")
+			script.SetText(resp.body)
+		})
 	})
+}
 
-	wg.Wait()
+// isModuleScript reports whether a <script> is a JavaScript module, which is deferred whether it is inline or
+// not, unless it is async.
+func isModuleScript(script *goquery.Selection) bool {
+	scriptType, _ := script.Attr("type")
+	return strings.EqualFold(strings.TrimSpace(scriptType), "module")
 }
 
-var isImageExtension map[string]bool = map[string]bool{
-	"JPEG": true,
-	"jpeg": true,
-	"JPG":  true,
-	"jpg":  true,
-	"GIF":  true,
-	"gif":  true,
-	"PNG":  true,
-	"png":  true,
-	"BMP":  true,
-	"bmp":  true,
-	"TIFF": true,
-	"tiff": true,
+// isParserBlocking reports whether a classic <script> with a src runs as soon as it is parsed, blocking the
+// parsing of the rest of the document, rather than once it is fetched (async) or once the document is parsed
+// (defer).
+func isParserBlocking(script *goquery.Selection) bool {
+	_, deferred := script.Attr("defer")
+	_, async := script.Attr("async")
+	return !deferred && !async
 }
 
-// cureImages will fetch the image of all <img> elements concurrently and wait for them to be complete.
-// Then it will convert the image into a base64 data URL and replace the src value with the data URL.
-func (a *Antidote) cureImages() {
-	images := a.website.Find("img")
+// cureImages will schedule fetching the image of all <img> elements. Then it will convert the image into
+// a base64 data URL and replace the src value with the data URL. Images are recognized by the extension of
+// their URL, or by the type they are served with if it has none.
+func (a *Antidote) cureImages(p *pipeline) {
+	if a.ingredients.SkipImages {
+		return
+	}
 
-	var wg sync.WaitGroup
-	wg.Add(images.Length())
+	a.website.Find("img").Each(func(index int, img *goquery.Selection) {
+		src, ok := img.Attr("src")
+		if !ok {
+			return
+		}
 
-	images.Each(func(index int, img *goquery.Selection) {
-		go (func() {
-			defer wg.Done()
+		mimeType, ok := imageType(src)
+		if !ok {
+			return
+		}
 
-			if src, ok := img.Attr("src"); ok {
-				imgExtensions := make([]string, len(isImageExtension), 0)
-				for k, _ := range isImageExtension {
-					imgExtensions = append(imgExtensions, "."+k)
-				}
+		p.scheduleFetch(priorityImage, AssetImage, src, func() {
+			resp, err := a.fetchAssetResponse(AssetImage, nil, src, a.ingredients.spillsImages(), "")
+			if err != nil {
+				a.warn(err)
+				return
+			}
+			if resp.overBudget && a.dropsImage(resp.url) {
+				a.log(LogDebug, "dropped image", "url", resp.url)
+				p.mutate(func() {
+					img.Remove()
+				})
+				return
+			}
+			if resp.remote {
+				a.keepRemote(p, img, "src", src)
+				return
+			}
 
-				matchedExtension, err := hasExtension(src, imgExtensions...)
-				if err != nil {
-					log.Println(err)
-					return
-				}
+			// The type the image is served with wins over the one of its extension.
+			if mimeType = a.servedImageType(resp, mimeType); mimeType == "" {
+				return
+			}
 
-				if matchedExtension != "" {
-					normalizedSrc, err := normalizeSourceUrl(src, a.parsedUrl)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-
-					source, err := fetch(normalizedSrc)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-
-					img.SetAttr(
-						"src",
-						fmt.Sprintf(
-							"data:image/%s;base64,%s",
-							strings.ToLower(matchedExtension),
-							base64.StdEncoding.EncodeToString([]byte(source)),
-						),
-					)
+			if a.ingredients.ExtractAssets || a.ingredients.ImageAttachments {
+				name := a.extract(resp.body, assetExtension(src, imageExtension(mimeType)), resp.url)
+				ref := assetsDir + name
+				if a.ingredients.ImageAttachments {
+					ref = attachmentScheme + name
 				}
+				p.mutate(func() {
+					img.SetAttr("src", ref)
+				})
+				return
 			}
-		})()
-	})
 
-	wg.Wait()
+			dataURL := resp.dataURL(mimeType)
+			p.mutate(func() {
+				img.SetAttr("src", dataURL)
+			})
+		})
+	})
 }
 
-// hasExtension matches an extension to a URL. If there is a match, the extension is returned.
+// hasExtension matches the extension of the path of a URL to a list of extensions, case-insensitively. The
+// query string and fragment of the URL are ignored, e.g. "app.css?v=2#hash" has the ".css" extension. If
+// there is a match, the extension of the list is returned.
 func hasExtension(src string, extensions ...string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(src))
+	if err != nil {
+		return "", err
+	}
+
+	ext := path.Ext(u.Path)
 	for _, extension := range extensions {
-		found, err := regexp.MatchString(extension, src)
-		if err != nil {
-			return "", err
-		}
-		if found {
+		if strings.EqualFold(ext, extension) {
 			return extension, nil
 		}
 	}
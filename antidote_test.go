@@ -0,0 +1,43 @@
+package antidote
+
+import "testing"
+
+func TestHasExtension(t *testing.T) {
+	tests := []struct {
+		src        string
+		extensions []string
+		want       string
+	}{
+		{"app.css", []string{".css"}, ".css"},
+		{"https://cdn.example.com/app.css?v=2#hash", []string{".css"}, ".css"},
+		{"app.css?v=2#hash", []string{".css"}, ".css"},
+		{"/static/APP.CSS", []string{".css"}, ".css"},
+		{" app.css ", []string{".css"}, ".css"},
+		{"app.js", []string{".css", ".js"}, ".js"},
+
+		// The extension must end the path, not merely appear in it.
+		{"gocssx.html", []string{".css"}, ""},
+		{"/css/index.html", []string{".css"}, ""},
+		{"app.css.map", []string{".css"}, ""},
+		{"app.cssx", []string{".css"}, ""},
+		{"style?file=app.css", []string{".css"}, ""},
+		{"page#app.css", []string{".css"}, ""},
+		{"https://www.example.com/", []string{".css"}, ""},
+		{"", []string{".css"}, ""},
+	}
+
+	for _, test := range tests {
+		got, err := hasExtension(test.src, test.extensions...)
+		if err != nil {
+			t.Errorf("hasExtension(%q, %q) returned an error: %v", test.src, test.extensions, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("hasExtension(%q, %q) = %q, want %q", test.src, test.extensions, got, test.want)
+		}
+	}
+
+	if _, err := hasExtension("http://[::1", ".css"); err == nil {
+		t.Errorf("hasExtension() of an invalid URL returned no error")
+	}
+}
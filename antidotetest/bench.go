@@ -0,0 +1,60 @@
+package antidotetest
+
+import "github.com/lansana/antidote"
+
+// Replay cures the page of a fixture once against a replay server. The URL and Transport of the
+// ingredients are set to the ones of the fixture.
+func Replay(f *Fixture, ingredients antidote.Ingredients) (*antidote.Snapshot, error) {
+	server := NewServer(f)
+	defer server.Close()
+
+	ingredients.URL = f.URL
+	ingredients.Transport = server.Transport()
+
+	a := antidote.New()
+	a.Mix(&ingredients)
+
+	return a.CureToSnapshot()
+}
+
+// B is the part of *testing.B used by BenchmarkCure(). The package does not import testing, so that the programs
+// importing it, e.g. to record or replay fixtures, do not link it.
+type B interface {
+	ReportAllocs()
+	ResetTimer()
+	Fatal(args ...interface{})
+}
+
+// BenchmarkCure cures the page of a fixture n times end-to-end against a replay server, e.g.
+//
+//	func BenchmarkExample(b *testing.B) {
+//		fixture, err := antidotetest.Load("testdata/example.com")
+//		if err != nil {
+//			b.Fatal(err)
+//		}
+//		antidotetest.BenchmarkCure(b, b.N, fixture, antidote.Ingredients{})
+//	}
+//
+// The URL and Transport of the ingredients are set to the ones of the fixture. Pass it to
+// testing.Benchmark() to benchmark outside of go test.
+func BenchmarkCure(b B, n int, f *Fixture, ingredients antidote.Ingredients) {
+	server := NewServer(f)
+	defer server.Close()
+
+	ingredients.URL = f.URL
+	ingredients.Transport = server.Transport()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < n; i++ {
+		a := antidote.New()
+		a.Mix(&ingredients)
+
+		snapshot, err := a.CureToSnapshot()
+		if err != nil {
+			b.Fatal(err)
+		}
+		snapshot.Close()
+	}
+}
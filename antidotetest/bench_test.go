@@ -0,0 +1,48 @@
+package antidotetest_test
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"testing"
+
+	"github.com/lansana/antidote"
+	"github.com/lansana/antidote/antidotetest"
+)
+
+// pageFixture returns a fixture of a page with a stylesheet, a script and an image.
+func pageFixture(b *testing.B) *antidotetest.Fixture {
+	f := antidotetest.NewFixture("https://page.antidote.test/")
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 16, 16))); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, response := range []struct {
+		path        string
+		contentType string
+		body        []byte
+	}{
+		{"", "text/html; charset=utf-8", []byte(`<!DOCTYPE html><html><head>` +
+			`<link rel="stylesheet" href="` + f.URL + `style.css"><script src="` + f.URL + `app.js"></script></head>` +
+			`<body><h1>Page</h1><img src="` + f.URL + `logo.png" alt="Logo"></body></html>`)},
+		{"style.css", "text/css", []byte(`body { background: url(logo.png); } h1 { color: red; }`)},
+		{"app.js", "application/javascript", []byte(`document.title = "Page";`)},
+		{"logo.png", "image/png", img.Bytes()},
+	} {
+		f.Add(&antidotetest.Response{
+			URL:        f.URL + response.path,
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {response.contentType}},
+			Body:       response.body,
+		})
+	}
+
+	return f
+}
+
+func BenchmarkCure(b *testing.B) {
+	antidotetest.BenchmarkCure(b, b.N, pageFixture(b), antidote.Ingredients{})
+}
@@ -0,0 +1,111 @@
+package antidotetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// cassetteResponse is the layout of a response in a cassette, which holds its body inline.
+type cassetteResponse struct {
+	*Response
+	Body []byte `json:"body"`
+}
+
+// cassette is the layout of a cassette file.
+type cassette struct {
+	URL       string              `json:"url"`
+	Responses []*cassetteResponse `json:"responses"`
+}
+
+// SaveCassette writes the fixture to a single cassette file, the bodies of the responses included.
+func (f *Fixture) SaveCassette(name string) error {
+	c := cassette{URL: f.URL}
+	for _, url := range f.urls() {
+		r, _ := f.Response(url)
+		c.Responses = append(c.Responses, &cassetteResponse{Response: r, Body: r.Body})
+	}
+
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(name, b, 0644)
+}
+
+// LoadCassette reads a fixture written by Fixture.SaveCassette() from a cassette file.
+func LoadCassette(name string) (*Fixture, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var c cassette
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %v", name, err)
+	}
+
+	f := NewFixture(c.URL)
+	for _, r := range c.Responses {
+		if r.Response == nil {
+			return nil, fmt.Errorf("invalid cassette %s: empty response", name)
+		}
+
+		r.Response.Body = r.Body
+		f.Add(r.Response)
+	}
+
+	return f, nil
+}
+
+// Player is an http.RoundTripper that answers every request with the response recorded for its URL in a
+// fixture, without any network access, so that cures are offline and deterministic.
+type Player struct {
+	Fixture *Fixture
+}
+
+// NewPlayer returns a player of the responses of the fixture.
+func NewPlayer(f *Fixture) *Player {
+	return &Player{Fixture: f}
+}
+
+// RoundTrip implements http.RoundTripper. Requests of URLs that were not recorded fail.
+func (p *Player) RoundTrip(req *http.Request) (*http.Response, error) {
+	r, ok := p.Fixture.Response(req.URL.String())
+	if !ok {
+		return nil, fmt.Errorf("%s was not recorded", req.URL)
+	}
+
+	header := replayHeader(r.Header)
+	header.Set("Content-Length", strconv.Itoa(len(r.Body)))
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}, nil
+}
+
+// replayHeader returns the headers of a recorded response that are replayed. The body is recorded decoded
+// and whole, so the headers describing its encoding are not.
+func replayHeader(header http.Header) http.Header {
+	replayed := make(http.Header, len(header))
+	for name, values := range header {
+		if name == "Content-Encoding" || name == "Content-Length" || name == "Transfer-Encoding" {
+			continue
+		}
+		replayed[name] = append([]string(nil), values...)
+	}
+
+	return replayed
+}
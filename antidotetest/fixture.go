@@ -0,0 +1,171 @@
+// Package antidotetest provides utilities for testing and benchmarking cures against recorded fixtures
+// instead of the network.
+//
+// A fixture is recorded once from a real website:
+//
+//	fixture, err := antidotetest.Record(antidote.Ingredients{URL: "https://example.com"})
+//	err = fixture.Save("testdata/example.com")
+//
+// and replayed by a local server in tests and benchmarks:
+//
+//	fixture, err := antidotetest.Load("testdata/example.com")
+//	server := antidotetest.NewServer(fixture)
+//	defer server.Close()
+//
+//	a := antidote.New()
+//	a.Mix(&antidote.Ingredients{URL: fixture.URL, Transport: server.Transport()})
+package antidotetest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// fixtureFile is the name of the index of a fixture saved to a directory.
+const fixtureFile = "fixture.json"
+
+// bodiesDir is the directory the bodies of the responses of a fixture are saved in, named by their hash.
+const bodiesDir = "bodies"
+
+// Response object represents a recorded HTTP response.
+type Response struct {
+	URL        string      `json:"url"`
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"-"`
+
+	// BodyHash is the hex encoded SHA-256 hash of the body, which names its file in a saved fixture.
+	BodyHash string `json:"bodyHash"`
+
+	// Method and RequestHeader are those of the request, if recorded.
+	Method        string      `json:"method,omitempty"`
+	RequestHeader http.Header `json:"requestHeader,omitempty"`
+
+	// StartedAt is when the request was made, and Duration how long the response took, if recorded. They are
+	// only saved in HARs, see Fixture.SaveHAR().
+	StartedAt time.Time     `json:"-"`
+	Duration  time.Duration `json:"-"`
+}
+
+// Fixture object represents a page and all of its assets, recorded by URL.
+type Fixture struct {
+	// URL is the URL of the recorded page.
+	URL string `json:"url"`
+
+	// Responses are the recorded responses by URL.
+	Responses map[string]*Response `json:"-"`
+
+	mu sync.RWMutex
+}
+
+// NewFixture returns an empty fixture of the page at url.
+func NewFixture(url string) *Fixture {
+	return &Fixture{URL: url, Responses: make(map[string]*Response)}
+}
+
+// Add records a response, replacing any previous response of the same URL.
+func (f *Fixture) Add(r *Response) {
+	sum := sha256.Sum256(r.Body)
+	r.BodyHash = hex.EncodeToString(sum[:])
+
+	f.mu.Lock()
+	f.Responses[r.URL] = r
+	f.mu.Unlock()
+}
+
+// Response returns the recorded response of a URL.
+func (f *Fixture) Response(url string) (*Response, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	r, ok := f.Responses[url]
+	return r, ok
+}
+
+// urls returns the recorded URLs in order.
+func (f *Fixture) urls() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	urls := make([]string, 0, len(f.Responses))
+	for url := range f.Responses {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	return urls
+}
+
+// fixtureIndex is the layout of the index of a saved fixture.
+type fixtureIndex struct {
+	URL       string      `json:"url"`
+	Responses []*Response `json:"responses"`
+}
+
+// Save writes the fixture to dir: an index of the responses in fixture.json, and their bodies in the
+// bodies directory, named by their hash.
+func (f *Fixture) Save(dir string) error {
+	if err := os.MkdirAll(filepath.Join(dir, bodiesDir), 0755); err != nil {
+		return err
+	}
+
+	index := fixtureIndex{URL: f.URL}
+	for _, url := range f.urls() {
+		r, _ := f.Response(url)
+		index.Responses = append(index.Responses, r)
+
+		if err := ioutil.WriteFile(filepath.Join(dir, bodiesDir, r.BodyHash), r.Body, 0644); err != nil {
+			return err
+		}
+	}
+
+	b, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, fixtureFile), b, 0644)
+}
+
+// Load reads a fixture written by Fixture.Save() from dir.
+func Load(dir string) (*Fixture, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, fixtureFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var index fixtureIndex
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %v", dir, err)
+	}
+
+	f := NewFixture(index.URL)
+	for _, r := range index.Responses {
+		sum, err := hex.DecodeString(r.BodyHash)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid body hash of %s", r.URL)
+		}
+
+		if r.Body, err = ioutil.ReadFile(filepath.Join(dir, bodiesDir, r.BodyHash)); err != nil {
+			return nil, err
+		}
+
+		if actual := sha256.Sum256(r.Body); !bytes.Equal(actual[:], sum) {
+			return nil, fmt.Errorf("body of %s does not match its hash", r.URL)
+		}
+
+		f.Add(r)
+	}
+
+	return f, nil
+}
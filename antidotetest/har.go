@@ -0,0 +1,283 @@
+package antidotetest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// harVersion is the version of the HAR format written by Fixture.SaveHAR().
+const harVersion = "1.2"
+
+// harFile is the layout of a HAR (HTTP Archive) file, as exported by the developer tools of browsers.
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Pages   []harPage   `json:"pages"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harPage struct {
+	StartedDateTime time.Time      `json:"startedDateTime"`
+	ID              string         `json:"id"`
+	Title           string         `json:"title"`
+	PageTimings     map[string]int `json:"pageTimings"`
+}
+
+type harEntry struct {
+	PageRef         string         `json:"pageref,omitempty"`
+	StartedDateTime time.Time      `json:"startedDateTime"`
+	Time            float64        `json:"time"`
+	Request         harRequest     `json:"request"`
+	Response        harResponse    `json:"response"`
+	Cache           struct{}       `json:"cache"`
+	Timings         map[string]int `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// LoadHAR reads a fixture from a HAR file, e.g. exported by the developer tools of a browser, so that a page is
+// cured from the responses the browser received. Only the entries of GET requests with their content are read,
+// the first one of every URL; the URL of the fixture is that of the first page of the HAR, or else of its first
+// entry.
+func LoadHAR(name string) (*Fixture, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var har harFile
+	if err := json.Unmarshal(b, &har); err != nil {
+		return nil, fmt.Errorf("invalid HAR %s: %v", name, err)
+	}
+
+	f := NewFixture("")
+	if len(har.Log.Pages) > 0 {
+		if u, err := url.Parse(har.Log.Pages[0].Title); err == nil && u.IsAbs() {
+			f.URL = u.String()
+		}
+	}
+
+	for _, entry := range har.Log.Entries {
+		if f.URL == "" {
+			f.URL = entry.Request.URL
+		}
+
+		if entry.Request.Method != http.MethodGet || entry.Response.Status == 0 {
+			continue
+		}
+		if _, ok := f.Response(entry.Request.URL); ok {
+			continue
+		}
+
+		// The content of responses may not have been captured, which redirects have none of anyway.
+		content := entry.Response.Content
+		body := []byte(content.Text)
+		if content.Encoding == "base64" {
+			if body, err = base64.StdEncoding.DecodeString(content.Text); err != nil {
+				return nil, fmt.Errorf("invalid HAR %s: content of %s: %v", name, entry.Request.URL, err)
+			}
+		} else if content.Text == "" && content.Size > 0 {
+			continue
+		}
+
+		r := &Response{
+			URL:           entry.Request.URL,
+			StatusCode:    entry.Response.Status,
+			Header:        harHeader(entry.Response.Headers),
+			Body:          body,
+			Method:        entry.Request.Method,
+			RequestHeader: harHeader(entry.Request.Headers),
+			StartedAt:     entry.StartedDateTime,
+			Duration:      time.Duration(entry.Time * float64(time.Millisecond)),
+		}
+		if r.Header.Get("Content-Type") == "" && content.MimeType != "" {
+			r.Header.Set("Content-Type", content.MimeType)
+		}
+		f.Add(r)
+	}
+
+	if f.URL == "" {
+		return nil, fmt.Errorf("invalid HAR %s: no entries", name)
+	}
+
+	return f, nil
+}
+
+// harHeader converts the headers of a HAR entry. The pseudo-headers of HTTP/2, e.g. ":status", are left out.
+func harHeader(headers []harNameValue) http.Header {
+	header := make(http.Header)
+	for _, h := range headers {
+		if !strings.HasPrefix(h.Name, ":") {
+			header.Add(h.Name, h.Value)
+		}
+	}
+
+	return header
+}
+
+// SaveHAR writes the fixture to a HAR file, the bodies of the responses included, to be inspected in the
+// developer tools of a browser or any HAR viewer. The entries are in the order the requests were made, if they
+// were recorded, or else by URL.
+func (f *Fixture) SaveHAR(name string) error {
+	var responses []*Response
+	for _, url := range f.urls() {
+		r, _ := f.Response(url)
+		responses = append(responses, r)
+	}
+	sort.SliceStable(responses, func(i, j int) bool {
+		return responses[i].StartedAt.Before(responses[j].StartedAt)
+	})
+
+	har := harFile{Log: harLog{
+		Version: harVersion,
+		Creator: harCreator{Name: "antidote"},
+		Entries: []*harEntry{},
+	}}
+
+	page := harPage{ID: "page_1", Title: f.URL, PageTimings: map[string]int{}}
+	if len(responses) > 0 {
+		page.StartedDateTime = responses[0].StartedAt
+	}
+	har.Log.Pages = []harPage{page}
+
+	for _, r := range responses {
+		method := r.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+
+		entry := &harEntry{
+			PageRef:         page.ID,
+			StartedDateTime: r.StartedAt,
+			Time:            float64(r.Duration) / float64(time.Millisecond),
+			Request: harRequest{
+				Method:      method,
+				URL:         r.URL,
+				HTTPVersion: "HTTP/1.1",
+				Cookies:     []harNameValue{},
+				Headers:     harHeaders(r.RequestHeader),
+				QueryString: []harNameValue{},
+				HeadersSize: -1,
+			},
+			Response: harResponse{
+				Status:      r.StatusCode,
+				StatusText:  http.StatusText(r.StatusCode),
+				HTTPVersion: "HTTP/1.1",
+				Cookies:     []harNameValue{},
+				Headers:     harHeaders(r.Header),
+				Content:     harBody(r),
+				RedirectURL: r.Header.Get("Location"),
+				HeadersSize: -1,
+				BodySize:    len(r.Body),
+			},
+			Timings: map[string]int{"send": 0, "wait": int(r.Duration / time.Millisecond), "receive": 0},
+		}
+
+		if u, err := url.Parse(r.URL); err == nil {
+			query := u.Query()
+			names := make([]string, 0, len(query))
+			for name := range query {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				for _, value := range query[name] {
+					entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{name, value})
+				}
+			}
+		}
+
+		har.Log.Entries = append(har.Log.Entries, entry)
+	}
+
+	b, err := json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(name, b, 0644)
+}
+
+// harHeaders converts headers into those of a HAR entry, sorted by name.
+func harHeaders(header http.Header) []harNameValue {
+	headers := []harNameValue{}
+	for name, values := range header {
+		for _, value := range values {
+			headers = append(headers, harNameValue{name, value})
+		}
+	}
+	sort.SliceStable(headers, func(i, j int) bool {
+		return headers[i].Name < headers[j].Name
+	})
+
+	return headers
+}
+
+// harBody returns the content of a response in a HAR entry: as text if it is, or else base64 encoded.
+func harBody(r *Response) harContent {
+	content := harContent{Size: len(r.Body), MimeType: r.Header.Get("Content-Type")}
+
+	mediaType, _, _ := mime.ParseMediaType(content.MimeType)
+	textual := strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml") || strings.HasSuffix(mediaType, "javascript")
+	if textual && utf8.Valid(r.Body) {
+		content.Text = string(r.Body)
+	} else if len(r.Body) > 0 {
+		content.Text = base64.StdEncoding.EncodeToString(r.Body)
+		content.Encoding = "base64"
+	}
+
+	return content
+}
@@ -0,0 +1,271 @@
+package antidotetest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lansana/antidote"
+	"golang.org/x/net/html"
+)
+
+// scriptOrderPage is the page of ScriptOrderFixture(), with scripts of every kind between its content.
+const scriptOrderPage = `<!DOCTYPE html>
+<html>
+<head>
+<script src="/js/head.js"></script>
+<script defer src="/js/deferred-1.js"></script>
+<script type="module" src="/js/module.js"></script>
+<script nomodule src="/js/legacy.js"></script>
+<script>window.order.push("inline-head");</script>
+</head>
+<body>
+<div id="first">First</div>
+<script src="/js/body-1.js"></script>
+<p id="second">Second</p>
+<script async src="/js/async.js"></script>
+<script defer src="/js/deferred-2.js"></script>
+<script type="application/ld+json">{"@context": "https://schema.org", "@type": "WebPage"}</script>
+<section id="third"><script src="/js/nested.js"></script></section>
+<script>window.order.push("inline-body");</script>
+<script type="module">window.order.push("inline-module");</script>
+<script src="/js/body-2.js"></script>
+<footer id="fourth">Fourth</footer>
+</body>
+</html>
+`
+
+// ScriptOrderFixture returns a fixture of a page with scripts of every kind between its content: parser
+// blocking, deferred, async, modules, nomodule and inline scripts, and a data block. Every script records its
+// name in window.order when it runs. Cure it with CheckScriptOrder() to check that the cure preserves the order
+// the scripts run in, e.g.
+//
+//	func TestScriptOrder(t *testing.T) {
+//		fixture := antidotetest.ScriptOrderFixture()
+//		snapshot, err := antidotetest.Replay(fixture, antidote.Ingredients{})
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		if err := antidotetest.CheckScriptOrder(fixture, snapshot); err != nil {
+//			t.Error(err)
+//		}
+//	}
+func ScriptOrderFixture() *Fixture {
+	f := NewFixture("https://scripts.antidote.test/")
+	f.Add(&Response{
+		URL:        f.URL,
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		Body:       []byte(scriptOrderPage),
+	})
+
+	for _, name := range []string{"head", "deferred-1", "module", "legacy", "body-1", "async", "deferred-2", "nested", "body-2"} {
+		body := fmt.Sprintf("window.order.push(%q);\n", name)
+		if name == "head" {
+			body = "window.order = [];\n" + body
+		}
+
+		f.Add(&Response{
+			URL:        f.URL + "js/" + name + ".js",
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/javascript"}},
+			Body:       []byte(body),
+		})
+	}
+
+	return f
+}
+
+// orderedScript object represents a <script> of a page, as it affects the order scripts run in.
+type orderedScript struct {
+	// timing is when the script runs: "blocking" when it is parsed, "deferred" once the document is parsed,
+	// "async" as soon as it is fetched, or "data" for the scripts of other types, which do not run. The
+	// nomodule scripts are suffixed with " nomodule".
+	timing string
+
+	// content is the JS of the script, inline or fetched from its src.
+	content []byte
+
+	// position is the number of elements of the body preceding the script in document order, leaving out the
+	// scripts, stylesheets and other elements the cure may replace.
+	position int
+}
+
+// cureReplacedElements are the elements that may be replaced or removed by a cure with the default ingredients,
+// which are not counted in the positions of the scripts.
+var cureReplacedElements = map[string]bool{"script": true, "style": true, "link": true, "noscript": true, "template": true}
+
+// CheckScriptOrder checks that the scripts of the snapshot of a cure of the page of a fixture run in the same
+// order as the scripts of the page itself, which Antidote guarantees: the same scripts, of the same timing
+// (parser blocking, deferred or async), in the same order and at the same positions relative to the content
+// of the document. The snapshot must be cured with the ingredients keeping scripts, i.e. without
+// Ingredients.StripJS and Ingredients.Prune. It returns an error describing the first difference.
+func CheckScriptOrder(f *Fixture, snapshot *antidote.Snapshot) error {
+	page, ok := f.Response(f.URL)
+	if !ok {
+		return fmt.Errorf("no response recorded for the page %s", f.URL)
+	}
+
+	base, err := url.Parse(f.URL)
+	if err != nil {
+		return err
+	}
+
+	original, err := orderedScripts(page.Body, func(src string) ([]byte, error) {
+		return f.scriptContent(base, src)
+	})
+	if err != nil {
+		return fmt.Errorf("page: %v", err)
+	}
+
+	cured, err := orderedScripts([]byte(snapshot.HTML), func(src string) ([]byte, error) {
+		if strings.HasPrefix(src, "data:") {
+			return decodeDataURL(src)
+		}
+		if content, ok := snapshot.Files[src]; ok {
+			return content, nil
+		}
+		return f.scriptContent(base, src)
+	})
+	if err != nil {
+		return fmt.Errorf("snapshot: %v", err)
+	}
+
+	for i := 0; i < len(original) || i < len(cured); i++ {
+		switch {
+		case i >= len(cured):
+			return fmt.Errorf("script %d of the page is missing from the snapshot: %q", i+1, original[i].content)
+		case i >= len(original):
+			return fmt.Errorf("script %d of the snapshot is not in the page: %q", i+1, cured[i].content)
+		case !bytes.Equal(original[i].content, cured[i].content):
+			return fmt.Errorf("script %d is %q, want %q", i+1, cured[i].content, original[i].content)
+		case original[i].timing != cured[i].timing:
+			return fmt.Errorf("script %d (%q) is %s, want %s", i+1, original[i].content, cured[i].timing, original[i].timing)
+		case original[i].position != cured[i].position:
+			return fmt.Errorf("script %d (%q) follows %d elements of the body, want %d", i+1, original[i].content, cured[i].position, original[i].position)
+		}
+	}
+
+	return nil
+}
+
+// scriptContent returns the recorded body of the src of a script, resolved against base.
+func (f *Fixture) scriptContent(base *url.URL, src string) ([]byte, error) {
+	ref, err := url.Parse(strings.TrimSpace(src))
+	if err != nil {
+		return nil, err
+	}
+
+	u := base.ResolveReference(ref).String()
+	r, ok := f.Response(u)
+	if !ok {
+		return nil, fmt.Errorf("no response recorded for the script %s", u)
+	}
+
+	return r.Body, nil
+}
+
+// orderedScripts returns the scripts of a page in document order. The content of the scripts with a src is
+// read by fetch.
+func orderedScripts(page []byte, fetch func(src string) ([]byte, error)) ([]*orderedScript, error) {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+
+	var scripts []*orderedScript
+	position := 0
+	inBody := false
+
+	var walk func(n *html.Node) error
+	walk = func(n *html.Node) error {
+		if n.Type == html.ElementNode {
+			switch {
+			case n.Data == "script":
+				script, err := newOrderedScript(n, fetch)
+				if err != nil {
+					return err
+				}
+				script.position = position
+				scripts = append(scripts, script)
+			case inBody && !cureReplacedElements[n.Data]:
+				position++
+			}
+		}
+
+		if n.Type == html.ElementNode && n.Data == "body" {
+			inBody = true
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := walk(c); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return scripts, walk(doc)
+}
+
+// newOrderedScript returns the script of a <script> element.
+func newOrderedScript(n *html.Node, fetch func(src string) ([]byte, error)) (*orderedScript, error) {
+	attrs := make(map[string]string)
+	for _, attr := range n.Attr {
+		attrs[attr.Key] = attr.Val
+	}
+
+	script := new(orderedScript)
+	if src, ok := attrs["src"]; ok {
+		content, err := fetch(src)
+		if err != nil {
+			return nil, err
+		}
+		script.content = content
+	} else if n.FirstChild != nil {
+		script.content = []byte(n.FirstChild.Data)
+	}
+
+	_, hasSrc := attrs["src"]
+	_, async := attrs["async"]
+	_, deferred := attrs["defer"]
+
+	switch scriptType := strings.ToLower(strings.TrimSpace(attrs["type"])); {
+	case scriptType == "module" && async:
+		script.timing = "async"
+	case scriptType == "module":
+		script.timing = "deferred"
+	case scriptType != "" && !strings.Contains(scriptType, "javascript") && !strings.Contains(scriptType, "ecmascript"):
+		script.timing = "data"
+	case hasSrc && async:
+		script.timing = "async"
+	case hasSrc && deferred:
+		script.timing = "deferred"
+	default:
+		script.timing = "blocking"
+	}
+
+	if _, ok := attrs["nomodule"]; ok {
+		script.timing += " nomodule"
+	}
+
+	return script, nil
+}
+
+// decodeDataURL returns the data of a data URL.
+func decodeDataURL(dataURL string) ([]byte, error) {
+	comma := strings.IndexByte(dataURL, ',')
+	if comma < 0 {
+		return nil, fmt.Errorf("invalid data URL %.32q", dataURL)
+	}
+
+	if strings.HasSuffix(dataURL[:comma], ";base64") {
+		return base64.StdEncoding.DecodeString(dataURL[comma+1:])
+	}
+
+	data, err := url.PathUnescape(dataURL[comma+1:])
+	return []byte(data), err
+}
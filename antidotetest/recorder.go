@@ -0,0 +1,80 @@
+package antidotetest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/lansana/antidote"
+)
+
+// Recorder is an http.RoundTripper that records every response it receives into a fixture.
+type Recorder struct {
+	// Transport makes the actual requests. Defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// Fixture receives the recorded responses.
+	Fixture *Fixture
+}
+
+// NewRecorder returns a recorder of the page at url, making requests with http.DefaultTransport.
+func NewRecorder(url string) *Recorder {
+	return &Recorder{Fixture: NewFixture(url)}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	start := time.Now()
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Conditional responses are not recorded, as a replay is always made without validators.
+	if res.StatusCode != http.StatusNotModified {
+		r.Fixture.Add(&Response{
+			URL:           req.URL.String(),
+			StatusCode:    res.StatusCode,
+			Header:        res.Header.Clone(),
+			Body:          body,
+			Method:        req.Method,
+			RequestHeader: req.Header.Clone(),
+			StartedAt:     start,
+			Duration:      time.Since(start),
+		})
+	}
+
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return res, nil
+}
+
+// Record cures the page of the ingredients once and returns the fixture of every response fetched while
+// curing it. Ingredients.Transport, if set, makes the actual requests.
+func Record(ingredients antidote.Ingredients) (*Fixture, error) {
+	recorder := NewRecorder(ingredients.URL)
+	recorder.Transport = ingredients.Transport
+	ingredients.Transport = recorder
+
+	a := antidote.New()
+	a.Mix(&ingredients)
+
+	snapshot, err := a.CureToSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Close()
+
+	return recorder.Fixture, nil
+}
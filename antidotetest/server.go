@@ -0,0 +1,86 @@
+package antidotetest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+// fixtureURLHeader carries the original URL of a request routed to a fixture server by its transport.
+const fixtureURLHeader = "X-Antidote-Fixture-Url"
+
+// Server object represents a local HTTP server that replays the responses of a fixture.
+type Server struct {
+	*httptest.Server
+
+	fixture *Fixture
+}
+
+// NewServer starts a server replaying the responses of the fixture. The caller must call Close() when done.
+//
+// Requests made with Server.Transport() are answered with the response recorded for their URL, whatever
+// its host. Requests made to the server directly are answered with the response recorded for the same
+// path and query on the host of the page of the fixture. Unknown URLs are answered with 404 Not Found.
+func NewServer(f *Fixture) *Server {
+	s := &Server{fixture: f}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	target := r.Header.Get(fixtureURLHeader)
+	if target == "" {
+		page, err := url.Parse(s.fixture.URL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		target = page.ResolveReference(&url.URL{Path: r.URL.Path, RawQuery: r.URL.RawQuery}).String()
+	}
+
+	res, ok := s.fixture.Response(target)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	for name, values := range replayHeader(res.Header) {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(res.StatusCode)
+	w.Write(res.Body)
+}
+
+// Transport returns an http.RoundTripper that routes every request to the server, so that a cure of the
+// original URL of the fixture is replayed, including the assets of other hosts.
+func (s *Server) Transport() http.RoundTripper {
+	return &replayTransport{server: s}
+}
+
+// replayTransport routes requests to a fixture server, passing their original URL in a header.
+type replayTransport struct {
+	server *Server
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, err := url.Parse(t.server.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	routed := req.Clone(req.Context())
+	routed.Header.Set(fixtureURLHeader, req.URL.String())
+	routed.URL.Scheme = target.Scheme
+	routed.URL.Host = target.Host
+	routed.Host = target.Host
+
+	resp, err := t.server.Client().Transport.RoundTrip(routed)
+	if err != nil {
+		return nil, err
+	}
+
+	// The response is that of the original request, whose URL the references of the page are resolved against.
+	resp.Request = req
+	return resp, nil
+}
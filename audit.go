@@ -0,0 +1,279 @@
+package antidote
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Origin classifies where an asset is served from, see Snapshot.Audit().
+type Origin string
+
+const (
+	// OriginFirstParty is the host of the page or one of its subdomains.
+	OriginFirstParty Origin = "first-party"
+
+	// OriginCDN is a known public CDN, see KnownCDNs.
+	OriginCDN Origin = "cdn"
+
+	// OriginTracker is a host listed by a filter list of trackers, see AuditOptions.Trackers.
+	OriginTracker Origin = "tracker"
+
+	// OriginThirdParty is any other host.
+	OriginThirdParty Origin = "third-party"
+)
+
+// KnownCDNs are the hosts of common public CDNs. A leading "*." matches every subdomain.
+var KnownCDNs = []string{
+	"cdnjs.cloudflare.com", "cdn.jsdelivr.net", "unpkg.com", "code.jquery.com", "ajax.googleapis.com",
+	"fonts.googleapis.com", "fonts.gstatic.com", "maxcdn.bootstrapcdn.com", "stackpath.bootstrapcdn.com",
+	"use.fontawesome.com", "kit.fontawesome.com", "use.typekit.net", "ajax.aspnetcdn.com", "cdn.statically.io",
+	"*.cloudfront.net", "*.akamaihd.net", "*.akamaized.net", "*.fastly.net", "*.azureedge.net", "*.b-cdn.net",
+}
+
+// FilterList object represents a list of hosts, e.g. of trackers, read from a hosts file or from the network
+// rules of an Adblock Plus filter list such as EasyPrivacy.
+type FilterList struct {
+	// Name identifies the list in audit reports.
+	Name string
+
+	hosts map[string]bool
+}
+
+// NewFilterList creates a new instance of a FilterList pointer listing hosts and their subdomains.
+func NewFilterList(name string, hosts ...string) *FilterList {
+	l := &FilterList{Name: name, hosts: make(map[string]bool)}
+	for _, host := range hosts {
+		l.hosts[strings.ToLower(strings.TrimPrefix(host, "*."))] = true
+	}
+
+	return l
+}
+
+// ParseFilterList reads a filter list of one host per line, in any of the formats: "example.com", a hosts file
+// entry "0.0.0.0 example.com", or an Adblock Plus rule blocking a whole domain "||example.com^". Comments and
+// the other rules, e.g. those matching paths, elements or exceptions, are ignored.
+func ParseFilterList(name string, r io.Reader) (*FilterList, error) {
+	l := NewFilterList(name)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") {
+			continue
+		}
+
+		var host string
+		switch fields := strings.Fields(line); {
+		case strings.HasPrefix(line, "||"):
+			rule := strings.TrimPrefix(line, "||")
+			end := strings.IndexAny(rule, "^$")
+			if end < 0 || strings.ContainsAny(rule[:end], "/*") {
+				continue
+			}
+			host = rule[:end]
+		case len(fields) >= 2 && net.ParseIP(fields[0]) != nil:
+			host = fields[1]
+		case len(fields) == 1 && !strings.ContainsAny(line, "/*^$|@#"):
+			host = line
+		default:
+			continue
+		}
+
+		if host = strings.ToLower(host); host != "localhost" && strings.Contains(host, ".") {
+			l.hosts[host] = true
+		}
+	}
+
+	return l, scanner.Err()
+}
+
+// Match reports whether a host, or one of its parent domains, is listed.
+func (l *FilterList) Match(host string) bool {
+	host = strings.ToLower(host)
+	for {
+		if l.hosts[host] {
+			return true
+		}
+
+		dot := strings.IndexByte(host, '.')
+		if dot < 0 {
+			return false
+		}
+		host = host[dot+1:]
+	}
+}
+
+// DefaultTrackers lists the hosts of the analytics and A/B testing services of DefaultPruneRules, along with
+// other common advertising and tracking hosts.
+var DefaultTrackers = func() *FilterList {
+	hosts := []string{
+		"doubleclick.net", "googlesyndication.com", "googleadservices.com", "adservice.google.com",
+		"scorecardresearch.com", "quantserve.com", "chartbeat.com", "chartbeat.net", "newrelic.com", "nr-data.net",
+		"amplitude.com", "heapanalytics.com", "fullstory.com", "clarity.ms", "bat.bing.com", "ads-twitter.com",
+		"analytics.twitter.com", "analytics.tiktok.com", "snap.licdn.com", "px.ads.linkedin.com", "criteo.com",
+		"criteo.net", "taboola.com", "outbrain.com", "adnxs.com", "krxd.net", "matomo.cloud", "plausible.io",
+	}
+	for _, rule := range DefaultPruneRules {
+		hosts = append(hosts, rule.Hosts...)
+	}
+
+	return NewFilterList("default", hosts...)
+}()
+
+// AuditOptions object represents options for Snapshot.Audit().
+type AuditOptions struct {
+	// Trackers are the filter lists of trackers. Defaults to DefaultTrackers.
+	Trackers []*FilterList
+
+	// CDNs are the hosts of CDNs. A leading "*." matches every subdomain. Defaults to KnownCDNs.
+	CDNs []string
+}
+
+// OriginReport object represents every asset of a page served from one host.
+type OriginReport struct {
+	Host   string `json:"host"`
+	Origin Origin `json:"origin"`
+
+	// List is the name of the filter list a tracker is listed by.
+	List string `json:"list,omitempty"`
+
+	// Assets is the number of assets served from the host, and Bytes their total size.
+	Assets int `json:"assets"`
+	Bytes  int `json:"bytes"`
+
+	// Pruned is the number of assets of the host removed by Ingredients.Prune, which were not fetched.
+	Pruned int `json:"pruned,omitempty"`
+
+	// Kinds are the kinds of the assets.
+	Kinds []AssetKind `json:"kinds"`
+
+	// URLs are the URLs of the assets.
+	URLs []string `json:"urls"`
+}
+
+// AuditReport object represents the origins a page pulls its assets from, to review what it discloses to
+// third parties and which of them its content depends on.
+type AuditReport struct {
+	URL string `json:"url"`
+
+	// Counts are the number of hosts of every origin.
+	Counts map[Origin]int `json:"counts"`
+
+	// Origins are the hosts, first-party first, then CDNs, other third parties and trackers, largest first.
+	Origins []*OriginReport `json:"origins"`
+}
+
+// Audit classifies every asset of the snapshot by origin: first-party, known CDN, tracker according to filter
+// lists, or other third party. Pruned assets are included, as the page references them.
+func (s *Snapshot) Audit(options AuditOptions) *AuditReport {
+	if options.Trackers == nil {
+		options.Trackers = []*FilterList{DefaultTrackers}
+	}
+	if options.CDNs == nil {
+		options.CDNs = KnownCDNs
+	}
+
+	pageURL, _ := url.Parse(s.URL)
+	pageHost := ""
+	if pageURL != nil {
+		pageHost = pageURL.Hostname()
+	}
+
+	report := &AuditReport{URL: s.URL, Counts: make(map[Origin]int), Origins: []*OriginReport{}}
+	origins := make(map[string]*OriginReport)
+
+	add := func(assetURL string, kind AssetKind, size int, pruned bool) {
+		u, err := url.Parse(assetURL)
+		if err != nil || u.Hostname() == "" {
+			return
+		}
+		host := strings.ToLower(u.Hostname())
+
+		o, ok := origins[host]
+		if !ok {
+			o = &OriginReport{Host: host, Origin: OriginThirdParty, Kinds: []AssetKind{}, URLs: []string{}}
+			switch {
+			case firstParty(pageHost, host):
+				o.Origin = OriginFirstParty
+			case matchAnyHost(options.CDNs, host):
+				o.Origin = OriginCDN
+			}
+			for _, list := range options.Trackers {
+				if o.Origin != OriginFirstParty && list.Match(host) {
+					o.Origin, o.List = OriginTracker, list.Name
+					break
+				}
+			}
+
+			origins[host] = o
+			report.Origins = append(report.Origins, o)
+			report.Counts[o.Origin]++
+		}
+
+		o.Assets++
+		o.Bytes += size
+		if pruned {
+			o.Pruned++
+		}
+		if !containsKind(o.Kinds, kind) {
+			o.Kinds = append(o.Kinds, kind)
+		}
+		o.URLs = append(o.URLs, assetURL)
+	}
+
+	for _, asset := range s.Assets {
+		add(asset.URL, asset.Kind, asset.Size, false)
+	}
+	for _, pruned := range s.Pruned {
+		if pruned.Source == "" || pageURL == nil {
+			continue
+		}
+		if u, err := pageURL.Parse(strings.TrimSpace(pruned.Source)); err == nil {
+			add(u.String(), pruned.Kind, 0, true)
+		}
+	}
+
+	rank := map[Origin]int{OriginFirstParty: 0, OriginCDN: 1, OriginThirdParty: 2, OriginTracker: 3}
+	sort.SliceStable(report.Origins, func(i, j int) bool {
+		a, b := report.Origins[i], report.Origins[j]
+		if rank[a.Origin] != rank[b.Origin] {
+			return rank[a.Origin] < rank[b.Origin]
+		}
+		return a.Bytes > b.Bytes
+	})
+
+	return report
+}
+
+// firstParty reports whether a host is the host of the page or one of its subdomains, ignoring a www prefix.
+func firstParty(pageHost string, host string) bool {
+	pageHost = strings.TrimPrefix(strings.ToLower(pageHost), "www.")
+	host = strings.ToLower(host)
+
+	return pageHost != "" && (host == pageHost || strings.HasSuffix(host, "."+pageHost))
+}
+
+// matchAnyHost reports whether a host matches any of the patterns, see matchHost().
+func matchAnyHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if matchHost(pattern, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsKind(kinds []AssetKind, kind AssetKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,140 @@
+package antidote
+
+import (
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// Bookmark object represents a bookmark of a browser or of a bookmarking service.
+type Bookmark struct {
+	// URL is the absolute URL of the bookmarked page.
+	URL string `json:"url"`
+
+	// Title is the title the bookmark was saved with.
+	Title string `json:"title,omitempty"`
+
+	// Folders are the folders the bookmark is filed in, from the outermost one, e.g. ["Bookmarks bar", "Recipes"].
+	Folders []string `json:"folders,omitempty"`
+
+	// Tags are the tags of the bookmark, as exported by Firefox, Pinboard or Raindrop.io.
+	Tags []string `json:"tags,omitempty"`
+
+	// AddedAt is when the page was bookmarked, if exported.
+	AddedAt *time.Time `json:"addedAt,omitempty"`
+}
+
+// ReadBookmarks reads the bookmarks of a file in the Netscape bookmark file format, the bookmarks.html exported by
+// every browser and most bookmarking services, in the order they appear in. Only the bookmarks of http and https
+// URL's are returned, e.g. not those of bookmarklets.
+func ReadBookmarks(r io.Reader) ([]Bookmark, error) {
+	r, err := charset.NewReader(r, "text/html")
+	if err != nil {
+		return nil, err
+	}
+
+	var bookmarks []Bookmark
+	var folders []string
+	var heading, text strings.Builder
+	var bookmark *Bookmark
+	inHeading := false
+
+	z := html.NewTokenizer(r)
+	for {
+		tokenType := z.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return bookmarks, nil
+			}
+			return nil, z.Err()
+		case html.TextToken:
+			if inHeading {
+				heading.Write(z.Text())
+			} else if bookmark != nil {
+				text.Write(z.Text())
+			}
+		case html.StartTagToken, html.EndTagToken:
+			name, hasAttributes := z.TagName()
+			start := tokenType == html.StartTagToken
+
+			switch string(name) {
+			case "h3":
+				// The heading of a folder is followed by the list of its bookmarks.
+				inHeading = start
+				if start {
+					heading.Reset()
+				}
+			case "dl":
+				if start {
+					folders = append(folders, strings.TrimSpace(heading.String()))
+					heading.Reset()
+				} else if len(folders) > 0 {
+					folders = folders[:len(folders)-1]
+				}
+			case "a":
+				if !start {
+					if bookmark != nil {
+						bookmark.Title = strings.TrimSpace(text.String())
+						bookmarks = append(bookmarks, *bookmark)
+					}
+					bookmark = nil
+					continue
+				}
+
+				bookmark = nil
+				text.Reset()
+				attributes := make(map[string]string)
+				for hasAttributes {
+					var key, value []byte
+					key, value, hasAttributes = z.TagAttr()
+					attributes[string(key)] = string(value)
+				}
+
+				u, err := url.Parse(strings.TrimSpace(attributes["href"]))
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+					continue
+				}
+
+				bookmark = &Bookmark{URL: u.String(), AddedAt: bookmarkTime(attributes["add_date"])}
+				for _, folder := range folders {
+					if folder != "" {
+						bookmark.Folders = append(bookmark.Folders, folder)
+					}
+				}
+				for _, tag := range strings.Split(attributes["tags"], ",") {
+					if tag = strings.TrimSpace(tag); tag != "" {
+						bookmark.Tags = append(bookmark.Tags, tag)
+					}
+				}
+			}
+		}
+	}
+}
+
+// bookmarkTime parses the ADD_DATE of a bookmark, a Unix time in seconds, or in milliseconds or microseconds for
+// some exporters.
+func bookmarkTime(value string) *time.Time {
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || n <= 0 {
+		return nil
+	}
+
+	var t time.Time
+	switch {
+	case n >= 1e14:
+		t = time.Unix(0, n*int64(time.Microsecond))
+	case n >= 1e11:
+		t = time.Unix(0, n*int64(time.Millisecond))
+	default:
+		t = time.Unix(n, 0)
+	}
+	t = t.UTC()
+
+	return &t
+}
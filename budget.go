@@ -0,0 +1,81 @@
+package antidote
+
+import (
+	"context"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Degradation object represents how a cure degrades once Ingredients.Deadline or Ingredients.MaxTotalSize is
+// exceeded, in order: the media elements are dropped, then the images too large to be worth a request, and
+// the other assets not fetched are left as references to their URL. The zero value only does the latter.
+type Degradation struct {
+	// DropMedia removes the <video>, <audio>, <object> and <embed> elements of the page, which are never
+	// inlined, so the partial snapshot does not load the heaviest resources of the page from the network.
+	DropMedia bool
+
+	// DropImagesOver removes the <img> elements whose image was not fetched and is larger than this many bytes,
+	// as learned by Ingredients.Preflight or else a HEAD request, which is only made within the time left before
+	// Ingredients.Deadline. Images of an unknown size are left as references to their URL. Zero drops no image.
+	DropImagesOver int64
+}
+
+// exceedsBudget reports whether Ingredients.Deadline or Ingredients.MaxTotalSize have been exceeded, in which
+// case the snapshot is marked partial.
+func (a *Antidote) exceedsBudget() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	exceeded := a.ingredients.Deadline > 0 && time.Since(a.snapshot.StartedAt) > a.ingredients.Deadline ||
+		a.ingredients.MaxTotalSize > 0 && a.fetchedSize >= a.ingredients.MaxTotalSize
+	if exceeded {
+		a.snapshot.Partial = true
+	}
+
+	return exceeded
+}
+
+// dropsImage reports whether an image not fetched as the budget was exceeded is dropped, see
+// Degradation.DropImagesOver.
+func (a *Antidote) dropsImage(normalizedSrc string) bool {
+	limit := a.ingredients.Degradation.DropImagesOver
+	if limit <= 0 {
+		return false
+	}
+
+	a.mu.Lock()
+	size, ok := a.probed[normalizedSrc]
+	a.mu.Unlock()
+	if !ok {
+		ctx, cancel := a.budgetContext()
+		defer cancel()
+
+		if ctx.Err() == nil {
+			size, _ = a.head(ctx, normalizedSrc)
+		}
+	}
+
+	return size > limit
+}
+
+// budgetContext returns a context done once Ingredients.Deadline is exceeded, if it is set.
+func (a *Antidote) budgetContext() (context.Context, context.CancelFunc) {
+	if a.ingredients.Deadline <= 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithDeadline(context.Background(), a.snapshot.StartedAt.Add(a.ingredients.Deadline))
+}
+
+// degrade applies Degradation.DropMedia to a partial snapshot.
+func (a *Antidote) degrade() {
+	if !a.snapshot.Partial || !a.ingredients.Degradation.DropMedia {
+		return
+	}
+
+	a.website.Find("video, audio, object, embed").Each(func(index int, media *goquery.Selection) {
+		a.log(LogDebug, "dropped media", "element", goquery.NodeName(media))
+		media.Remove()
+	})
+}
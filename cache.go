@@ -0,0 +1,104 @@
+package antidote
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Cache stores the bytes of fetched assets by the hash of their content, so later cures can reuse them.
+type Cache interface {
+	// Get returns the bytes stored under a key.
+	Get(key string) ([]byte, bool)
+
+	// Set stores bytes under a key.
+	Set(key string, value []byte)
+}
+
+// MemoryCache is a Cache held in memory. It is safe for concurrent use.
+type MemoryCache struct {
+	entries map[string][]byte
+	mu      sync.RWMutex
+}
+
+// NewMemoryCache creates a new instance of a MemoryCache pointer.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string][]byte)}
+}
+
+// Get returns the bytes stored under a key.
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	value, ok := c.entries[key]
+	return value, ok
+}
+
+// Set stores bytes under a key.
+func (c *MemoryCache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = value
+}
+
+// hashContent returns the hex encoded SHA-256 hash of content, which is the key assets are cached under.
+func hashContent(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// DirCache is a Cache stored in a directory, one file per key, so that it outlives the process. It is safe for
+// concurrent use.
+type DirCache struct {
+	dir string
+}
+
+// NewDirCache creates a new instance of a DirCache pointer storing its entries in dir, which is created if
+// it does not exist.
+func NewDirCache(dir string) (*DirCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &DirCache{dir: dir}, nil
+}
+
+// Get returns the bytes stored under a key.
+func (c *DirCache) Get(key string) ([]byte, bool) {
+	value, err := ioutil.ReadFile(c.path(key))
+	return value, err == nil
+}
+
+// Set stores bytes under a key. The entry is written to a temporary file first, so that an interrupted write
+// never leaves a truncated entry behind. Entries that can not be written are skipped, as the bytes are
+// fetched again when they are missing.
+func (c *DirCache) Set(key string, value []byte) {
+	f, err := ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		return
+	}
+
+	_, err = f.Write(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// path returns the path of the file of a key. Keys are hex encoded hashes, anything else is escaped so that
+// the file stays in the directory.
+func (c *DirCache) path(key string) string {
+	return filepath.Join(c.dir, strings.Replace(url.PathEscape(key), ".", "%2E", -1))
+}
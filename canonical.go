@@ -0,0 +1,233 @@
+package antidote
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// TrailingSlash is how a Canonicalizer normalizes the trailing slash of the path of URL's.
+type TrailingSlash string
+
+const (
+	// TrailingSlashKeep leaves paths as they are.
+	TrailingSlashKeep TrailingSlash = ""
+
+	// TrailingSlashStrip removes the trailing slash of paths, e.g. "/blog/" becomes "/blog".
+	TrailingSlashStrip TrailingSlash = "strip"
+
+	// TrailingSlashAdd adds a trailing slash to the paths whose last segment has no extension, e.g. "/blog"
+	// becomes "/blog/", but "/index.html" is left as it is.
+	TrailingSlashAdd TrailingSlash = "add"
+)
+
+func (t TrailingSlash) validate() error {
+	switch t {
+	case TrailingSlashKeep, TrailingSlashStrip, TrailingSlashAdd:
+		return nil
+	default:
+		return fmt.Errorf("unknown trailing slash %q", t)
+	}
+}
+
+// DefaultTrackingParams are the query parameters added by analytics, ad and newsletter platforms to track
+// where visitors come from, which do not change the page.
+var DefaultTrackingParams = []string{
+	"utm_*", "fbclid", "gclid", "gclsrc", "dclid", "msclkid", "yclid", "twclid", "igshid", "mc_cid", "mc_eid",
+	"_ga", "_gl", "_hsenc", "_hsmi", "mkt_tok", "oly_anon_id", "oly_enc_id", "vero_id", "ref_src",
+}
+
+// DefaultCanonicalizer strips the tracking parameters of URL's, sorts their query, strips their trailing slash
+// and follows the <link rel="canonical"> of pages.
+var DefaultCanonicalizer = Canonicalizer{
+	StripParams:     DefaultTrackingParams,
+	SortQuery:       true,
+	TrailingSlash:   TrailingSlashStrip,
+	FollowCanonical: true,
+}
+
+// Canonicalizer object represents rules rewriting URL's into a canonical form, so that the same page linked
+// with trivially different URL's, e.g. with tracking parameters, is identified by a single URL, see
+// Ingredients.Canonicalizer. The scheme and the host are always lowercased, and the default port and the
+// fragment removed.
+type Canonicalizer struct {
+	// StripParams are the query parameters removed, e.g. DefaultTrackingParams. A trailing "*" matches any
+	// suffix, e.g. "utm_*".
+	StripParams []string
+
+	// SortQuery sorts the query parameters by name, keeping the order of the values of a parameter.
+	SortQuery bool
+
+	// TrailingSlash is how the trailing slash of paths is normalized. The root path is always "/".
+	TrailingSlash TrailingSlash
+
+	// FollowCanonical identifies a page by the URL of its <link rel="canonical"> (or og:url) instead, see
+	// Metadata.CanonicalURL, canonicalized as well, if it is of the same host, so that a page can not claim the
+	// URL of another site.
+	FollowCanonical bool
+
+	// Rules are custom rules applied to the URL's after the others, e.g. removing the session ID in the path of
+	// the pages of a site.
+	Rules []func(u *url.URL)
+}
+
+func (c *Canonicalizer) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	return c.TrailingSlash.validate()
+}
+
+// Canonicalize returns the canonical form of an absolute URL.
+func (c *Canonicalizer) Canonicalize(rawurl string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawurl))
+	if err != nil {
+		return "", err
+	}
+
+	if !u.IsAbs() || u.Host == "" {
+		return "", fmt.Errorf("%q is not an absolute URL", rawurl)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); port == "80" && u.Scheme == "http" || port == "443" && u.Scheme == "https" {
+		u.Host = u.Hostname()
+		if strings.Contains(u.Host, ":") {
+			u.Host = "[" + u.Host + "]"
+		}
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	c.canonicalizePath(u)
+	c.canonicalizeQuery(u)
+
+	for _, rule := range c.Rules {
+		rule(u)
+	}
+
+	return u.String(), nil
+}
+
+// canonicalizePath normalizes the trailing slash of the path of a URL.
+func (c *Canonicalizer) canonicalizePath(u *url.URL) {
+	if u.Path == "" || u.Path == "/" {
+		u.Path, u.RawPath = "/", ""
+		return
+	}
+
+	switch c.TrailingSlash {
+	case TrailingSlashStrip:
+		u.Path = strings.TrimRight(u.Path, "/")
+		u.RawPath = strings.TrimRight(u.RawPath, "/")
+		if u.Path == "" {
+			u.Path, u.RawPath = "/", ""
+		}
+	case TrailingSlashAdd:
+		if !strings.HasSuffix(u.Path, "/") && path.Ext(u.Path) == "" {
+			u.Path += "/"
+			if u.RawPath != "" {
+				u.RawPath += "/"
+			}
+		}
+	}
+}
+
+// canonicalizeQuery strips the parameters of StripParams from the query of a URL, and sorts it with SortQuery.
+// The query is left as it is otherwise, as some servers depend on the encoding of their parameters.
+func (c *Canonicalizer) canonicalizeQuery(u *url.URL) {
+	if u.RawQuery == "" {
+		u.ForceQuery = false
+		return
+	}
+
+	var params []string
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		if param == "" {
+			continue
+		}
+
+		name := param
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			name = param[:i]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+
+		if !c.strips(name) {
+			params = append(params, param)
+		}
+	}
+
+	if c.SortQuery {
+		sort.SliceStable(params, func(i, j int) bool {
+			return paramName(params[i]) < paramName(params[j])
+		})
+	}
+
+	u.RawQuery = strings.Join(params, "&")
+	u.ForceQuery = false
+}
+
+// strips reports whether a query parameter is one of StripParams.
+func (c *Canonicalizer) strips(name string) bool {
+	for _, pattern := range c.StripParams {
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) || name == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+// paramName returns the name of a query parameter of the form "name=value".
+func paramName(param string) string {
+	return strings.SplitN(param, "=", 2)[0]
+}
+
+// canonicalURL returns the canonical URL of the page being cured, or Ingredients.URL as it is without
+// Ingredients.Canonicalizer. The metadata of the snapshot must have been extracted.
+func (a *Antidote) canonicalURL() string {
+	c := a.ingredients.Canonicalizer
+	if c == nil {
+		return a.ingredients.URL
+	}
+
+	rawurl := a.ingredients.URL
+	if declared := a.snapshot.Metadata.CanonicalURL; c.FollowCanonical && declared != "" {
+		page, err := url.Parse(rawurl)
+		canonical, canonicalErr := url.Parse(declared)
+		if err == nil && canonicalErr == nil && strings.EqualFold(canonical.Hostname(), page.Hostname()) {
+			rawurl = declared
+		}
+	}
+
+	canonical, err := c.Canonicalize(rawurl)
+	if err != nil {
+		a.log(LogDebug, "not canonicalized", "url", rawurl, "error", err)
+		return a.ingredients.URL
+	}
+
+	return canonical
+}
+
+// canonicalKey returns the canonical form of the URL of an asset with Ingredients.Canonicalizer, which keys the
+// prior assets of Antidote.Recure(), or the URL as it is. The <link rel="canonical"> of pages does not apply to
+// assets.
+func (a *Antidote) canonicalKey(rawurl string) string {
+	if a.ingredients.Canonicalizer == nil {
+		return rawurl
+	}
+
+	canonical, err := a.ingredients.Canonicalizer.Canonicalize(rawurl)
+	if err != nil {
+		return rawurl
+	}
+
+	return canonical
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lansana/antidote"
+)
+
+// listFlag is a repeatable flag of values.
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// audit cures a page and reports the origins of its assets.
+func audit(args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: antidote audit [flags] <url>")
+		flags.PrintDefaults()
+	}
+	ingredients := fetchFlags(flags)
+	prune := flags.Bool("prune", false, "prune analytics, A/B testing and font loading scripts instead of fetching them, while still reporting them")
+	var filterLists listFlag
+	flags.Var(&filterLists, "filter-list", "classify the hosts listed in this `file` (hosts file, one domain per line, or Adblock Plus ||domain^ rules, e.g. EasyPrivacy) as trackers instead of the built-in list (repeatable)")
+	var cdns listFlag
+	flags.Var(&cdns, "cdn", "also classify this `host` as a CDN, *.example.com for every subdomain (repeatable)")
+	jsonOutput := flags.Bool("json", false, "print the report as JSON")
+	c, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if err := c.applyHosts(ingredients); err != nil {
+		return err
+	}
+
+	if *prune {
+		if ingredients.Prune, err = c.pruneRules(); err != nil {
+			return err
+		}
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return invalidUsage("exactly one URL is required")
+	}
+
+	options := antidote.AuditOptions{CDNs: append(append([]string{}, antidote.KnownCDNs...), cdns...)}
+	for _, name := range filterLists {
+		f, err := os.Open(name)
+		if err != nil {
+			return &usageError{err}
+		}
+
+		list, err := antidote.ParseFilterList(filepath.Base(name), f)
+		f.Close()
+		if err != nil {
+			return &usageError{fmt.Errorf("%s: %v", name, err)}
+		}
+
+		options.Trackers = append(options.Trackers, list)
+	}
+
+	ingredients.URL = flags.Arg(0)
+
+	a := antidote.New()
+	a.Mix(ingredients)
+
+	snapshot, err := a.CureToSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	report := snapshot.Audit(options)
+
+	if *jsonOutput {
+		return printJSON(report)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ORIGIN\tHOST\tASSETS\tSIZE\tKINDS\tLIST")
+	for _, o := range report.Origins {
+		kinds := make([]string, len(o.Kinds))
+		for i, kind := range o.Kinds {
+			kinds[i] = string(kind)
+		}
+
+		assets := fmt.Sprint(o.Assets)
+		if o.Pruned > 0 {
+			assets += fmt.Sprintf(" (%d pruned)", o.Pruned)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", o.Origin, o.Host, assets, o.Bytes, strings.Join(kinds, ","), o.List)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%d first-party, %d CDN, %d third-party and %d tracker hosts\n", report.Counts[antidote.OriginFirstParty],
+		report.Counts[antidote.OriginCDN], report.Counts[antidote.OriginThirdParty], report.Counts[antidote.OriginTracker])
+
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"errors"
+
+	"github.com/lansana/antidote/server"
+)
+
+// newSyslogAuditSink fails, as there is no syslog on this system.
+func newSyslogAuditSink(tag string) (server.AuditSink, error) {
+	return nil, errors.New("-audit-syslog is not supported on this system")
+}
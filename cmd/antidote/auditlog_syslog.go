@@ -0,0 +1,11 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import "github.com/lansana/antidote/server"
+
+// newSyslogAuditSink returns an audit sink logging to the local syslog server with a tag.
+func newSyslogAuditSink(tag string) (server.AuditSink, error) {
+	return server.NewSyslogAuditSink("", "", tag)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/lansana/antidote"
+)
+
+// batchResult is the outcome of curing one URL of a batch.
+type batchResult struct {
+	URL      string
+	Output   string
+	Snapshot *antidote.Snapshot
+	Duration time.Duration
+	Err      error
+
+	// Duplicate is the URL of the page of the batch this page is a near-duplicate of, see -dedupe.
+	Duplicate string
+}
+
+// error returns the error of the cure, or a partialError if the page was cured but some of its assets failed.
+func (r *batchResult) error() error {
+	if r.Err != nil {
+		return r.Err
+	}
+
+	if errs := r.Snapshot.Errors(); len(errs) > 0 {
+		return &partialError{len(errs)}
+	}
+
+	return nil
+}
+
+// cureBatch cures every URL with its own copy of the ingredients, jobs of them at a time. If a prior snapshot is
+// given, the URLs are recured with it instead, see Antidote.Recure(). If done is set, it is called with every
+// result as soon as its cure ends, from the goroutine of the cure. The results are returned in the order of the
+// URLs. The stylesheets shared by the pages are only cured once, see Ingredients.TransformCache.
+func cureBatch(ingredients *antidote.Ingredients, urls []string, jobs int, prior *antidote.Snapshot, done func(result *batchResult)) []*batchResult {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	if ingredients.TransformCache == nil {
+		shared := *ingredients
+		shared.TransformCache = antidote.NewMemoryCache()
+		ingredients = &shared
+	}
+
+	results := make([]*batchResult, len(urls))
+	jobSlots := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+
+	for i, u := range urls {
+		wg.Add(1)
+		jobSlots <- struct{}{}
+
+		go func(i int, u string) {
+			defer wg.Done()
+			defer func() { <-jobSlots }()
+
+			pageIngredients := *ingredients
+			pageIngredients.URL = u
+
+			a := antidote.New()
+			a.Mix(&pageIngredients)
+
+			result := &batchResult{URL: u}
+			start := time.Now()
+			if prior != nil {
+				result.Snapshot, result.Err = a.Recure(prior)
+			} else {
+				result.Snapshot, result.Err = a.CureToSnapshot()
+			}
+			result.Duration = time.Since(start)
+
+			if done != nil {
+				done(result)
+			}
+			results[i] = result
+		}(i, u)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// duplicateOf returns the URL of the page cured before that the page of a result is a near-duplicate of, if any.
+// Pages with too little text to be told apart are never duplicates.
+func duplicateOf(duplicates *antidote.DuplicateDetector, result *batchResult) string {
+	fingerprint, ok, err := result.Snapshot.Fingerprint()
+	if err != nil || !ok {
+		return ""
+	}
+
+	original, _ := duplicates.Add(result.URL, fingerprint)
+	return original
+}
+
+// writeDuplicate replaces the output of a near-duplicate page with a relative symlink to the output of the page
+// it duplicates with -dedupe link, or with -dedupe skip, writes nothing and reports that output as its own.
+func writeDuplicate(result *batchResult, original string, dedupe string) error {
+	if dedupe == "skip" {
+		result.Output = original
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(result.Output), 0755); err != nil {
+		return err
+	}
+
+	target, err := filepath.Rel(filepath.Dir(result.Output), original)
+	if err != nil {
+		return err
+	}
+
+	// The output of a previous run is replaced, as writeSnapshot() would.
+	if _, err := os.Lstat(result.Output); err == nil {
+		if err := os.RemoveAll(result.Output); err != nil {
+			return err
+		}
+	}
+
+	return os.Symlink(target, result.Output)
+}
+
+// readURLs reads a list of URLs, one per line, from a file, or from stdin if name is "-". Blank lines and lines
+// starting with # are skipped.
+func readURLs(name string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+
+	return urls, scanner.Err()
+}
+
+// uniqueURLs returns the URLs without the ones listed more than once, in order. With a canonicalizer, URLs of
+// the same canonical form are listed once, as the first of them.
+func uniqueURLs(urls []string, canonicalizer *antidote.Canonicalizer) []string {
+	seen := make(map[string]bool)
+	unique := urls[:0]
+	for _, u := range urls {
+		key := u
+		if canonicalizer != nil {
+			if canonical, err := canonicalizer.Canonicalize(u); err == nil {
+				key = canonical
+			}
+		}
+
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, u)
+		}
+	}
+
+	return unique
+}
+
+// outputName holds the fields available to -out-template.
+type outputName struct {
+	// URL is the URL being cured.
+	URL string
+
+	// Scheme is the scheme of the URL, e.g. https.
+	Scheme string
+
+	// Host is the hostname of the URL, without its port.
+	Host string
+
+	// Port is the port of the URL, if it has one.
+	Port string
+
+	// Path is the path of the URL without its leading slash and its extension, or "index" if it has none.
+	Path string
+
+	// Query is the query of the URL, if it has one.
+	Query string
+
+	// Index is the position of the URL in the batch, starting at 1.
+	Index int
+}
+
+// outputNames executes the template for every URL, and makes sure no two URLs are written to the same name.
+func outputNames(tmpl *template.Template, urls []string) ([]string, error) {
+	names := make([]string, len(urls))
+	owners := make(map[string]string)
+
+	for i, rawurl := range urls {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return nil, err
+		}
+
+		p := strings.Trim(path.Clean("/"+u.Path), "/")
+		p = strings.TrimSuffix(p, path.Ext(p))
+		if p == "" {
+			p = "index"
+		}
+
+		var b bytes.Buffer
+		err = tmpl.Execute(&b, outputName{
+			URL:    rawurl,
+			Scheme: u.Scheme,
+			Host:   u.Hostname(),
+			Port:   u.Port(),
+			Path:   p,
+			Query:  u.RawQuery,
+			Index:  i + 1,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		name := filepath.Clean(b.String())
+		if owner, ok := owners[name]; ok {
+			return nil, fmt.Errorf("both %s and %s would be written to %s, use a more specific -out-template", owner, rawurl, name)
+		}
+		owners[name] = rawurl
+		names[i] = name
+	}
+
+	return names, nil
+}
+
+// printSummary prints a table of the outcome of every URL of a batch.
+func printSummary(w io.Writer, results []*batchResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tURL\tOUTPUT\tTIME")
+
+	failed, duplicates := 0, 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			fmt.Fprintf(tw, "failed\t%s\t%v\t%s\n", result.URL, result.Err, result.Duration.Round(time.Millisecond))
+			continue
+		}
+		if result.Duplicate != "" {
+			duplicates++
+			fmt.Fprintf(tw, "duplicate\t%s\t%s (of %s)\t%s\n", result.URL, result.Output, result.Duplicate, result.Duration.Round(time.Millisecond))
+			continue
+		}
+		fmt.Fprintf(tw, "ok\t%s\t%s\t%s\n", result.URL, result.Output, result.Duration.Round(time.Millisecond))
+	}
+	tw.Flush()
+
+	if duplicates > 0 {
+		fmt.Fprintf(w, "%d cured, %d duplicates, %d failed\n", len(results)-failed-duplicates, duplicates, failed)
+		return
+	}
+	fmt.Fprintf(w, "%d cured, %d failed\n", len(results)-failed, failed)
+}
+
+// printHostStats prints a table of the requests made to every host by the cures of a batch, slowest host first.
+func printHostStats(w io.Writer, results []*batchResult) {
+	byHost := make(map[string]*antidote.HostStats)
+	var hosts []*antidote.HostStats
+	for _, result := range results {
+		if result.Snapshot == nil {
+			continue
+		}
+
+		for _, stats := range result.Snapshot.Hosts {
+			total, ok := byHost[stats.Host]
+			if !ok {
+				total = &antidote.HostStats{Host: stats.Host}
+				byHost[stats.Host] = total
+				hosts = append(hosts, total)
+			}
+
+			// The average latency is summed up here, and divided by the number of requests once every cure has.
+			total.AverageLatency += stats.AverageLatency * time.Duration(stats.Requests)
+			total.Requests += stats.Requests
+			total.Errors += stats.Errors
+			total.Bytes += stats.Bytes
+			total.NewConnections += stats.NewConnections
+			total.ReusedConnections += stats.ReusedConnections
+			total.TLSHandshakes += stats.TLSHandshakes
+			total.TLSResumed += stats.TLSResumed
+		}
+	}
+
+	for _, total := range hosts {
+		if total.Requests > 0 {
+			total.AverageLatency /= time.Duration(total.Requests)
+		}
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].AverageLatency > hosts[j].AverageLatency
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tREQUESTS\tERRORS\tBYTES\tAVG LATENCY\tNEW CONNS\tREUSED CONNS\tTLS RESUMED")
+	for _, stats := range hosts {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%d\t%d\t%d/%d\n", stats.Host, stats.Requests, stats.Errors, stats.Bytes,
+			stats.AverageLatency.Round(time.Millisecond), stats.NewConnections, stats.ReusedConnections, stats.TLSResumed, stats.TLSHandshakes)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/lansana/antidote"
+	"github.com/lansana/antidote/antidotetest"
+)
+
+func record(args []string) error {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: antidote record [flags] -o <fixture directory> <url>")
+		flags.PrintDefaults()
+	}
+	ingredients := fetchFlags(flags)
+	out := flags.String("o", "", "the directory to write the fixture to")
+	c, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if err := c.applyHosts(ingredients); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 || *out == "" {
+		flags.Usage()
+		return invalidUsage("a fixture directory and exactly one URL are required")
+	}
+
+	ingredients.URL = flags.Arg(0)
+
+	fixture, err := antidotetest.Record(*ingredients)
+	if err != nil {
+		return err
+	}
+
+	if err := fixture.Save(*out); err != nil {
+		return err
+	}
+
+	fmt.Printf("Recorded %d responses of %s\n", len(fixture.Responses), fixture.URL)
+	return nil
+}
+
+func bench(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: antidote bench [flags] <fixture directory>...")
+		flags.PrintDefaults()
+	}
+	concurrency := flags.Int("concurrency", 0, "maximum number of assets fetched at the same time (0 means the default of 16)")
+	stripJS := flags.Bool("strip-js", false, "remove every script instead of inlining them")
+	skipImages := flags.Bool("skip-images", false, "leave images as remote references")
+	extract := flags.Bool("extract", false, "extract the assets instead of inlining them")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return invalidUsage("at least one fixture directory is required")
+	}
+
+	ingredients := antidote.Ingredients{
+		Concurrency:   *concurrency,
+		StripJS:       *stripJS,
+		SkipImages:    *skipImages,
+		ExtractAssets: *extract,
+	}
+
+	for _, dir := range flags.Args() {
+		fixture, err := antidotetest.Load(dir)
+		if err != nil {
+			return err
+		}
+
+		// A failing cure is reported once here, as testing.Benchmark() discards the errors of its runs.
+		snapshot, err := antidotetest.Replay(fixture, ingredients)
+		if err != nil {
+			return fmt.Errorf("%s: %v", dir, err)
+		}
+		snapshot.Close()
+
+		result := testing.Benchmark(func(b *testing.B) {
+			antidotetest.BenchmarkCure(b, b.N, fixture, ingredients)
+		})
+
+		fmt.Printf("%s\t%s\t%s\n", dir, result, result.MemString())
+	}
+
+	return nil
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lansana/antidote"
+	"github.com/lansana/antidote/server"
+	"gopkg.in/yaml.v2"
+)
+
+// config is the contents of a YAML configuration file. Top-level keys set the flag of the same name for
+// every command, and the keys of a section named after a command only apply to that command:
+//
+//	concurrency: 8
+//	timeout: 30s
+//	serve:
+//	  addr: :9000
+//	  workers: 16
+//
+// The hosts key holds per-host rules, see hostRule, the prune-rules key the rules of -prune, see pruneRule, the
+// tenants key the tenants of the daemon, see tenant, the canonical key the rules of -canonicalize, see canonical,
+// and the host-rewrites key maps hostnames to the address
+// connections are made to instead:
+//
+//	host-rewrites:
+//	  www.example.com: 10.0.0.5:8443
+type config map[string]interface{}
+
+// hostRule is a per-host rule in a configuration file:
+//
+//	hosts:
+//	  - host: cdn.example.com
+//	    headers:
+//	      Referer: https://www.example.com
+//	  - host: "*.fragile.org"
+//	    concurrency: 1
+//	  - host: spa.example.com
+//	    strip-js: true
+type hostRule struct {
+	Host        string            `yaml:"host"`
+	Headers     map[string]string `yaml:"headers"`
+	Concurrency int               `yaml:"concurrency"`
+	StripJS     bool              `yaml:"strip-js"`
+}
+
+// pruneRule is a rule pruning assets in a configuration file, which replace antidote.DefaultPruneRules:
+//
+//	prune-rules:
+//	  - name: analytics
+//	    hosts: [stats.example.com]
+//	    patterns: [/track.js]
+//	  - name: font-loader
+//	    kind: js
+//	    patterns: [fontloader.js]
+//	    when-fonts-inlined: true
+type pruneRule struct {
+	Name             string   `yaml:"name"`
+	Kind             string   `yaml:"kind"`
+	Hosts            []string `yaml:"hosts"`
+	Patterns         []string `yaml:"patterns"`
+	WhenFontsInlined bool     `yaml:"when-fonts-inlined"`
+}
+
+// canonical are the rules of -canonicalize in a configuration file, which replace antidote.DefaultCanonicalizer:
+//
+//	canonical:
+//	  strip-params: ["utm_*", fbclid, sessionid]
+//	  sort-query: true
+//	  trailing-slash: add
+//	  follow-canonical: false
+type canonical struct {
+	StripParams     []string `yaml:"strip-params"`
+	SortQuery       bool     `yaml:"sort-query"`
+	TrailingSlash   string   `yaml:"trailing-slash"`
+	FollowCanonical bool     `yaml:"follow-canonical"`
+}
+
+// tenant is a tenant of the daemon in a configuration file, see server.Tenant:
+//
+//	tenants:
+//	  - name: docs
+//	    key: 8f1c0e7d9a
+//	    rate-limit: 60
+//	    quota: 1073741824
+//	    hosts: [docs.example.com, "*.cdn.example.com"]
+//	    max-total-size: 52428800
+type tenant struct {
+	Name         string        `yaml:"name"`
+	Key          string        `yaml:"key"`
+	RateLimit    int           `yaml:"rate-limit"`
+	Quota        int64         `yaml:"quota"`
+	Hosts        []string      `yaml:"hosts"`
+	Concurrency  int           `yaml:"concurrency"`
+	Timeout      time.Duration `yaml:"timeout"`
+	Deadline     time.Duration `yaml:"deadline"`
+	MaxAssetSize int64         `yaml:"max-asset-size"`
+	MaxTotalSize int64         `yaml:"max-total-size"`
+}
+
+// loadConfig reads and parses a configuration file.
+func loadConfig(path string) (config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := config{}
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return c, nil
+}
+
+// lookup returns the value of a flag for a command, preferring the section of the command over the top-level key.
+func (c config) lookup(command, name string) (interface{}, bool) {
+	if section, ok := c[command].(map[interface{}]interface{}); ok {
+		if value, ok := section[name]; ok {
+			return value, true
+		}
+	}
+
+	value, ok := c[name]
+	return value, ok
+}
+
+// hostRules returns the per-host rules of the configuration.
+func (c config) hostRules() ([]antidote.HostRule, error) {
+	hosts, ok := c["hosts"]
+	if !ok {
+		return nil, nil
+	}
+
+	// Round-trip the generic value through YAML to decode it into typed rules.
+	b, err := yaml.Marshal(hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []hostRule
+	if err := yaml.UnmarshalStrict(b, &rules); err != nil {
+		return nil, fmt.Errorf("hosts: %v", err)
+	}
+
+	hostRules := make([]antidote.HostRule, len(rules))
+	for i, rule := range rules {
+		if rule.Host == "" {
+			return nil, fmt.Errorf("hosts: rule %d has no host", i+1)
+		}
+
+		hostRules[i] = antidote.HostRule{
+			Host:        rule.Host,
+			Headers:     rule.Headers,
+			Concurrency: rule.Concurrency,
+			StripJS:     rule.StripJS,
+		}
+	}
+
+	return hostRules, nil
+}
+
+// pruneRules returns the prune rules of the configuration, or antidote.DefaultPruneRules if it has none.
+func (c config) pruneRules() ([]antidote.PruneRule, error) {
+	prune, ok := c["prune-rules"]
+	if !ok {
+		return antidote.DefaultPruneRules, nil
+	}
+
+	b, err := yaml.Marshal(prune)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []pruneRule
+	if err := yaml.UnmarshalStrict(b, &rules); err != nil {
+		return nil, fmt.Errorf("prune-rules: %v", err)
+	}
+
+	pruneRules := make([]antidote.PruneRule, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("prune-rules: rule %d has no name", i+1)
+		}
+
+		kind := antidote.AssetKind(rule.Kind)
+		if kind != "" && kind != antidote.AssetJS && kind != antidote.AssetCSS {
+			return nil, fmt.Errorf("prune-rules: rule %s has unknown kind %q", rule.Name, rule.Kind)
+		}
+
+		pruneRules[i] = antidote.PruneRule{
+			Name:             rule.Name,
+			Kind:             kind,
+			Hosts:            rule.Hosts,
+			Patterns:         rule.Patterns,
+			WhenFontsInlined: rule.WhenFontsInlined,
+		}
+	}
+
+	return pruneRules, nil
+}
+
+// tenants returns the tenants of the daemon in the configuration.
+func (c config) tenants() ([]*server.Tenant, error) {
+	list, ok := c["tenants"]
+	if !ok {
+		return nil, nil
+	}
+
+	b, err := yaml.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []tenant
+	if err := yaml.UnmarshalStrict(b, &entries); err != nil {
+		return nil, fmt.Errorf("tenants: %v", err)
+	}
+
+	names := make(map[string]bool)
+	keys := make(map[string]bool)
+	tenants := make([]*server.Tenant, len(entries))
+	for i, entry := range entries {
+		switch {
+		case entry.Name == "" || strings.ContainsAny(entry.Name, "/\\") || entry.Name == "." || entry.Name == "..":
+			return nil, fmt.Errorf("tenants: tenant %d has no valid name", i+1)
+		case names[entry.Name]:
+			return nil, fmt.Errorf("tenants: tenant %s is defined twice", entry.Name)
+		case entry.Key == "":
+			return nil, fmt.Errorf("tenants: tenant %s has no key", entry.Name)
+		case keys[entry.Key]:
+			return nil, fmt.Errorf("tenants: tenant %s has the key of another tenant", entry.Name)
+		}
+		names[entry.Name] = true
+		keys[entry.Key] = true
+
+		tenants[i] = &server.Tenant{
+			Name:      entry.Name,
+			Key:       entry.Key,
+			RateLimit: entry.RateLimit,
+			Quota:     entry.Quota,
+			Hosts:     entry.Hosts,
+			Config: server.TenantConfig{
+				Concurrency:  entry.Concurrency,
+				Timeout:      entry.Timeout,
+				Deadline:     entry.Deadline,
+				MaxAssetSize: entry.MaxAssetSize,
+				MaxTotalSize: entry.MaxTotalSize,
+			},
+		}
+	}
+
+	return tenants, nil
+}
+
+// hostRewrites returns the host rewrites of the configuration.
+func (c config) hostRewrites() (map[string]string, error) {
+	rewrites, ok := c["host-rewrites"]
+	if !ok {
+		return nil, nil
+	}
+
+	b, err := yaml.Marshal(rewrites)
+	if err != nil {
+		return nil, err
+	}
+
+	var hostRewrites map[string]string
+	if err := yaml.UnmarshalStrict(b, &hostRewrites); err != nil {
+		return nil, fmt.Errorf("host-rewrites: %v", err)
+	}
+
+	return hostRewrites, nil
+}
+
+// canonicalizer returns the canonicalization rules of the configuration, or nil if it has none.
+func (c config) canonicalizer() (*antidote.Canonicalizer, error) {
+	rules, ok := c["canonical"]
+	if !ok {
+		return nil, nil
+	}
+
+	b, err := yaml.Marshal(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	var r canonical
+	if err := yaml.UnmarshalStrict(b, &r); err != nil {
+		return nil, fmt.Errorf("canonical: %v", err)
+	}
+
+	trailingSlash := antidote.TrailingSlash(r.TrailingSlash)
+	if r.TrailingSlash == "keep" {
+		trailingSlash = antidote.TrailingSlashKeep
+	}
+
+	switch trailingSlash {
+	case antidote.TrailingSlashKeep, antidote.TrailingSlashStrip, antidote.TrailingSlashAdd:
+	default:
+		return nil, fmt.Errorf("canonical: unknown trailing-slash %q, expected keep, strip or add", r.TrailingSlash)
+	}
+
+	return &antidote.Canonicalizer{
+		StripParams:     r.StripParams,
+		SortQuery:       r.SortQuery,
+		TrailingSlash:   trailingSlash,
+		FollowCanonical: r.FollowCanonical,
+	}, nil
+}
+
+// applyHosts sets the per-host rules and the host rewrites of the configuration on the ingredients, and its
+// canonicalization rules with -canonicalize. Rewrites given with -resolve win over the ones of the configuration.
+func (c config) applyHosts(ingredients *antidote.Ingredients) error {
+	var err error
+	if ingredients.Hosts, err = c.hostRules(); err != nil {
+		return err
+	}
+
+	if ingredients.Canonicalizer != nil {
+		canonicalizer, err := c.canonicalizer()
+		if err != nil {
+			return err
+		}
+		if canonicalizer != nil {
+			ingredients.Canonicalizer = canonicalizer
+		}
+	}
+
+	rewrites, err := c.hostRewrites()
+	if err != nil {
+		return err
+	}
+
+	for from, to := range rewrites {
+		if _, ok := ingredients.HostRewrites[from]; ok {
+			continue
+		}
+		if ingredients.HostRewrites == nil {
+			ingredients.HostRewrites = make(map[string]string)
+		}
+		ingredients.HostRewrites[from] = to
+	}
+
+	return nil
+}
+
+// envName returns the environment variable that overrides a flag, e.g. "strip-js" => "ANTIDOTE_STRIP_JS".
+func envName(name string) string {
+	return "ANTIDOTE_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// parseFlags parses the arguments of a command, then fills in every flag that was not given on the command
+// line from, in order of precedence, the environment and the configuration file given by -config, which is
+// returned.
+func parseFlags(flags *flag.FlagSet, args []string) (config, error) {
+	path := flags.String("config", os.Getenv("ANTIDOTE_CONFIG"), "load flag defaults from this YAML config file")
+	flags.Parse(args)
+
+	c := config{}
+	if *path != "" {
+		var err error
+		if c, err = loadConfig(*path); err != nil {
+			return nil, &usageError{err}
+		}
+	}
+
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] || f.Name == "config" {
+			return
+		}
+
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			var v interface{}
+			if v, ok = c.lookup(flags.Name(), f.Name); !ok {
+				return
+			}
+			value = fmt.Sprint(v)
+		}
+
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = invalidUsage("invalid value %q for %s: %v", value, f.Name, setErr)
+		}
+	})
+
+	return c, err
+}
@@ -0,0 +1,881 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/lansana/antidote"
+	"github.com/lansana/antidote/antidotetest"
+)
+
+// fetchFlags defines the flags shared by every command that cures websites, and returns the ingredients
+// they are parsed into.
+func fetchFlags(flags *flag.FlagSet) *antidote.Ingredients {
+	ingredients := &antidote.Ingredients{}
+	flags.IntVar(&ingredients.Concurrency, "concurrency", 0, "maximum number of assets fetched at the same time (0 means the default of 16)")
+	flags.DurationVar(&ingredients.Timeout, "timeout", 0, "time limit of each HTTP request (0 means no timeout)")
+	flags.Int64Var(&ingredients.MaxAssetSize, "max-asset-size", 0, "fail assets (and the page) larger than this many bytes (0 means no limit)")
+	flags.IntVar(&ingredients.RateLimitRetries, "rate-limit-retries", 0, "retry requests answered with 429 or 503 up to this many times, honoring Retry-After and reducing the concurrency of their host (0 never retries)")
+	flags.DurationVar(&ingredients.Deadline, "deadline", 0, "leave the assets not fetched within this time of the start of the cure as references to their URL (0 means no limit)")
+	flags.Int64Var(&ingredients.MaxTotalSize, "max-total-size", 0, "leave the assets fetched once this many bytes of assets have been as references to their URL (0 means no limit)")
+	flags.BoolVar(&ingredients.Degradation.DropMedia, "drop-media", false, "once -deadline or -max-total-size is exceeded, remove the <video>, <audio>, <object> and <embed> elements")
+	flags.Int64Var(&ingredients.Degradation.DropImagesOver, "drop-images-over", 0, "once -deadline or -max-total-size is exceeded, remove the images not fetched larger than this many bytes (0 drops none)")
+	flags.IntVar(&ingredients.ResumeAttempts, "resume", 0, "resume downloads cut short with Range requests, up to this many times per response (0 never resumes)")
+	flags.BoolVar(&ingredients.Preflight, "preflight", false, "probe the assets of every page with HEAD requests in parallel first, to fetch the smallest first and log their total size with -v")
+	flags.BoolVar(&ingredients.Prefetch, "prefetch", false, "start fetching the stylesheets, scripts and images of every page while it downloads, and the resources its Link headers preload (ignored with -deadline, -max-total-size, -preflight and -selector)")
+	flags.BoolVar(&ingredients.UpgradeInsecure, "upgrade-insecure", false, "fetch assets referenced with http URLs over https instead")
+	flags.BoolVar(&ingredients.RepairMixedContent, "repair-mixed-content", false, "on https pages, fetch assets referenced with http URLs over https, falling back to http for those only reachable over plaintext (listed in the mixedContent of -json)")
+	flags.StringVar((*string)(&ingredients.Integrity), "integrity", "", "check scripts and stylesheets against their integrity attribute: report mismatches, or enforce to leave them remote and fail")
+	flags.Var((*rewritesFlag)(&ingredients.HostRewrites), "resolve", "connect to `host=address` instead of host, e.g. www.example.com=10.0.0.5:8443 (repeatable)")
+	flags.Var(&resolverFlag{&ingredients.Resolver}, "dns", "resolve hostnames with the DNS server at this `address` instead of the system resolver")
+	flags.Var(&scanFlag{ingredients}, "scan", "pipe every asset into this `command` before embedding it, e.g. \"clamdscan --no-summary -\", and fail the assets it exits non-zero for")
+	flags.Var(&canonicalFlag{ingredients}, "canonicalize", "identify pages by their canonical URL, without tracking parameters, with a sorted query and no trailing slash, or that of their <link rel=\"canonical\"> (see canonical in the config file)")
+	flags.Var(&levelFlag{ingredients, antidote.LogError}, "q", "quiet: log nothing but errors")
+	flags.Var(&levelFlag{ingredients, antidote.LogInfo}, "v", "verbose: also log the start and the end of every cure")
+	flags.Var(&levelFlag{ingredients, antidote.LogDebug}, "vv", "debug: also log every HTTP request, with its status, size and duration")
+
+	return ingredients
+}
+
+// rewritesFlag is a repeatable flag of comma-separated host=address rewrites.
+type rewritesFlag map[string]string
+
+func (f *rewritesFlag) String() string {
+	var rewrites []string
+	for from, to := range *f {
+		rewrites = append(rewrites, from+"="+to)
+	}
+	sort.Strings(rewrites)
+
+	return strings.Join(rewrites, ",")
+}
+
+func (f *rewritesFlag) Set(value string) error {
+	if *f == nil {
+		*f = make(rewritesFlag)
+	}
+
+	for _, rewrite := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(rewrite), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("%q is not of the form host=address", rewrite)
+		}
+		(*f)[parts[0]] = parts[1]
+	}
+
+	return nil
+}
+
+// canonicalFlag is a boolean flag setting the canonicalizer of the ingredients to antidote.DefaultCanonicalizer,
+// whose rules the configuration may replace, see config.applyHosts().
+type canonicalFlag struct {
+	ingredients *antidote.Ingredients
+}
+
+func (f *canonicalFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (f *canonicalFlag) String() string {
+	return "false"
+}
+
+func (f *canonicalFlag) Set(value string) error {
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+
+	f.ingredients.Canonicalizer = nil
+	if enabled {
+		canonicalizer := antidote.DefaultCanonicalizer
+		f.ingredients.Canonicalizer = &canonicalizer
+	}
+
+	return nil
+}
+
+// levelFlag is a boolean flag setting the level of the logger of the ingredients.
+type levelFlag struct {
+	ingredients *antidote.Ingredients
+	level       antidote.LogLevel
+}
+
+func (f *levelFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (f *levelFlag) String() string {
+	return "false"
+}
+
+func (f *levelFlag) Set(value string) error {
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+
+	if enabled {
+		f.ingredients.Logger = &antidote.Logger{Level: f.level, Output: os.Stderr}
+	}
+
+	return nil
+}
+
+// resolverFlag is a flag setting a resolver that queries the DNS server at an address.
+type resolverFlag struct {
+	resolver **net.Resolver
+}
+
+func (f *resolverFlag) String() string {
+	return ""
+}
+
+func (f *resolverFlag) Set(value string) error {
+	server := value
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(value, "53")
+	}
+
+	dialer := &net.Dialer{}
+	*f.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+
+	return nil
+}
+
+// scanFlag is a flag scanning every asset with a command, which reads the asset on its standard input and
+// flags it by exiting with a non-zero status. The URL and the Content-Type of the asset are passed in the
+// ANTIDOTE_URL and ANTIDOTE_CONTENT_TYPE environment variables.
+type scanFlag struct {
+	ingredients *antidote.Ingredients
+}
+
+func (f *scanFlag) String() string {
+	return ""
+}
+
+func (f *scanFlag) Set(value string) error {
+	args := strings.Fields(value)
+	if len(args) == 0 {
+		return fmt.Errorf("empty command")
+	}
+
+	f.ingredients.ScanAsset = func(url string, contentType string, body io.Reader) error {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = body
+		cmd.Env = append(os.Environ(), "ANTIDOTE_URL="+url, "ANTIDOTE_CONTENT_TYPE="+contentType)
+
+		output, err := cmd.CombinedOutput()
+		if err != nil && len(bytes.TrimSpace(output)) > 0 {
+			return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// injectFlag is a repeatable flag adding an injection to the ingredients: the content of a CSS, JS or HTML
+// file, or a <meta> tag.
+type injectFlag struct {
+	ingredients *antidote.Ingredients
+	kind        string
+}
+
+func (f *injectFlag) String() string {
+	return ""
+}
+
+func (f *injectFlag) Set(value string) error {
+	var injection antidote.Injection
+
+	switch f.kind {
+	case "meta":
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("%q is not of the form name=content", value)
+		}
+		injection = antidote.InjectMeta(parts[0], parts[1])
+	case "html":
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return fmt.Errorf("%q is not of the form position=file", value)
+		}
+		b, err := ioutil.ReadFile(parts[1])
+		if err != nil {
+			return err
+		}
+		injection = antidote.Injection{Position: antidote.InjectPosition(parts[0]), HTML: string(b)}
+	default:
+		b, err := ioutil.ReadFile(value)
+		if err != nil {
+			return err
+		}
+		if f.kind == "css" {
+			injection = antidote.InjectCSS(string(b))
+		} else {
+			injection = antidote.InjectJS(string(b))
+		}
+	}
+
+	f.ingredients.Inject = append(f.ingredients.Inject, injection)
+	return nil
+}
+
+func cure(args []string) error {
+	flags := flag.NewFlagSet("cure", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: antidote cure [flags] <url>...\n       antidote cure [flags] -f <url list>")
+		flags.PrintDefaults()
+	}
+	ingredients := fetchFlags(flags)
+	flags.StringVar((*string)(&ingredients.Profile), "profile", "", "apply a preset of flags: static for a snapshot without JavaScript, as -strip-js -strip-event-handlers -unwrap-noscript, or email for HTML that can be embedded in an email, as static with -strip-iframes -inline-styles")
+	flags.BoolVar(&ingredients.StripJS, "strip-js", false, "remove every script instead of inlining them")
+	flags.BoolVar(&ingredients.StripEventHandlers, "strip-event-handlers", false, "remove the event handler attributes of every element, e.g. onclick")
+	flags.BoolVar(&ingredients.UnwrapNoscript, "unwrap-noscript", false, "replace every <noscript> with its content, with its stylesheets and images inlined")
+	flags.BoolVar(&ingredients.StripIframes, "strip-iframes", false, "remove every iframe, e.g. embedded videos and ads")
+	flags.BoolVar(&ingredients.InlineStyles, "inline-styles", false, "move the CSS rules into the style attributes of the elements they match, keeping those of @media or :hover in a <style> element")
+	flags.BoolVar(&ingredients.DropStyles, "drop-styles", false, "with -inline-styles, remove every <style> element once inlined, along with the rules that could not be")
+	flags.BoolVar(&ingredients.ImageAttachments, "image-attachments", false, "attach the images to the email written by -format eml, referenced with cid: URLs, instead of inlining them as data URLs")
+	flags.BoolVar(&ingredients.CriticalCSS, "critical-css", false, "drop the CSS rules whose selectors match no element of the page")
+	flags.BoolVar(&ingredients.CustomProperties, "custom-properties", false, "inline the url() of the CSS custom properties declared in style attributes, e.g. style=\"--hero: url(/img/hero.jpg)\", which the stylesheets use with var()")
+	flags.StringVar(&ingredients.Selector, "selector", "", "only keep the elements of the body matching this CSS `selector`, e.g. #main-article, with the CSS rules they need")
+	wrapTemplate := flags.String("wrap", "", "wrap the cured page in the Go html/template of this `file`, which inserts {{.Head}} and {{.Body}} of the page and may use the fields of the snapshot, e.g. {{.URL}}")
+	flags.StringVar(&ingredients.Media.Type, "media", "", "emulate a media type while inlining CSS: print for the print styles of the page, or screen to drop them")
+	flags.StringVar(&ingredients.Media.ColorScheme, "color-scheme", "", "emulate prefers-color-scheme while inlining CSS: dark or light")
+	flags.Var(&injectFlag{ingredients, "css"}, "inject-css", "add the stylesheet of this `file` at the end of the <head>, e.g. print styles (repeatable)")
+	flags.Var(&injectFlag{ingredients, "js"}, "inject-js", "add the script of this `file` at the end of the <body> (repeatable)")
+	flags.Var(&injectFlag{ingredients, "meta"}, "inject-meta", "add a <meta> tag of the form `name=content` at the start of the <head> (repeatable)")
+	flags.Var(&injectFlag{ingredients, "html"}, "inject", "add the HTML of a file at a position of the form `position=file`, where position is head-start, head-end, body-start or body-end (repeatable)")
+	prune := flags.Bool("prune", false, "remove analytics, A/B testing and font loading scripts that can not affect the page (see prune-rules in the config file)")
+	flags.BoolVar(&ingredients.Quarantine, "quarantine", false, "neutralize form submissions, link pings, preconnects and the requests of scripts, e.g. beacons, so that opening the cured page contacts nothing of the original site")
+	flags.BoolVar(&ingredients.SkipImages, "skip-images", false, "leave images as remote references")
+	flags.StringVar((*string)(&ingredients.StructuredData), "structured-data", "", "rewrite the URLs of the JSON-LD and microdata of the page: absolutize to resolve them against the URL of the page, or inline to also inline their images, e.g. logos")
+	flags.Int64Var(&ingredients.InlineLimit, "inline-limit", 0, "leave assets larger than this many bytes as references to their absolute URL (0 inlines every asset)")
+	flags.StringVar(&ingredients.CrossOrigin, "crossorigin", "", "crossorigin attribute of the elements left referencing assets larger than -inline-limit, e.g. anonymous")
+	flags.Int64Var(&ingredients.SpillThreshold, "spill-threshold", 0, "stream inlined assets larger than this many bytes to temporary files instead of memory (0 disables)")
+	flags.StringVar((*string)(&ingredients.Output.Layout), "layout", "", "layout of the HTML: as-is, pretty (indented) or minify")
+	flags.StringVar(&ingredients.Output.Indent, "indent", "", "indentation of every level of -layout pretty (defaults to two spaces)")
+	flags.StringVar((*string)(&ingredients.Output.VoidStyle), "void-style", "", "how void elements are written: slash (<br/>), html (<br>) or xhtml (<br />)")
+	flags.StringVar((*string)(&ingredients.Output.Quotes), "quotes", "", "how attribute values are quoted: double, or minimal to leave them unquoted where allowed")
+	flags.StringVar((*string)(&ingredients.ConditionalComments), "conditional-comments", "", "handle the conditional comments of Internet Explorer, e.g. <!--[if IE]>: cure to inline their stylesheets, scripts and images as well, or strip to remove them")
+	flags.BoolVar(&ingredients.PreserveProlog, "preserve-prolog", false, "keep the doctype, the <html> start tag and the comments around them exactly as they were, for legacy pages")
+	format := flags.String("format", "html", "output format: html, json for the structured snapshot, text for the readable text of the page, markdown (or markdown-dir with images as separate files), dir/zip for the page with its assets as separate files, monolith/singlefile for the HTML as saved by those tools, eml for an email of the page, or mirror for the page and its assets at the paths of their URL's, as mirrored by wget -p -k")
+	out := flags.String("o", "", "write the output to this file (or directory) instead of stdout, or with several URLs, the directory their outputs are written in")
+	list := flags.String("f", "", "cure every URL listed in this file, one per line, or in stdin if - (see -out-template)")
+	outTemplate := flags.String("out-template", "", "name of the output of every URL cured with -f or given as arguments, as a Go template of .Host, .Port, .Path, .Query, .Scheme, .URL and .Index (defaults to {{.Host}}/{{.Path}}.<format>, and ignored by the mirror format)")
+	jobs := flags.Int("jobs", 4, "number of URLs cured at the same time with -f")
+	stateFile := flags.String("state", "", "save the progress of a batch to this file, along with a cache of its assets, and resume from it: interrupted batches only cure the URLs left")
+	dedupe := flags.String("dedupe", "", "in a batch, detect the pages whose main content is a near-duplicate of a page cured before, e.g. of the same template, and skip them, or link their output to that of the first page with a symlink: skip or link")
+	dedupeDistance := flags.Int("dedupe-distance", 3, "maximum number of bits (of 64) the content fingerprints of near-duplicate pages differ by with -dedupe (0 only detects identical content)")
+	indexFile := flags.String("index", "", "record every snapshot written in this SQLite index, for antidote snapshots list and search")
+	jsonReport := flags.Bool("json", false, "print a machine-readable report of the cure (or of every URL of a batch) to stdout, with its status, exit code, asset errors and integrity manifest")
+	signKey := flags.String("sign-key", "", "sign the snapshot with the ed25519 private key in this PEM file (json, dir and zip formats)")
+	screenshot := flags.String("screenshot", "", "also save a screenshot of the cured page next to the output, of the `viewport` or of the full page, rendered with a headless Chrome or Chromium (experimental)")
+	screenshotFormat := flags.String("screenshot-format", "png", "image format of -screenshot: png or webp")
+	browser := flags.String("browser", "", "the Chrome or Chromium executable -screenshot renders pages with (defaults to the first one found in PATH)")
+	browserNoSandbox := flags.Bool("browser-no-sandbox", false, "run the browser of -screenshot without its sandbox, which Chrome can not start as root or in most containers: the scripts of the page then run unconfined")
+	hostStats := flags.Bool("host-stats", false, "print the requests, bytes, errors, average latency and connection reuse of every host to stderr after the cure (or the batch)")
+	graph := flags.String("graph", "", "also write the dependency graph of the assets (page, stylesheets, fonts, images...) to this file, in Graphviz DOT if it ends with .dot or .gv, in JSON otherwise")
+	recordTo := flags.String("record", "", "save every HTTP exchange of the cure to this cassette file, or to a HAR if it ends with .har")
+	replayFrom := flags.String("replay", "", "serve every HTTP request of the cure from this cassette file, or from a HAR if it ends with .har, e.g. exported by a browser, without network access")
+	importFrom := flags.String("import", "", "cure the page saved by monolith or SingleFile in this `file` instead of fetching it, with the URL and the save date it records")
+	c, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if err := c.applyHosts(ingredients); err != nil {
+		return err
+	}
+
+	if *prune {
+		if ingredients.Prune, err = c.pruneRules(); err != nil {
+			return err
+		}
+	}
+
+	if *recordTo != "" && *replayFrom != "" {
+		return invalidUsage("-record and -replay are mutually exclusive")
+	}
+
+	if *replayFrom != "" {
+		fixture, err := loadFixture(*replayFrom)
+		if err != nil {
+			return err
+		}
+		ingredients.Transport = antidotetest.NewPlayer(fixture)
+
+		// The URL of the cassette or the HAR is cured unless another one is given.
+		if flags.NArg() == 0 {
+			ingredients.URL = fixture.URL
+		}
+	}
+
+	var saved *antidote.SavedPage
+	if *importFrom != "" {
+		if saved, err = readSavedPage(*importFrom); err != nil {
+			return err
+		}
+		ingredients.Source = saved.HTML
+
+		// The URL the page was saved from is cured unless another one is given.
+		if flags.NArg() == 0 {
+			if saved.URL == "" {
+				return invalidUsage("%s does not record the URL it was saved from, give it as an argument", *importFrom)
+			}
+			ingredients.URL = saved.URL
+		}
+	}
+
+	var urls []string
+	if *list != "" {
+		if urls, err = readURLs(*list); err != nil {
+			return &usageError{err}
+		}
+	}
+	urls = uniqueURLs(append(urls, flags.Args()...), ingredients.Canonicalizer)
+	batch := *list != "" || *stateFile != "" || len(urls) > 1
+
+	if batch && (*recordTo != "" || *replayFrom != "" || *importFrom != "" || *graph != "") {
+		return invalidUsage("-record, -replay, -import and -graph cure a single URL")
+	}
+
+	if !batch && len(urls) == 0 && ingredients.URL == "" {
+		flags.Usage()
+		return invalidUsage("a URL is required")
+	}
+
+	switch *format {
+	case "html", "json", "text", "markdown", "monolith", "singlefile", "eml":
+	case "dir", "zip", "markdown-dir", "mirror":
+		if *out == "" && !batch {
+			return invalidUsage("-o is required with the %s format", *format)
+		}
+		ingredients.ExtractAssets = true
+	default:
+		return invalidUsage("unknown format %q", *format)
+	}
+
+	if *jsonReport && *out == "" && !batch {
+		return invalidUsage("-json requires -o, as the report is printed to stdout")
+	}
+
+	var key ed25519.PrivateKey
+	if *signKey != "" {
+		if *format != "json" && *format != "dir" && *format != "zip" {
+			return invalidUsage("-sign-key requires the json, dir or zip format")
+		}
+
+		if key, err = readPrivateKey(*signKey); err != nil {
+			return err
+		}
+	}
+
+	var screenshotOptions *antidote.ScreenshotOptions
+	if *screenshot != "" {
+		if *screenshot != "viewport" && *screenshot != "full" {
+			return invalidUsage("unknown -screenshot %q, expected viewport or full", *screenshot)
+		}
+		if *screenshotFormat != "png" && *screenshotFormat != "webp" {
+			return invalidUsage("unknown -screenshot-format %q", *screenshotFormat)
+		}
+		if *out == "" && !batch {
+			return invalidUsage("-screenshot requires -o, as it is saved next to the output")
+		}
+
+		screenshotOptions = &antidote.ScreenshotOptions{
+			Browser:   *browser,
+			Format:    antidote.ScreenshotFormat(*screenshotFormat),
+			FullPage:  *screenshot == "full",
+			NoSandbox: *browserNoSandbox,
+		}
+	}
+
+	var duplicates *antidote.DuplicateDetector
+	switch *dedupe {
+	case "":
+	case "skip", "link":
+		if *dedupeDistance < 0 || *dedupeDistance > 64 {
+			return invalidUsage("-dedupe-distance must be between 0 and 64")
+		}
+		duplicates = &antidote.DuplicateDetector{MaxDistance: *dedupeDistance}
+	default:
+		return invalidUsage("unknown -dedupe %q, expected skip or link", *dedupe)
+	}
+
+	var wrap *htmltemplate.Template
+	if *wrapTemplate != "" {
+		if wrap, err = htmltemplate.ParseFiles(*wrapTemplate); err != nil {
+			return err
+		}
+	}
+
+	var index *antidote.Index
+	if *indexFile != "" {
+		if index, err = openIndex(*indexFile); err != nil {
+			return err
+		}
+	}
+
+	if batch {
+		options := &batchOptions{format: *format, dir: *out, outTemplate: *outTemplate, jobs: *jobs, key: key, jsonReport: *jsonReport, hostStats: *hostStats, index: index, screenshot: screenshotOptions, wrap: wrap, dedupe: *dedupe, duplicates: duplicates}
+		if *stateFile != "" {
+			if options.state, err = loadState(*stateFile); err != nil {
+				return err
+			}
+		}
+
+		return cureAll(ingredients, urls, options)
+	}
+
+	if len(urls) == 1 {
+		ingredients.URL = urls[0]
+	}
+
+	var recorder *antidotetest.Recorder
+	if *recordTo != "" {
+		recorder = antidotetest.NewRecorder(ingredients.URL)
+		ingredients.Transport = recorder
+	}
+
+	a := antidote.New()
+	a.Mix(ingredients)
+
+	start := time.Now()
+	snapshot, err := a.CureToSnapshot()
+	result := &batchResult{URL: ingredients.URL, Output: *out, Snapshot: snapshot, Duration: time.Since(start), Err: err}
+
+	if err == nil {
+		defer snapshot.Close()
+
+		// An imported page is dated by its save, so that it is indexed and written as captured then.
+		if saved != nil && !saved.SavedAt.IsZero() {
+			snapshot.StartedAt = saved.SavedAt
+		}
+
+		if recorder != nil {
+			result.Err = saveFixture(recorder.Fixture, *recordTo)
+		}
+		if result.Err == nil && wrap != nil {
+			result.Err = snapshot.Wrap(wrap)
+		}
+		if result.Err == nil {
+			result.Err = writeSnapshot(snapshot, *format, *out, key)
+		}
+		if result.Err == nil && screenshotOptions != nil {
+			result.Err = writeScreenshot(snapshot, *format, *out, screenshotOptions)
+		}
+		if result.Err == nil && *graph != "" {
+			result.Err = writeGraph(snapshot, *graph)
+		}
+		if result.Err == nil && index != nil {
+			result.Err = indexSnapshot(index, snapshot, *out)
+		}
+	}
+
+	if *jsonReport {
+		if err := printJSON(newReport(result)); err != nil {
+			return err
+		}
+	}
+	if *hostStats {
+		printHostStats(os.Stderr, []*batchResult{result})
+	}
+
+	return result.error()
+}
+
+// batchOptions are the flags of the cure command that apply to a batch.
+type batchOptions struct {
+	format      string
+	dir         string
+	outTemplate string
+	jobs        int
+	key         ed25519.PrivateKey
+	jsonReport  bool
+	hostStats   bool
+	state       *batchState
+	index       *antidote.Index
+	screenshot  *antidote.ScreenshotOptions
+	wrap        *htmltemplate.Template
+	dedupe      string
+	duplicates  *antidote.DuplicateDetector
+
+	// annotate is called with every page cured before its output is written, e.g. to add to its metadata.
+	annotate func(result *batchResult)
+}
+
+// cureAll cures a batch of URLs, writing each one in the format to the name given by the output template in the
+// directory. A summary of the batch is printed to stderr, or the report of every URL to stdout with -json. An
+// error is returned if any URL or asset failed, see batchError().
+//
+// With a state, the progress of the batch is saved after every URL, and the batch can be paused with an
+// interrupt signal. Running it again with the same state resumes it: the URLs already cured are skipped, and
+// the assets fetched before the pause are only downloaded again if they have changed.
+//
+// With -dedupe, the pages whose content is a near-duplicate of a page cured before are skipped or linked to it,
+// see writeDuplicate(). Pages are compared in the order their cures end, and only with those of the same run.
+func cureAll(ingredients *antidote.Ingredients, urls []string, options *batchOptions) error {
+	state := options.state
+	var prior *antidote.Snapshot
+
+	if state != nil {
+		// A batch is resumed with the URLs it was started with, unless others are given.
+		if len(urls) == 0 {
+			urls = state.URLs
+		}
+		state.URLs = urls
+
+		cache, err := antidote.NewDirCache(state.CacheDir)
+		if err != nil {
+			return err
+		}
+		ingredients.Cache = cache
+		ingredients.OnAsset = state.record
+
+		prior = state.prior()
+	}
+
+	if len(urls) == 0 {
+		return invalidUsage("no URL to cure")
+	}
+
+	outTemplate := options.outTemplate
+	if outTemplate == "" {
+		outTemplate = "{{.Host}}/{{.Path}}"
+		switch options.format {
+		case "dir", "markdown-dir":
+		case "text":
+			outTemplate += ".txt"
+		case "markdown":
+			outTemplate += ".md"
+		case "monolith", "singlefile":
+			outTemplate += ".html"
+		default:
+			outTemplate += "." + options.format
+		}
+	}
+
+	tmpl, err := template.New("out-template").Option("missingkey=error").Parse(outTemplate)
+	if err != nil {
+		return invalidUsage("invalid -out-template: %v", err)
+	}
+
+	names, err := outputNames(tmpl, urls)
+	if err != nil {
+		return &usageError{err}
+	}
+
+	// A mirror is written to the root of the directory, where the pages of the batch link to each other.
+	outputs := make(map[string]string)
+	for i, u := range urls {
+		outputs[u] = filepath.Join(options.dir, names[i])
+		if options.format == "mirror" {
+			name, err := antidote.MirrorPath(u, "")
+			if err != nil {
+				return &usageError{err}
+			}
+			outputs[u] = filepath.Join(options.dir, filepath.FromSlash(name))
+		}
+	}
+	pages := urls
+
+	// The outputs are named after the whole batch, so that they keep their name when it is resumed.
+	if state != nil {
+		if err := state.save(); err != nil {
+			return err
+		}
+
+		all := len(urls)
+		if urls = state.pending(); len(urls) < all {
+			fmt.Fprintf(os.Stderr, "Resuming %s: %d of %d URLs left\n", state.path, len(urls), all)
+		}
+		if len(urls) == 0 {
+			return nil
+		}
+
+		pauseOnInterrupt(state)
+	}
+
+	var mu sync.Mutex
+	reports := make(map[string]*report)
+
+	results := cureBatch(ingredients, urls, options.jobs, prior, func(result *batchResult) {
+		if result.Err == nil {
+			defer result.Snapshot.Close()
+
+			result.Output = outputs[result.URL]
+			if options.annotate != nil {
+				options.annotate(result)
+			}
+			if options.duplicates != nil {
+				result.Duplicate = duplicateOf(options.duplicates, result)
+			}
+
+			if result.Duplicate != "" {
+				result.Err = writeDuplicate(result, outputs[result.Duplicate], options.dedupe)
+			} else {
+				if options.wrap != nil {
+					result.Err = result.Snapshot.Wrap(options.wrap)
+				}
+				if result.Err == nil {
+					result.Err = os.MkdirAll(filepath.Dir(result.Output), 0755)
+				}
+				if result.Err == nil && options.format == "mirror" {
+					result.Err = result.Snapshot.WriteMirror(options.dir, pages)
+				} else if result.Err == nil {
+					result.Err = writeSnapshot(result.Snapshot, options.format, result.Output, options.key)
+				}
+				if result.Err == nil && options.screenshot != nil {
+					result.Err = writeScreenshot(result.Snapshot, options.format, result.Output, options.screenshot)
+				}
+			}
+		}
+
+		if state != nil && result.Err == nil {
+			if err := state.done(result.URL, result.Output); err != nil {
+				fmt.Fprintln(os.Stderr, "antidote:", err)
+			}
+		}
+
+		if options.index != nil && result.Err == nil && result.Duplicate == "" {
+			if err := indexSnapshot(options.index, result.Snapshot, result.Output); err != nil {
+				fmt.Fprintln(os.Stderr, "antidote:", err)
+			}
+		}
+
+		// The manifest can only be computed before the snapshot is closed.
+		if options.jsonReport {
+			mu.Lock()
+			reports[result.URL] = newReport(result)
+			mu.Unlock()
+		}
+	})
+
+	if options.jsonReport {
+		ordered := make([]*report, len(results))
+		for i, result := range results {
+			ordered[i] = reports[result.URL]
+		}
+		if err := printJSON(ordered); err != nil {
+			return err
+		}
+	} else {
+		printSummary(os.Stderr, results)
+	}
+	if options.hostStats {
+		printHostStats(os.Stderr, results)
+	}
+
+	return batchError(results)
+}
+
+// pauseOnInterrupt saves the state of the batch and exits when the process is interrupted, so that the batch
+// can be resumed later.
+func pauseOnInterrupt(state *batchState) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+
+		if err := state.save(); err != nil {
+			fmt.Fprintln(os.Stderr, "antidote:", err)
+			os.Exit(exitFailure)
+		}
+
+		fmt.Fprintf(os.Stderr, "Paused: run the same command again to resume from %s\n", state.path)
+		os.Exit(exitPaused)
+	}()
+}
+
+// printJSON prints a value as indented JSON to stdout.
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// writeScreenshot saves a screenshot of a snapshot written to out next to it: in the directory of the dir
+// formats, or in place of the extension of the file otherwise.
+func writeScreenshot(snapshot *antidote.Snapshot, format string, out string, options *antidote.ScreenshotOptions) error {
+	image, err := snapshot.CaptureScreenshot(*options)
+	if err != nil {
+		return fmt.Errorf("screenshot of %s: %v", snapshot.URL, err)
+	}
+
+	name := strings.TrimSuffix(out, filepath.Ext(out)) + "." + string(options.Format)
+	if format == "dir" || format == "markdown-dir" {
+		name = filepath.Join(out, "screenshot."+string(options.Format))
+	}
+
+	return ioutil.WriteFile(name, image, 0644)
+}
+
+// writeGraph writes the dependency graph of the assets of a snapshot to a file, in DOT or JSON by its extension.
+func writeGraph(snapshot *antidote.Snapshot, name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	graph := snapshot.Graph()
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".dot", ".gv":
+		err = graph.WriteDOT(f)
+	default:
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(graph)
+	}
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// indexSnapshot records a snapshot written to out in the index. The output is recorded as an absolute path, so
+// that it can be found from any directory.
+func indexSnapshot(index *antidote.Index, snapshot *antidote.Snapshot, out string) error {
+	if out != "" {
+		if abs, err := filepath.Abs(out); err == nil {
+			out = abs
+		}
+	}
+
+	_, err := index.Add(snapshot, out)
+	return err
+}
+
+// writeSnapshot signs the snapshot with the key, if any, then writes it in the format to out, or to stdout if
+// out is empty.
+func writeSnapshot(snapshot *antidote.Snapshot, format string, out string, key ed25519.PrivateKey) error {
+	if key != nil {
+		if err := snapshot.Sign(key); err != nil {
+			return err
+		}
+	}
+
+	if format == "dir" {
+		return snapshot.WriteDir(out)
+	}
+
+	if format == "markdown-dir" {
+		return snapshot.WriteMarkdownDir(out, antidote.MarkdownOptions{})
+	}
+
+	if format == "mirror" {
+		return snapshot.WriteMirror(out, nil)
+	}
+
+	var w io.Writer = os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	if format == "zip" {
+		return snapshot.WriteZip(w)
+	}
+
+	if format == "json" {
+		if err := snapshot.Expand(); err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(snapshot)
+	}
+
+	if format == "markdown" {
+		markdown, err := snapshot.Markdown(antidote.MarkdownOptions{})
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(w, markdown)
+		return err
+	}
+
+	if format == "monolith" || format == "singlefile" {
+		return snapshot.WriteSavedPage(w, antidote.SaveTool(format))
+	}
+
+	if format == "eml" {
+		return snapshot.WriteEmail(w)
+	}
+
+	if format == "text" {
+		text, err := snapshot.Text(antidote.TextOptions{})
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(w, text+"\n")
+		return err
+	}
+
+	return snapshot.WriteHTML(w)
+}
+
+// readSavedPage reads a page saved by monolith or SingleFile.
+func readSavedPage(name string) (*antidote.SavedPage, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, &usageError{err}
+	}
+	defer f.Close()
+
+	return antidote.ReadSavedPage(f)
+}
+
+// loadFixture reads the fixture of a HAR if the name ends with .har, or else of a cassette.
+func loadFixture(name string) (*antidotetest.Fixture, error) {
+	if strings.EqualFold(filepath.Ext(name), ".har") {
+		return antidotetest.LoadHAR(name)
+	}
+
+	return antidotetest.LoadCassette(name)
+}
+
+// saveFixture writes a fixture to a HAR if the name ends with .har, or else to a cassette.
+func saveFixture(fixture *antidotetest.Fixture, name string) error {
+	if strings.EqualFold(filepath.Ext(name), ".har") {
+		return fixture.SaveHAR(name)
+	}
+
+	return fixture.SaveCassette(name)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/lansana/antidote"
+)
+
+// encryptionFlags are the flags of the keys the stored snapshots and cache entries are encrypted with.
+type encryptionFlags struct {
+	key            *string
+	keyCommand     *string
+	decryptionKeys *string
+}
+
+func newEncryptionFlags(flags *flag.FlagSet) *encryptionFlags {
+	return &encryptionFlags{
+		key:            flags.String("encryption-key", "", "encrypt the stored jobs and cache entries with AES-GCM with this base64 `key` of 16, 24 or 32 bytes (best set with ANTIDOTE_ENCRYPTION_KEY)"),
+		keyCommand:     flags.String("encryption-key-command", "", "run this `command` to get the base64 encryption key on its standard output instead, e.g. to decrypt it with a key management service"),
+		decryptionKeys: flags.String("decryption-keys", "", "comma-separated base64 `keys` the stored blobs may still be encrypted with, after the encryption key was rotated"),
+	}
+}
+
+// keys returns the encryption keys of the flags, or nil if none is set. The command of -encryption-key-command is
+// run the first time a key is needed.
+func (f *encryptionFlags) keys() (antidote.EncryptionKeys, error) {
+	if *f.key != "" && *f.keyCommand != "" {
+		return nil, invalidUsage("-encryption-key and -encryption-key-command are mutually exclusive")
+	}
+	if *f.key == "" && *f.keyCommand == "" {
+		if *f.decryptionKeys != "" {
+			return nil, invalidUsage("-decryption-keys requires -encryption-key or -encryption-key-command")
+		}
+		return nil, nil
+	}
+
+	var previous [][]byte
+	for _, encoded := range strings.Split(*f.decryptionKeys, ",") {
+		if encoded = strings.TrimSpace(encoded); encoded == "" {
+			continue
+		}
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, invalidUsage("invalid -decryption-keys: %v", err)
+		}
+		previous = append(previous, key)
+	}
+
+	if *f.keyCommand == "" {
+		key, err := decodeKey(*f.key)
+		if err != nil {
+			return nil, invalidUsage("invalid -encryption-key: %v", err)
+		}
+		keys, err := antidote.NewStaticKeys(key, previous...)
+		if err != nil {
+			return nil, invalidUsage("invalid -encryption-key: %v", err)
+		}
+		return keys, nil
+	}
+
+	args := strings.Fields(*f.keyCommand)
+	return &antidote.FetchedKeys{Fetch: func() ([]byte, [][]byte, error) {
+		cmd := exec.Command(args[0], args[1:]...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+
+		output, err := cmd.Output()
+		if err != nil && stderr.Len() > 0 {
+			return nil, nil, fmt.Errorf("%v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		key, err := decodeKey(string(output))
+		return key, previous, err
+	}}, nil
+}
+
+// decodeKey decodes a base64 key, ignoring the whitespace around it.
+func decodeKey(encoded string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+}
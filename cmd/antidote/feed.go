@@ -0,0 +1,152 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"os"
+
+	"github.com/lansana/antidote"
+)
+
+func feed(args []string) error {
+	flags := flag.NewFlagSet("feed", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: antidote feed [flags] -o <file> <feed url>")
+		flags.PrintDefaults()
+	}
+	ingredients := fetchFlags(flags)
+	flags.BoolVar(&ingredients.StripJS, "strip-js", false, "remove every script instead of inlining them")
+	flags.BoolVar(&ingredients.SkipImages, "skip-images", false, "leave images as remote references")
+	prune := flags.Bool("prune", false, "remove analytics, A/B testing and font loading scripts that can not affect the articles (see prune-rules in the config file)")
+	format := flags.String("format", "epub", "output format: epub for an e-book with one chapter per article, or zip for a bundle of cured articles with an index")
+	out := flags.String("o", "", "write the output to this file")
+	limit := flags.Int("limit", 0, "cure only the first this many entries of the feed (0 cures every entry)")
+	jobs := flags.Int("jobs", 4, "number of articles cured at the same time")
+	title := flags.String("title", "", "title of the e-book or bundle (defaults to the title of the feed)")
+	c, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if err := c.applyHosts(ingredients); err != nil {
+		return err
+	}
+
+	if *prune {
+		if ingredients.Prune, err = c.pruneRules(); err != nil {
+			return err
+		}
+	}
+
+	if flags.NArg() != 1 || *out == "" {
+		flags.Usage()
+		return invalidUsage("an output file and exactly one feed URL are required")
+	}
+
+	if *format != "epub" && *format != "zip" {
+		return invalidUsage("unknown format %q", *format)
+	}
+
+	ingredients.URL = flags.Arg(0)
+
+	a := antidote.New()
+	a.Mix(ingredients)
+
+	f, err := a.FetchFeed()
+	if err != nil {
+		return err
+	}
+
+	entries := f.Entries
+	if *limit > 0 && len(entries) > *limit {
+		entries = entries[:*limit]
+	}
+
+	if len(entries) == 0 {
+		return errors.New("the feed has no entries linking to an article")
+	}
+
+	if *title == "" {
+		*title = f.Title
+	}
+	if *title == "" {
+		*title = ingredients.URL
+	}
+
+	urls := make([]string, len(entries))
+	for i, entry := range entries {
+		urls[i] = entry.URL
+	}
+
+	var chapters []antidote.Chapter
+	for i, result := range cureBatch(ingredients, urls, *jobs, nil, nil) {
+		if result.Err != nil {
+			fmt.Fprintf(os.Stderr, "antidote: %s: %v\n", result.URL, result.Err)
+			continue
+		}
+		defer result.Snapshot.Close()
+
+		chapters = append(chapters, antidote.Chapter{Title: entries[i].Title, Snapshot: result.Snapshot})
+	}
+
+	if len(chapters) == 0 {
+		return errors.New("no article of the feed could be cured")
+	}
+
+	w, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if *format == "zip" {
+		err = writeBundle(w, *title, chapters)
+	} else {
+		book := &antidote.Book{Title: *title, Chapters: chapters}
+		err = book.WriteEPUB(w)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Cured %d of %d articles of %s\n", len(chapters), len(entries), ingredients.URL)
+	return w.Close()
+}
+
+// writeBundle writes a zip archive of cured articles, one HTML file per article, along with an index.html
+// linking to every one of them.
+func writeBundle(w io.Writer, title string, chapters []antidote.Chapter) error {
+	archive := zip.NewWriter(w)
+
+	index, err := archive.Create("index.html")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(index, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n<h1>%s</h1>\n<ol>\n", html.EscapeString(title), html.EscapeString(title))
+	for i, chapter := range chapters {
+		name := chapter.Title
+		if name == "" {
+			name = chapter.Snapshot.URL
+		}
+		fmt.Fprintf(index, "<li><a href=\"article-%d.html\">%s</a></li>\n", i+1, html.EscapeString(name))
+	}
+	fmt.Fprint(index, "</ol>\n</body></html>\n")
+
+	for i, chapter := range chapters {
+		f, err := archive.Create(fmt.Sprintf("article-%d.html", i+1))
+		if err != nil {
+			return err
+		}
+
+		if err := chapter.Snapshot.WriteHTML(f); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
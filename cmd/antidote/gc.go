@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lansana/antidote"
+	"github.com/lansana/antidote/server"
+)
+
+// gc collects the garbage of the store of the daemon once, see server.CollectGarbage(), e.g. from a cron job
+// instead of the daemon itself.
+func gc(args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: antidote gc -store <url> [flags]")
+		flags.PrintDefaults()
+	}
+	storage := newStoreFlags(flags, "collect the garbage of the store of the daemon in the bucket at this `URL`, e.g. s3://bucket/prefix or gs://bucket/prefix")
+	var policy server.RetentionPolicy
+	flags.IntVar(&policy.MaxVersions, "max-versions", 0, "keep at most this many finished jobs of the same URL, the most recent ones (0 means no limit)")
+	flags.DurationVar(&policy.MaxAge, "max-age", 0, "delete the jobs and cache entries stored longer ago than this (0 means no limit)")
+	flags.Int64Var(&policy.MaxBytes, "max-bytes", 0, "delete the oldest jobs and cache entries once they take more than this many bytes (0 means no limit)")
+	dryRun := flags.Bool("n", false, "only print the keys that would be deleted")
+	jsonOutput := flags.Bool("json", false, "print the report as JSON")
+	encryption := newEncryptionFlags(flags)
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return invalidUsage("gc takes no arguments")
+	}
+	if *storage.url == "" {
+		return invalidUsage("-store is required")
+	}
+	if policy.MaxVersions == 0 && policy.MaxAge == 0 && policy.MaxBytes == 0 {
+		return invalidUsage("at least one of -max-versions, -max-age or -max-bytes is required")
+	}
+
+	keys, err := encryption.keys()
+	if err != nil {
+		return err
+	}
+
+	s3, err := storage.open()
+	if err != nil {
+		return err
+	}
+
+	// The keys are only needed to rewrite the deltas against the versions deleted, see -store-deltas.
+	var store antidote.ListableStore = s3
+	if keys != nil {
+		store = &antidote.EncryptedStore{Store: s3, Keys: keys}
+	}
+
+	report, err := server.CollectGarbage(store, policy, time.Now(), *dryRun)
+	if report != nil {
+		if *jsonOutput {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(report); err != nil {
+				return err
+			}
+		} else {
+			for _, key := range report.Deleted {
+				fmt.Println(key)
+			}
+			for _, key := range report.Rewritten {
+				fmt.Fprintf(os.Stderr, "%s rewritten in full\n", key)
+			}
+			fmt.Fprintf(os.Stderr, "%d blobs deleted (%d bytes), %d kept (%d bytes)\n", len(report.Deleted), report.DeletedBytes, report.Kept, report.KeptBytes)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lansana/antidote"
+)
+
+// importBookmarks cures every bookmark of a bookmarks file exported by a browser into a directory, with its folders
+// and tags recorded in the metadata of its snapshot.
+func importBookmarks(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: antidote import [flags] -o <directory> <bookmarks file>")
+		flags.PrintDefaults()
+	}
+	ingredients := fetchFlags(flags)
+	flags.BoolVar(&ingredients.StripJS, "strip-js", false, "remove every script instead of inlining them")
+	flags.BoolVar(&ingredients.SkipImages, "skip-images", false, "leave images as remote references")
+	prune := flags.Bool("prune", false, "remove analytics, A/B testing and font loading scripts that can not affect the pages (see prune-rules in the config file)")
+	format := flags.String("format", "dir", "output format of every bookmark: dir for the page with its assets and a manifest.json of its metadata, zip for the same in an archive, json for the structured snapshot, or html, which does not keep the folders and tags")
+	out := flags.String("o", "", "write the outputs to this directory")
+	outTemplate := flags.String("out-template", "", "name of the output of every bookmark, as a Go template of .Host, .Port, .Path, .Query, .Scheme, .URL and .Index (defaults to {{.Host}}/{{.Path}}.<format>)")
+	folder := flags.String("folder", "", "only cure the bookmarks filed in a folder of this `name`, or in its subfolders")
+	jobs := flags.Int("jobs", 4, "number of bookmarks cured at the same time")
+	stateFile := flags.String("state", "", "save the progress of the import to this file, along with a cache of its assets, and resume from it: interrupted imports only cure the bookmarks left")
+	indexFile := flags.String("index", "", "record every snapshot written in this SQLite index, for antidote snapshots list and search")
+	jsonReport := flags.Bool("json", false, "print a machine-readable report of every bookmark to stdout, with its status, exit code, asset errors and integrity manifest")
+	c, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if err := c.applyHosts(ingredients); err != nil {
+		return err
+	}
+
+	if *prune {
+		if ingredients.Prune, err = c.pruneRules(); err != nil {
+			return err
+		}
+	}
+
+	if flags.NArg() != 1 || *out == "" {
+		flags.Usage()
+		return invalidUsage("an output directory and exactly one bookmarks file are required")
+	}
+
+	switch *format {
+	case "html", "json":
+	case "dir", "zip":
+		ingredients.ExtractAssets = true
+	default:
+		return invalidUsage("unknown format %q", *format)
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return &usageError{err}
+	}
+	bookmarks, err := antidote.ReadBookmarks(f)
+	f.Close()
+	if err != nil {
+		return &usageError{err}
+	}
+
+	// A page bookmarked more than once is cured once, with the folders of its first bookmark and the tags of all.
+	var urls []string
+	byURL := make(map[string]*antidote.Bookmark)
+	for i := range bookmarks {
+		bookmark := &bookmarks[i]
+		if *folder != "" && !contains(bookmark.Folders, *folder) {
+			continue
+		}
+
+		first, ok := byURL[bookmark.URL]
+		if !ok {
+			byURL[bookmark.URL] = bookmark
+			urls = append(urls, bookmark.URL)
+			continue
+		}
+		for _, tag := range bookmark.Tags {
+			if !contains(first.Tags, tag) {
+				first.Tags = append(first.Tags, tag)
+			}
+		}
+	}
+	urls = uniqueURLs(urls, ingredients.Canonicalizer)
+
+	if len(urls) == 0 && *folder != "" {
+		return invalidUsage("%s has no bookmark of an http or https URL to cure in a folder named %q", flags.Arg(0), *folder)
+	}
+	if len(urls) == 0 {
+		return invalidUsage("%s has no bookmark of an http or https URL to cure", flags.Arg(0))
+	}
+
+	options := &batchOptions{format: *format, dir: *out, outTemplate: *outTemplate, jobs: *jobs, jsonReport: *jsonReport}
+	options.annotate = func(result *batchResult) {
+		bookmark, ok := byURL[result.URL]
+		if !ok {
+			return
+		}
+
+		metadata := &result.Snapshot.Metadata
+		metadata.Tags, metadata.Folders = bookmark.Tags, bookmark.Folders
+		if metadata.Title == "" {
+			metadata.Title = bookmark.Title
+		}
+	}
+
+	if *indexFile != "" {
+		if options.index, err = openIndex(*indexFile); err != nil {
+			return err
+		}
+	}
+	if *stateFile != "" {
+		if options.state, err = loadState(*stateFile); err != nil {
+			return err
+		}
+	}
+
+	return cureAll(ingredients, urls, options)
+}
+
+// contains reports whether a value is one of values.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
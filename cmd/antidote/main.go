@@ -0,0 +1,106 @@
+// Command antidote cures websites from the command line, or runs the antidote daemon.
+//
+//	antidote cure [flags] <url>
+//	antidote verify [flags] <snapshot>
+//	antidote serve [flags]
+//	antidote record [flags] -o <fixture> <url>
+//	antidote bench [flags] <fixture>...
+//	antidote feed [flags] -o <file> <feed url>
+//	antidote snapshots list|search [flags]
+//	antidote search [flags] <query>
+//	antidote visual-diff [flags] <before.png> <after.png>
+//	antidote audit [flags] <url>
+//	antidote merge [flags] -o <file> <url>...
+//	antidote replay [flags] <directory>
+//	antidote migrate [flags] <snapshot>...
+//	antidote gc [flags] -store <url>
+//	antidote import [flags] -o <directory> <bookmarks file>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+const usage = `Usage:
+  antidote cure [flags] <url>        cure a website and print the HTML
+  antidote verify [flags] <snapshot> verify the signature of a snapshot, or that it is self-contained
+  antidote serve [flags]             run the antidote daemon
+  antidote record [flags] <url>      record a website and its assets into a fixture
+  antidote bench [flags] <fixture>   benchmark cures of recorded fixtures
+  antidote feed [flags] <feed url>   cure every article of an RSS or Atom feed into an EPUB or a bundle
+  antidote snapshots list|search     list or search the snapshots recorded in an index
+  antidote search [flags] <query>    search the text of the snapshots recorded in an index
+  antidote visual-diff <a> <b>       compare two screenshots of a page saved by cure -screenshot
+  antidote audit [flags] <url>       report the first-party, CDN, third-party and tracker origins of a page
+  antidote merge -o <file> <url>...  cure several pages into a single HTML document with a table of contents
+  antidote replay [flags] <dir>      serve the snapshots of a directory at their original paths, with an index
+  antidote migrate <snapshot>...     rewrite snapshots of older format versions in the current one
+  antidote gc -store <url> [flags]   delete the jobs and cache entries of the store of the daemon beyond retention
+  antidote import -o <dir> <file>    cure every bookmark of a bookmarks.html exported by a browser, with its folders and tags
+
+Run 'antidote <command> -h' for the flags of a command.
+
+Exit codes:
+  0  success
+  1  failure
+  2  invalid flags, arguments or input files
+  3  a page could not be fetched
+  4  every page was cured, but some of their assets failed
+  5  a batch was paused, run it again with the same -state to resume it
+  6  the screenshots compared by visual-diff differ
+  7  the snapshot checked by verify -self-contained references external resources
+`
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	var err error
+	switch command, args := flag.Arg(0), flag.Args()[1:]; command {
+	case "cure":
+		err = cure(args)
+	case "verify":
+		err = verify(args)
+	case "serve":
+		err = serve(args)
+	case "record":
+		err = record(args)
+	case "bench":
+		err = bench(args)
+	case "feed":
+		err = feed(args)
+	case "snapshots":
+		err = snapshots(args)
+	case "search":
+		err = search(args)
+	case "visual-diff":
+		err = visualDiff(args)
+	case "audit":
+		err = audit(args)
+	case "merge":
+		err = merge(args)
+	case "replay":
+		err = replay(args)
+	case "migrate":
+		err = migrate(args)
+	case "gc":
+		err = gc(args)
+	case "import":
+		err = importBookmarks(args)
+	default:
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "antidote:", err)
+		os.Exit(exitCode(err))
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lansana/antidote"
+)
+
+// merge cures several URLs and merges them into a single HTML document.
+func merge(args []string) error {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: antidote merge [flags] -o <file> <url>...")
+		flags.PrintDefaults()
+	}
+	ingredients := fetchFlags(flags)
+	flags.BoolVar(&ingredients.SkipImages, "skip-images", false, "leave images as remote references")
+	prune := flags.Bool("prune", false, "remove analytics, A/B testing and font loading scripts that can not affect the pages (see prune-rules in the config file)")
+	out := flags.String("o", "", "write the merged document to this file")
+	jobs := flags.Int("jobs", 4, "number of pages cured at the same time")
+	title := flags.String("title", "", "title of the merged document (defaults to the title of the first page)")
+	c, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if err := c.applyHosts(ingredients); err != nil {
+		return err
+	}
+
+	if *prune {
+		if ingredients.Prune, err = c.pruneRules(); err != nil {
+			return err
+		}
+	}
+
+	if flags.NArg() == 0 || *out == "" {
+		flags.Usage()
+		return invalidUsage("an output file and at least one URL are required")
+	}
+
+	// Scripts are dropped from the merged document.
+	ingredients.StripJS = true
+
+	var chapters []antidote.Chapter
+	for _, result := range cureBatch(ingredients, flags.Args(), *jobs, nil, nil) {
+		if result.Err != nil {
+			fmt.Fprintf(os.Stderr, "antidote: %s: %v\n", result.URL, result.Err)
+			continue
+		}
+		defer result.Snapshot.Close()
+
+		chapters = append(chapters, antidote.Chapter{Snapshot: result.Snapshot})
+	}
+
+	if len(chapters) == 0 {
+		return errors.New("no page could be cured")
+	}
+
+	book := &antidote.Book{Title: *title, Chapters: chapters}
+
+	w, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if err := book.WriteHTML(w); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Merged %d of %d pages into %s\n", len(chapters), flags.NArg(), *out)
+	return w.Close()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/lansana/antidote"
+)
+
+// migrate rewrites snapshots written in an older version of the format in the current one, see
+// antidote.FormatVersion: JSON snapshots, and the manifest of the snapshots written as directories.
+func migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: antidote migrate [flags] <snapshot>...")
+		flags.PrintDefaults()
+	}
+	dryRun := flags.Bool("n", false, "only print the snapshots that would be migrated")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return invalidUsage("at least one snapshot is required")
+	}
+
+	for _, name := range flags.Args() {
+		if err := migrateSnapshot(name, *dryRun); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// migrateSnapshot migrates a JSON snapshot, or the manifest.json of a directory, unless it is up to date.
+func migrateSnapshot(name string, dryRun bool) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	file := name
+	if info.IsDir() {
+		file = filepath.Join(name, "manifest.json")
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var header antidote.FormatHeader
+	if err := json.Unmarshal(b, &header); err != nil {
+		return err
+	}
+	if header.FormatVersion == antidote.FormatVersion {
+		fmt.Printf("%s is up to date\n", name)
+		return nil
+	}
+
+	var migrated interface{}
+	if info.IsDir() {
+		migrated, err = antidote.ReadManifest(bytes.NewReader(b))
+	} else {
+		migrated, err = antidote.ReadSnapshot(bytes.NewReader(b))
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: format version %d to %d\n", name, header.FormatVersion, antidote.FormatVersion)
+	if dryRun {
+		return nil
+	}
+
+	// The same layout as the manifests of Snapshot.WriteDir() and the snapshots of cure -format json.
+	out, err := json.MarshalIndent(migrated, "", "  ")
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		out = append(out, '\n')
+	}
+
+	return ioutil.WriteFile(file, out, 0644)
+}
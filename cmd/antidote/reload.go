@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/lansana/antidote"
+	"github.com/lansana/antidote/server"
+)
+
+// reloader loads the options of the daemon that change often in production: the per-host rules, host rewrites,
+// prune rules and tenants of the config file, and the filter lists of -filter-list. The daemon reloads them on
+// SIGHUP, or once one of their files changes, without restarting. The other flags are only read at startup.
+type reloader struct {
+	// path is the config file, which may be empty.
+	path string
+
+	filterLists []string
+	prune       bool
+
+	// base are the ingredients given by the flags, which the options loaded are applied to.
+	base antidote.Ingredients
+
+	modTimes map[string]time.Time
+}
+
+// load returns the ingredients of the daemon and its tenants with the options of a config file.
+func (r *reloader) load(c config) (antidote.Ingredients, []*server.Tenant, error) {
+	ingredients := r.base
+
+	// The rewrites of -resolve are copied, as those of the config file are added to them.
+	ingredients.HostRewrites = nil
+	for from, to := range r.base.HostRewrites {
+		if ingredients.HostRewrites == nil {
+			ingredients.HostRewrites = make(map[string]string)
+		}
+		ingredients.HostRewrites[from] = to
+	}
+
+	if err := c.applyHosts(&ingredients); err != nil {
+		return ingredients, nil, err
+	}
+
+	if r.prune {
+		var err error
+		if ingredients.Prune, err = c.pruneRules(); err != nil {
+			return ingredients, nil, err
+		}
+	}
+
+	for _, name := range r.filterLists {
+		f, err := os.Open(name)
+		if err != nil {
+			return ingredients, nil, err
+		}
+
+		list, err := antidote.ParseFilterList(filepath.Base(name), f)
+		f.Close()
+		if err != nil {
+			return ingredients, nil, fmt.Errorf("%s: %v", name, err)
+		}
+
+		ingredients.Prune = append(ingredients.Prune, antidote.PruneRule{Name: list.Name, List: list})
+	}
+
+	tenants, err := c.tenants()
+	if err != nil {
+		return ingredients, nil, err
+	}
+
+	return ingredients, tenants, nil
+}
+
+// reload reads the config file and the filter lists again and applies them to the queue. The queue keeps its
+// options if they are invalid.
+func (r *reloader) reload(queue *server.Queue) {
+	// The files are not reloaded again until they change, even if they are invalid.
+	r.modTimes = r.stat()
+
+	c := config{}
+	if r.path != "" {
+		var err error
+		if c, err = loadConfig(r.path); err != nil {
+			log.Printf("antidote not reloaded: %v", err)
+			return
+		}
+	}
+
+	ingredients, tenants, err := r.load(c)
+	if err != nil {
+		log.Printf("antidote not reloaded: %v", err)
+		return
+	}
+
+	queue.Reload(ingredients, tenants)
+	log.Printf("antidote reloaded its configuration")
+}
+
+// watch reloads the options of the queue on SIGHUP, and once the config file or a filter list changes, checking
+// every interval, or never if it is zero, until ctx is done.
+func (r *reloader) watch(ctx context.Context, queue *server.Queue, interval time.Duration) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	r.modTimes = r.stat()
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			r.reload(queue)
+		case <-tick:
+			if r.changed() {
+				r.reload(queue)
+			}
+		}
+	}
+}
+
+// stat returns the modification times of the files of the options.
+func (r *reloader) stat() map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+	for _, name := range append([]string{r.path}, r.filterLists...) {
+		if info, err := os.Stat(name); err == nil && name != "" {
+			modTimes[name] = info.ModTime()
+		}
+	}
+
+	return modTimes
+}
+
+// changed reports whether a file of the options changed since they were loaded.
+func (r *reloader) changed() bool {
+	modTimes := r.stat()
+	if len(modTimes) != len(r.modTimes) {
+		return true
+	}
+
+	for name, modTime := range modTimes {
+		if !modTime.Equal(r.modTimes[name]) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/lansana/antidote/server"
+)
+
+// replay serves the snapshots of a directory at their original paths.
+func replay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: antidote replay [flags] <directory>")
+		flags.PrintDefaults()
+	}
+	addr := flags.String("addr", ":8080", "address to listen on")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return invalidUsage("exactly one directory is required")
+	}
+
+	if info, err := os.Stat(flags.Arg(0)); err != nil || !info.IsDir() {
+		return invalidUsage("%s is not a directory", flags.Arg(0))
+	}
+
+	r, err := server.NewReplay(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("antidote replaying %s on %s, index at /_antidote/", flags.Arg(0), *addr)
+
+	return http.ListenAndServe(*addr, r)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/lansana/antidote"
+)
+
+// Exit codes of the antidote command, so that scripts and CI jobs can tell failures apart.
+const (
+	// exitFailure is returned for any failure without an exit code of its own.
+	exitFailure = 1
+
+	// exitUsage is returned for invalid flags, arguments or input files.
+	exitUsage = 2
+
+	// exitNetwork is returned when a page could not be fetched, or was answered with a status other than 2xx.
+	exitNetwork = 3
+
+	// exitPartial is returned when every page was cured, but some of their assets failed.
+	exitPartial = 4
+
+	// exitPaused is returned when a batch was interrupted after saving its state, to be resumed later.
+	exitPaused = 5
+
+	// exitChanged is returned when two screenshots compared by visual-diff differ.
+	exitChanged = 6
+
+	// exitExternal is returned when a snapshot checked by verify -self-contained references external resources.
+	exitExternal = 7
+)
+
+// usageError is an error caused by invalid flags, arguments or input files.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *usageError) Unwrap() error {
+	return e.err
+}
+
+// invalidUsage returns a usageError with a formatted message.
+func invalidUsage(format string, a ...interface{}) error {
+	return &usageError{fmt.Errorf(format, a...)}
+}
+
+// partialError is returned when pages were cured but some of their assets failed.
+type partialError struct {
+	failed int
+}
+
+func (e *partialError) Error() string {
+	return fmt.Sprintf("%d assets could not be cured", e.failed)
+}
+
+// changedError is returned when two screenshots differ.
+type changedError struct {
+	diff *antidote.VisualDiff
+}
+
+func (e *changedError) Error() string {
+	return fmt.Sprintf("the screenshots differ: SSIM %.4f, %.2f%% of the pixels changed", e.diff.SSIM, e.diff.ChangedRatio*100)
+}
+
+// externalError is returned when a snapshot loads external resources.
+type externalError struct {
+	loaded int
+}
+
+func (e *externalError) Error() string {
+	return fmt.Sprintf("the snapshot is not self-contained: %d external references", e.loaded)
+}
+
+// exitCode returns the exit code of the command for an error.
+func exitCode(err error) int {
+	var usage *usageError
+	var partial *partialError
+	var changed *changedError
+	var external *externalError
+	var urlErr *url.Error
+	var netErr net.Error
+	var truncated *antidote.TruncatedError
+	var status *antidote.StatusError
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.As(err, &partial):
+		return exitPartial
+	case errors.As(err, &changed):
+		return exitChanged
+	case errors.As(err, &external):
+		return exitExternal
+	case errors.As(err, &urlErr), errors.As(err, &netErr), errors.As(err, &truncated), errors.As(err, &status):
+		return exitNetwork
+	default:
+		return exitFailure
+	}
+}
+
+// report is the machine-readable result of curing one URL, printed by -json.
+type report struct {
+	// URL is the URL that was cured.
+	URL string `json:"url"`
+
+	// CanonicalURL is the URL the snapshot is identified by with -canonicalize, if it is not URL.
+	CanonicalURL string `json:"canonicalUrl,omitempty"`
+
+	// DuplicateOf is the URL of the page of the batch this page is a near-duplicate of with -dedupe, in which case
+	// Output is the output of that page with -dedupe skip.
+	DuplicateOf string `json:"duplicateOf,omitempty"`
+
+	// Status is "ok" if the page and all of its assets were cured, "partial" if some of its assets failed, or
+	// "failed" if the page could not be cured.
+	Status string `json:"status"`
+
+	// ExitCode is the exit code the cure of this URL alone would have.
+	ExitCode int `json:"exitCode"`
+
+	// Output is the file (or directory) the result was written to.
+	Output string `json:"output,omitempty"`
+
+	// Duration is how long the cure took, in nanoseconds.
+	Duration time.Duration `json:"duration"`
+
+	// Error is the reason the page could not be cured, if any.
+	Error string `json:"error,omitempty"`
+
+	// AssetErrors are the error messages of every asset that failed to be cured.
+	AssetErrors []string `json:"assetErrors,omitempty"`
+
+	// MixedContent are the URLs of the assets of a page served over https that were fetched over plaintext http.
+	MixedContent []string `json:"mixedContent,omitempty"`
+
+	// Hosts are the statistics of the requests made to every host.
+	Hosts []*antidote.HostStats `json:"hosts,omitempty"`
+
+	// Manifest is the integrity report of the snapshot.
+	Manifest *antidote.Manifest `json:"manifest,omitempty"`
+}
+
+// newReport returns the report of a cure. The snapshot must not be closed yet.
+func newReport(result *batchResult) *report {
+	r := &report{URL: result.URL, DuplicateOf: result.Duplicate, Output: result.Output, Duration: result.Duration}
+
+	if result.Err == nil {
+		if result.Snapshot.URL != result.URL {
+			r.CanonicalURL = result.Snapshot.URL
+		}
+		r.AssetErrors = result.Snapshot.Errors()
+		r.MixedContent = result.Snapshot.MixedContent()
+		r.Hosts = result.Snapshot.Hosts
+		if manifest, err := result.Snapshot.Manifest(); err == nil {
+			r.Manifest = manifest
+		}
+	}
+
+	err := result.error()
+	r.ExitCode = exitCode(err)
+
+	switch {
+	case err == nil:
+		r.Status = "ok"
+	case errors.As(err, new(*partialError)):
+		r.Status = "partial"
+	default:
+		r.Status = "failed"
+		r.Error = err.Error()
+	}
+
+	return r
+}
+
+// batchError returns the error of a batch: the first error of a page that could not be cured, or a partialError
+// counting the failed assets of every page.
+func batchError(results []*batchResult) error {
+	failedPages, failedAssets := 0, 0
+	var first error
+	for _, result := range results {
+		if result.Err != nil {
+			failedPages++
+			if first == nil {
+				first = result.Err
+			}
+			continue
+		}
+		failedAssets += len(result.Snapshot.Errors())
+	}
+
+	if first != nil {
+		return fmt.Errorf("%d of %d URLs could not be cured, the first one with: %w", failedPages, len(results), first)
+	}
+	if failedAssets > 0 {
+		return &partialError{failedAssets}
+	}
+
+	return nil
+}
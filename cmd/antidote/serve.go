@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	pprofhttp "net/http/pprof"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lansana/antidote"
+	"github.com/lansana/antidote/server"
+	"github.com/lansana/antidote/server/antidotepb"
+	"google.golang.org/grpc"
+)
+
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: antidote serve [flags]")
+		flags.PrintDefaults()
+	}
+	defaults := fetchFlags(flags)
+	addr := flags.String("addr", "", "address to listen on (default \":8080\", or \"127.0.0.1:8080\" with -proxy)")
+	grpcAddr := flags.String("grpc-addr", "", "also serve the gRPC API (Cure, Analyze and BatchCure, see server/antidotepb/antidote.proto) on this `address`, authenticated with the keys of the tenants like the HTTP API")
+	workers := flags.Int("workers", 4, "number of cures to run at the same time")
+	capacity := flags.Int("queue", 1024, "number of jobs that may wait to be run")
+	maxFetches := flags.Int("max-fetches", 0, "maximum number of fetches in progress at the same time across all the jobs, handed to the jobs in turn (0 means no limit)")
+	maxBandwidth := flags.Int64("max-bandwidth", 0, "maximum bytes per second fetched across all the jobs (0 means no limit)")
+	retentionPeriod := flags.Duration("retention", time.Hour, "how long finished jobs are kept")
+	coordinator := flags.Bool("coordinator", false, "hand the jobs out to the workers of a cluster (started with -join) instead of curing them")
+	lease := flags.Duration("lease", 10*time.Minute, "with -coordinator, how long a worker has to report a job before it is handed to another one")
+	join := flags.String("join", "", "run as a worker of the cluster of the coordinator at this `URL`, curing the jobs it hands out (-workers at a time)")
+	clusterSecret := flags.String("cluster-secret", "", "with -coordinator or -join, the `secret` the workers of the cluster authenticate with, the same on every daemon")
+	storage := newStoreFlags(flags, "keep finished jobs and the asset cache in the bucket at this `URL`, e.g. s3://bucket/prefix or gs://bucket/prefix")
+	storeURL := storage.url
+	var retention server.RetentionPolicy
+	flags.IntVar(&retention.MaxVersions, "store-max-versions", 0, "with -store, keep at most this many finished jobs of the same URL, the most recent ones (0 means no limit)")
+	flags.DurationVar(&retention.MaxAge, "store-max-age", 0, "with -store, delete the jobs and cache entries stored longer ago than this (0 means no limit)")
+	flags.Int64Var(&retention.MaxBytes, "store-max-bytes", 0, "with -store, delete the oldest jobs and cache entries once they take more than this many bytes (0 means no limit)")
+	storeDeltas := flags.Int("store-deltas", 0, "with -store, keep the snapshot of a job as a delta against the previous version of its URL, up to this many in a row before a full snapshot (0 keeps every snapshot in full)")
+	storeSharedAssets := flags.Bool("store-shared-assets", false, "with -store, keep the images, fonts, scripts and stylesheets inlined into the snapshots of jobs once, shared by the snapshots embedding them")
+	collectInterval := flags.Duration("gc-interval", server.DefaultCollectInterval, "with -store-max-versions, -store-max-age or -store-max-bytes, how often the store is garbage collected")
+	saveToken := flags.String("save-token", "", "enable POST /save, which cures the pages posted by a browser extension with the cookies of the browser, for requests with this bearer `token`")
+	shareKey := flags.String("share-key", "", "enable POST /jobs/{id}/share, which creates expiring links to the snapshot of a job that open without an API key, signed with this `secret` (changing it revokes every link)")
+	maxShareTTL := flags.Duration("max-share-ttl", 0, "with -share-key, the longest a share link may be valid (0 means no limit)")
+	pprof := flags.Bool("pprof", false, "serve the profiles of the runtime at /debug/pprof/, without authentication: do not expose it publicly")
+	drainTimeout := flags.Duration("drain-timeout", 5*time.Minute, "on SIGTERM or SIGINT, how long to wait for the queued cures to finish before exiting")
+	auditFile := flags.String("audit-file", "", "record every cure request to this audit log `file`, one JSON object per line")
+	auditSyslog := flags.String("audit-syslog", "", "record every cure request to the local syslog server with this `tag`")
+	auditURL := flags.String("audit-url", "", "record every cure request by posting it as JSON to this `URL`")
+	visualDiff := flags.Bool("visual-diff", false, "capture a screenshot of the snapshot of every job done and compare it with the previous one of its URL, so that purely visual changes are reported as the visualChange of the job and in the audit log")
+	visualThreshold := flags.Float64("visual-diff-threshold", server.DefaultVisualThreshold, "with -visual-diff, the structural similarity under which a page changed visually")
+	browser := flags.String("browser", "", "the Chrome or Chromium executable -visual-diff renders pages with (defaults to the first one found in PATH)")
+	browserNoSandbox := flags.Bool("browser-no-sandbox", false, "run the browser of -visual-diff without its sandbox, which Chrome can not start as root or in most containers: the scripts of the page then run unconfined")
+	prune := flags.Bool("prune", false, "remove analytics, A/B testing and font loading scripts that can not affect the page (see prune-rules in the config file)")
+	var filterLists listFlag
+	flags.Var(&filterLists, "filter-list", "remove the scripts and stylesheets served from the hosts listed in this `file` (hosts file, one domain per line, or Adblock Plus ||domain^ rules, e.g. EasyPrivacy) (repeatable)")
+	reloadInterval := flags.Duration("reload-interval", 10*time.Second, "how often to check the config file and the filter lists for changes, which are reloaded without restarting, as on SIGHUP (0 only reloads on SIGHUP)")
+	proxy := flags.Bool("proxy", false, "serve a caching forward proxy for development instead of the job API: the pages browsed through it are cured, and the other GET responses cached and open to any origin")
+	proxyCache := flags.String("proxy-cache", "", "with -proxy, keep the cached responses and assets in this `directory` instead of in memory")
+	proxyMaxAge := flags.Duration("proxy-max-age", 0, "with -proxy, how long a cached response is served before it is fetched again (0 keeps them until the proxy stops)")
+	proxyUpgrade := flags.Bool("proxy-upgrade", false, "with -proxy, fetch the http URLs browsed through the proxy over https")
+	proxyPublic := flags.Bool("proxy-public", false, "with -proxy, allow -addr to listen on other interfaces than loopback, which opens the proxy to the network")
+	encryption := newEncryptionFlags(flags)
+	c, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if *maxFetches > 0 || *maxBandwidth > 0 {
+		defaults.Scheduler = antidote.NewFetchScheduler(*maxFetches, *maxBandwidth)
+	}
+
+	reload := &reloader{
+		path:        flags.Lookup("config").Value.String(),
+		filterLists: filterLists,
+		prune:       *prune,
+		base:        *defaults,
+	}
+	loaded, tenants, err := reload.load(c)
+	if err != nil {
+		return &usageError{err}
+	}
+	*defaults = loaded
+
+	if *coordinator && *join != "" {
+		return invalidUsage("-coordinator and -join are mutually exclusive")
+	}
+	if (*coordinator || *join != "") && *clusterSecret == "" {
+		return invalidUsage("-coordinator and -join require -cluster-secret")
+	}
+
+	if (retention.MaxVersions > 0 || retention.MaxAge > 0 || retention.MaxBytes > 0) && *storeURL == "" {
+		return invalidUsage("-store-max-versions, -store-max-age and -store-max-bytes require -store")
+	}
+	if *storeDeltas > 0 && *storeURL == "" {
+		return invalidUsage("-store-deltas requires -store")
+	}
+	if *storeSharedAssets && *storeURL == "" {
+		return invalidUsage("-store-shared-assets requires -store")
+	}
+
+	keys, err := encryption.keys()
+	if err != nil {
+		return err
+	}
+	if keys != nil && *storeURL == "" && (!*proxy || *proxyCache == "") {
+		return invalidUsage("-encryption-key requires -store, or -proxy with -proxy-cache")
+	}
+
+	if *grpcAddr != "" && (*proxy || *join != "") {
+		return invalidUsage("-grpc-addr can not be combined with -proxy or -join")
+	}
+
+	if *proxy {
+		if *coordinator || *join != "" || *storeURL != "" {
+			return invalidUsage("-proxy can not be combined with -coordinator, -join or -store")
+		}
+
+		// The proxy fetches any URL for anyone who can reach it, so it only listens on loopback unless asked.
+		if *addr == "" {
+			*addr = "127.0.0.1:8080"
+		}
+		if !*proxyPublic && !isLoopback(*addr) {
+			return invalidUsage("-proxy only listens on a loopback -addr, such as 127.0.0.1:8080, without -proxy-public")
+		}
+
+		options := server.ProxyOptions{
+			Defaults:        *defaults,
+			MaxAge:          *proxyMaxAge,
+			UpgradeInsecure: *proxyUpgrade,
+		}
+		// The cured pages and the assets they are cured with share the cache.
+		options.Cache = antidote.NewMemoryCache()
+		if *proxyCache != "" {
+			cache, err := antidote.NewDirCache(*proxyCache)
+			if err != nil {
+				return err
+			}
+			options.Cache = cache
+			if keys != nil {
+				options.Cache = &antidote.EncryptedCache{Cache: cache, Keys: keys}
+			}
+		}
+		options.Defaults.Cache = options.Cache
+
+		log.Printf("antidote proxying on %s", *addr)
+
+		return http.ListenAndServe(*addr, server.NewProxy(options))
+	}
+	if *addr == "" {
+		*addr = ":8080"
+	}
+
+	var store antidote.Store
+	if *storeURL != "" {
+		if *join != "" {
+			return invalidUsage("-store is set on the coordinator, not on its workers")
+		}
+
+		s3, err := storage.open()
+		if err != nil {
+			return err
+		}
+
+		store = s3
+		if keys != nil {
+			store = &antidote.EncryptedStore{Store: s3, Keys: keys}
+		}
+	}
+
+	if *join != "" {
+		defaults.Cache = &server.HTTPCache{Coordinator: *join, Secret: *clusterSecret}
+		worker := server.NewWorker(&server.HTTPBroker{Coordinator: *join, Secret: *clusterSecret}, server.WorkerOptions{
+			Concurrency: *workers,
+			Defaults:    *defaults,
+		})
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		log.Printf("antidote working for %s", *join)
+
+		return worker.Run(ctx)
+	}
+
+	var auditLog []server.AuditSink
+	if *auditFile != "" {
+		sink, err := server.NewFileAuditSink(*auditFile)
+		if err != nil {
+			return err
+		}
+		defer sink.Close()
+		auditLog = append(auditLog, sink)
+	}
+	if *auditSyslog != "" {
+		sink, err := newSyslogAuditSink(*auditSyslog)
+		if err != nil {
+			return err
+		}
+		auditLog = append(auditLog, sink)
+	}
+	if *auditURL != "" {
+		auditLog = append(auditLog, &server.HTTPAuditSink{URL: *auditURL})
+	}
+
+	options := server.QueueOptions{
+		Workers:         *workers,
+		Capacity:        *capacity,
+		Retention:       *retentionPeriod,
+		Defaults:        *defaults,
+		Store:           store,
+		StoreRetention:  retention,
+		CollectInterval: *collectInterval,
+		StoreDeltas:     *storeDeltas,
+		ShareAssets:     *storeSharedAssets,
+		Tenants:         tenants,
+		AuditLog:        auditLog,
+	}
+	if *visualDiff {
+		options.VisualDiff = &server.VisualDiffOptions{
+			Screenshot: antidote.ScreenshotOptions{Browser: *browser, NoSandbox: *browserNoSandbox},
+			Threshold:  *visualThreshold,
+		}
+	}
+	if *coordinator {
+		options.Broker = server.NewMemoryBroker(*lease)
+		options.Defaults.Cache = antidote.NewMemoryCache()
+	}
+	if store != nil {
+		options.Defaults.Cache = &antidote.StoreCache{Store: store, Prefix: "cache/"}
+	}
+
+	queue := server.NewQueue(options)
+
+	api := server.New(queue)
+	api.SaveToken = *saveToken
+	api.ShareKey = []byte(*shareKey)
+	api.MaxShareTTL = *maxShareTTL
+	api.ClusterSecret = *clusterSecret
+
+	mux := http.NewServeMux()
+	mux.Handle("/", api)
+	if *pprof {
+		mux.HandleFunc("/debug/pprof/", pprofhttp.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprofhttp.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprofhttp.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprofhttp.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprofhttp.Trace)
+	}
+
+	httpServer := &http.Server{Addr: *addr, Handler: mux}
+	errs := make(chan error, 2)
+	go (func() {
+		errs <- httpServer.ListenAndServe()
+	})()
+
+	log.Printf("antidote listening on %s", *addr)
+
+	if *grpcAddr != "" {
+		listener, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			return err
+		}
+
+		grpcServer := grpc.NewServer()
+		antidotepb.RegisterAntidoteServer(grpcServer, server.NewGRPCServer(queue))
+		go (func() {
+			errs <- grpcServer.Serve(listener)
+		})()
+		// The streams of the jobs left are ended once the queue has been drained.
+		defer grpcServer.Stop()
+
+		log.Printf("antidote serving gRPC on %s", *grpcAddr)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go reload.watch(ctx, queue, *reloadInterval)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	// The queue is drained while the API keeps serving, so that the results of the last cures can still be
+	// fetched and /readyz takes the daemon out of rotation. A second signal exits right away.
+	log.Printf("antidote draining, waiting up to %s for the queued cures to finish", *drainTimeout)
+
+	drained := make(chan struct{})
+	go (func() {
+		queue.Close()
+		close(drained)
+	})()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-drained:
+	case <-time.After(*drainTimeout):
+		log.Printf("antidote exiting with cures still running")
+	case <-signals:
+		log.Printf("antidote exiting with cures still running")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return httpServer.Shutdown(shutdownCtx)
+}
+
+// isLoopback reports whether a listen address only accepts connections from the local machine.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// storeFlags are the flags of the bucket a store is kept in.
+type storeFlags struct {
+	url       *string
+	endpoint  *string
+	region    *string
+	pathStyle *bool
+	insecure  *bool
+}
+
+// newStoreFlags adds the flags of a store to a flag set, with the usage of -store.
+func newStoreFlags(flags *flag.FlagSet, usage string) *storeFlags {
+	return &storeFlags{
+		url:       flags.String("store", "", usage),
+		endpoint:  flags.String("s3-endpoint", "", "with -store, host of an S3-compatible service other than Amazon S3, e.g. minio:9000"),
+		region:    flags.String("s3-region", "", "with -store, region of the bucket (defaults to AWS_REGION, or us-east-1)"),
+		pathStyle: flags.Bool("s3-path-style", false, "with -store, address the bucket in the path of requests instead of the hostname"),
+		insecure:  flags.Bool("s3-insecure", false, "with -store, connect to the endpoint over http instead of https"),
+	}
+}
+
+// open returns the store of the flags.
+func (f *storeFlags) open() (*antidote.S3Store, error) {
+	s3, err := newS3Store(*f.url)
+	if err != nil {
+		return nil, err
+	}
+	if *f.endpoint != "" {
+		s3.Endpoint = *f.endpoint
+	}
+	if *f.region != "" {
+		s3.Region = *f.region
+	}
+	s3.PathStyle = s3.PathStyle || *f.pathStyle
+	s3.Insecure = *f.insecure
+	s3.Client = &http.Client{Timeout: time.Minute}
+
+	return s3, nil
+}
+
+// newS3Store returns the store of a bucket URL, with the credentials of the AWS environment variables. gs://
+// URLs use the XML API of Google Cloud Storage, with HMAC keys as credentials.
+func newS3Store(rawURL string) (*antidote.S3Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, invalidUsage("invalid -store URL %q", rawURL)
+	}
+
+	store := &antidote.S3Store{
+		Bucket:       u.Host,
+		Prefix:       strings.TrimPrefix(u.Path, "/"),
+		Region:       os.Getenv("AWS_REGION"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if store.Prefix != "" && !strings.HasSuffix(store.Prefix, "/") {
+		store.Prefix += "/"
+	}
+
+	switch u.Scheme {
+	case "s3":
+	case "gs":
+		store.Endpoint = "storage.googleapis.com"
+		store.PathStyle = true
+		if store.Region == "" {
+			store.Region = "auto"
+		}
+	default:
+		return nil, invalidUsage("unsupported -store scheme %q, expected s3 or gs", u.Scheme)
+	}
+
+	if store.AccessKey == "" || store.SecretKey == "" {
+		return nil, invalidUsage("-store requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to be set")
+	}
+
+	return store, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lansana/antidote"
+)
+
+// indexDriver is the name of the database/sql driver the snapshot index is opened with. It is only set when
+// antidote is built with a SQLite driver, see sqlite.go.
+var indexDriver string
+
+func snapshots(args []string) error {
+	flags := flag.NewFlagSet("snapshots", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: antidote snapshots list [flags]\n       antidote snapshots search [flags] <text>")
+		flags.PrintDefaults()
+	}
+	indexFile := flags.String("index", "", "the SQLite snapshot index, as written by antidote cure -index")
+	url := flags.String("url", "", "only list the snapshots of exactly this URL")
+	since := flags.String("since", "", "only list the snapshots captured since this date (2006-01-02) or duration ago (24h)")
+	limit := flags.Int("limit", 0, "maximum number of snapshots listed (0 lists every one of them)")
+	jsonOutput := flags.Bool("json", false, "print the snapshots as JSON")
+
+	if len(args) == 0 {
+		flags.Usage()
+		return invalidUsage("a subcommand is required")
+	}
+	subcommand, args := args[0], args[1:]
+
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	query := antidote.IndexQuery{URL: *url, Limit: *limit}
+
+	switch subcommand {
+	case "list":
+		if flags.NArg() != 0 {
+			flags.Usage()
+			return invalidUsage("list takes no arguments")
+		}
+	case "search":
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return invalidUsage("exactly one search text is required")
+		}
+		query.Text = flags.Arg(0)
+	default:
+		flags.Usage()
+		return invalidUsage("unknown subcommand %q", subcommand)
+	}
+
+	if *indexFile == "" {
+		return invalidUsage("-index is required")
+	}
+
+	if *since != "" {
+		var err error
+		if query.Since, err = parseSince(*since); err != nil {
+			return &usageError{err}
+		}
+	}
+
+	index, err := openIndex(*indexFile)
+	if err != nil {
+		return err
+	}
+
+	entries, err := index.Search(query)
+	if err != nil {
+		return err
+	}
+
+	return printEntries(entries, *jsonOutput)
+}
+
+// search runs a full-text search of the text of the snapshots recorded in an index.
+func search(args []string) error {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: antidote search [flags] <query>")
+		flags.PrintDefaults()
+	}
+	indexFile := flags.String("index", "", "the SQLite snapshot index, as written by antidote cure -index")
+	since := flags.String("since", "", "only search the snapshots captured since this date (2006-01-02) or duration ago (24h)")
+	limit := flags.Int("limit", 20, "maximum number of snapshots listed (0 lists every match)")
+	jsonOutput := flags.Bool("json", false, "print the snapshots as JSON")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return invalidUsage("a query is required")
+	}
+
+	if *indexFile == "" {
+		return invalidUsage("-index is required")
+	}
+
+	// Several arguments make a single query, in which every one of their terms must match.
+	query := antidote.IndexQuery{Match: strings.Join(flags.Args(), " "), Limit: *limit}
+
+	if *since != "" {
+		var err error
+		if query.Since, err = parseSince(*since); err != nil {
+			return &usageError{err}
+		}
+	}
+
+	index, err := openIndex(*indexFile)
+	if err != nil {
+		return err
+	}
+
+	entries, err := index.Search(query)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		return printJSON(entries)
+	}
+
+	for _, entry := range entries {
+		fmt.Printf("%s  %s\n", entry.CapturedAt.Local().Format("2006-01-02 15:04"), entry.URL)
+		if entry.Title != "" {
+			fmt.Printf("    %s\n", entry.Title)
+		}
+		if entry.Snippet != "" {
+			fmt.Printf("    %s\n", entry.Snippet)
+		}
+		if entry.Output != "" {
+			fmt.Printf("    %s\n", entry.Output)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+// printEntries prints index entries as a table to stdout, or as JSON.
+func printEntries(entries []antidote.IndexEntry, jsonOutput bool) error {
+	if jsonOutput {
+		return printJSON(entries)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CAPTURED\tURL\tTITLE\tSIZE\tASSETS\tOUTPUT")
+	for _, entry := range entries {
+		assets := fmt.Sprint(entry.Assets)
+		if entry.FailedAssets > 0 {
+			assets += fmt.Sprintf(" (%d failed)", entry.FailedAssets)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", entry.CapturedAt.Local().Format("2006-01-02 15:04"), entry.URL,
+			entry.Title, entry.Size, assets, entry.Output)
+	}
+
+	return w.Flush()
+}
+
+// openIndex opens the snapshot index in a SQLite database file, creating it if needed.
+func openIndex(name string) (*antidote.Index, error) {
+	if indexDriver == "" {
+		return nil, &usageError{fmt.Errorf("antidote was built without SQLite support, rebuild it with -tags sqlite to use -index")}
+	}
+
+	db, err := sql.Open(indexDriver, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer at a time.
+	db.SetMaxOpenConns(1)
+
+	return antidote.OpenIndex(db)
+}
+
+// parseSince parses a date, or a duration before now.
+func parseSince(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("-since %q is neither a date (2006-01-02) nor a duration (24h)", value)
+	}
+
+	return t, nil
+}
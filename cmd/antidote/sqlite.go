@@ -0,0 +1,17 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+// The snapshot index needs a SQLite driver, which is left out of default builds as it is a large dependency.
+// modernc.org/sqlite is a pure Go driver, so antidote still builds without cgo:
+//
+//	go install -tags sqlite ./cmd/antidote
+//
+// It is pinned to v1.17.3 in go.mod, a release that targets Go 1.16 like antidote, as the recent releases require
+// a newer Go.
+import _ "modernc.org/sqlite"
+
+func init() {
+	indexDriver = "sqlite"
+}
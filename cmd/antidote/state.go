@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/lansana/antidote"
+)
+
+// batchState is the progress of a batch, persisted to a file so that an interrupted batch resumes where it left
+// off instead of starting from scratch.
+type batchState struct {
+	// URLs are every URL of the batch, in order.
+	URLs []string `json:"urls"`
+
+	// Done maps the URLs that have been cured to the output they were written to.
+	Done map[string]string `json:"done"`
+
+	// Assets are the assets fetched successfully by every cure of the batch so far, by their URL. Their bytes
+	// are kept in the cache directory under their hash, so interrupted pages are recured without downloading
+	// the assets that have not changed again, see Antidote.Recure().
+	Assets map[string]*antidote.Asset `json:"assets"`
+
+	// CacheDir is the directory of the cache of the batch.
+	CacheDir string `json:"cacheDir"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// loadState reads the state of a batch from a file, or returns a new state if the file does not exist. The
+// cache directory of a new state is named after the file.
+func loadState(path string) (*batchState, error) {
+	s := &batchState{
+		Done:     make(map[string]string),
+		Assets:   make(map[string]*antidote.Asset),
+		CacheDir: path + ".cache",
+		path:     path,
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, invalidUsage("%s: %v", path, err)
+	}
+
+	return s, nil
+}
+
+// pending returns the URLs of the batch that are left to cure. URLs whose output has disappeared since they
+// were cured are cured again.
+func (s *batchState) pending() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var urls []string
+	for _, u := range s.URLs {
+		if output, ok := s.Done[u]; ok {
+			if _, err := os.Stat(output); err == nil {
+				continue
+			}
+		}
+		urls = append(urls, u)
+	}
+
+	return urls
+}
+
+// prior returns a snapshot holding every asset fetched so far, to recure pages with.
+func (s *batchState) prior() *antidote.Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prior := &antidote.Snapshot{Assets: []*antidote.Asset{}}
+	for _, asset := range s.Assets {
+		prior.Assets = append(prior.Assets, asset)
+	}
+
+	return prior
+}
+
+// record adds an asset to the state if it was fetched successfully, see Ingredients.OnAsset.
+func (s *batchState) record(asset *antidote.Asset) {
+	if asset.URL == "" || asset.Hash == "" || asset.Error != "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Assets[asset.URL] = asset
+}
+
+// done marks a URL as cured and saves the state.
+func (s *batchState) done(u string, output string) error {
+	s.mu.Lock()
+	s.Done[u] = output
+	s.mu.Unlock()
+
+	return s.save()
+}
+
+// save writes the state to its file. It is written to a temporary file first, so that an interruption never
+// leaves a truncated state behind.
+func (s *batchState) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.path), ".antidote-state-")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/lansana/antidote"
+)
+
+func verify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: antidote verify [-key <public key>] [-self-contained] <snapshot.json | snapshot directory>")
+		flags.PrintDefaults()
+	}
+	keyFile := flags.String("key", "", "the ed25519 public key in PEM format the snapshot must be signed with")
+	selfContained := flags.Bool("self-contained", false, "check that the snapshot loads nothing from the network once opened, listing its external references otherwise (exit code 7)")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 || *keyFile == "" && !*selfContained {
+		flags.Usage()
+		return invalidUsage("a public key or -self-contained, and exactly one snapshot are required")
+	}
+
+	snapshot, err := readSnapshot(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *keyFile != "" {
+		key, err := readPublicKey(*keyFile)
+		if err != nil {
+			return err
+		}
+
+		if err := antidote.Verify(snapshot, key); err != nil {
+			return err
+		}
+	}
+
+	if *selfContained {
+		report, err := snapshot.Validate()
+		if err != nil {
+			return err
+		}
+
+		if loaded := report.Loaded(); len(loaded) > 0 {
+			for _, ref := range loaded {
+				fmt.Printf("%s\t%s\n", ref.Location, ref.URL)
+			}
+			return &externalError{len(loaded)}
+		}
+	}
+
+	fmt.Printf("OK: %s captured at %s\n", snapshot.URL, snapshot.StartedAt)
+	return nil
+}
+
+// readSnapshot reads a snapshot from a JSON file written with -format json, or from a directory written
+// with -format dir.
+func readSnapshot(name string) (*antidote.Snapshot, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return antidote.ReadDir(name)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	snapshot, err := antidote.ReadSnapshot(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	return snapshot, nil
+}
+
+// readPEM returns the DER bytes of the first PEM block of a file.
+func readPEM(name string) ([]byte, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", name)
+	}
+
+	return block.Bytes, nil
+}
+
+// readPrivateKey reads an ed25519 private key in PKCS #8 PEM format, as written by
+// `openssl genpkey -algorithm ed25519`.
+func readPrivateKey(name string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(name)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 private key", name)
+	}
+
+	return privateKey, nil
+}
+
+// readPublicKey reads an ed25519 public key in PKIX PEM format, as written by `openssl pkey -pubout`.
+func readPublicKey(name string) (ed25519.PublicKey, error) {
+	der, err := readPEM(name)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 public key", name)
+	}
+
+	return publicKey, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"os"
+
+	"github.com/lansana/antidote"
+)
+
+// visualDiff compares two screenshots of a page, as saved by cure -screenshot.
+func visualDiff(args []string) error {
+	flags := flag.NewFlagSet("visual-diff", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: antidote visual-diff [flags] <before.png> <after.png>")
+		flags.PrintDefaults()
+	}
+	threshold := flags.Float64("threshold", 0.99, "structural similarity under which the screenshots differ, from 0 to 1")
+	out := flags.String("o", "", "write an image of the changes, in red over the faded later screenshot, to this PNG file")
+	jsonOutput := flags.Bool("json", false, "print the comparison as JSON")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return invalidUsage("exactly two screenshots are required")
+	}
+
+	var screenshots [2][]byte
+	for i := range screenshots {
+		b, err := ioutil.ReadFile(flags.Arg(i))
+		if err != nil {
+			return &usageError{err}
+		}
+		screenshots[i] = b
+	}
+
+	diff, err := antidote.CompareScreenshots(screenshots[0], screenshots[1])
+	if err != nil {
+		return &usageError{err}
+	}
+
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(f, diff.Image); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	if *jsonOutput {
+		if err := printJSON(diff); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("SSIM %.4f, %d of %d pixels changed (%.2f%%)\n", diff.SSIM, diff.ChangedPixels, diff.Width*diff.Height, diff.ChangedRatio*100)
+	}
+
+	if diff.Changed(*threshold) {
+		return &changedError{diff}
+	}
+
+	return nil
+}
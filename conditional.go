@@ -0,0 +1,141 @@
+package antidote
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// ConditionalComments is how the conditional comments of Internet Explorer are handled, e.g.
+// <!--[if lt IE 9]><link rel="stylesheet" href="ie.css"><![endif]-->, which legacy pages use to serve their
+// stylesheets and scripts to older versions of Internet Explorer. Every other browser treats their content
+// as a comment, so it is left untouched by the cure unless told otherwise.
+type ConditionalComments string
+
+const (
+	// ConditionalCommentsKeep leaves conditional comments as they are, with their content referencing the
+	// original site.
+	ConditionalCommentsKeep ConditionalComments = ""
+
+	// ConditionalCommentsCure cures the content of conditional comments as the rest of the page, e.g. their
+	// stylesheets are inlined, and writes it back into the comment. The conditional comments around the
+	// <html> start tag are left as they are.
+	ConditionalCommentsCure ConditionalComments = "cure"
+
+	// ConditionalCommentsStrip removes conditional comments along with their content, and the markers of the
+	// downlevel-revealed ones, e.g. <![if !IE]>, whose content every browser but Internet Explorer renders
+	// and is kept.
+	ConditionalCommentsStrip ConditionalComments = "strip"
+)
+
+func (c ConditionalComments) validate() error {
+	switch c {
+	case ConditionalCommentsKeep, ConditionalCommentsCure, ConditionalCommentsStrip:
+		return nil
+	default:
+		return fmt.Errorf("unknown conditional comments handling %q", c)
+	}
+}
+
+// conditionalElement is the name of the elements holding the content of conditional comments while it is
+// cured, see Antidote.revealConditionalComments().
+const conditionalElement = "antidote-conditional"
+
+// splitConditionalComment returns the condition and the content of the data of a downlevel-hidden conditional
+// comment, e.g. "[if IE]" and "<link ...>" for "[if IE]><link ...><![endif]". It reports false for other
+// comments, including the markers of downlevel-revealed conditional comments.
+func splitConditionalComment(data string) (condition string, content string, ok bool) {
+	if !strings.HasPrefix(data, "[if ") || !strings.HasSuffix(data, "<![endif]") {
+		return "", "", false
+	}
+
+	end := strings.Index(data, "]>")
+	if end < 0 || end+2 > len(data)-len("<![endif]") {
+		return "", "", false
+	}
+
+	return data[:end+1], data[end+2 : len(data)-len("<![endif]")], true
+}
+
+// revealConditionalComments prepares the conditional comments of the document for the cure, see
+// Ingredients.ConditionalComments. With ConditionalCommentsCure the content of every conditional comment
+// within an element is parsed into a conditionalElement in its place, so that it is cured as the rest of the
+// document, until Antidote.hideConditionalComments() turns it back into a comment.
+func (a *Antidote) revealConditionalComments() {
+	mode := a.ingredients.ConditionalComments
+	if mode == ConditionalCommentsKeep {
+		return
+	}
+
+	var comments []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.CommentNode && isConditionalComment(c.Data) {
+				comments = append(comments, c)
+			}
+			walk(c)
+		}
+	}
+	walk(a.website.Nodes[0])
+
+	for _, comment := range comments {
+		condition, content, hidden := splitConditionalComment(comment.Data)
+
+		if mode == ConditionalCommentsStrip {
+			comment.Parent.RemoveChild(comment)
+			continue
+		}
+
+		if !hidden || comment.Parent.Type != html.ElementNode {
+			continue
+		}
+
+		nodes, err := html.ParseFragment(strings.NewReader(content), comment.Parent)
+		if err != nil {
+			a.warn(err)
+			continue
+		}
+
+		element := &html.Node{
+			Type: html.ElementNode,
+			Data: conditionalElement,
+			Attr: []html.Attribute{{Key: "condition", Val: condition}, {Key: "source", Val: comment.Data}},
+		}
+		for _, node := range nodes {
+			element.AppendChild(node)
+		}
+
+		comment.Parent.InsertBefore(element, comment)
+		comment.Parent.RemoveChild(comment)
+	}
+}
+
+// hideConditionalComments turns the content of the conditional comments revealed by
+// Antidote.revealConditionalComments() back into comments, once it has been cured.
+func (a *Antidote) hideConditionalComments() {
+	if a.ingredients.ConditionalComments != ConditionalCommentsCure {
+		return
+	}
+
+	a.website.Find(conditionalElement).Each(func(index int, element *goquery.Selection) {
+		condition, _ := element.Attr("condition")
+		data, _ := element.Attr("source")
+
+		// The cured content is kept unless it would end the comment early, e.g. a script holding "-->".
+		content, err := element.Html()
+		if err != nil {
+			a.warn(err)
+		} else if strings.Contains(content, "-->") {
+			a.log(LogWarn, "conditional comment left uncured", "condition", condition)
+		} else {
+			data = condition + ">" + content + "<![endif]"
+		}
+
+		node := element.Nodes[0]
+		node.Parent.InsertBefore(&html.Node{Type: html.CommentNode, Data: data}, node)
+		node.Parent.RemoveChild(node)
+	})
+}
@@ -0,0 +1,368 @@
+package antidote
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// groupingRules are the at-rules whose block holds other rules, which are pruned in turn. The blocks of
+// other at-rules (e.g. @font-face or @keyframes) are always kept.
+var groupingRules = map[string]bool{
+	"@container": true,
+	"@document":  true,
+	"@layer":     true,
+	"@media":     true,
+	"@supports":  true,
+}
+
+// structuralPseudoClasses are the pseudo-classes matched against the document as they are. Every other
+// pseudo-class or pseudo-element (e.g. :hover or ::before) depends on the state of the page in the browser,
+// so it is left out of the selector, which then matches every element it could apply to.
+var structuralPseudoClasses = map[string]bool{
+	"empty":            true,
+	"first-child":      true,
+	"first-of-type":    true,
+	"has":              true,
+	"last-child":       true,
+	"last-of-type":     true,
+	"not":              true,
+	"nth-child":        true,
+	"nth-last-child":   true,
+	"nth-last-of-type": true,
+	"nth-of-type":      true,
+	"only-child":       true,
+	"only-of-type":     true,
+	"root":             true,
+}
+
+// maxCompiledSelectors is the number of selectors kept in compiledSelectors, so that a daemon curing pages of
+// every site does not grow it without bound.
+const maxCompiledSelectors = 16384
+
+// compiledSelectors caches the selectors compiled by selectorMatcher across cures, as the pages of a batch share
+// their stylesheets, e.g. those of a CSS framework, whose thousands of selectors are otherwise compiled again for
+// every page. The least recently used selectors are evicted once it is full, so that those of the frameworks in
+// use stay cached. Selectors that can not be compiled are cached as nil.
+var compiledSelectors = &selectorCache{
+	elements: make(map[string]*list.Element),
+	order:    list.New(),
+	max:      maxCompiledSelectors,
+}
+
+// selectorCache is a cache of compiled selectors bounded to its max most recently used ones.
+type selectorCache struct {
+	elements map[string]*list.Element
+	order    *list.List
+	max      int
+	mu       sync.Mutex
+}
+
+// compiledSelector is an element of the order of a selectorCache, most recently used first.
+type compiledSelector struct {
+	selector string
+	compiled cascadia.Selector
+}
+
+// get returns a selector from the cache, and reports whether it was cached.
+func (c *selectorCache) get(selector string) (cascadia.Selector, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.elements[selector]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+
+	return element.Value.(*compiledSelector).compiled, true
+}
+
+// add caches a selector, evicting the least recently used one if the cache is full.
+func (c *selectorCache) add(selector string, compiled cascadia.Selector) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.elements[selector]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.elements[selector] = c.order.PushFront(&compiledSelector{selector: selector, compiled: compiled})
+	if c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.elements, oldest.Value.(*compiledSelector).selector)
+	}
+}
+
+// cachedSelector compiles a selector, or returns it from compiledSelectors. It reports false if the selector
+// can not be compiled.
+func cachedSelector(selector string) (cascadia.Selector, bool) {
+	if compiled, ok := compiledSelectors.get(selector); ok {
+		return compiled, compiled != nil
+	}
+
+	compiled, err := cascadia.Compile(selector)
+	if err != nil {
+		compiled = nil
+	}
+	compiledSelectors.add(selector, compiled)
+
+	return compiled, compiled != nil
+}
+
+// selectorMatcher reports whether selectors match an element of a document, caching the result of every
+// selector as the same ones are repeated across stylesheets. It is safe for concurrent use, as long as the
+// document is not written to.
+type selectorMatcher struct {
+	root    *html.Node
+	matches map[string]bool
+	mu      sync.Mutex
+}
+
+func newSelectorMatcher(root *html.Node) *selectorMatcher {
+	return &selectorMatcher{root: root, matches: make(map[string]bool)}
+}
+
+// used reports whether a selector may apply to an element of the document. Selectors that can not be
+// matched are considered used.
+func (m *selectorMatcher) used(selector string) bool {
+	selector = matchableSelector(selector)
+	if selector == "" {
+		return true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if used, ok := m.matches[selector]; ok {
+		return used
+	}
+
+	compiled, ok := cachedSelector(selector)
+	used := !ok || compiled.MatchFirst(m.root) != nil
+	m.matches[selector] = used
+
+	return used
+}
+
+// criticalCSS removes the rules of a stylesheet that apply to no element of the document, see
+// Ingredients.CriticalCSS.
+func (a *Antidote) criticalCSS(css string) string {
+	return pruneCSS(css, func(selectors string) bool {
+		for _, selector := range splitCSS(selectors, ',') {
+			if a.matcher.used(selector) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// pruneCSS removes the style rules of a stylesheet for which used returns false, given their selector list.
+// The rules of grouping at-rules such as @media are pruned as well, and the at-rules dropped once empty.
+// Every other at-rule is kept, as is anything that can not be parsed.
+func pruneCSS(css string, used func(selectors string) bool) string {
+	var b strings.Builder
+
+	for i := 0; i < len(css); {
+		open := scanCSS(css, i, "{;")
+		if open == len(css) || css[open] == ';' {
+			end := open + 1
+			if end > len(css) {
+				end = len(css)
+			}
+			b.WriteString(css[i:end])
+			i = end
+			continue
+		}
+
+		close := scanCSS(css, open+1, "}")
+		if close == len(css) {
+			b.WriteString(css[i:])
+			break
+		}
+
+		prelude := strings.TrimSpace(stripCSSComments(css[i:open]))
+		switch {
+		case strings.HasPrefix(prelude, "@"):
+			name := strings.ToLower(strings.FieldsFunc(prelude, func(r rune) bool {
+				return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' || r == '('
+			})[0])
+
+			if !groupingRules[name] {
+				b.WriteString(css[i : close+1])
+				break
+			}
+
+			if rules := pruneCSS(css[open+1:close], used); strings.TrimSpace(rules) != "" {
+				b.WriteString(css[i : open+1])
+				b.WriteString(rules)
+				b.WriteString("}")
+			}
+		case used(prelude):
+			b.WriteString(css[i : close+1])
+		}
+
+		i = close + 1
+	}
+
+	return b.String()
+}
+
+// scanCSS returns the index of the first of the delimiters found at the top level of css from index i,
+// skipping comments, strings, escapes and nested blocks, or len(css) if there is none.
+func scanCSS(css string, i int, delimiters string) int {
+	depth := 0
+
+	for i < len(css) {
+		c := css[i]
+
+		switch {
+		case depth == 0 && strings.IndexByte(delimiters, c) >= 0:
+			return i
+		case c == '\\':
+			i += 2
+			continue
+		case c == '"' || c == '\'':
+			i = skipCSSString(css, i)
+			continue
+		case strings.HasPrefix(css[i:], "/*"):
+			end := strings.Index(css[i+2:], "*/")
+			if end < 0 {
+				return len(css)
+			}
+			i += end + 4
+			continue
+		case c == '{' || c == '(' || c == '[':
+			depth++
+		case (c == '}' || c == ')' || c == ']') && depth > 0:
+			depth--
+		}
+
+		i++
+	}
+
+	return len(css)
+}
+
+// skipCSSString returns the index following the string starting at index i of css.
+func skipCSSString(css string, i int) int {
+	quote := css[i]
+
+	for i++; i < len(css); i++ {
+		switch css[i] {
+		case '\\':
+			i++
+		case quote, '\n':
+			return i + 1
+		}
+	}
+
+	return len(css)
+}
+
+// stripCSSComments removes the comments of css.
+func stripCSSComments(css string) string {
+	for {
+		start := strings.Index(css, "/*")
+		if start < 0 {
+			return css
+		}
+
+		end := strings.Index(css[start+2:], "*/")
+		if end < 0 {
+			return css[:start]
+		}
+
+		css = css[:start] + " " + css[start+2+end+2:]
+	}
+}
+
+// splitCSS splits css at every top-level occurrence of sep, see scanCSS().
+func splitCSS(css string, sep byte) []string {
+	var parts []string
+
+	for {
+		i := scanCSS(css, 0, string(sep))
+		parts = append(parts, css[:i])
+		if i == len(css) {
+			return parts
+		}
+		css = css[i+1:]
+	}
+}
+
+// matchableSelector returns a selector without the pseudo-classes and pseudo-elements that depend on the
+// state of the page, e.g. "a.nav:hover::after" becomes "a.nav", and is empty if nothing else is left.
+func matchableSelector(selector string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(selector); {
+		c := selector[i]
+
+		switch {
+		case c == '\\':
+			end := i + 2
+			if end > len(selector) {
+				end = len(selector)
+			}
+			b.WriteString(selector[i:end])
+			i = end
+		case c == '"' || c == '\'':
+			end := skipCSSString(selector, i)
+			b.WriteString(selector[i:end])
+			i = end
+		case c == '[':
+			end := scanCSS(selector, i+1, "]") + 1
+			if end > len(selector) {
+				end = len(selector)
+			}
+			b.WriteString(selector[i:end])
+			i = end
+		case c == ':':
+			start := i
+			for i < len(selector) && selector[i] == ':' {
+				i++
+			}
+
+			nameStart := i
+			for i < len(selector) && isCSSNameChar(selector[i]) {
+				i++
+			}
+			name := strings.ToLower(selector[nameStart:i])
+
+			if i < len(selector) && selector[i] == '(' {
+				i = scanCSS(selector, i+1, ")") + 1
+				if i > len(selector) {
+					i = len(selector)
+				}
+			}
+
+			if structuralPseudoClasses[name] && selector[start+1] != ':' {
+				b.WriteString(selector[start:i])
+			}
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	matchable := strings.TrimSpace(b.String())
+
+	// A combinator left dangling by a removed pseudo-element, e.g. "ul > :hover", applies to every element.
+	if strings.HasSuffix(matchable, ">") || strings.HasSuffix(matchable, "+") || strings.HasSuffix(matchable, "~") {
+		matchable += " *"
+	}
+
+	return matchable
+}
+
+// isCSSNameChar reports whether c may be part of a CSS identifier.
+func isCSSNameChar(c byte) bool {
+	return c == '-' || c == '_' || c >= 0x80 || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
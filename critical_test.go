@@ -0,0 +1,98 @@
+package antidote_test
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lansana/antidote"
+	"github.com/lansana/antidote/antidotetest"
+)
+
+// largePageFixture returns a fixture of a page with as many distinct images as images, and a stylesheet with as
+// many rules as selectors, as pulled in by a CSS framework: a tenth of the rules select the elements of the
+// images, up to the number of images.
+func largePageFixture(images int, selectors int) (*antidotetest.Fixture, error) {
+	f := antidotetest.NewFixture("https://large.antidote.test/")
+
+	var css strings.Builder
+	for i := 0; i < selectors; i++ {
+		if i%10 == 0 {
+			fmt.Fprintf(&css, ".card-%d > img:hover, #item-%d { margin: %dpx; }\n", i/10, i/10, i%7)
+		} else {
+			fmt.Fprintf(&css, ".unused-%d .child:not(.other) > span { padding: %dpx; }\n", i, i%5)
+		}
+	}
+	f.Add(&antidotetest.Response{
+		URL:        f.URL + "css/framework.css",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/css"}},
+		Body:       []byte(css.String()),
+	})
+
+	var page strings.Builder
+	fmt.Fprintf(&page, `<!DOCTYPE html><html><head><link rel="stylesheet" href="%scss/framework.css"></head><body>`, f.URL)
+	for i := 0; i < images; i++ {
+		var body bytes.Buffer
+		if err := png.Encode(&body, image.NewGray(image.Rect(0, 0, 1+i%16, 1+i/16))); err != nil {
+			return nil, err
+		}
+
+		f.Add(&antidotetest.Response{
+			URL:        fmt.Sprintf("%simages/%d.png", f.URL, i),
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"image/png"}},
+			Body:       body.Bytes(),
+		})
+		fmt.Fprintf(&page, `<div class="card-%d" id="item-%d"><img src="%simages/%d.png" alt="Image %d"></div>`, i, i, f.URL, i, i)
+	}
+	page.WriteString(`</body></html>`)
+
+	f.Add(&antidotetest.Response{
+		URL:        f.URL,
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		Body:       []byte(page.String()),
+	})
+
+	return f, nil
+}
+
+func BenchmarkCureLargePage(b *testing.B) {
+	fixture, err := largePageFixture(300, 6000)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	antidotetest.BenchmarkCure(b, b.N, fixture, antidote.Ingredients{CriticalCSS: true})
+}
+
+func TestCriticalCSS(t *testing.T) {
+	fixture, err := largePageFixture(30, 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := antidotetest.Replay(fixture, antidote.Ingredients{CriticalCSS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snapshot.Close()
+
+	if errs := snapshot.Errors(); len(errs) > 0 {
+		t.Fatalf("assets failed to be cured: %q", errs)
+	}
+	if got := strings.Count(snapshot.HTML, "data:image/png;base64,"); got != 30 {
+		t.Errorf("%d images are inlined, want 30", got)
+	}
+	if !strings.Contains(snapshot.HTML, ".card-29 > img:hover") {
+		t.Errorf("the rules of the selectors matching the page were dropped")
+	}
+	if strings.Contains(snapshot.HTML, ".card-30 ") || strings.Contains(snapshot.HTML, ".unused-") {
+		t.Errorf("the rules of the selectors matching nothing were kept")
+	}
+}
@@ -0,0 +1,326 @@
+package antidote
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// cssReferencePattern matches the references of a stylesheet to other resources: @import rules (groups 1-5
+// hold the URL, group 6 the media query list) and url() functions (groups 7-9 hold the URL).
+var cssReferencePattern = regexp.MustCompile(
+	`@import\s+(?:url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]*))\s*\)|"([^"]*)"|'([^']*)')([^;]*);` +
+		`|url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]*))\s*\)`,
+)
+
+// imageFunctionPattern matches the start of the functions of CSS images whose candidates may be strings
+// rather than url() functions: image-set(), image() and cross-fade(), and their -webkit- prefixed variants.
+var imageFunctionPattern = regexp.MustCompile(`(?i)(?:-webkit-)?(?:image-set|image|cross-fade)\(`)
+
+// fontTypes are the MIME types of the fonts by their lowercased extension. The sub-resources of a stylesheet
+// with these extensions are recorded as fonts.
+var fontTypes = map[string]string{
+	".eot":   "application/vnd.ms-fontobject",
+	".otf":   "font/otf",
+	".ttf":   "font/ttf",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+}
+
+// cssReference object represents a reference of a stylesheet to another resource.
+type cssReference struct {
+	url      string
+	isImport bool
+	media    string
+}
+
+// parseCSSReference converts a submatch of cssReferencePattern into a cssReference.
+func parseCSSReference(match []string) cssReference {
+	for i := 1; i <= 5; i++ {
+		if match[i] != "" {
+			return cssReference{url: match[i], isImport: true, media: strings.TrimSpace(match[6])}
+		}
+	}
+
+	return cssReference{url: match[7] + match[8] + match[9]}
+}
+
+// inlinable reports whether a reference points to a resource that can be fetched and inlined.
+func (r cssReference) inlinable() bool {
+	return r.url != "" && !strings.HasPrefix(r.url, "data:") && !strings.HasPrefix(r.url, "#")
+}
+
+// cssReferences returns every inlinable reference of a stylesheet.
+func cssReferences(css string) []cssReference {
+	var refs []cssReference
+	for _, match := range cssReferencePattern.FindAllStringSubmatch(css, -1) {
+		if ref := parseCSSReference(match); ref.inlinable() {
+			refs = append(refs, ref)
+		}
+	}
+
+	return refs
+}
+
+// normalizeImageFunctions wraps the string candidates of the image functions of a stylesheet in url(), e.g.
+// image-set("a.png" 1x, "a@2x.png" 2x) becomes image-set(url("a.png") 1x, url("a@2x.png") 2x), which is
+// equivalent, so that every candidate is inlined like the other url() functions. The strings of the functions
+// nested in their arguments, e.g. type("image/avif"), are left as they are.
+func normalizeImageFunctions(css string) string {
+	locations := imageFunctionPattern.FindAllStringIndex(css, -1)
+	if len(locations) == 0 {
+		return css
+	}
+
+	var b strings.Builder
+	last, i := 0, 0
+	for _, location := range locations {
+		// The functions nested in the arguments of another are normalized along with it.
+		start, argsStart := location[0], location[1]
+		if start < last {
+			continue
+		}
+
+		// Matches within strings and comments, e.g. content: "image(...)", are not functions.
+		for i < start {
+			switch {
+			case css[i] == '\\':
+				i += 2
+			case css[i] == '"' || css[i] == '\'':
+				i = skipCSSString(css, i)
+			case strings.HasPrefix(css[i:], "/*"):
+				end := strings.Index(css[i+2:], "*/")
+				if end < 0 {
+					i = len(css)
+				} else {
+					i += end + 4
+				}
+			default:
+				i++
+			}
+		}
+		if i > start || start > 0 && isCSSNameChar(css[start-1]) {
+			continue
+		}
+
+		argsEnd := scanCSS(css, argsStart, ")")
+		b.WriteString(css[last:argsStart])
+		b.WriteString(wrapImageCandidates(normalizeImageFunctions(css[argsStart:argsEnd])))
+		last, i = argsEnd, argsEnd
+	}
+	b.WriteString(css[last:])
+
+	return b.String()
+}
+
+// wrapImageCandidates wraps the top-level strings of the arguments of an image function in url().
+func wrapImageCandidates(args string) string {
+	var b strings.Builder
+	for i := 0; i < len(args); {
+		start := scanCSS(args, i, `"'`)
+		b.WriteString(args[i:start])
+		if start == len(args) {
+			break
+		}
+
+		end := skipCSSString(args, start)
+		b.WriteString("url(" + args[start:end] + ")")
+		i = end
+	}
+
+	return b.String()
+}
+
+// rewriteCSS replaces every reference of a stylesheet for which replace returns true.
+func rewriteCSS(css string, replace func(ref cssReference) (string, bool)) string {
+	return cssReferencePattern.ReplaceAllStringFunc(css, func(match string) string {
+		ref := parseCSSReference(cssReferencePattern.FindStringSubmatch(match))
+		if !ref.inlinable() {
+			return match
+		}
+
+		if replacement, ok := replace(ref); ok {
+			return replacement
+		}
+		return match
+	})
+}
+
+// remoteImport takes the place of the CSS of an imported stylesheet larger than Ingredients.InlineLimit.
+const remoteImport = "\x00remote"
+
+// cureStylesheet inlines every resource referenced by a stylesheet, then calls done with the cured CSS.
+// Imported stylesheets replace their @import rule, and every url() is converted to a data URL, as are the
+// candidates of image-set() and the other image functions, see normalizeImageFunctions(). The
+// resources are fetched by tasks scheduled on the pipeline, so done may be called from any worker.
+// Imports already in the chain of the stylesheet are skipped to break cycles. References are resolved
+// against base, the URL the stylesheet was served from, or the URL of the page if it is nil (<style>).
+//
+// With Ingredients.ExtractAssets the resources are extracted instead, and the references rewritten to
+// point at the extracted files from dir, the directory of the assets relative to the stylesheet.
+//
+// With Ingredients.CriticalCSS (or Ingredients.Selector) the unused rules are dropped first, so the resources
+// only they reference are never fetched, as are those of the media queries never matching Ingredients.Media.
+//
+// With Ingredients.TransformCache the cured stylesheet is reused from the cache when the same CSS has already
+// been cured with the same ingredients, see Antidote.transformKey().
+func (a *Antidote) cureStylesheet(p *pipeline, css string, base *url.URL, chain map[string]bool, dir string, done func(string)) {
+	if key := a.transformKey(css, base, chain); key != "" {
+		if cured, ok := a.reuseStylesheet(key); ok {
+			done(cured)
+			return
+		}
+		done = a.cacheStylesheet(key, base, done)
+	}
+
+	if a.ingredients.Media.enabled() {
+		css = a.emulateMedia(css)
+	}
+	if a.matcher != nil {
+		css = a.criticalCSS(css)
+	}
+	css = normalizeImageFunctions(css)
+
+	refs := cssReferences(css)
+	if len(refs) == 0 {
+		done(css)
+		return
+	}
+
+	var mu sync.Mutex
+	imports := make(map[string]string)
+	urls := make(map[string]string)
+	remaining := len(refs)
+
+	finish := func(ref cssReference, cured string) {
+		mu.Lock()
+		if cured != "" {
+			if ref.isImport {
+				imports[ref.url] = cured
+			} else {
+				urls[ref.url] = cured
+			}
+		}
+		remaining--
+		last := remaining == 0
+		mu.Unlock()
+
+		if !last {
+			return
+		}
+
+		done(rewriteCSS(css, func(ref cssReference) (string, bool) {
+			if !ref.isImport {
+				cured, ok := urls[ref.url]
+				return fmt.Sprintf(`url("%s")`, cured), ok
+			}
+
+			imported, ok := imports[ref.url]
+			if imported == remoteImport {
+				return strings.TrimSpace(fmt.Sprintf(`@import url("%s") %s`, a.remoteURL(base, ref.url), ref.media)) + ";", true
+			}
+			if ok && a.ingredients.ExtractAssets {
+				importURL, _ := a.assetURL(base, ref.url)
+				return strings.TrimSpace(fmt.Sprintf(`@import url("%s") %s`, dir+a.extract(imported, ".css", importURL), ref.media)) + ";", true
+			}
+			if ok && ref.media != "" {
+				imported = fmt.Sprintf("@media %s {\n%s\n}", ref.media, imported)
+			}
+			return imported, ok
+		}))
+	}
+
+	for _, ref := range refs {
+		ref := ref
+
+		if !ref.isImport {
+			priority := priorityImage
+			if styleResourceKind(ref.url) == AssetFont {
+				priority = priorityFont
+			}
+
+			p.schedule(priority, func() {
+				finish(ref, a.cureStyleResource(base, ref.url, dir))
+			})
+			continue
+		}
+
+		normalizedURL, err := a.assetURL(base, ref.url)
+		if err != nil || chain[normalizedURL] {
+			finish(ref, "")
+			continue
+		}
+
+		p.schedule(priorityStylesheet, func() {
+			resp, err := a.fetchAssetResponse(AssetCSS, base, ref.url, false, "")
+			if err != nil {
+				a.warn(err)
+				finish(ref, "")
+				return
+			}
+			if resp.remote {
+				finish(ref, remoteImport)
+				return
+			}
+
+			importChain := map[string]bool{normalizedURL: true}
+			for u := range chain {
+				importChain[u] = true
+			}
+
+			// Imported stylesheets are extracted next to the other assets.
+			a.cureStylesheet(p, resp.body, resp.baseURL(), importChain, "", func(cured string) {
+				finish(ref, cured)
+			})
+		})
+	}
+}
+
+// styleResourceKind returns the kind of a resource referenced by a url() function of a stylesheet: a font if
+// its URL has the extension of a font, or an image.
+func styleResourceKind(src string) AssetKind {
+	if _, ok := fontTypes[strings.ToLower(path.Ext(strings.SplitN(src, "?", 2)[0]))]; ok {
+		return AssetFont
+	}
+
+	return AssetImage
+}
+
+// cureStyleResource fetches a resource referenced by a url() function of a stylesheet (e.g. a font or
+// a background image) and returns it as a data URL, or an empty string if it could not be fetched. The
+// resource is resolved against base, see Antidote.cureStylesheet(). Resources larger than
+// Ingredients.InlineLimit, and images served with a type other than an image, are returned as their absolute
+// URL. With Ingredients.ExtractAssets the path of the extracted file from dir is returned instead.
+func (a *Antidote) cureStyleResource(base *url.URL, src string, dir string) string {
+	extension := strings.ToLower(path.Ext(strings.SplitN(src, "?", 2)[0]))
+	kind := styleResourceKind(src)
+
+	if a.ingredients.ExtractAssets {
+		resp, err := a.fetchAssetResponse(kind, base, src, false, "")
+		if err != nil {
+			a.warn(err)
+			return ""
+		}
+		if resp.remote || kind == AssetImage && a.servedImageType(resp, typeByExtension(extension)) == "" {
+			return a.remoteURL(base, src)
+		}
+
+		sourceURL, _ := a.assetURL(base, src)
+		return dir + a.extract(resp.body, assetExtension(src, ""), sourceURL)
+	}
+
+	// Images served with a type other than an image are left as references, like the <img> elements of the page.
+	dataURL, err := a.fetchDataURL(kind, base, src, typeByExtension(extension))
+	if err == errKeepRemote || err == errNotImage {
+		return a.remoteURL(base, src)
+	}
+	if err != nil {
+		a.warn(err)
+		return ""
+	}
+
+	return dataURL
+}
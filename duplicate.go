@@ -0,0 +1,101 @@
+package antidote
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// shingleSize is the number of consecutive words of the shingles hashed by Simhash().
+const shingleSize = 4
+
+// Simhash returns the 64-bit simhash of a text, computed from its shingles, every run of four consecutive words,
+// case-insensitively. Texts that only differ slightly, e.g. by a date or a counter, have fingerprints differing by
+// a few bits, see SimhashDistance(). It reports false for texts too short to have a shingle.
+func Simhash(text string) (uint64, bool) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) < shingleSize {
+		return 0, false
+	}
+
+	var weights [64]int
+	for i := 0; i+shingleSize <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+shingleSize], " ")))
+		sum := h.Sum64()
+
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<uint(bit)) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << uint(bit)
+		}
+	}
+
+	return fingerprint, true
+}
+
+// SimhashDistance returns the number of bits two fingerprints differ by.
+func SimhashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Fingerprint returns the simhash of the main content of the page, see Simhash(). The boilerplate is left out,
+// as pages of the same template share it, see TextOptions.RemoveBoilerplate. It reports false for pages with too
+// little text to be told apart, e.g. those rendered by scripts.
+func (s *Snapshot) Fingerprint() (uint64, bool, error) {
+	text, err := s.Text(TextOptions{RemoveBoilerplate: true})
+	if err != nil {
+		return 0, false, err
+	}
+
+	fingerprint, ok := Simhash(text)
+	return fingerprint, ok, nil
+}
+
+// DuplicateDetector finds the near-duplicates among the pages of a crawl or a batch, e.g. those of the same
+// template with trivial differences, by comparing the fingerprints of their snapshots. It is safe for concurrent
+// use.
+type DuplicateDetector struct {
+	// MaxDistance is the maximum number of bits the fingerprints of near-duplicate pages differ by. Zero only
+	// detects pages of the same fingerprint; a handful of bits detects pages differing by a few words.
+	MaxDistance int
+
+	pages []fingerprintedPage
+	mu    sync.Mutex
+}
+
+// fingerprintedPage is a page seen by a DuplicateDetector.
+type fingerprintedPage struct {
+	url         string
+	fingerprint uint64
+}
+
+// Add records the fingerprint of a page, and returns the URL of the first page added before it that it is a
+// near-duplicate of, if any, in which case the page itself is not recorded.
+func (d *DuplicateDetector) Add(url string, fingerprint uint64) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, page := range d.pages {
+		if SimhashDistance(page.fingerprint, fingerprint) <= d.MaxDistance {
+			return page.url, true
+		}
+	}
+
+	d.pages = append(d.pages, fingerprintedPage{url: url, fingerprint: fingerprint})
+
+	return "", false
+}
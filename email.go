@@ -0,0 +1,116 @@
+package antidote
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// attachmentScheme is the scheme of the URL's of the images attached to an email, see
+// Ingredients.ImageAttachments.
+const attachmentScheme = "cid:"
+
+// spillsImages reports whether the images of <img> elements may be spilled to disk, see
+// Ingredients.SpillThreshold, which they may not once extracted into files.
+func (i *Ingredients) spillsImages() bool {
+	return !i.ExtractAssets && !i.ImageAttachments
+}
+
+// stripIframes removes every <iframe> element, see Ingredients.StripIframes.
+func (a *Antidote) stripIframes() {
+	if a.ingredients.StripIframes {
+		a.website.Find("iframe").Remove()
+	}
+}
+
+// WriteEmail writes the cured page as an email, a MIME message of type multipart/related whose subject is the
+// title of the page. The images attached with Ingredients.ImageAttachments are parts of their own, referenced by
+// their Content-ID, which most email clients render, unlike the data URL's of images. The other assets must be
+// inlined.
+func (s *Snapshot) WriteEmail(w io.Writer) error {
+	var names []string
+	for name := range s.Files {
+		if !strings.Contains(s.HTML, attachmentScheme+path.Base(name)) {
+			return errors.New("a snapshot with extracted assets can not be written as an email, only attached images")
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var page bytes.Buffer
+	if err := s.WriteHTML(&page); err != nil {
+		return err
+	}
+
+	parts := multipart.NewWriter(w)
+	header := fmt.Sprintf("MIME-Version: 1.0\r\nDate: %s\r\nSubject: %s\r\nContent-Location: %s\r\n"+
+		"Content-Type: multipart/related; type=\"text/html\"; boundary=%q\r\n\r\n",
+		s.StartedAt.Format(time.RFC1123Z), mime.QEncoding.Encode("utf-8", s.title()), s.URL, parts.Boundary())
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+
+	part, err := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write(page.Bytes()); err != nil {
+		return err
+	}
+	if err := qp.Close(); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		part, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Id":                {"<" + path.Base(name) + ">"},
+			"Content-Disposition":       {mime.FormatMediaType("inline", map[string]string{"filename": path.Base(name)})},
+		})
+		if err != nil {
+			return err
+		}
+		if err := writeBase64Lines(part, s.Files[name]); err != nil {
+			return err
+		}
+	}
+
+	return parts.Close()
+}
+
+// writeBase64Lines writes data in base64, in lines of 76 characters as MIME requires.
+func writeBase64Lines(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := 76
+		if n > len(encoded) {
+			n = len(encoded)
+		}
+		if _, err := io.WriteString(w, encoded[:n]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package antidote
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// embeddedHeadElements are the elements of the <head> of a cured page kept by Snapshot.EmbedHTML(), those that
+// style or run the page. The others, e.g. <title>, <meta> and <base>, belong to the page embedding it.
+const embeddedHeadElements = "style, script, link[rel~=stylesheet]"
+
+// EmbedHTML returns the cured page as a fragment to insert in another page, e.g. a widget rendered server-side by
+// an html/template: the stylesheets and scripts of its <head>, followed by the content of its <body>, without the
+// <html>, <head> and <body> tags nor the elements describing the page, e.g. its <title> and <meta> tags. Spilled
+// assets are expanded. The assets must be inlined, as the fragment is served by another page.
+func (s *Snapshot) EmbedHTML() (template.HTML, error) {
+	if len(s.Files) > 0 {
+		return "", errors.New("a snapshot with extracted assets can not be embedded")
+	}
+
+	var b bytes.Buffer
+	if err := s.WriteHTML(&b); err != nil {
+		return "", err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(&b)
+	if err != nil {
+		return "", err
+	}
+
+	var fragment strings.Builder
+	var failed error
+	doc.Find("head").Find(embeddedHeadElements).Each(func(index int, element *goquery.Selection) {
+		content, err := goquery.OuterHtml(element)
+		if err != nil {
+			failed = err
+		}
+		fragment.WriteString(content)
+	})
+	if failed != nil {
+		return "", failed
+	}
+
+	body, err := doc.Find("body").Html()
+	if err != nil {
+		return "", err
+	}
+	fragment.WriteString(body)
+
+	return template.HTML(fragment.String()), nil
+}
+
+// Embedder cures pages into fragments embedded in the pages of a Go web app, e.g. third-party widgets rendered
+// server-side, see Snapshot.EmbedHTML(). The fragments are cached for a while, so that rendering a page does not
+// cure them again, and concurrent requests for the same URL share a single cure. It is safe for concurrent use.
+type Embedder struct {
+	ingredients Ingredients
+	ttl         time.Duration
+	entries     map[string]*embedEntry
+	mu          sync.Mutex
+}
+
+// embedEntry is a fragment cached by an Embedder, whose cure is done once ready is closed.
+type embedEntry struct {
+	ready   chan struct{}
+	html    template.HTML
+	err     error
+	curedAt time.Time
+}
+
+// NewEmbedder creates a new instance of an Embedder pointer curing pages with a copy of ingredients, e.g. with a
+// Selector keeping the widget of every page, whose fragments are cached for ttl. A ttl of 0 caches them for the
+// lifetime of the Embedder. The URL of the ingredients is ignored, and the assets are always inlined.
+func NewEmbedder(ingredients *Ingredients, ttl time.Duration) *Embedder {
+	e := &Embedder{ttl: ttl, entries: make(map[string]*embedEntry)}
+	if ingredients != nil {
+		e.ingredients = *ingredients
+	}
+	e.ingredients.ExtractAssets = false
+
+	return e
+}
+
+// Embed returns the fragment of a URL, from the cache if it was cured less than the ttl of the Embedder ago.
+// Failed cures are not cached, so that they are tried again on the next call.
+func (e *Embedder) Embed(url string) (template.HTML, error) {
+	e.mu.Lock()
+	entry, ok := e.entries[url]
+	if ok {
+		select {
+		case <-entry.ready:
+			if e.ttl > 0 && time.Since(entry.curedAt) >= e.ttl {
+				ok = false
+			}
+		default:
+		}
+	}
+	if !ok {
+		entry = &embedEntry{ready: make(chan struct{})}
+		e.entries[url] = entry
+	}
+	e.mu.Unlock()
+
+	if !ok {
+		e.cure(url, entry)
+	}
+
+	<-entry.ready
+	return entry.html, entry.err
+}
+
+// cure cures the fragment of an entry, which is dropped from the cache if it failed.
+func (e *Embedder) cure(url string, entry *embedEntry) {
+	defer close(entry.ready)
+
+	ingredients := e.ingredients
+	ingredients.URL = url
+
+	a := New()
+	a.Mix(&ingredients)
+
+	snapshot, err := a.CureToSnapshot()
+	if err == nil {
+		entry.html, err = snapshot.EmbedHTML()
+		snapshot.Close()
+	}
+	entry.err = err
+	entry.curedAt = time.Now()
+
+	if err != nil {
+		e.mu.Lock()
+		if e.entries[url] == entry {
+			delete(e.entries, url)
+		}
+		e.mu.Unlock()
+	}
+}
+
+// Forget drops the fragment of a URL from the cache, so that it is cured again on the next call to Embed().
+func (e *Embedder) Forget(url string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.entries, url)
+}
+
+// FuncMap returns the functions of the Embedder for html/template: "embed" inserts the fragment of a URL, e.g.
+// {{embed "https://widgets.website.com/weather"}}, failing the execution of the template if the cure fails.
+func (e *Embedder) FuncMap() template.FuncMap {
+	return template.FuncMap{"embed": e.Embed}
+}
+
+// defaultEmbedder is the Embedder of Embed(), which caches fragments for 5 minutes.
+var defaultEmbedder = NewEmbedder(nil, 5*time.Minute)
+
+// Embed cures a URL with the default ingredients and returns it as a fragment for an html/template, see
+// Snapshot.EmbedHTML(). The fragments are cached for 5 minutes; use an Embedder for other ingredients or
+// durations.
+func Embed(url string) (template.HTML, error) {
+	return defaultEmbedder.Embed(url)
+}
@@ -0,0 +1,288 @@
+package antidote
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// encryptedMagic starts every blob encrypted by an EncryptedStore or an EncryptedCache, followed by the length of
+// the ID of its key, the ID, the nonce and the ciphertext sealed with AES-GCM.
+const encryptedMagic = "antidote-aes-gcm\x00"
+
+// ErrNotEncrypted is returned by EncryptedStore.Get() for the blobs stored in plaintext, e.g. before the store was
+// encrypted, unless EncryptedStore.AllowPlaintext is set.
+var ErrNotEncrypted = errors.New("the blob is not encrypted")
+
+// EncryptionKeys are the AES keys blobs are encrypted with, by ID, so that keys can be rotated: new blobs are
+// encrypted with the current key, and the blobs encrypted before with the key of their ID. Implement it to fetch
+// the keys from a key management service.
+type EncryptionKeys interface {
+	// CurrentKey returns the ID and the key new blobs are encrypted with.
+	CurrentKey() (id string, key []byte, err error)
+
+	// Key returns the key of an ID.
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys are EncryptionKeys held in memory, identified by a hash of the keys themselves.
+type StaticKeys struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewStaticKeys creates a new instance of a StaticKeys pointer, which encrypts with the current key and decrypts
+// with any of the keys. Keys are 16, 24 or 32 bytes long, for AES-128, AES-192 or AES-256.
+func NewStaticKeys(current []byte, previous ...[]byte) (*StaticKeys, error) {
+	k := &StaticKeys{keys: make(map[string][]byte)}
+	for i, key := range append([][]byte{current}, previous...) {
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, err
+		}
+
+		id := keyID(key)
+		k.keys[id] = key
+		if i == 0 {
+			k.current = id
+		}
+	}
+
+	return k, nil
+}
+
+// CurrentKey returns the ID and the key new blobs are encrypted with.
+func (k *StaticKeys) CurrentKey() (string, []byte, error) {
+	return k.current, k.keys[k.current], nil
+}
+
+// Key returns the key of an ID.
+func (k *StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", id)
+	}
+
+	return key, nil
+}
+
+// keyID returns the ID of a key, a hash that does not reveal it.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("antidote key id\x00"), key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// FetchedKeys are EncryptionKeys fetched by a hook, e.g. a data key decrypted by a key management service. The
+// hook is only called the first time a key is needed, or again if it failed. It is safe for concurrent use.
+type FetchedKeys struct {
+	// Fetch returns the key new blobs are encrypted with, and the previous keys of a rotation, if any.
+	Fetch func() (current []byte, previous [][]byte, err error)
+
+	keys *StaticKeys
+	mu   sync.Mutex
+}
+
+// CurrentKey returns the ID and the key new blobs are encrypted with.
+func (k *FetchedKeys) CurrentKey() (string, []byte, error) {
+	keys, err := k.fetch()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return keys.CurrentKey()
+}
+
+// Key returns the key of an ID.
+func (k *FetchedKeys) Key(id string) ([]byte, error) {
+	keys, err := k.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	return keys.Key(id)
+}
+
+// fetch returns the keys, fetching them the first time.
+func (k *FetchedKeys) fetch() (*StaticKeys, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.keys != nil {
+		return k.keys, nil
+	}
+
+	current, previous, err := k.Fetch()
+	if err != nil {
+		return nil, fmt.Errorf("fetching the encryption keys: %v", err)
+	}
+	if k.keys, err = NewStaticKeys(current, previous...); err != nil {
+		return nil, err
+	}
+
+	return k.keys, nil
+}
+
+// sealBlob encrypts a blob stored under a key with the current key of keys. The key it is stored under is
+// authenticated along with it, so that a blob can not be passed off as another one.
+func sealBlob(keys EncryptionKeys, name string, plaintext []byte) ([]byte, error) {
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("encryption key ID %.32q... is longer than 255 bytes", id)
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(encryptedMagic), byte(len(id)))
+	header = append(header, id...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := append(header, nonce...)
+	return aead.Seal(sealed, nonce, plaintext, []byte(name)), nil
+}
+
+// openBlob decrypts a blob sealed by sealBlob() under the same key, or returns ErrNotEncrypted.
+func openBlob(keys EncryptionKeys, name string, blob []byte) ([]byte, error) {
+	if !bytes.HasPrefix(blob, []byte(encryptedMagic)) {
+		return nil, ErrNotEncrypted
+	}
+	blob = blob[len(encryptedMagic):]
+
+	if len(blob) == 0 || len(blob) < 1+int(blob[0]) {
+		return nil, errors.New("truncated encrypted blob")
+	}
+	id := string(blob[1 : 1+int(blob[0])])
+	blob = blob[1+int(blob[0]):]
+
+	key, err := keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(blob) < aead.NonceSize() {
+		return nil, errors.New("truncated encrypted blob")
+	}
+
+	return aead.Open(nil, blob[:aead.NonceSize()], blob[aead.NonceSize():], []byte(name))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// EncryptedStore is a Store encrypting its blobs with AES-GCM before they are kept in another Store, e.g. to
+// archive sensitive internal pages in a shared bucket. Snapshots and cache entries are encrypted alike when the
+// store is given to server.QueueOptions.Store or a StoreCache.
+type EncryptedStore struct {
+	// Store is where the encrypted blobs are kept.
+	Store Store
+
+	// Keys are the keys blobs are encrypted with, see NewStaticKeys() and FetchedKeys.
+	Keys EncryptionKeys
+
+	// AllowPlaintext returns the blobs stored in plaintext as they are instead of ErrNotEncrypted, so that a store
+	// can be encrypted gradually as its blobs are replaced.
+	AllowPlaintext bool
+}
+
+// Get returns the decrypted blob stored under a key, or ErrNotStored.
+func (s *EncryptedStore) Get(key string) ([]byte, error) {
+	blob, err := s.Store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := openBlob(s.Keys, key, blob)
+	if err == ErrNotEncrypted && s.AllowPlaintext {
+		return blob, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", key, err)
+	}
+
+	return value, nil
+}
+
+// Put encrypts a blob and stores it under a key.
+func (s *EncryptedStore) Put(key string, value []byte) error {
+	blob, err := sealBlob(s.Keys, key, value)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Put(key, blob)
+}
+
+// List returns the blobs whose key starts with a prefix, with their encrypted size. The underlying Store must be
+// a ListableStore.
+func (s *EncryptedStore) List(prefix string) ([]StoredBlob, error) {
+	store, ok := s.Store.(ListableStore)
+	if !ok {
+		return nil, errors.New("the store of the encrypted store can not be listed")
+	}
+
+	return store.List(prefix)
+}
+
+// Delete removes the blob stored under a key. The underlying Store must be a ListableStore.
+func (s *EncryptedStore) Delete(key string) error {
+	store, ok := s.Store.(ListableStore)
+	if !ok {
+		return errors.New("the store of the encrypted store can not be listed")
+	}
+
+	return store.Delete(key)
+}
+
+// EncryptedCache is a Cache encrypting its entries with AES-GCM before they are kept in another Cache, e.g. a
+// DirCache on a shared disk. Entries that can not be decrypted are treated as missing, as the bytes are fetched
+// again when they are.
+type EncryptedCache struct {
+	// Cache is where the encrypted entries are kept.
+	Cache Cache
+
+	// Keys are the keys entries are encrypted with, see NewStaticKeys() and FetchedKeys.
+	Keys EncryptionKeys
+}
+
+// Get returns the decrypted bytes stored under a key.
+func (c *EncryptedCache) Get(key string) ([]byte, bool) {
+	blob, ok := c.Cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	value, err := openBlob(c.Keys, key, blob)
+	return value, err == nil
+}
+
+// Set encrypts bytes and stores them under a key. Entries that can not be encrypted are skipped.
+func (c *EncryptedCache) Set(key string, value []byte) {
+	if blob, err := sealBlob(c.Keys, key, value); err == nil {
+		c.Cache.Set(key, blob)
+	}
+}
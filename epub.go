@@ -0,0 +1,205 @@
+package antidote
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Book object represents an EPUB e-book made of cured websites, one chapter per website.
+type Book struct {
+	// Title is the title of the book.
+	Title string
+
+	// Language is the language of the book as a BCP 47 tag. Defaults to "en".
+	Language string
+
+	// Identifier uniquely identifies the book. Defaults to a hash of the URL's of its chapters.
+	Identifier string
+
+	// Modified is when the book was last modified. Defaults to the time it is written at.
+	Modified time.Time
+
+	// Chapters are the chapters of the book, in reading order.
+	Chapters []Chapter
+}
+
+// Chapter object represents a chapter of a Book.
+type Chapter struct {
+	// Title is the title of the chapter in the table of contents. Defaults to the URL of the snapshot.
+	Title string
+
+	// Snapshot is the cured website making up the content of the chapter.
+	Snapshot *Snapshot
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+// WriteEPUB writes the book as an EPUB 3 archive. The HTML of every chapter is converted to XHTML, as EPUB
+// requires, and its assets stay inlined as data URL's, so the snapshots should not be cured with
+// Ingredients.ExtractAssets.
+func (b *Book) WriteEPUB(w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	// The mimetype must be the first file of the archive, and must not be compressed.
+	mimetype, err := archive.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mimetype, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	files := []struct {
+		name    string
+		content func() (string, error)
+	}{
+		{"META-INF/container.xml", func() (string, error) { return epubContainer, nil }},
+		{"OEBPS/content.opf", func() (string, error) { return b.packageDocument(), nil }},
+		{"OEBPS/nav.xhtml", func() (string, error) { return b.navigationDocument(), nil }},
+	}
+
+	for i, chapter := range b.Chapters {
+		chapter := chapter
+		files = append(files, struct {
+			name    string
+			content func() (string, error)
+		}{"OEBPS/" + chapterName(i), chapter.xhtml})
+	}
+
+	for _, file := range files {
+		content, err := file.content()
+		if err != nil {
+			return fmt.Errorf("%s: %v", file.name, err)
+		}
+
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: b.modified()})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, content); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// packageDocument returns the package document of the book (content.opf), which holds its metadata, the list
+// of its files and their reading order.
+func (b *Book) packageDocument() string {
+	language := b.Language
+	if language == "" {
+		language = "en"
+	}
+
+	identifier := b.Identifier
+	if identifier == "" {
+		var urls []string
+		for _, chapter := range b.Chapters {
+			urls = append(urls, chapter.Snapshot.URL)
+		}
+		identifier = "urn:sha256:" + hashContent(strings.Join(urls, "\n"))
+	}
+
+	var manifest, spine strings.Builder
+	for i := range b.Chapters {
+		fmt.Fprintf(&manifest, "    <item id=\"chapter-%d\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", i+1, chapterName(i))
+		fmt.Fprintf(&spine, "    <itemref idref=\"chapter-%d\"/>\n", i+1)
+	}
+
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<package version="3.0" unique-identifier="book-id" xmlns="http://www.idpf.org/2007/opf">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">` + escapeXML(identifier) + `</dc:identifier>
+    <dc:title>` + escapeXML(b.Title) + `</dc:title>
+    <dc:language>` + escapeXML(language) + `</dc:language>
+    <meta property="dcterms:modified">` + b.modified().UTC().Format("2006-01-02T15:04:05Z") + `</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+` + manifest.String() + `  </manifest>
+  <spine>
+` + spine.String() + `  </spine>
+</package>
+`
+}
+
+// navigationDocument returns the navigation document of the book (nav.xhtml), its table of contents.
+func (b *Book) navigationDocument() string {
+	var items strings.Builder
+	for i, chapter := range b.Chapters {
+		fmt.Fprintf(&items, "      <li><a href=\"%s\">%s</a></li>\n", chapterName(i), escapeXML(chapter.title()))
+	}
+
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>` + escapeXML(b.Title) + `</title></head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <h1>` + escapeXML(b.Title) + `</h1>
+    <ol>
+` + items.String() + `    </ol>
+  </nav>
+</body>
+</html>
+`
+}
+
+// modified returns when the book was last modified, see Book.Modified.
+func (b *Book) modified() time.Time {
+	if b.Modified.IsZero() {
+		b.Modified = time.Now()
+	}
+
+	return b.Modified
+}
+
+// title returns the title of the chapter, see Chapter.Title.
+func (c Chapter) title() string {
+	if c.Title != "" {
+		return c.Title
+	}
+
+	return c.Snapshot.URL
+}
+
+// xhtml returns the complete HTML of the snapshot of the chapter as XHTML.
+func (c Chapter) xhtml() (string, error) {
+	var b strings.Builder
+	if err := c.Snapshot.WriteHTML(&b); err != nil {
+		return "", err
+	}
+
+	doc, err := html.Parse(strings.NewReader(b.String()))
+	if err != nil {
+		return "", err
+	}
+
+	return `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + serialize(doc, OutputFormat{}, true), nil
+}
+
+// chapterName returns the name of the file of the chapter at an index.
+func chapterName(index int) string {
+	return fmt.Sprintf("chapter-%d.xhtml", index+1)
+}
+
+// escapeXML escapes text to be written in an XML document.
+func escapeXML(text string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(text))
+
+	return b.String()
+}
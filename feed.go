@@ -0,0 +1,179 @@
+package antidote
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Feed object represents an RSS or Atom feed.
+type Feed struct {
+	// Title is the title of the feed.
+	Title string `json:"title"`
+
+	// Entries are the entries of the feed, in the order they appear in.
+	Entries []FeedEntry `json:"entries"`
+}
+
+// FeedEntry object represents a single entry (or item) of a feed.
+type FeedEntry struct {
+	// Title is the title of the entry.
+	Title string `json:"title"`
+
+	// URL is the absolute URL of the article the entry links to.
+	URL string `json:"url"`
+
+	// Published is when the entry was published (or last updated), as written in the feed.
+	Published string `json:"published,omitempty"`
+}
+
+// feedLink is a <link> element, holding its URL as text in RSS or as the href attribute in Atom.
+type feedLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Text string `xml:",chardata"`
+}
+
+// feedItem is an RSS <item> or an Atom <entry>.
+type feedItem struct {
+	Title     string     `xml:"title"`
+	Links     []feedLink `xml:"link"`
+	GUID      string     `xml:"guid"`
+	PubDate   string     `xml:"pubDate"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Date      string     `xml:"date"`
+}
+
+// feedDocument is the root element of an RSS 2.0 (<rss>), RSS 1.0 (<rdf:RDF>) or Atom (<feed>) document.
+type feedDocument struct {
+	XMLName xml.Name
+	Title   string `xml:"title"`
+	Channel struct {
+		Title string     `xml:"title"`
+		Items []feedItem `xml:"item"`
+	} `xml:"channel"`
+	Items   []feedItem `xml:"item"`
+	Entries []feedItem `xml:"entry"`
+}
+
+// FetchFeed fetches and parses the RSS or Atom feed at Ingredients.URL, with the same transport, host rules
+// and size limits as a cure. Every entry of the feed can then be cured with ingredients of its own.
+func (a *Antidote) FetchFeed() (*Feed, error) {
+	if a.ingredients == nil {
+		return nil, errors.New("Antidote.Mix() must be called before Antidote.FetchFeed().")
+	}
+
+	feedURL, err := url.Parse(strings.TrimSpace(a.ingredients.URL))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := encodeURL(feedURL); err != nil {
+		return nil, err
+	}
+
+	transport, err := a.transport()
+	if err != nil {
+		return nil, err
+	}
+
+	a.client = &http.Client{Timeout: a.ingredients.Timeout, Transport: transport}
+	a.hostLimits = make(map[*HostRule]chan struct{})
+
+	resp, err := a.fetch(feedURL.String(), nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseFeed(strings.NewReader(resp.body), resp.url)
+}
+
+// ParseFeed parses an RSS 2.0, RSS 1.0 or Atom feed. The URL's of the entries are resolved against the URL
+// the feed was fetched from, and entries without a URL are skipped.
+func ParseFeed(r io.Reader, feedURL string) (*Feed, error) {
+	base, err := url.Parse(feedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = charset.NewReaderLabel
+	decoder.Strict = false
+
+	var doc feedDocument
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid feed: %v", err)
+	}
+
+	feed := &Feed{Entries: []FeedEntry{}}
+	var items []feedItem
+
+	switch doc.XMLName.Local {
+	case "rss":
+		feed.Title, items = doc.Channel.Title, doc.Channel.Items
+	case "RDF":
+		feed.Title, items = doc.Channel.Title, doc.Items
+	case "feed":
+		feed.Title, items = doc.Title, doc.Entries
+	default:
+		return nil, fmt.Errorf("invalid feed: unknown root element <%s>", doc.XMLName.Local)
+	}
+	feed.Title = strings.TrimSpace(feed.Title)
+
+	for _, item := range items {
+		link := item.link()
+		if link == "" {
+			continue
+		}
+
+		ref, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+
+		feed.Entries = append(feed.Entries, FeedEntry{
+			Title:     strings.TrimSpace(item.Title),
+			URL:       base.ResolveReference(ref).String(),
+			Published: strings.TrimSpace(firstNonEmpty(item.PubDate, item.Published, item.Updated, item.Date)),
+		})
+	}
+
+	return feed, nil
+}
+
+// link returns the URL of the article of an item: the text of the RSS <link>, the href of the Atom <link>
+// of the alternate relation, or the GUID of the item if it is an http URL.
+func (item feedItem) link() string {
+	for _, link := range item.Links {
+		if text := strings.TrimSpace(link.Text); text != "" {
+			return text
+		}
+		if link.Href != "" && (link.Rel == "" || link.Rel == "alternate") {
+			return strings.TrimSpace(link.Href)
+		}
+	}
+
+	if guid := strings.TrimSpace(item.GUID); strings.HasPrefix(guid, "http://") || strings.HasPrefix(guid, "https://") {
+		return guid
+	}
+
+	return ""
+}
+
+// firstNonEmpty returns the first of the values that is not empty.
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,460 @@
+package antidote
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+)
+
+// Layout is how the elements of the cured HTML are laid out.
+type Layout string
+
+const (
+	// LayoutAsIs keeps the whitespace of the original HTML.
+	LayoutAsIs Layout = "as-is"
+
+	// LayoutPretty puts every block of elements on its own line, indented by its depth.
+	LayoutPretty Layout = "pretty"
+
+	// LayoutMinify removes comments and the whitespace between blocks of elements, and collapses the rest.
+	LayoutMinify Layout = "minify"
+)
+
+// VoidStyle is how void elements, such as <br> or <img>, are written.
+type VoidStyle string
+
+const (
+	// VoidSlash writes void elements as "<br/>", the same as goquery.
+	VoidSlash VoidStyle = "slash"
+
+	// VoidHTML writes void elements as "<br>".
+	VoidHTML VoidStyle = "html"
+
+	// VoidXHTML writes void elements as "<br />".
+	VoidXHTML VoidStyle = "xhtml"
+)
+
+// QuoteStyle is how the values of attributes are quoted.
+type QuoteStyle string
+
+const (
+	// QuoteDouble quotes every value with double quotes.
+	QuoteDouble QuoteStyle = "double"
+
+	// QuoteMinimal leaves values unquoted where HTML allows it, and writes empty attributes as their name.
+	QuoteMinimal QuoteStyle = "minimal"
+)
+
+// OutputFormat object represents how the cured HTML is serialized. The zero value serializes it the same
+// way as goquery, except for the downlevel-revealed conditional comments of Internet Explorer, e.g.
+// "<![if !IE]>", which are written as they were instead of as regular comments.
+type OutputFormat struct {
+	// Layout is how the elements are laid out. Empty means LayoutAsIs. The content of <pre>, <textarea>,
+	// <script> and <style> elements, and of elements mixing text with inline elements, is always kept as is,
+	// as its whitespace may be significant.
+	Layout Layout
+
+	// Indent is the indentation of every level of LayoutPretty. Defaults to two spaces.
+	Indent string
+
+	// VoidStyle is how void elements are written. Empty means VoidSlash.
+	VoidStyle VoidStyle
+
+	// Quotes is how the values of attributes are quoted. Empty means QuoteDouble.
+	Quotes QuoteStyle
+}
+
+// validate reports whether every option of the format is known.
+func (f OutputFormat) validate() error {
+	switch f.Layout {
+	case "", LayoutAsIs, LayoutPretty, LayoutMinify:
+	default:
+		return fmt.Errorf("unknown layout %q", f.Layout)
+	}
+
+	switch f.VoidStyle {
+	case "", VoidSlash, VoidHTML, VoidXHTML:
+	default:
+		return fmt.Errorf("unknown void style %q", f.VoidStyle)
+	}
+
+	switch f.Quotes {
+	case "", QuoteDouble, QuoteMinimal:
+	default:
+		return fmt.Errorf("unknown quote style %q", f.Quotes)
+	}
+
+	return nil
+}
+
+// rawTextElements are the elements whose text is written without escaping.
+var rawTextElements = map[string]bool{
+	"iframe":    true,
+	"noembed":   true,
+	"noframes":  true,
+	"noscript":  true,
+	"plaintext": true,
+	"script":    true,
+	"style":     true,
+	"xmp":       true,
+}
+
+// preformattedElements are the elements whose whitespace is always significant.
+var preformattedElements = map[string]bool{
+	"listing":  true,
+	"pre":      true,
+	"textarea": true,
+}
+
+// voidElements are the elements that can not have any content.
+var voidElements = map[string]bool{
+	"area":   true,
+	"base":   true,
+	"br":     true,
+	"col":    true,
+	"embed":  true,
+	"hr":     true,
+	"img":    true,
+	"input":  true,
+	"keygen": true,
+	"link":   true,
+	"meta":   true,
+	"param":  true,
+	"source": true,
+	"track":  true,
+	"wbr":    true,
+}
+
+// inlineElements are the elements laid out within lines of text, around which whitespace is significant.
+var inlineElements = map[string]bool{
+	"a": true, "abbr": true, "acronym": true, "audio": true, "b": true, "bdi": true, "bdo": true, "big": true,
+	"br": true, "button": true, "canvas": true, "cite": true, "code": true, "data": true, "del": true,
+	"dfn": true, "em": true, "embed": true, "font": true, "i": true, "iframe": true, "img": true, "input": true,
+	"ins": true, "kbd": true, "label": true, "map": true, "mark": true, "meter": true, "noscript": true,
+	"object": true, "output": true, "picture": true, "progress": true, "q": true, "ruby": true, "s": true,
+	"samp": true, "select": true, "small": true, "span": true, "strike": true, "strong": true, "sub": true,
+	"sup": true, "svg": true, "textarea": true, "time": true, "tt": true, "u": true, "var": true,
+	"video": true, "wbr": true,
+}
+
+// serializer writes a document in an OutputFormat.
+type serializer struct {
+	format OutputFormat
+	xhtml  bool
+	b      strings.Builder
+}
+
+// serialize returns the HTML of a document in the format. If xhtml is set, the document is written as valid
+// XHTML: void elements are closed with " />", attribute values are always quoted, and the content of
+// <script> and <style> elements is wrapped in CDATA sections if it is not well-formed.
+func serialize(doc *nethtml.Node, format OutputFormat, xhtml bool) string {
+	s := &serializer{format: format, xhtml: xhtml}
+	if s.format.Indent == "" {
+		s.format.Indent = "  "
+	}
+	if xhtml {
+		s.format.VoidStyle = VoidXHTML
+		s.format.Quotes = QuoteDouble
+	}
+
+	s.node(doc, 0, false)
+
+	return s.b.String()
+}
+
+// node writes a node at a depth of the document. Once preserved, the whitespace of the node and of its
+// descendants is kept as is.
+func (s *serializer) node(n *nethtml.Node, depth int, preserved bool) {
+	switch n.Type {
+	case nethtml.DocumentNode:
+		s.children(n, -1, preserved)
+	case nethtml.TextNode:
+		if preserved || s.format.Layout != LayoutMinify {
+			s.b.WriteString(html.EscapeString(n.Data))
+		} else {
+			s.b.WriteString(html.EscapeString(collapseWhitespace(n.Data)))
+		}
+	case nethtml.CommentNode:
+		if isRevealedConditional(n.Data) && !s.xhtml {
+			s.b.WriteString("<!" + n.Data + ">")
+		} else {
+			s.b.WriteString("<!--" + n.Data + "-->")
+		}
+	case nethtml.DoctypeNode:
+		s.doctype(n)
+	case nethtml.RawNode:
+		s.b.WriteString(n.Data)
+	case nethtml.ElementNode:
+		s.element(n, depth, preserved)
+	}
+}
+
+// element writes an element along with its content.
+func (s *serializer) element(n *nethtml.Node, depth int, preserved bool) {
+	s.b.WriteString("<" + n.Data)
+	for _, attr := range n.Attr {
+		s.attribute(attr)
+	}
+	// XHTML elements are only recognized by XML parsers in the XHTML namespace.
+	if s.xhtml && n.Data == "html" && !hasAttr(n, "xmlns") {
+		s.b.WriteString(` xmlns="http://www.w3.org/1999/xhtml"`)
+	}
+
+	if voidElements[n.Data] {
+		switch s.format.VoidStyle {
+		case VoidHTML:
+			s.b.WriteString(">")
+		case VoidXHTML:
+			s.b.WriteString(" />")
+		default:
+			s.b.WriteString("/>")
+		}
+		return
+	}
+	s.b.WriteString(">")
+
+	// A newline following the start tag is ignored by parsers, so a leading newline of the content must be doubled.
+	if c := n.FirstChild; preformattedElements[n.Data] && c != nil && c.Type == nethtml.TextNode && strings.HasPrefix(c.Data, "\n") {
+		s.b.WriteString("\n")
+	}
+
+	if rawTextElements[n.Data] {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == nethtml.TextNode && s.xhtml {
+				s.b.WriteString(xhtmlRawText(n.Data, c.Data))
+			} else if c.Type == nethtml.TextNode {
+				s.b.WriteString(c.Data)
+			} else {
+				s.node(c, depth+1, true)
+			}
+		}
+	} else {
+		s.children(n, depth, preserved || preformattedElements[n.Data])
+	}
+
+	// The content of <plaintext> runs until the end of the document, so it can not be closed.
+	if n.Data != "plaintext" {
+		s.b.WriteString("</" + n.Data + ">")
+	}
+}
+
+// children writes the children of a node. The children of blocks are laid out according to the format,
+// and the ones of other nodes are written one after the other.
+func (s *serializer) children(n *nethtml.Node, depth int, preserved bool) {
+	if preserved || s.format.Layout == "" || s.format.Layout == LayoutAsIs || !isBlock(n) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			s.node(c, depth+1, preserved)
+		}
+		return
+	}
+
+	written := false
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == nethtml.TextNode {
+			continue
+		}
+		if c.Type == nethtml.CommentNode && s.format.Layout == LayoutMinify && !isConditionalComment(c.Data) {
+			continue
+		}
+
+		if s.format.Layout == LayoutPretty && (written || depth >= 0) {
+			s.b.WriteString("\n" + strings.Repeat(s.format.Indent, depth+1))
+		}
+		s.node(c, depth+1, false)
+		written = true
+	}
+
+	if s.format.Layout == LayoutPretty && written {
+		if depth >= 0 {
+			s.b.WriteString("\n" + strings.Repeat(s.format.Indent, depth))
+		} else {
+			s.b.WriteString("\n")
+		}
+	}
+}
+
+// attribute writes an attribute of an element, preceded by a space.
+func (s *serializer) attribute(attr nethtml.Attribute) {
+	s.b.WriteString(" ")
+	if attr.Namespace != "" {
+		s.b.WriteString(attr.Namespace + ":")
+	}
+	s.b.WriteString(attr.Key)
+
+	if s.format.Quotes == QuoteMinimal {
+		if attr.Val == "" {
+			return
+		}
+		if !strings.ContainsAny(attr.Val, " \t\n\r\f\"'=<>`") {
+			s.b.WriteString("=" + html.EscapeString(attr.Val))
+			return
+		}
+	}
+
+	s.b.WriteString(`="` + html.EscapeString(attr.Val) + `"`)
+}
+
+// doctype writes a doctype, with its public and system identifiers.
+func (s *serializer) doctype(n *nethtml.Node) {
+	var public, system string
+	for _, attr := range n.Attr {
+		switch attr.Key {
+		case "public":
+			public = attr.Val
+		case "system":
+			system = attr.Val
+		}
+	}
+
+	s.b.WriteString("<!DOCTYPE " + n.Data)
+	if public != "" {
+		s.b.WriteString(" PUBLIC " + quoteIdentifier(public))
+		if system != "" {
+			s.b.WriteString(" " + quoteIdentifier(system))
+		}
+	} else if system != "" {
+		s.b.WriteString(" SYSTEM " + quoteIdentifier(system))
+	}
+	s.b.WriteString(">")
+}
+
+// quoteIdentifier quotes an identifier of a doctype with double quotes, or single quotes if it contains any.
+func quoteIdentifier(identifier string) string {
+	if strings.Contains(identifier, `"`) {
+		return "'" + identifier + "'"
+	}
+
+	return `"` + identifier + `"`
+}
+
+// isBlock reports whether the children of a node can be laid out on lines of their own: every one of them is
+// a comment, an element that is not inline, or whitespace.
+func isBlock(n *nethtml.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case nethtml.TextNode:
+			if strings.Trim(c.Data, " \t\n\r\f") != "" {
+				return false
+			}
+		case nethtml.ElementNode:
+			if inlineElements[c.Data] {
+				return false
+			}
+		case nethtml.RawNode:
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasAttr reports whether an element has an attribute without a namespace.
+func hasAttr(n *nethtml.Node, key string) bool {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isConditionalComment reports whether the data of a comment is an Internet Explorer conditional comment,
+// e.g. "[if lt IE 9]>...<![endif]", which is kept by LayoutMinify.
+func isConditionalComment(data string) bool {
+	return strings.HasPrefix(data, "[if ") || strings.HasSuffix(data, "<![endif]") || isRevealedConditional(data)
+}
+
+// isRevealedConditional reports whether the data of a comment is the start or the end of a downlevel-revealed
+// conditional comment, "<![if expression]>" or "<![endif]>", which are parsed as comments.
+func isRevealedConditional(data string) bool {
+	return data == "[endif]" || strings.HasPrefix(data, "[if ") && strings.HasSuffix(data, "]") && !strings.Contains(data, ">")
+}
+
+// restoreProlog replaces the prolog of the cured HTML with the prolog of the source HTML, see
+// Ingredients.PreserveProlog. The prolog is everything up to and including the <html> start tag: the
+// doctype, the comments around it and the attributes of <html>. The cured HTML is returned as is if the
+// source has no <html> start tag.
+func restoreProlog(cured string, source string) string {
+	sourceEnd := prologEnd(source)
+	curedEnd := prologEnd(cured)
+	if sourceEnd < 0 || curedEnd < 0 {
+		return cured
+	}
+
+	return source[:sourceEnd] + cured[curedEnd:]
+}
+
+// prologEnd returns the index following the <html> start tag of an HTML document, skipping comments, or -1
+// if another element starts first.
+func prologEnd(document string) int {
+	for i := 0; i < len(document); i++ {
+		if document[i] != '<' {
+			continue
+		}
+		rest := document[i:]
+
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest[2:], "-->")
+			if end < 0 {
+				return -1
+			}
+			i += end + 4
+		case len(rest) > 5 && strings.EqualFold(rest[:5], "<html") && strings.IndexByte(" \t\n\r\f/>", rest[5]) >= 0:
+			return i + tagEnd(rest)
+		case strings.HasPrefix(rest, "<!") || strings.HasPrefix(rest, "<?"):
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				return -1
+			}
+			i += end
+		case len(rest) > 1 && isCSSNameChar(rest[1]):
+			return -1
+		}
+	}
+
+	return -1
+}
+
+// tagEnd returns the index following the start tag at the beginning of html, skipping quoted attribute values.
+func tagEnd(html string) int {
+	var quote byte
+	for i := 1; i < len(html); i++ {
+		switch c := html[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i + 1
+		}
+	}
+
+	return len(html)
+}
+
+// collapseWhitespace replaces every run of whitespace of text with a single space.
+func collapseWhitespace(text string) string {
+	var b strings.Builder
+
+	space := false
+	for i := 0; i < len(text); i++ {
+		if strings.IndexByte(" \t\n\r\f", text[i]) >= 0 {
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+
+		b.WriteByte(text[i])
+		space = false
+	}
+
+	return b.String()
+}
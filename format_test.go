@@ -0,0 +1,170 @@
+package antidote
+
+import (
+	"strings"
+	"testing"
+
+	nethtml "golang.org/x/net/html"
+)
+
+func TestRestoreProlog(t *testing.T) {
+	const body = `<head><title>Page</title></head><body></body></html>`
+
+	tests := []struct {
+		name   string
+		source string
+		cured  string
+		want   string
+	}{
+		{
+			name:   "doctype",
+			source: "<!doctype html>\n<html>\n<head><title>Page</title></head><body></body></html>",
+			cured:  "<!DOCTYPE html><html>" + body,
+			want:   "<!doctype html>\n<html>" + body,
+		},
+		{
+			name:   "legacy doctype",
+			source: `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN" "http://www.w3.org/TR/html4/loose.dtd"><html>` + body,
+			cured:  `<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN" "http://www.w3.org/TR/html4/loose.dtd"><html>` + body,
+			want:   `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN" "http://www.w3.org/TR/html4/loose.dtd"><html>` + body,
+		},
+		{
+			name:   "html attributes",
+			source: `<!DOCTYPE html><HTML lang=en data-x='a>b' class="no-js">` + body,
+			cured:  `<!DOCTYPE html><html lang="en" data-x="a>b" class="no-js">` + body,
+			want:   `<!DOCTYPE html><HTML lang=en data-x='a>b' class="no-js">` + body,
+		},
+		{
+			name: "conditional comments",
+			source: "<!DOCTYPE html>\n" +
+				`<!--[if lt IE 7]><html class="no-js ie6"><![endif]-->` + "\n" +
+				`<!--[if IE 7]><html class="no-js ie7"><![endif]-->` + "\n" +
+				`<!--[if gt IE 8]><!--> <html class="no-js"> <!--<![endif]-->` + "\n" + body,
+			cured: `<!DOCTYPE html><!--[if lt IE 7]><html class="no-js ie6"><![endif]--><!--[if IE 7]><html class="no-js ie7"><![endif]-->` +
+				`<!--[if gt IE 8]><!--><html class="no-js"><!--<![endif]-->` + body,
+			want: "<!DOCTYPE html>\n" +
+				`<!--[if lt IE 7]><html class="no-js ie6"><![endif]-->` + "\n" +
+				`<!--[if IE 7]><html class="no-js ie7"><![endif]-->` + "\n" +
+				`<!--[if gt IE 8]><!--> <html class="no-js"><!--<![endif]-->` + body,
+		},
+		{
+			name:   "xml declaration",
+			source: `<?xml version="1.0" encoding="UTF-8"?><html xmlns="http://www.w3.org/1999/xhtml">` + body,
+			cured:  `<html xmlns="http://www.w3.org/1999/xhtml">` + body,
+			want:   `<?xml version="1.0" encoding="UTF-8"?><html xmlns="http://www.w3.org/1999/xhtml">` + body,
+		},
+		{
+			name:   "no html start tag",
+			source: "<!DOCTYPE html><title>Page</title><p>Text</p>",
+			cured:  "<!DOCTYPE html><html><head><title>Page</title></head><body><p>Text</p></body></html>",
+			want:   "<!DOCTYPE html><html><head><title>Page</title></head><body><p>Text</p></body></html>",
+		},
+		{
+			name:   "element before html",
+			source: "<p>Text</p><html>" + body,
+			cured:  "<html>" + body,
+			want:   "<html>" + body,
+		},
+		{
+			name:   "htmlx is not html",
+			source: "<!DOCTYPE html><htmlx><html>" + body,
+			cured:  "<!DOCTYPE html><html>" + body,
+			want:   "<!DOCTYPE html><html>" + body,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := restoreProlog(test.cured, test.source); got != test.want {
+				t.Errorf("restoreProlog() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSerializeConditionalComments(t *testing.T) {
+	const page = `<!DOCTYPE html><html><head>` +
+		`<!--[if lt IE 9]><script src="html5shiv.js"></script><![endif]-->` +
+		`<!-- a regular comment -->` +
+		`</head><body>` +
+		`<![if !IE]><p>Not Internet Explorer</p><![endif]>` +
+		`</body></html>`
+
+	tests := []struct {
+		name   string
+		format OutputFormat
+		xhtml  bool
+		want   []string
+		absent []string
+	}{
+		{
+			name: "downlevel-revealed kept as they were",
+			want: []string{
+				`<!--[if lt IE 9]><script src="html5shiv.js"></script><![endif]-->`,
+				`<!-- a regular comment -->`,
+				`<![if !IE]><p>Not Internet Explorer</p><![endif]>`,
+			},
+			absent: []string{`<!--[if !IE]-->`, `<!--[endif]-->`},
+		},
+		{
+			name:   "minified",
+			format: OutputFormat{Layout: LayoutMinify},
+			want: []string{
+				`<!--[if lt IE 9]><script src="html5shiv.js"></script><![endif]-->`,
+				`<![if !IE]><p>Not Internet Explorer</p><![endif]>`,
+			},
+			absent: []string{`a regular comment`},
+		},
+		{
+			name:  "xhtml",
+			xhtml: true,
+			want: []string{
+				`<!--[if lt IE 9]><script src="html5shiv.js"></script><![endif]-->`,
+				`<!--[if !IE]--><p>Not Internet Explorer</p><!--[endif]-->`,
+			},
+			absent: []string{`<![if !IE]>`, `<![endif]>`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc, err := nethtml.Parse(strings.NewReader(page))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := serialize(doc, test.format, test.xhtml)
+			for _, want := range test.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("serialize() = %q, want it to contain %q", got, want)
+				}
+			}
+			for _, absent := range test.absent {
+				if strings.Contains(got, absent) {
+					t.Errorf("serialize() = %q, want it not to contain %q", got, absent)
+				}
+			}
+		})
+	}
+}
+
+func TestPreserveProlog(t *testing.T) {
+	const source = "<!doctype html>\n" +
+		`<!--[if IE 8]><html class="ie8" lang="en"><![endif]-->` + "\n" +
+		`<!--[if gt IE 8]><!--><html class="modern" lang="en"><!--<![endif]-->` + "\n" +
+		`<head><title>Page</title></head><body><p>Text</p></body></html>`
+
+	doc, err := nethtml.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cured := restoreProlog(serialize(doc, OutputFormat{}, false), source)
+	prolog := source[:strings.Index(source, `<!--<![endif]-->`)]
+	if !strings.HasPrefix(cured, prolog) {
+		t.Errorf("the cured HTML is %q, want it to start with %q", cured, prolog)
+	}
+	if !strings.HasSuffix(cured, "<p>Text</p></body></html>") || strings.Count(cured, "<head>") != 1 {
+		t.Errorf("the cured HTML is %q, want the rest of the document once", cured)
+	}
+}
@@ -0,0 +1,71 @@
+package antidote
+
+import (
+	"fmt"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+var (
+	headSelector = cascadia.MustCompile("head")
+	bodySelector = cascadia.MustCompile("body")
+)
+
+// compileSelector compiles Ingredients.Selector, so that an invalid one fails before anything is fetched.
+func (i *Ingredients) compileSelector() (cascadia.Selector, error) {
+	if i.Selector == "" {
+		return nil, nil
+	}
+
+	selector, err := cascadia.Compile(i.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %v", i.Selector, err)
+	}
+
+	return selector, nil
+}
+
+// selectFragment removes every element of the body of the document but the ones matching a selector, see
+// Ingredients.Selector. Their ancestors are kept, without their other children, so that the rules of the
+// stylesheets descending from them still apply.
+func (a *Antidote) selectFragment(selector cascadia.Selector) error {
+	body := bodySelector.MatchFirst(a.website.Nodes[0])
+	if body == nil {
+		return fmt.Errorf("the page has no body for the selector %q to match", a.ingredients.Selector)
+	}
+
+	selected := selector.MatchAll(body)
+	if len(selected) == 0 {
+		return fmt.Errorf("no element matches the selector %q", a.ingredients.Selector)
+	}
+
+	fragment := make(map[*html.Node]bool)
+	ancestors := make(map[*html.Node]bool)
+	for _, n := range selected {
+		fragment[n] = true
+		for parent := n.Parent; parent != nil && parent != body; parent = parent.Parent {
+			ancestors[parent] = true
+		}
+	}
+
+	var prune func(n *html.Node)
+	prune = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			switch {
+			case fragment[c]:
+			case ancestors[c]:
+				prune(c)
+			default:
+				n.RemoveChild(c)
+			}
+			c = next
+		}
+	}
+	prune(body)
+
+	a.log(LogDebug, "selected fragment", "selector", a.ingredients.Selector, "elements", len(selected))
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package antidote
+
+import (
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// maxFrameDepth is the number of levels of nested frames that are cured. Deeper frames are left as they are.
+const maxFrameDepth = 4
+
+// cureFrames will schedule curing the document of every <frame> element of a <frameset> as a website of its
+// own, with the same ingredients. Then it will replace the src of the <frame> with a data URL of the cured
+// document, as frames have no srcdoc attribute. With Ingredients.ExtractAssets the cured document is
+// extracted instead, with its assets inlined. Frames already in the chain of documents being cured are
+// skipped to break cycles, as are the ones nested deeper than maxFrameDepth.
+func (a *Antidote) cureFrames(p *pipeline) {
+	if len(a.frameChain) >= maxFrameDepth {
+		return
+	}
+
+	a.website.Find("frame").Each(func(index int, frame *goquery.Selection) {
+		src, ok := frame.Attr("src")
+		if !ok || strings.TrimSpace(src) == "" || hasScheme(src, "data", "about", "javascript") {
+			return
+		}
+
+		normalizedSrc, err := a.assetURL(nil, src)
+		if err != nil {
+			a.warn(err)
+			return
+		}
+
+		if normalizedSrc == a.parsedUrl.String() || a.frameChain[normalizedSrc] {
+			return
+		}
+
+		p.scheduleFetch(priorityFrame, AssetFrame, src, func() {
+			if a.exceedsBudget() {
+				a.keepRemote(p, frame, "src", src)
+				return
+			}
+
+			html, err := a.cureFrame(src, normalizedSrc)
+			if err != nil {
+				a.warn(err)
+				return
+			}
+
+			if a.ingredients.ExtractAssets {
+				name := a.extract(html, ".html", normalizedSrc)
+				p.mutate(func() {
+					frame.SetAttr("src", assetsDir+name)
+				})
+				return
+			}
+
+			dataURL := encodeDataURL("text/html", html)
+			p.mutate(func() {
+				frame.SetAttr("src", dataURL)
+			})
+		})
+	})
+}
+
+// cureFrame cures the document of a frame and returns its HTML. The document is recorded in the snapshot as
+// an asset of its own, along with its assets.
+func (a *Antidote) cureFrame(src string, normalizedSrc string) (string, error) {
+	asset := &Asset{Kind: AssetFrame, Source: src, URL: normalizedSrc}
+	defer a.record(asset)
+
+	ingredients := *a.ingredients
+	ingredients.URL = normalizedSrc
+	ingredients.Source = ""
+	ingredients.ExtractAssets = false
+
+	// The frame is cured within what is left of the budgets of the page, which must stay positive as zero means
+	// no limit.
+	if ingredients.Deadline > 0 {
+		ingredients.Deadline -= time.Since(a.snapshot.StartedAt)
+		if ingredients.Deadline <= 0 {
+			ingredients.Deadline = 1
+		}
+	}
+	if ingredients.MaxTotalSize > 0 {
+		a.mu.Lock()
+		ingredients.MaxTotalSize -= a.fetchedSize
+		a.mu.Unlock()
+		if ingredients.MaxTotalSize <= 0 {
+			ingredients.MaxTotalSize = 1
+		}
+	}
+
+	frame := New()
+	frame.Mix(&ingredients)
+	frame.frameChain = map[string]bool{a.parsedUrl.String(): true}
+	for u := range a.frameChain {
+		frame.frameChain[u] = true
+	}
+
+	start := time.Now()
+	snapshot, err := frame.CureToSnapshot()
+	if err == nil {
+		err = snapshot.Expand()
+	}
+	asset.Duration = time.Since(start)
+	if err != nil {
+		asset.Error = err.Error()
+		return "", err
+	}
+
+	asset.Size = len(snapshot.HTML)
+	asset.Hash = hashContent(snapshot.HTML)
+
+	for _, frameAsset := range snapshot.Assets {
+		if frameAsset.Parent == "" {
+			frameAsset.Parent = normalizedSrc
+		}
+	}
+
+	// The assets of the frame have been reported by Ingredients.OnAsset already.
+	a.mu.Lock()
+	a.snapshot.Assets = append(a.snapshot.Assets, snapshot.Assets...)
+	a.snapshot.Pruned = append(a.snapshot.Pruned, snapshot.Pruned...)
+	a.snapshot.Partial = a.snapshot.Partial || snapshot.Partial
+	a.mu.Unlock()
+
+	a.mergeHostStats(snapshot.Hosts)
+
+	return snapshot.HTML, nil
+}
+
+// hasScheme reports whether a URL has one of the schemes, case-insensitively.
+func hasScheme(src string, schemes ...string) bool {
+	src = strings.TrimSpace(src)
+	for _, scheme := range schemes {
+		if len(src) > len(scheme) && src[len(scheme)] == ':' && strings.EqualFold(src[:len(scheme)], scheme) {
+			return true
+		}
+	}
+
+	return false
+}
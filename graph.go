@@ -0,0 +1,138 @@
+package antidote
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// GraphNode object represents the page or one of its assets in an AssetGraph.
+type GraphNode struct {
+	// URL identifies the node: the URL of the page or of the asset, or its source if it could not be resolved.
+	URL string `json:"url"`
+
+	// Kind is "page", or the kind of the asset.
+	Kind string `json:"kind"`
+
+	// Host is the host the node was fetched from.
+	Host string `json:"host,omitempty"`
+
+	// ThirdParty is set when the host is neither the host of the page nor one of its subdomains, see
+	// Snapshot.Audit() for a finer classification.
+	ThirdParty bool `json:"thirdParty,omitempty"`
+
+	// Size is the number of bytes fetched for the node.
+	Size int `json:"size"`
+
+	// Remote is set when the asset was left as a reference to its URL, see Asset.Remote.
+	Remote bool `json:"remote,omitempty"`
+
+	// Error is the reason the asset could not be cured, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// GraphEdge object represents a reference from the page, a stylesheet or a frame to an asset.
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// AssetGraph object represents the dependency graph of a page: the stylesheets, scripts, images and frames it
+// references, the stylesheets, fonts and images those stylesheets reference in turn, and so on.
+type AssetGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// Graph returns the dependency graph of the assets of the snapshot, e.g. to audit third-party dependencies or
+// find out where a missing asset is referenced from. Assets referenced several times are a single node.
+func (s *Snapshot) Graph() *AssetGraph {
+	g := &AssetGraph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+
+	pageHost := ""
+	if u, err := url.Parse(s.URL); err == nil {
+		pageHost = u.Hostname()
+	}
+
+	nodes := make(map[string]int)
+	node := func(n GraphNode) int {
+		if i, ok := nodes[n.URL]; ok {
+			return i
+		}
+
+		if u, err := url.Parse(n.URL); err == nil && u.Hostname() != "" {
+			n.Host = u.Hostname()
+			n.ThirdParty = pageHost != "" && !firstParty(pageHost, n.Host)
+		}
+
+		nodes[n.URL] = len(g.Nodes)
+		g.Nodes = append(g.Nodes, n)
+		return nodes[n.URL]
+	}
+
+	node(GraphNode{URL: s.URL, Kind: "page", Size: len(s.HTML)})
+
+	edges := make(map[GraphEdge]bool)
+	for _, asset := range s.Assets {
+		id := asset.URL
+		if id == "" {
+			id = asset.Source
+		}
+
+		// Assets already seen, e.g. fetched from the cache of a prior cure, keep the outcome recorded first.
+		node(GraphNode{URL: id, Kind: string(asset.Kind), Size: asset.Size, Remote: asset.Remote, Error: asset.Error})
+
+		parent := asset.Parent
+		if parent == "" {
+			parent = s.URL
+		}
+		// Parents that are not assets themselves, e.g. the final URL of a redirected stylesheet, are still shown.
+		if _, ok := nodes[parent]; !ok {
+			node(GraphNode{URL: parent, Kind: string(AssetCSS)})
+		}
+
+		edge := GraphEdge{From: parent, To: id}
+		if !edges[edge] {
+			edges[edge] = true
+			g.Edges = append(g.Edges, edge)
+		}
+	}
+
+	return g
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language, e.g. to be rendered with `dot -Tsvg`. Failed assets are
+// red, and third-party ones dashed.
+func (g *AssetGraph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "digraph assets {")
+	fmt.Fprintln(bw, "\trankdir=LR;")
+	fmt.Fprintln(bw, "\tnode [shape=box, fontname=\"Helvetica\", fontsize=10];")
+
+	for _, n := range g.Nodes {
+		attributes := []string{"label=" + strconv.Quote(n.Kind+"\n"+n.URL)}
+		if n.Kind == "page" {
+			attributes = append(attributes, "shape=doubleoctagon")
+		}
+		if n.Error != "" {
+			attributes = append(attributes, "color=red", "fontcolor=red", "tooltip="+strconv.Quote(n.Error))
+		}
+		if n.ThirdParty {
+			attributes = append(attributes, "style=dashed")
+		}
+
+		fmt.Fprintf(bw, "\t%s [%s];\n", strconv.Quote(n.URL), strings.Join(attributes, ", "))
+	}
+
+	for _, e := range g.Edges {
+		fmt.Fprintf(bw, "\t%s -> %s;\n", strconv.Quote(e.From), strconv.Quote(e.To))
+	}
+
+	fmt.Fprintln(bw, "}")
+
+	return bw.Flush()
+}
@@ -0,0 +1,178 @@
+package antidote
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// HostRule object represents options that only apply to the pages and assets served from matching hosts.
+type HostRule struct {
+	// Host is the hostname the rule applies to. A leading "*." matches every subdomain, e.g. "*.example.com".
+	Host string
+
+	// Headers are added to every request made to the host.
+	Headers map[string]string
+
+	// Concurrency is the maximum number of requests made to the host at the same time. Zero means no limit
+	// other than Ingredients.Concurrency.
+	Concurrency int
+
+	// StripJS removes every <script> served from the host instead of inlining it. Inline scripts are
+	// considered to be served from the host of the website itself.
+	StripJS bool
+
+	// Renderer renders the pages cured from the host instead of Ingredients.Renderer, e.g. the single-page
+	// applications of a domain. It does not apply to the assets nor to the frames of a page.
+	Renderer Renderer
+}
+
+// matches reports whether the rule applies to a hostname.
+func (r *HostRule) matches(host string) bool {
+	return matchHost(r.Host, host)
+}
+
+// matchHost reports whether a hostname matches a pattern, in which a leading "*." matches every subdomain.
+// Internationalized hostnames match whether they are written in unicode or punycode.
+func matchHost(pattern string, host string) bool {
+	if strings.HasPrefix(pattern, "*.") {
+		pattern = "*." + asciiHostname(pattern[2:])
+	} else {
+		pattern = asciiHostname(pattern)
+	}
+	host = asciiHostname(host)
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+
+	return host == pattern
+}
+
+// hostRule returns the first rule of Ingredients.Hosts that applies to the host of a URL, or nil if none do.
+func (a *Antidote) hostRule(rawurl string) *HostRule {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil
+	}
+
+	for i := range a.ingredients.Hosts {
+		if rule := &a.ingredients.Hosts[i]; rule.matches(u.Hostname()) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// renderer returns the Renderer of the page: that of the host rule of URL if it has one, or else
+// Ingredients.Renderer.
+func (a *Antidote) renderer() Renderer {
+	if rule := a.hostRule(a.parsedUrl.String()); rule != nil && rule.Renderer != nil {
+		return rule.Renderer
+	}
+
+	return a.ingredients.Renderer
+}
+
+// hostSlots returns the semaphore limiting the concurrent requests made to the host of a rule.
+func (a *Antidote) hostSlots(rule *HostRule) chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	slots, ok := a.hostLimits[rule]
+	if !ok {
+		slots = make(chan struct{}, rule.Concurrency)
+		a.hostLimits[rule] = slots
+	}
+
+	return slots
+}
+
+// stripsScript reports whether a <script> is served from a host whose rule strips JS.
+func (a *Antidote) stripsScript(script *goquery.Selection) bool {
+	src, ok := script.Attr("src")
+	if !ok {
+		rule := a.hostRule(a.ingredients.URL)
+		return rule != nil && rule.StripJS
+	}
+
+	normalizedSrc, err := a.assetURL(nil, src)
+	if err != nil {
+		return false
+	}
+
+	rule := a.hostRule(normalizedSrc)
+	return rule != nil && rule.StripJS
+}
+
+// transport returns the transport of the cure, which dials through Ingredients.HostRewrites and
+// Ingredients.Resolver when they are set.
+func (a *Antidote) transport() (http.RoundTripper, error) {
+	if len(a.ingredients.HostRewrites) == 0 && a.ingredients.Resolver == nil {
+		return a.ingredients.Transport, nil
+	}
+
+	base := a.ingredients.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	t, ok := base.(*http.Transport)
+	if !ok {
+		return nil, errors.New("Ingredients.HostRewrites and Ingredients.Resolver require Ingredients.Transport to be an *http.Transport")
+	}
+
+	// The same settings as http.DefaultTransport.
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Resolver:  a.ingredients.Resolver,
+	}
+
+	t = t.Clone()
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, a.rewriteAddress(addr))
+	}
+
+	return t, nil
+}
+
+// rewriteAddress returns the address a connection to addr ("host:port") is made to instead according to
+// Ingredients.HostRewrites, preferring a rewrite of the host and port over one of the host alone. Hostnames
+// match whether they are written in unicode or punycode.
+func (a *Antidote) rewriteAddress(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	rewrites := make(map[string]string, len(a.ingredients.HostRewrites))
+	for from, to := range a.ingredients.HostRewrites {
+		if fromHost, fromPort, err := net.SplitHostPort(from); err == nil {
+			from = net.JoinHostPort(asciiHostname(fromHost), fromPort)
+		} else {
+			from = asciiHostname(from)
+		}
+		rewrites[from] = to
+	}
+
+	to, ok := rewrites[net.JoinHostPort(asciiHostname(host), port)]
+	if !ok {
+		if to, ok = rewrites[asciiHostname(host)]; !ok {
+			return addr
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(to); err == nil {
+		return to
+	}
+
+	return net.JoinHostPort(strings.Trim(to, "[]"), port)
+}
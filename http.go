@@ -1,57 +1,475 @@
 package antidote
 
 import (
-	"io/ioutil"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
+
+	"golang.org/x/net/idna"
 )
 
-func fetch(url string) (string, error) {
-	resp, err := http.Get(url)
+// response object represents the result of fetching a URL.
+type response struct {
+	// url is the URL the response was served from, after following redirects.
+	url string
+
+	body         string
+	contentType  string
+	etag         string
+	lastModified string
+
+	// notModified is set when a conditional request was answered with 304 Not Modified, in which case
+	// the body is empty.
+	notModified bool
+
+	// spill is set when the body was streamed to a temporary file, in which case the body is empty.
+	spill *spillFile
+
+	// remote is set when the asset is larger than Ingredients.InlineLimit, or was not fetched as the budget of
+	// the cure was exceeded, in which case overBudget is set as well.
+	remote     bool
+	overBudget bool
+
+	// resumed is the number of times the download was resumed, see Ingredients.ResumeAttempts.
+	resumed int
+}
+
+// applyHostRule adds the headers of the HostRule of the host of a request, and waits for a slot of the host if
+// the rule limits its concurrency. The returned function releases the slot once the request is done.
+func (a *Antidote) applyHostRule(req *http.Request) func() {
+	rule := a.hostRule(req.URL.String())
+	if rule == nil {
+		return func() {}
+	}
+
+	for name, value := range rule.Headers {
+		req.Header.Set(name, value)
+	}
+
+	if rule.Concurrency <= 0 {
+		return func() {}
+	}
+
+	hostSlots := a.hostSlots(rule)
+	hostSlots <- struct{}{}
+	return func() { <-hostSlots }
+}
+
+// fetch retrieves the body of a URL with the client of the cure in progress, applying the HostRule of
+// its host. The number of fetches made to the host at the same time is limited by HostRule.Concurrency.
+// If a prior version of the asset is given, the request is made conditional on its validators. If spillable
+// is set, large bodies are streamed to a temporary file (see Antidote.readBody()). Assets prefetched while the
+// page was downloading are not fetched again, see Ingredients.Prefetch.
+func (a *Antidote) fetch(url string, prior *Asset, spillable bool) (*response, error) {
+	if prior == nil {
+		if resp, err, ok := a.takePrefetch(url, spillable); ok {
+			return resp, err
+		}
+	}
+
+	return a.download(url, prior, spillable, nil)
+}
+
+// download fetches a URL, see Antidote.fetch(). If tee is set, the body is copied to the writer it returns for
+// the URL the response was served from and its headers while it is read, and the writer is closed once it is.
+func (a *Antidote) download(url string, prior *Asset, spillable bool, tee func(url string, header http.Header) io.WriteCloser) (*response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	if prior != nil {
+		if prior.ETag != "" {
+			req.Header.Set("If-None-Match", prior.ETag)
+		}
+		if prior.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prior.LastModified)
+		}
+	}
+
+	release := a.applyHostRule(req)
+	defer release()
+
+	// The slot of the scheduler is taken once the host allows the fetch, so that it is not held while waiting.
+	unschedule := a.schedule()
+	defer unschedule()
+
+	start := time.Now()
+	resp, err := a.do(req)
+	if err != nil {
+		a.log(LogDebug, "fetch failed", "url", url, "error", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	b, err := ioutil.ReadAll(resp.Body)
+	r := &response{
+		url:          url,
+		contentType:  resp.Header.Get("Content-Type"),
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		notModified:  prior != nil && resp.StatusCode == http.StatusNotModified,
+	}
+
+	// Transports are not required to set the request of their responses.
+	if resp.Request != nil {
+		r.url = resp.Request.URL.String()
+	}
+
+	if r.notModified {
+		a.log(LogDebug, "not modified", "url", url, "duration", time.Since(start).Round(time.Millisecond))
+		return r, nil
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		a.log(LogDebug, "fetch failed", "url", url, "status", resp.StatusCode)
+		return nil, &StatusError{URL: url, Code: resp.StatusCode}
+	}
+
+	limit := a.ingredients.MaxAssetSize
+	if limit > 0 && resp.ContentLength > limit {
+		return nil, &TruncatedError{URL: url, Limit: limit, Expected: resp.ContentLength}
+	}
+
+	resumer := a.newResumingReader(req, resp)
+	defer resumer.Close()
+
+	body := &countingReader{r: resumer}
+	if limit > 0 {
+		body.r = io.LimitReader(resumer, limit+1)
+	}
+	if a.ingredients.Scheduler != nil {
+		body.r = a.ingredients.Scheduler.reader(body.r)
+	}
+
+	var in io.Reader = body
+	if tee != nil {
+		w := tee(r.url, resp.Header)
+		defer w.Close()
+		in = io.TeeReader(body, w)
+	}
+
+	r.body, r.spill, err = a.readBody(in, resp.ContentLength, spillable)
+	a.countBytes(req.URL.Host, body.n)
+	r.resumed = resumer.resumed
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, &TruncatedError{URL: url, Read: body.n, Expected: resp.ContentLength}
+	}
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	if limit > 0 && body.n > limit {
+		return nil, &TruncatedError{URL: url, Limit: limit, Read: body.n, Expected: resp.ContentLength}
+	}
+	if resp.ContentLength >= 0 && body.n != resp.ContentLength {
+		return nil, &TruncatedError{URL: url, Read: body.n, Expected: resp.ContentLength}
+	}
+
+	a.log(LogDebug, "fetched", "url", url, "status", resp.StatusCode, "size", body.n, "duration", time.Since(start).Round(time.Millisecond))
+
+	return r, nil
+}
+
+// TruncatedError is returned when the body of a response is cut short, either by Ingredients.MaxAssetSize
+// or because the connection ended before the Content-Length of the response was read.
+type TruncatedError struct {
+	// URL is the URL of the response.
+	URL string
+
+	// Limit is the Ingredients.MaxAssetSize the body exceeded, or zero if it was cut short by the server.
+	Limit int64
+
+	// Read is the number of bytes read before the body was cut short.
+	Read int64
+
+	// Expected is the Content-Length of the response, or -1 if it is unknown.
+	Expected int64
+}
+
+func (e *TruncatedError) Error() string {
+	if e.Limit > 0 {
+		return fmt.Sprintf("%s: body exceeds the limit of %d bytes", e.URL, e.Limit)
+	}
+
+	return fmt.Sprintf("%s: body truncated after %d of %d bytes", e.URL, e.Read, e.Expected)
+}
+
+// StatusError is returned when a URL is answered with a status code other than 2xx, or 304 Not Modified to a
+// conditional request, e.g. 404 Not Found for a missing asset.
+type StatusError struct {
+	// URL is the URL of the response.
+	URL string
+
+	// Code is the status code of the response.
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %d %s", e.URL, e.Code, http.StatusText(e.Code))
+}
+
+// resumingReader reads the body of a response, and resumes it with a Range request from where it was cut short,
+// up to Ingredients.ResumeAttempts times. The server must answer with the rest of the same version of the body:
+// the request is conditional on its validator with If-Range, and the Content-Range of the response must
+// start where the body was cut short and have the same total size.
+type resumingReader struct {
+	a        *Antidote
+	req      *http.Request
+	body     io.ReadCloser
+	read     int64
+	size     int64
+	attempts int
+	resumed  int
+}
+
+func (a *Antidote) newResumingReader(req *http.Request, resp *http.Response) *resumingReader {
+	r := &resumingReader{a: a, req: req, body: resp.Body, size: resp.ContentLength}
+
+	// Redirects have been followed already.
+	if resp.Request != nil {
+		r.req = resp.Request
+	}
+
+	r.req = r.req.Clone(r.req.Context())
+	r.req.Header.Del("If-None-Match")
+	r.req.Header.Del("If-Modified-Since")
+	// The rest of the body is requested as is, as ranges apply to the encoded body.
+	r.req.Header.Set("Accept-Encoding", "identity")
+
+	// Without a validator, If-Range can not tell whether the body changed in the meantime. Weak ETags are not
+	// allowed by If-Range.
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		r.req.Header.Set("If-Range", etag)
+	} else if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		r.req.Header.Set("If-Range", lastModified)
+	} else {
+		r.size = -1
 	}
 
-	return string(b), nil
+	if resp.Header.Get("Accept-Ranges") == "none" || resp.Header.Get("Content-Encoding") != "" {
+		r.size = -1
+	}
+
+	return r
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.body.Read(p)
+		r.read += int64(n)
+
+		if err == nil || err == io.EOF && (r.size < 0 || r.read >= r.size) {
+			return n, err
+		}
+
+		if r.size < 0 || r.read >= r.size || r.attempts >= r.a.ingredients.ResumeAttempts || !r.resume(err) {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
 }
 
-func addHttpProtocolIfNotExists(url string) string {
-	if strings.Contains(url, "http://") || strings.Contains(url, "https://") {
-		return url
+// resume requests the rest of the body, and reports whether the server sent it.
+func (r *resumingReader) resume(cause error) bool {
+	r.attempts++
+
+	req := r.req.Clone(r.req.Context())
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.read))
+
+	r.a.log(LogDebug, "resuming", "url", req.URL, "offset", r.read, "size", r.size, "error", cause)
+
+	// The slot of the request in the throttle of its host is still held by the body being resumed.
+	resp, err := r.a.send(req)
+	if err != nil {
+		r.a.log(LogDebug, "resume failed", "url", req.URL, "error", err)
+		return false
+	}
+
+	if resp.StatusCode != http.StatusPartialContent || !matchContentRange(resp.Header.Get("Content-Range"), r.read, r.size) {
+		resp.Body.Close()
+		r.a.log(LogDebug, "resume refused", "url", req.URL, "status", resp.StatusCode, "range", resp.Header.Get("Content-Range"))
+		return false
 	}
 
-	return "http://" + url
+	r.body.Close()
+	r.body = resp.Body
+	r.resumed++
+
+	return true
+}
+
+func (r *resumingReader) Close() error {
+	return r.body.Close()
+}
+
+// matchContentRange reports whether a Content-Range header, e.g. "bytes 1000-4999/5000", holds the bytes of a
+// body of a size from an offset to its end.
+func matchContentRange(contentRange string, offset int64, size int64) bool {
+	var start, end, total int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total); err != nil {
+		return false
+	}
+
+	return start == offset && end == size-1 && total == size
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
 }
 
-// normalizeSourceUrl converts relative URL's like '/css/foo/bar.css' into HTTP requestable URL's
-// like 'http://domain.com/css/foo/bar.css' based on the source origin.
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// normalizeSourceUrl resolves the URL of an asset like '/css/foo/bar.css', '../bar.css' or '//cdn.com/bar.css'
+// against the URL of the page it is referenced by, e.g. 'https://domain.com:8443/css/foo/bar.css'. Relative
+// URL's keep the scheme, userinfo, host and port of the origin exactly as they are, and protocol-relative
+// URL's its scheme. The fragment is dropped, as it is never sent to the server.
 func normalizeSourceUrl(assetPath string, origin *url.URL) (string, error) {
-	s, err := url.Parse(assetPath)
+	s, err := url.Parse(strings.TrimSpace(assetPath))
 	if err != nil {
 		return "", err
 	}
 
-	// Remove '//' from assets. Ex: //foo.bar/baz.css => foo.bar/baz.css
-	if strings.HasPrefix(assetPath, "//") {
-		assetPath = strings.Replace(assetPath, "//", "", 1)
+	u := origin.ResolveReference(s)
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if err := encodeURL(u); err != nil {
+		return "", err
 	}
 
-	// Remove relative '..' paths. Ex: ../../app.css => app.css
-	assetPath = strings.Replace(assetPath, "../", "", -1)
+	return u.String(), nil
+}
 
-	// If the asset path doesn't contain a host (meaning it's a relative path), prefix it with the origin host.
-	if len(s.Host) == 0 {
-		assetPath = addHttpProtocolIfNotExists(origin.Host + "/" + assetPath)
-	} else {
-		assetPath = addHttpProtocolIfNotExists(assetPath)
+// encodeURL converts a URL to the form sent over the wire: internationalized hostnames are converted to
+// their ASCII (punycode) form, e.g. "bücher.example" to "xn--bcher-kva.example", and the characters of the
+// query that are not allowed in URL's, such as spaces or unicode characters, are percent-encoded. Paths
+// are percent-encoded by url.URL.String() already.
+func encodeURL(u *url.URL) error {
+	if hostname := u.Hostname(); hostname != "" && net.ParseIP(hostname) == nil {
+		asciiHostname, err := idna.Lookup.ToASCII(hostname)
+		if err != nil {
+			return fmt.Errorf("invalid hostname %q: %v", hostname, err)
+		}
+
+		if port := u.Port(); port != "" {
+			u.Host = net.JoinHostPort(asciiHostname, port)
+		} else {
+			u.Host = asciiHostname
+		}
+	}
+
+	u.RawQuery = escapeQuery(u.RawQuery)
+
+	return nil
+}
+
+// escapeQuery percent-encodes the bytes of a raw query that are not allowed in URL's, leaving existing
+// escapes and the delimiters of the query as they are.
+func escapeQuery(query string) string {
+	const hex = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case c == '%' && i+2 < len(query) && isHex(query[i+1]) && isHex(query[i+2]):
+			b.WriteByte(c)
+		case c <= ' ' || c >= 0x7f || strings.IndexByte(`"%<>\^`+"`{|}", c) >= 0:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// isHex reports whether c is a hexadecimal digit.
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// asciiHostname returns the lowercased ASCII (punycode) form of a hostname, or the lowercased hostname if it
+// is not valid, so that hostnames can be compared whichever form they are written in.
+func asciiHostname(hostname string) string {
+	if asciiHostname, err := idna.Lookup.ToASCII(hostname); err == nil {
+		return strings.ToLower(asciiHostname)
+	}
+
+	return strings.ToLower(hostname)
+}
+
+// baseURL returns the URL the references of the response are resolved against, or nil if it is invalid, in
+// which case they are resolved against the URL of the page.
+func (r *response) baseURL() *url.URL {
+	u, err := url.Parse(r.url)
+	if err != nil {
+		return nil
+	}
+
+	return u
+}
+
+// resolveBase sets the URL the references of the document are resolved against: the URL the page was
+// served from after following redirects, or the href of its <base> element resolved against that URL. With
+// Ingredients.ExtractAssets the <base> element is removed, as it would break the references to the
+// extracted files.
+func (a *Antidote) resolveBase(page *response) error {
+	base, err := url.Parse(page.url)
+	if err != nil {
+		return err
+	}
+
+	baseElement := a.website.Find("base[href]").First()
+	if href, ok := baseElement.Attr("href"); ok {
+		// Browsers ignore invalid base URL's, and so does Antidote.
+		if ref, err := url.Parse(strings.TrimSpace(href)); err == nil {
+			base = base.ResolveReference(ref)
+		}
+	}
+
+	if a.ingredients.ExtractAssets {
+		a.website.Find("base").Remove()
+	}
+
+	if err := encodeURL(base); err != nil {
+		return err
+	}
+	a.parsedUrl = base
+
+	return nil
+}
+
+// assetURL resolves the URL of an asset against the URL of the asset it is referenced by, e.g. a stylesheet,
+// or against the URL of the page if base is nil. It is upgraded to https with Ingredients.UpgradeInsecure, or
+// Ingredients.RepairMixedContent on pages served over https.
+func (a *Antidote) assetURL(base *url.URL, src string) (string, error) {
+	if base == nil {
+		base = a.parsedUrl
+	}
+
+	upgrade := a.ingredients.UpgradeInsecure || a.repairsMixedContent()
+
+	normalized, err := normalizeSourceUrl(src, base)
+	if err != nil || !upgrade || !strings.HasPrefix(normalized, "http://") {
+		return normalized, err
 	}
 
-	return assetPath, nil
+	return "https://" + strings.TrimPrefix(normalized, "http://"), nil
 }
@@ -0,0 +1,57 @@
+package antidote_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lansana/antidote"
+	"github.com/lansana/antidote/antidotetest"
+)
+
+func TestStatusError(t *testing.T) {
+	t.Run("asset", func(t *testing.T) {
+		f := antidotetest.NewFixture("https://status.antidote.test/")
+		f.Add(&antidotetest.Response{
+			URL:        f.URL,
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+			Body:       []byte(`<html><head><link rel="stylesheet" href="/missing.css"></head><body><img src="/missing.png"></body></html>`),
+		})
+
+		snapshot, err := antidotetest.Replay(f, antidote.Ingredients{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snapshot.Close()
+
+		// An asset answered with a 404 is left as a reference to its URL instead of inlining the error page.
+		for _, want := range []string{`href="/missing.css"`, `src="/missing.png"`} {
+			if !strings.Contains(snapshot.HTML, want) {
+				t.Errorf("the cured page is %q, want it to contain %q", snapshot.HTML, want)
+			}
+		}
+		if len(snapshot.Assets) != 2 {
+			t.Fatalf("the snapshot has %d assets, want 2", len(snapshot.Assets))
+		}
+		for _, asset := range snapshot.Assets {
+			if !strings.Contains(asset.Error, "404") {
+				t.Errorf("the error of %s is %q, want a 404", asset.URL, asset.Error)
+			}
+		}
+	})
+
+	t.Run("page", func(t *testing.T) {
+		f := antidotetest.NewFixture("https://status.antidote.test/missing.html")
+
+		_, err := antidotetest.Replay(f, antidote.Ingredients{})
+		var status *antidote.StatusError
+		if !errors.As(err, &status) {
+			t.Fatalf("the cure returned %v, want a *StatusError", err)
+		}
+		if status.Code != http.StatusNotFound {
+			t.Errorf("the status code is %d, want %d", status.Code, http.StatusNotFound)
+		}
+	})
+}
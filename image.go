@@ -0,0 +1,212 @@
+package antidote
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// lazySourceAttrs are the attributes lazy loaders hold the URL of an image in until it is loaded.
+var lazySourceAttrs = []string{"data-src", "data-lazy-src", "data-original", "data-srcset", "data-lazy-srcset"}
+
+// imageTypes are the MIME types of the images by their lowercased extension.
+var imageTypes = map[string]string{
+	".apng": "image/apng",
+	".avif": "image/avif",
+	".bmp":  "image/bmp",
+	".gif":  "image/gif",
+	".ico":  "image/x-icon",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".webp": "image/webp",
+}
+
+// imageType returns the MIME type of an image from the extension of the path of its URL, case-insensitively.
+// URL's without an extension, as served by many CDN's, may be images of any type, in which case ok is set
+// but the MIME type is empty and must be determined from the response.
+func imageType(src string) (mimeType string, ok bool) {
+	u, err := url.Parse(strings.TrimSpace(src))
+	if err != nil || u.Scheme == "data" {
+		return "", false
+	}
+
+	extension := strings.ToLower(path.Ext(u.Path))
+	if extension == "" {
+		return "", true
+	}
+
+	mimeType, ok = imageTypes[extension]
+	return mimeType, ok
+}
+
+// imageExtension returns the shortest extension of the images of a MIME type, e.g. ".jpg" for "image/jpeg".
+func imageExtension(mimeType string) string {
+	var shortest string
+	for extension, t := range imageTypes {
+		if t == mimeType && (shortest == "" || len(extension) < len(shortest)) {
+			shortest = extension
+		}
+	}
+
+	return shortest
+}
+
+// typeByExtension returns the MIME type of an asset from its lowercased extension, or
+// "application/octet-stream" if it is unknown.
+func typeByExtension(extension string) string {
+	if mimeType, ok := imageTypes[extension]; ok {
+		return mimeType
+	}
+	if mimeType, ok := fontTypes[extension]; ok {
+		return mimeType
+	}
+	if mimeType := mime.TypeByExtension(extension); mimeType != "" {
+		return mimeType
+	}
+
+	return "application/octet-stream"
+}
+
+// mediaType returns the MIME type of the response from its Content-Type header, without parameters. It is
+// empty if the header is missing, invalid or generic (e.g. "application/octet-stream").
+func (r *response) mediaType() string {
+	mimeType, _, err := mime.ParseMediaType(r.contentType)
+	if err != nil || mimeType == "application/octet-stream" || mimeType == "binary/octet-stream" || mimeType == "text/plain" {
+		return ""
+	}
+
+	return mimeType
+}
+
+// imageMimeType returns the MIME type of an image response from its Content-Type header, falling back to
+// sniffing its content. It is empty if the response is not an image.
+func (r *response) imageMimeType() string {
+	mimeType := r.mediaType()
+	if mimeType == "" {
+		mimeType = http.DetectContentType([]byte(r.body))
+	}
+
+	if !strings.HasPrefix(mimeType, "image/") {
+		return ""
+	}
+
+	return mimeType
+}
+
+// servedImageType returns the MIME type of an image response: the image type it is served with, or else
+// extensionType, the type of the extension of its URL. It is empty if the response is served with a type other
+// than an image, e.g. an HTML error page at the URL of a .png, whatever its extension.
+func (r *response) servedImageType(extensionType string) string {
+	if servedType := r.imageMimeType(); servedType != "" {
+		return servedType
+	}
+	if r.mediaType() != "" {
+		return ""
+	}
+
+	return extensionType
+}
+
+// servedImageType returns the MIME type of an image response, see response.servedImageType(), and warns when the
+// response is not an image.
+func (a *Antidote) servedImageType(resp *response, extensionType string) string {
+	mimeType := resp.servedImageType(extensionType)
+	if mimeType == "" {
+		a.log(LogWarn, "not an image", "url", resp.url, "contentType", resp.contentType)
+	}
+
+	return mimeType
+}
+
+// promoteNoscript replaces every <noscript> element with its content, as browsers would show it once scripts
+// are stripped, or only those holding an image if imagesOnly is set. Lazy loaders commonly precede the
+// fallback with an image without a src, which would never load without scripts, so an <img> right before the
+// <noscript> is removed if it lazily loads one of the promoted images.
+func (a *Antidote) promoteNoscript(imagesOnly bool) {
+	a.website.Find("noscript").Each(func(index int, noscript *goquery.Selection) {
+		parent := noscript.Parent()
+		if parent.Length() == 0 {
+			return
+		}
+
+		nodes, err := html.ParseFragment(strings.NewReader(noscript.Text()), parent.Get(0))
+		if err != nil {
+			a.warn(err)
+			return
+		}
+
+		var sources []string
+		for _, node := range nodes {
+			sources = append(sources, imageSources(node)...)
+		}
+		if imagesOnly && len(sources) == 0 {
+			return
+		}
+
+		if placeholder := noscript.Prev(); len(sources) > 0 && placeholder.Is("img") && a.lazilyLoads(placeholder, sources) {
+			placeholder.Remove()
+		}
+
+		noscript.ReplaceWithNodes(nodes...)
+	})
+}
+
+// imageSources returns the src of every <img> element of a node and of its descendants.
+func imageSources(n *html.Node) []string {
+	var sources []string
+
+	if n.Type == html.ElementNode && n.Data == "img" {
+		for _, attr := range n.Attr {
+			if attr.Key == "src" && attr.Namespace == "" {
+				sources = append(sources, attr.Val)
+			}
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sources = append(sources, imageSources(c)...)
+	}
+
+	return sources
+}
+
+// lazilyLoads reports whether an <img> lazily loads one of the sources, by any of lazySourceAttrs. Sources
+// are compared once resolved against the URL of the page.
+func (a *Antidote) lazilyLoads(img *goquery.Selection, sources []string) bool {
+	for _, attr := range lazySourceAttrs {
+		value, ok := img.Attr(attr)
+		if !ok {
+			continue
+		}
+
+		// A srcset lists candidates as "url descriptor", separated by commas.
+		for _, candidate := range strings.Split(value, ",") {
+			fields := strings.Fields(candidate)
+			if len(fields) == 0 {
+				continue
+			}
+
+			lazyURL, err := a.assetURL(nil, fields[0])
+			if err != nil {
+				continue
+			}
+
+			for _, src := range sources {
+				if srcURL, err := a.assetURL(nil, src); err == nil && srcURL == lazyURL {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
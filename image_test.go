@@ -0,0 +1,117 @@
+package antidote_test
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lansana/antidote"
+	"github.com/lansana/antidote/antidotetest"
+)
+
+// imageFixture returns a fixture of a page with one <img> of src, an absolute path, served with contentType.
+func imageFixture(t *testing.T, src string, contentType string) *antidotetest.Fixture {
+	var body bytes.Buffer
+	if err := png.Encode(&body, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	f := antidotetest.NewFixture("https://images.antidote.test/")
+	f.Add(&antidotetest.Response{
+		URL:        f.URL,
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		Body:       []byte(`<html><body><img src="` + src + `"></body></html>`),
+	})
+	f.Add(&antidotetest.Response{
+		URL:        f.URL + strings.TrimPrefix(strings.SplitN(src, "#", 2)[0], "/"),
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {contentType}},
+		Body:       body.Bytes(),
+	})
+
+	return f
+}
+
+func TestCureImages(t *testing.T) {
+	tests := []struct {
+		name        string
+		src         string
+		contentType string
+		want        string
+	}{
+		// Curing any image used to panic, as the extensions were appended to make([]string, len, 0).
+		{"extension", "/photo.png", "image/png", "data:image/png;base64,"},
+		{"uppercase extension", "/PHOTO.PNG", "image/png", "data:image/png;base64,"},
+		{"mixed case extension", "/photo.JpG", "image/jpeg", "data:image/jpeg;base64,"},
+		{"query and fragment", "/photo.png?w=100#top", "image/png", "data:image/png;base64,"},
+		{"generic type", "/photo.png", "application/octet-stream", "data:image/png;base64,"},
+		{"served type wins", "/photo.jpg", "image/png", "data:image/png;base64,"},
+
+		// CDN's commonly serve images without an extension.
+		{"extensionless", "/cdn/3f8a91c2", "image/png", "data:image/png;base64,"},
+		{"extensionless sniffed", "/cdn/3f8a91c2", "application/octet-stream", "data:image/png;base64,"},
+
+		// A page served instead of the image is not inlined as one, whatever its extension.
+		{"not an image", "/photo.png", "text/html; charset=utf-8", "/photo.png"},
+		{"not an image extension", "/photo.html", "image/png", "/photo.html"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			snapshot, err := antidotetest.Replay(imageFixture(t, test.src, test.contentType), antidote.Ingredients{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer snapshot.Close()
+
+			if !strings.Contains(snapshot.HTML, `<img src="`+test.want) {
+				t.Errorf("the cured page is %q, want an image with a src starting with %q", snapshot.HTML, test.want)
+			}
+		})
+	}
+}
+
+func TestCureStyleImages(t *testing.T) {
+	tests := []struct {
+		name        string
+		css         string
+		contentType string
+		want        string
+	}{
+		{"url", `background: url(/photo.png)`, "image/png", `url("data:image/png;base64,`},
+		{"image-set", `background: image-set("/photo.png" 1x)`, "image/png", `url("data:image/png;base64,`},
+
+		// A page served instead of the image is left as a reference to the URL of the image.
+		{"url not an image", `background: url(/photo.png)`, "text/html; charset=utf-8", `url("https://images.antidote.test/photo.png")`},
+		{"image-set not an image", `background: image-set("/photo.png" 1x)`, "text/html; charset=utf-8", `url("https://images.antidote.test/photo.png")`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := imageFixture(t, "/photo.png", test.contentType)
+			f.Add(&antidotetest.Response{
+				URL:        f.URL,
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+				Body:       []byte(`<html><head><style>body { ` + test.css + ` }</style></head><body></body></html>`),
+			})
+
+			snapshot, err := antidotetest.Replay(f, antidote.Ingredients{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer snapshot.Close()
+
+			if !strings.Contains(snapshot.HTML, test.want) {
+				t.Errorf("the cured page is %q, want it to contain %q", snapshot.HTML, test.want)
+			}
+			if strings.Contains(snapshot.HTML, "data:text/html") {
+				t.Errorf("the cured page is %q, want no page inlined as an image", snapshot.HTML)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package antidote
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// indexSchema creates the tables of an Index. It is written for SQLite, with the FTS5 extension for the text
+// of the pages, whose rows share the IDs of the snapshots.
+const indexSchema = `
+CREATE TABLE IF NOT EXISTS snapshots (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	url           TEXT NOT NULL,
+	title         TEXT NOT NULL,
+	captured_at   TEXT NOT NULL,
+	hash          TEXT NOT NULL,
+	size          INTEGER NOT NULL,
+	assets        INTEGER NOT NULL,
+	failed_assets INTEGER NOT NULL,
+	output        TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS snapshots_url ON snapshots (url);
+CREATE INDEX IF NOT EXISTS snapshots_captured_at ON snapshots (captured_at);
+CREATE VIRTUAL TABLE IF NOT EXISTS snapshots_text USING fts5 (title, text, tokenize = 'porter unicode61');
+`
+
+// IndexEntry object represents a snapshot recorded in an Index.
+type IndexEntry struct {
+	ID int64 `json:"id"`
+
+	// URL is the URL of the website that was cured.
+	URL string `json:"url"`
+
+	// Title is the title of the page, if it has one.
+	Title string `json:"title"`
+
+	// CapturedAt is when the cure began.
+	CapturedAt time.Time `json:"capturedAt"`
+
+	// Hash and Size are the hex encoded SHA-256 hash and the size of the complete HTML of the page.
+	Hash string `json:"hash"`
+	Size int    `json:"size"`
+
+	// Assets is the number of assets the cure attempted, and FailedAssets the number of those that failed.
+	Assets       int `json:"assets"`
+	FailedAssets int `json:"failedAssets"`
+
+	// Output is where the snapshot was written, e.g. a file or a directory.
+	Output string `json:"output,omitempty"`
+
+	// Snippet is the part of the text of the page matching IndexQuery.Match, with the matched terms in brackets.
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// IndexQuery object represents the criteria of Index.Search(). Zero values match every snapshot.
+type IndexQuery struct {
+	// Text matches snapshots whose URL or title contains it, case-insensitively.
+	Text string
+
+	// Match is a full-text query of the title and the text of the pages, in the FTS5 query syntax: every term
+	// must match, "quoted phrases" match in sequence, and OR and NOT combine terms. Snapshots matching it are
+	// returned best match first.
+	Match string
+
+	// URL matches snapshots of exactly this URL.
+	URL string
+
+	// Since and Until match snapshots captured within their range.
+	Since time.Time
+	Until time.Time
+
+	// Limit is the maximum number of snapshots returned. Defaults to all of them.
+	Limit int
+}
+
+// Index records every snapshot of a local archive in a SQL database, so that the archive can be listed and
+// searched without reading the snapshots. The schema is written for SQLite; the database/sql driver is up to
+// the caller, e.g. modernc.org/sqlite or github.com/mattn/go-sqlite3. It is safe for concurrent use.
+type Index struct {
+	db *sql.DB
+}
+
+// OpenIndex creates a new instance of an Index pointer on a database, creating its table if needed.
+func OpenIndex(db *sql.DB) (*Index, error) {
+	if _, err := db.Exec(indexSchema); err != nil {
+		return nil, err
+	}
+
+	return &Index{db: db}, nil
+}
+
+// Add records a snapshot along with where it was written.
+func (i *Index) Add(snapshot *Snapshot, output string) (IndexEntry, error) {
+	hash, size, err := snapshot.pageHash()
+	if err != nil {
+		return IndexEntry{}, err
+	}
+
+	entry := IndexEntry{
+		URL:          snapshot.URL,
+		Title:        snapshot.title(),
+		CapturedAt:   snapshot.StartedAt.UTC(),
+		Hash:         hash,
+		Size:         size,
+		Assets:       len(snapshot.Assets),
+		FailedAssets: len(snapshot.Errors()),
+		Output:       output,
+	}
+
+	text, err := snapshot.Text(TextOptions{BlockSeparator: "\n"})
+	if err != nil {
+		return IndexEntry{}, err
+	}
+
+	tx, err := i.db.Begin()
+	if err != nil {
+		return IndexEntry{}, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`INSERT INTO snapshots (url, title, captured_at, hash, size, assets, failed_assets, output)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.URL, entry.Title, entry.CapturedAt.Format(time.RFC3339Nano), entry.Hash, entry.Size,
+		entry.Assets, entry.FailedAssets, entry.Output,
+	)
+	if err != nil {
+		return IndexEntry{}, err
+	}
+
+	if entry.ID, err = result.LastInsertId(); err != nil {
+		return IndexEntry{}, err
+	}
+
+	_, err = tx.Exec("INSERT INTO snapshots_text (rowid, title, text) VALUES (?, ?, ?)", entry.ID, entry.Title, text)
+	if err != nil {
+		return IndexEntry{}, err
+	}
+
+	return entry, tx.Commit()
+}
+
+// Search returns the snapshots matching a query, most recently captured first, or best match first with
+// IndexQuery.Match.
+func (i *Index) Search(query IndexQuery) ([]IndexEntry, error) {
+	var where []string
+	var args []interface{}
+
+	columns := "id, url, snapshots.title, captured_at, hash, size, assets, failed_assets, output, ''"
+	tables := "snapshots"
+	order := "captured_at DESC, id DESC"
+
+	if query.Match != "" {
+		columns = "id, url, snapshots.title, captured_at, hash, size, assets, failed_assets, output, " +
+			"snippet(snapshots_text, 1, '[', ']', '...', 16)"
+		tables = "snapshots JOIN snapshots_text ON snapshots_text.rowid = snapshots.id"
+		order = "bm25(snapshots_text), " + order
+
+		where = append(where, "snapshots_text MATCH ?")
+		args = append(args, query.Match)
+	}
+
+	if query.Text != "" {
+		pattern := "%" + escapeLike(strings.ToLower(query.Text)) + "%"
+		where = append(where, `(lower(url) LIKE ? ESCAPE '\' OR lower(snapshots.title) LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+	if query.URL != "" {
+		where = append(where, "url = ?")
+		args = append(args, query.URL)
+	}
+	if !query.Since.IsZero() {
+		where = append(where, "captured_at >= ?")
+		args = append(args, query.Since.UTC().Format(time.RFC3339Nano))
+	}
+	if !query.Until.IsZero() {
+		where = append(where, "captured_at < ?")
+		args = append(args, query.Until.UTC().Format(time.RFC3339Nano))
+	}
+
+	statement := "SELECT " + columns + " FROM " + tables
+	if len(where) > 0 {
+		statement += " WHERE " + strings.Join(where, " AND ")
+	}
+	statement += " ORDER BY " + order
+	if query.Limit > 0 {
+		statement += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+
+	rows, err := i.db.Query(statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []IndexEntry{}
+	for rows.Next() {
+		var entry IndexEntry
+		var capturedAt string
+
+		err := rows.Scan(&entry.ID, &entry.URL, &entry.Title, &capturedAt, &entry.Hash, &entry.Size,
+			&entry.Assets, &entry.FailedAssets, &entry.Output, &entry.Snippet)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.CapturedAt, err = time.Parse(time.RFC3339Nano, capturedAt); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// title returns the title of the page, see Metadata.Title, or else for snapshots cured before their metadata was
+// extracted, the text of its title element, or an empty string.
+func (s *Snapshot) title() string {
+	if s.Metadata.Title != "" {
+		return s.Metadata.Title
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(s.HTML))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(doc.Find("title").First().Text())
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern with backslashes.
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
@@ -0,0 +1,104 @@
+package antidote
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// InjectPosition is where an Injection is inserted in the cured document.
+type InjectPosition string
+
+const (
+	// InjectHeadStart inserts at the start of the <head>, before the stylesheets of the page.
+	InjectHeadStart InjectPosition = "head-start"
+
+	// InjectHeadEnd inserts at the end of the <head>, after the stylesheets of the page, so that rules of the
+	// same specificity override them.
+	InjectHeadEnd InjectPosition = "head-end"
+
+	// InjectBodyStart inserts at the start of the <body>.
+	InjectBodyStart InjectPosition = "body-start"
+
+	// InjectBodyEnd inserts at the end of the <body>, after the content and the scripts of the page.
+	InjectBodyEnd InjectPosition = "body-end"
+)
+
+// Injection object represents HTML inserted as is into the cured document, see Ingredients.Inject.
+type Injection struct {
+	Position InjectPosition
+	HTML     string
+}
+
+// InjectCSS returns an Injection of a stylesheet at the end of the <head>, e.g. print styles or dark mode
+// overrides.
+func InjectCSS(css string) Injection {
+	return Injection{Position: InjectHeadEnd, HTML: "<style>" + css + "</style>"}
+}
+
+// InjectJS returns an Injection of a script at the end of the <body>.
+func InjectJS(js string) Injection {
+	return Injection{Position: InjectBodyEnd, HTML: "<script>" + js + "</script>"}
+}
+
+// InjectMeta returns an Injection of a <meta name="..." content="..."> tag at the start of the <head>.
+func InjectMeta(name string, content string) Injection {
+	return Injection{
+		Position: InjectHeadStart,
+		HTML:     fmt.Sprintf(`<meta name="%s" content="%s">`, html.EscapeString(name), html.EscapeString(content)),
+	}
+}
+
+func (i Injection) validate() error {
+	switch i.Position {
+	case InjectHeadStart, InjectHeadEnd, InjectBodyStart, InjectBodyEnd:
+		return nil
+	default:
+		return fmt.Errorf("unknown injection position %q", i.Position)
+	}
+}
+
+// inject inserts Ingredients.Inject into the document, in order at every position. The injected HTML is not
+// cured: the assets it references are left as they are.
+func (a *Antidote) inject() error {
+	root := a.website.Nodes[0]
+
+	for _, position := range []InjectPosition{InjectHeadStart, InjectHeadEnd, InjectBodyStart, InjectBodyEnd} {
+		name, dataAtom, selector := "body", atom.Body, bodySelector
+		if position == InjectHeadStart || position == InjectHeadEnd {
+			name, dataAtom, selector = "head", atom.Head, headSelector
+		}
+		context := &nethtml.Node{Type: nethtml.ElementNode, Data: name, DataAtom: dataAtom}
+
+		var parent, before *nethtml.Node
+		for _, injection := range a.ingredients.Inject {
+			if injection.Position != position {
+				continue
+			}
+
+			if parent == nil {
+				if parent = selector.MatchFirst(root); parent == nil {
+					return fmt.Errorf("the page has no <%s> to inject into", name)
+				}
+				// Injections at the start are inserted before the first child of the page, one after the other.
+				if position == InjectHeadStart || position == InjectBodyStart {
+					before = parent.FirstChild
+				}
+			}
+
+			nodes, err := nethtml.ParseFragment(strings.NewReader(injection.HTML), context)
+			if err != nil {
+				return err
+			}
+
+			for _, n := range nodes {
+				parent.InsertBefore(n, before)
+			}
+		}
+	}
+
+	return nil
+}
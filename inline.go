@@ -0,0 +1,266 @@
+package antidote
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// uninlinedElements are the elements that are not rendered, which never get a style attribute from
+// inlineStylesheets().
+var uninlinedElements = map[string]bool{
+	"base": true, "head": true, "link": true, "meta": true, "noscript": true, "script": true, "style": true,
+	"template": true, "title": true,
+}
+
+// inlineSpecificity is the specificity given to the declarations of style attributes, above that of any
+// selector.
+var inlineSpecificity = cascadia.Specificity{1 << 20, 0, 0}
+
+// inlineDeclaration object represents a declaration applying to an element, ranked by the cascade.
+type inlineDeclaration struct {
+	property    string
+	value       string
+	important   bool
+	specificity cascadia.Specificity
+
+	// order is the position of the rule of the declaration in the stylesheets of the document.
+	order int
+}
+
+// less reports whether the declaration loses to another one in the cascade.
+func (d *inlineDeclaration) less(other *inlineDeclaration) bool {
+	switch {
+	case d.important != other.important:
+		return !d.important
+	case d.specificity != other.specificity:
+		return d.specificity.Less(other.specificity)
+	default:
+		return d.order < other.order
+	}
+}
+
+// parseDeclarations returns the declarations of a declaration list, e.g. the block of a rule or a style
+// attribute, ranked with a specificity and an order.
+func parseDeclarations(block string, specificity cascadia.Specificity, order int) []*inlineDeclaration {
+	var declarations []*inlineDeclaration
+	for _, declaration := range splitCSS(stripCSSComments(block), ';') {
+		colon := strings.IndexByte(declaration, ':')
+		if colon < 0 {
+			continue
+		}
+
+		property := strings.TrimSpace(declaration[:colon])
+		value := strings.TrimSpace(declaration[colon+1:])
+		if property == "" || value == "" {
+			continue
+		}
+		if !strings.HasPrefix(property, "--") {
+			property = strings.ToLower(property)
+		}
+
+		important := false
+		if bang := strings.LastIndexByte(value, '!'); bang >= 0 && strings.EqualFold(strings.TrimSpace(value[bang+1:]), "important") {
+			important = true
+			value = strings.TrimSpace(value[:bang])
+		}
+
+		declarations = append(declarations, &inlineDeclaration{
+			property:    property,
+			value:       value,
+			important:   important,
+			specificity: specificity,
+			order:       order,
+		})
+	}
+
+	return declarations
+}
+
+// InlineStyleOptions object represents options for InlineStyles() and Snapshot.InlineStyles().
+type InlineStyleOptions struct {
+	// DropStyles removes every <style> element once its rules are inlined, along with the rules that can not be
+	// inlined and the stylesheets of other media, for consumers that strip <style> elements anyway.
+	DropStyles bool
+}
+
+// InlineStyles returns an HTML document with the rules of its stylesheets moved into the style attributes of the
+// elements they match. The declarations applying to every element are resolved as the cascade would: by
+// importance, then by the specificity of their selector, then by their order, and the declarations of the style
+// attribute itself win over the rules that are not !important. The rules that can not be inlined, i.e. the
+// at-rules, e.g. @media or @font-face, and the rules of selectors depending on the state of the page, e.g.
+// a:hover, are kept in a single <style> element in the <head>, unless InlineStyleOptions.DropStyles is set. The
+// stylesheets of other media, e.g. <style media="print">, are left as they are, and <link> elements are ignored.
+func InlineStyles(document string, options InlineStyleOptions) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(document))
+	if err != nil {
+		return "", err
+	}
+
+	inlineStylesheets(doc, options)
+
+	return goquery.OuterHtml(doc.Selection)
+}
+
+// InlineStyles moves the rules of the stylesheets of the cured page into the style attributes of the elements
+// they match, see InlineStyles(). The placeholders of spilled assets are kept, see Snapshot.WriteHTML().
+func (s *Snapshot) InlineStyles(options InlineStyleOptions) error {
+	inlined, err := InlineStyles(s.HTML, options)
+	if err != nil {
+		return err
+	}
+	s.HTML = inlined
+
+	return nil
+}
+
+// inlineStyles inlines the stylesheets of the page into style attributes, see Ingredients.InlineStyles.
+func (a *Antidote) inlineStyles() {
+	if a.ingredients.InlineStyles {
+		inlineStylesheets(a.website, InlineStyleOptions{DropStyles: a.ingredients.DropStyles})
+	}
+}
+
+// inlineStylesheets moves the rules of the stylesheets of a document into the style attributes of the elements
+// they match, see InlineStyles().
+func inlineStylesheets(doc *goquery.Document, options InlineStyleOptions) {
+	root := doc.Nodes[0]
+	styles := doc.Find("style").FilterFunction(func(index int, style *goquery.Selection) bool {
+		media, _ := style.Attr("media")
+		media = strings.ToLower(strings.TrimSpace(media))
+		return media == "" || media == "all" || media == "screen"
+	})
+
+	matched := make(map[*html.Node][]*inlineDeclaration)
+	var kept strings.Builder
+	order := 0
+
+	styles.Each(func(index int, style *goquery.Selection) {
+		css := style.Text()
+		for i := 0; i < len(css); {
+			open := scanCSS(css, i, "{;")
+			if open == len(css) || css[open] == ';' {
+				end := open + 1
+				if end > len(css) {
+					end = len(css)
+				}
+				kept.WriteString(css[i:end])
+				i = end
+				continue
+			}
+
+			close := scanCSS(css, open+1, "}")
+			if close == len(css) {
+				kept.WriteString(css[i:])
+				break
+			}
+
+			prelude := strings.TrimSpace(stripCSSComments(css[i:open]))
+			block := css[open+1 : close]
+			i = close + 1
+
+			if strings.HasPrefix(prelude, "@") {
+				kept.WriteString(prelude + "{" + block + "}")
+				continue
+			}
+
+			var uninlined []string
+			for _, selector := range splitCSS(prelude, ',') {
+				selector = strings.TrimSpace(selector)
+
+				sel, err := cascadia.Parse(selector)
+				if err != nil || matchableSelector(selector) != selector {
+					uninlined = append(uninlined, selector)
+					continue
+				}
+
+				order++
+				declarations := parseDeclarations(block, sel.Specificity(), order)
+				for _, n := range cascadia.QueryAll(root, sel) {
+					matched[n] = append(matched[n], declarations...)
+				}
+			}
+
+			if len(uninlined) > 0 {
+				kept.WriteString(strings.Join(uninlined, ",") + "{" + block + "}")
+			}
+		}
+	})
+
+	for n, declarations := range matched {
+		if uninlinedElements[n.Data] || isInHead(n) {
+			continue
+		}
+
+		element := goquery.NewDocumentFromNode(n).Selection
+		if style, ok := element.Attr("style"); ok {
+			declarations = append(declarations, parseDeclarations(style, inlineSpecificity, order+1)...)
+		}
+		element.SetAttr("style", cascadeDeclarations(declarations))
+	}
+
+	if options.DropStyles {
+		doc.Find("style").Remove()
+		return
+	}
+	if styles.Length() == 0 {
+		return
+	}
+
+	first := styles.First()
+	styles.Slice(1, styles.Length()).Remove()
+	if css := strings.TrimSpace(kept.String()); css != "" {
+		first.SetText(css)
+		if head := doc.Find("head"); head.Length() > 0 && !first.ParentsFiltered("head").Is("head") {
+			first.Remove()
+			head.AppendSelection(first)
+		}
+	} else {
+		first.Remove()
+	}
+}
+
+// cascadeDeclarations returns the declaration list of the declarations winning the cascade, one per property, in
+// the order of the cascade so that shorthand and longhand properties still override each other as they did.
+func cascadeDeclarations(declarations []*inlineDeclaration) string {
+	winners := make(map[string]*inlineDeclaration)
+	for _, declaration := range declarations {
+		if winner, ok := winners[declaration.property]; !ok || !declaration.less(winner) {
+			winners[declaration.property] = declaration
+		}
+	}
+
+	var cascaded []*inlineDeclaration
+	for _, declaration := range declarations {
+		if winners[declaration.property] == declaration {
+			cascaded = append(cascaded, declaration)
+		}
+	}
+	sort.SliceStable(cascaded, func(i, j int) bool {
+		return cascaded[i].less(cascaded[j])
+	})
+
+	list := make([]string, len(cascaded))
+	for i, declaration := range cascaded {
+		list[i] = declaration.property + ": " + declaration.value
+		if declaration.important {
+			list[i] += " !important"
+		}
+	}
+
+	return strings.Join(list, "; ")
+}
+
+// isInHead reports whether a node is within the <head> of the document.
+func isInHead(n *html.Node) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Type == html.ElementNode && p.Data == "head" {
+			return true
+		}
+	}
+
+	return false
+}
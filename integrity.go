@@ -0,0 +1,122 @@
+package antidote
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// IntegrityPolicy is how the scripts and stylesheets fetched are checked against the hashes of their integrity
+// attribute (Subresource Integrity), e.g. to catch a CDN serving tampered assets during a capture.
+type IntegrityPolicy string
+
+const (
+	// IntegrityIgnore does not check integrity attributes.
+	IntegrityIgnore IntegrityPolicy = ""
+
+	// IntegrityReport logs a warning for the assets not matching their integrity attribute, and sets
+	// Asset.IntegrityMismatch, but inlines them anyway.
+	IntegrityReport IntegrityPolicy = "report"
+
+	// IntegrityEnforce fails the assets not matching their integrity attribute, which are left as a reference to
+	// their URL, so browsers refuse to load them the same way they would have on the original page.
+	IntegrityEnforce IntegrityPolicy = "enforce"
+)
+
+func (p IntegrityPolicy) validate() error {
+	switch p {
+	case IntegrityIgnore, IntegrityReport, IntegrityEnforce:
+		return nil
+	default:
+		return fmt.Errorf("unknown integrity policy %q", p)
+	}
+}
+
+// integrityAlgorithms are the hash algorithms of integrity metadata, from the weakest to the strongest.
+var integrityAlgorithms = []struct {
+	name string
+	hash func() hash.Hash
+}{
+	{"sha256", sha256.New},
+	{"sha384", sha512.New384},
+	{"sha512", sha512.New},
+}
+
+// matchesIntegrity reports whether body matches the integrity metadata of an element, a list of
+// "algorithm-base64" hashes. As in browsers, only the hashes of the strongest algorithm are considered, any of
+// which may match, and metadata without a known algorithm matches any body.
+func matchesIntegrity(body string, metadata string) bool {
+	strongest := -1
+	var digests []string
+
+	for _, token := range strings.Fields(metadata) {
+		// Options follow a question mark, and are ignored.
+		token = strings.SplitN(token, "?", 2)[0]
+
+		parts := strings.SplitN(token, "-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		for i, algorithm := range integrityAlgorithms {
+			if !strings.EqualFold(parts[0], algorithm.name) || i < strongest {
+				continue
+			}
+			if i > strongest {
+				strongest, digests = i, nil
+			}
+			digests = append(digests, parts[1])
+		}
+	}
+
+	if strongest < 0 {
+		return true
+	}
+
+	h := integrityAlgorithms[strongest].hash()
+	h.Write([]byte(body))
+	sum := h.Sum(nil)
+
+	for _, digest := range digests {
+		// Both the standard and the URL alphabets of base64 are found in the wild, with or without padding.
+		digest = strings.NewReplacer("-", "+", "_", "/").Replace(strings.TrimRight(digest, "="))
+		if digest == base64.RawStdEncoding.EncodeToString(sum) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IntegrityError is returned by assets not matching their integrity attribute with IntegrityEnforce.
+type IntegrityError struct {
+	// URL is the URL of the asset.
+	URL string
+
+	// Integrity is the integrity attribute of the element referencing the asset.
+	Integrity string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("%s: body does not match the integrity attribute %q", e.URL, e.Integrity)
+}
+
+// verifyIntegrity checks the body of an asset against the integrity attribute of the element referencing it,
+// according to Ingredients.Integrity.
+func (a *Antidote) verifyIntegrity(asset *Asset, body string, integrity string) error {
+	if a.ingredients.Integrity == IntegrityIgnore || strings.TrimSpace(integrity) == "" || matchesIntegrity(body, integrity) {
+		return nil
+	}
+
+	err := &IntegrityError{URL: asset.URL, Integrity: integrity}
+	if a.ingredients.Integrity == IntegrityEnforce {
+		return err
+	}
+
+	asset.IntegrityMismatch = true
+	a.warn(err)
+	return nil
+}
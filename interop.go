@@ -0,0 +1,218 @@
+package antidote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// SaveTool is a tool saving web pages into single HTML files with their assets embedded as data URL's, whose
+// pages are read by ReadSavedPage(), e.g. to cure an archive of them again, and written by
+// Snapshot.WriteSavedPage(), for users of those tools.
+type SaveTool string
+
+const (
+	// SaveToolMonolith is monolith (https://github.com/Y2Z/monolith), whose pages start with a comment of their URL
+	// and save date: <!-- Saved from https://www.website.com/ at 2021-06-01T12:00:00Z using monolith v2.4.0 -->.
+	SaveToolMonolith SaveTool = "monolith"
+
+	// SaveToolSingleFile is SingleFile (https://github.com/gildas-lormeau/SingleFile), whose pages have a comment of
+	// their URL and save date after their <html> start tag, and a <link rel="canonical">. The images it embeds more
+	// than once are stored in CSS custom properties (--sf-img-*), the <img> elements showing them as backgrounds.
+	SaveToolSingleFile SaveTool = "singlefile"
+)
+
+func (t SaveTool) validate() error {
+	switch t {
+	case SaveToolMonolith, SaveToolSingleFile:
+		return nil
+	default:
+		return fmt.Errorf("unknown save tool %q", t)
+	}
+}
+
+// SavedPage object represents a page saved by a SaveTool.
+type SavedPage struct {
+	// Tool is the tool the page was saved by, or empty if it was not recognized.
+	Tool SaveTool
+
+	// URL is the URL the page was saved from, if it was recorded.
+	URL string
+
+	// SavedAt is when the page was saved, if it was recorded.
+	SavedAt time.Time
+
+	// HTML is the page, to be cured as Ingredients.Source.
+	HTML string
+}
+
+// savedHeaderSize is the length of the start of a page searched for the comment of a SaveTool.
+const savedHeaderSize = 4096
+
+var (
+	monolithCommentPattern   = regexp.MustCompile(`<!--\s*Saved from (\S+) at (\S+) using [^>]*?-->`)
+	singleFileCommentPattern = regexp.MustCompile(`(?s)<!--\s*Page saved with SingleFile(.*?)-->`)
+	singleFileImagePattern   = regexp.MustCompile(`--sf-img-(\d+)\s*:\s*url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]*))\s*\)`)
+	htmlStartTagPattern      = regexp.MustCompile(`(?i)<html(?:\s[^>]*)?>`)
+	headStartTagPattern      = regexp.MustCompile(`(?i)<head(?:\s[^>]*)?>`)
+	canonicalLinkPattern     = regexp.MustCompile(`(?i)<link\s[^>]*rel\s*=\s*["']?canonical`)
+)
+
+// singleFileDateLayout is the layout of the save date of SingleFile, that of Date.prototype.toString() in
+// JavaScript, followed by the name of the time zone in parentheses.
+const singleFileDateLayout = "Mon Jan 02 2006 15:04:05 GMT-0700"
+
+// singleFileImageProperties are the declarations SingleFile adds to the style of an <img> element to show an
+// image stored in a CSS custom property as its background.
+var singleFileImageProperties = map[string]bool{
+	"background-blend-mode": true, "background-clip": true, "background-position": true, "background-color": true,
+	"background-image": true, "background-size": true, "background-origin": true, "background-repeat": true,
+}
+
+// ReadSavedPage reads a page saved by monolith or SingleFile, along with its URL and save date, whose comment is
+// removed from the page, as Snapshot.WriteSavedPage() writes it again. The images of SingleFile stored in CSS
+// custom properties are put back in the src of their <img> elements, so that the page is cured, e.g. into
+// Markdown, as it shows. Pages of other tools are read as they are.
+func ReadSavedPage(r io.Reader) (*SavedPage, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &SavedPage{HTML: string(b)}
+	header := page.HTML
+	if len(header) > savedHeaderSize {
+		header = header[:savedHeaderSize]
+	}
+
+	if match := singleFileCommentPattern.FindStringSubmatch(header); match != nil {
+		page.Tool = SaveToolSingleFile
+		page.HTML = strings.Replace(page.HTML, match[0], "", 1)
+		for _, line := range strings.Split(match[1], "\n") {
+			line = strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "url:"):
+				page.URL = strings.TrimSpace(strings.TrimPrefix(line, "url:"))
+			case strings.HasPrefix(line, "saved date:"):
+				date := strings.TrimSpace(strings.TrimPrefix(line, "saved date:"))
+				if i := strings.Index(date, " ("); i >= 0 {
+					date = date[:i]
+				}
+				if t, err := time.Parse(singleFileDateLayout, date); err == nil {
+					page.SavedAt = t
+				}
+			}
+		}
+
+		if page.HTML, err = unpackSingleFileImages(page.HTML); err != nil {
+			return nil, err
+		}
+	} else if match := monolithCommentPattern.FindStringSubmatch(header); match != nil {
+		page.Tool = SaveToolMonolith
+		page.HTML = strings.TrimLeft(strings.Replace(page.HTML, match[0], "", 1), "\n")
+		// Pages saved from a file or stdin are "Saved from local source".
+		if hasScheme(match[1], "http", "https") {
+			page.URL = match[1]
+		}
+		if t, err := time.Parse(time.RFC3339, match[2]); err == nil {
+			page.SavedAt = t
+		}
+	}
+
+	return page, nil
+}
+
+// unpackSingleFileImages puts the images SingleFile stored in CSS custom properties back in the src of the <img>
+// elements showing them, and removes the background declarations of their style.
+func unpackSingleFileImages(page string) (string, error) {
+	images := make(map[string]string)
+	for _, match := range singleFileImagePattern.FindAllStringSubmatch(page, -1) {
+		images["--sf-img-"+match[1]] = match[2] + match[3] + match[4]
+	}
+	if len(images) == 0 {
+		return page, nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		return "", err
+	}
+
+	doc.Find("img[style]").Each(func(index int, img *goquery.Selection) {
+		style, _ := img.Attr("style")
+
+		var src string
+		var kept []string
+		for _, declaration := range strings.Split(style, ";") {
+			parts := strings.SplitN(declaration, ":", 2)
+			property := strings.ToLower(strings.TrimSpace(parts[0]))
+			if len(parts) == 2 && singleFileImageProperties[property] && strings.Contains(parts[1], "!important") {
+				if property == "background-image" {
+					value := strings.TrimSpace(strings.Replace(parts[1], "!important", "", 1))
+					src = images[strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(value, "var("), ")"))]
+				}
+				continue
+			}
+			if strings.TrimSpace(declaration) != "" {
+				kept = append(kept, declaration)
+			}
+		}
+
+		if src == "" {
+			return
+		}
+
+		img.SetAttr("src", src)
+		if len(kept) == 0 {
+			img.RemoveAttr("style")
+		} else {
+			img.SetAttr("style", strings.Join(kept, ";"))
+		}
+	})
+
+	return doc.Html()
+}
+
+// WriteSavedPage writes the cured page as the tool would have saved it, with the comment of its URL and capture
+// date, see SaveTool, so that it is recognized by the tools and the viewers of their archives. The assets must
+// be inlined, as the pages of these tools are single files.
+func (s *Snapshot) WriteSavedPage(w io.Writer, tool SaveTool) error {
+	if err := tool.validate(); err != nil {
+		return err
+	}
+
+	if len(s.Files) > 0 {
+		return errors.New("a snapshot with extracted assets can not be written as a single file")
+	}
+
+	// A URL could otherwise end the comment.
+	escapedURL := strings.Replace(s.URL, ">", "%3E", -1)
+
+	saved := *s
+	switch tool {
+	case SaveToolMonolith:
+		saved.HTML = fmt.Sprintf("<!-- Saved from %s at %s using antidote -->\n", escapedURL, s.StartedAt.UTC().Format(time.RFC3339)) + s.HTML
+	case SaveToolSingleFile:
+		if !canonicalLinkPattern.MatchString(saved.HTML) {
+			if loc := headStartTagPattern.FindStringIndex(saved.HTML); loc != nil {
+				saved.HTML = saved.HTML[:loc[1]] + `<link rel="canonical" href="` + strings.Replace(escapedURL, `"`, "%22", -1) + `">` + saved.HTML[loc[1]:]
+			}
+		}
+
+		comment := fmt.Sprintf("<!--\n Page saved with SingleFile \n url: %s \n saved date: %s (%s)\n-->",
+			escapedURL, s.StartedAt.Format(singleFileDateLayout), s.StartedAt.Format("MST"))
+		if loc := htmlStartTagPattern.FindStringIndex(saved.HTML); loc != nil {
+			saved.HTML = saved.HTML[:loc[1]] + comment + saved.HTML[loc[1]:]
+		} else {
+			saved.HTML = comment + saved.HTML
+		}
+	}
+
+	return saved.WriteHTML(w)
+}
@@ -0,0 +1,114 @@
+package antidote
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LogLevel is the verbosity of the messages logged during a cure.
+type LogLevel int
+
+const (
+	// LogError only logs errors. Cures return their errors rather than log them, so nothing is logged.
+	LogError LogLevel = iota
+
+	// LogWarn logs the assets that could not be cured. It is the default level.
+	LogWarn
+
+	// LogInfo logs the start and the end of every cure.
+	LogInfo
+
+	// LogDebug logs every HTTP request made by a cure.
+	LogDebug
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogError:
+		return "error"
+	case LogWarn:
+		return "warn"
+	case LogInfo:
+		return "info"
+	case LogDebug:
+		return "debug"
+	default:
+		return strconv.Itoa(int(l))
+	}
+}
+
+// Logger writes the messages logged during cures up to a level, one line of key=value fields per message, e.g.
+// `level=debug msg=fetched url=https://www.website.com/style.css status=200 size=5120 duration=12ms`.
+// It is safe for concurrent use.
+type Logger struct {
+	// Level is the most verbose level of the messages written.
+	Level LogLevel
+
+	// Output is where messages are written. Defaults to the standard logger of the log package.
+	Output io.Writer
+
+	// Handler receives the messages instead of Output when set, with their pairs of keys and values, e.g. to
+	// report the warnings of a cure as its progress. It may be called concurrently from multiple goroutines.
+	Handler func(level LogLevel, msg string, keyvals ...interface{})
+
+	mu sync.Mutex
+}
+
+// defaultLogger is used by cures without Ingredients.Logger.
+var defaultLogger = &Logger{Level: LogWarn}
+
+// Log writes a message at a level, followed by pairs of keys and values, if the level is enabled.
+func (l *Logger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	if level > l.Level {
+		return
+	}
+
+	if l.Handler != nil {
+		l.Handler(level, msg, keyvals...)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("level=" + level.String() + " msg=" + logValue(msg))
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		b.WriteString(fmt.Sprintf(" %v=", keyvals[i]) + logValue(fmt.Sprint(keyvals[i+1])))
+	}
+
+	if l.Output == nil {
+		log.Print(b.String())
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fmt.Fprintln(l.Output, b.String())
+}
+
+// logValue quotes a value of a message if it is empty or contains spaces, quotes or equal signs.
+func logValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n\r\"=") {
+		return strconv.Quote(value)
+	}
+
+	return value
+}
+
+// log writes a message with the logger of the ingredients, see Ingredients.Logger.
+func (a *Antidote) log(level LogLevel, msg string, keyvals ...interface{}) {
+	logger := a.ingredients.Logger
+	if logger == nil {
+		logger = defaultLogger
+	}
+
+	logger.Log(level, msg, keyvals...)
+}
+
+// warn logs an asset that could not be cured.
+func (a *Antidote) warn(err error) {
+	a.log(LogWarn, err.Error())
+}
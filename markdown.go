@@ -0,0 +1,384 @@
+package antidote
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// MarkdownOptions object represents options for Snapshot.Markdown().
+type MarkdownOptions struct {
+	// RemoveBoilerplate only converts the main content of the page, see TextOptions.RemoveBoilerplate.
+	RemoveBoilerplate bool
+}
+
+// markdownEscaper escapes the characters of text that Markdown would take for formatting.
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`, `<`, `\<`)
+
+// Markdown returns the page converted to CommonMark, with GitHub tables: headings, paragraphs, emphasis, links,
+// lists, block quotes, code and tables are kept, the rest of the markup is dropped. Images keep their source, so
+// they are embedded as data URLs, or reference the files extracted by Ingredients.ExtractAssets, see
+// Snapshot.WriteMarkdownDir().
+func (s *Snapshot) Markdown(options MarkdownOptions) (string, error) {
+	content, err := s.content(options.RemoveBoilerplate)
+	if err != nil {
+		return "", err
+	}
+
+	var blocks []string
+	for _, n := range content.Nodes {
+		if b := markdownBlocks(n); b != "" {
+			blocks = append(blocks, b)
+		}
+	}
+
+	return strings.Join(blocks, "\n\n") + "\n", nil
+}
+
+// WriteMarkdownDir writes the page converted to Markdown to a directory as index.md, along with the extracted
+// files it references, which requires the snapshot to be cured with Ingredients.ExtractAssets.
+func (s *Snapshot) WriteMarkdownDir(dir string, options MarkdownOptions) error {
+	markdown, err := s.Markdown(options)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, name := range s.fileNames() {
+		if !strings.Contains(markdown, "("+name+")") {
+			continue
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(target, s.Files[name], 0644); err != nil {
+			return err
+		}
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, "index.md"), []byte(markdown), 0644)
+}
+
+// markdownBlocks converts the children of a node to Markdown blocks separated by blank lines. Runs of inline
+// children are gathered into paragraphs.
+func markdownBlocks(n *html.Node) string {
+	var blocks []string
+	var inline strings.Builder
+
+	paragraph := func() {
+		if text := trimMarkdownLines(inline.String()); text != "" {
+			blocks = append(blocks, text)
+		}
+		inline.Reset()
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode || c.Type == html.ElementNode && inlineElements[c.Data] {
+			inline.WriteString(markdownInline(c))
+			continue
+		}
+
+		paragraph()
+		if b := markdownBlock(c); b != "" {
+			blocks = append(blocks, b)
+		}
+	}
+	paragraph()
+
+	return strings.Join(blocks, "\n\n")
+}
+
+// markdownBlock converts a block element to Markdown.
+func markdownBlock(n *html.Node) string {
+	if n.Type != html.ElementNode || skippedElements[n.Data] {
+		return ""
+	}
+
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		text := strings.Join(strings.Fields(markdownChildren(n)), " ")
+		if text == "" {
+			return ""
+		}
+		return strings.Repeat("#", int(n.Data[1]-'0')) + " " + text
+	case "p":
+		return trimMarkdownLines(markdownChildren(n))
+	case "pre":
+		return markdownCode(n)
+	case "ul", "ol":
+		return markdownList(n)
+	case "blockquote":
+		content := markdownBlocks(n)
+		if content == "" {
+			return ""
+		}
+		return prefixLines(content, "> ", ">")
+	case "hr":
+		return "---"
+	case "table":
+		return markdownTable(n)
+	default:
+		return markdownBlocks(n)
+	}
+}
+
+// markdownInline converts an inline node to Markdown. Whitespace is collapsed as a browser would.
+func markdownInline(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return markdownEscaper.Replace(collapseWhitespace(n.Data))
+	}
+	if n.Type != html.ElementNode || skippedElements[n.Data] {
+		return ""
+	}
+
+	switch n.Data {
+	case "br":
+		return "\\\n"
+	case "strong", "b":
+		return wrapMarkdown(markdownChildren(n), "**")
+	case "em", "i":
+		return wrapMarkdown(markdownChildren(n), "*")
+	case "del", "s", "strike":
+		return wrapMarkdown(markdownChildren(n), "~~")
+	case "code", "kbd", "samp", "tt":
+		return markdownCodeSpan(collapseWhitespace(nodeText(n)))
+	case "img":
+		src := attr(n, "src")
+		if src == "" {
+			return ""
+		}
+		return "![" + markdownEscaper.Replace(attr(n, "alt")) + "](" + markdownURL(src) + ")"
+	case "a":
+		text := markdownChildren(n)
+		href := attr(n, "href")
+		if href == "" || strings.HasPrefix(strings.ToLower(href), "javascript:") || strings.TrimSpace(text) == "" {
+			return text
+		}
+		return wrapMarkdownLink(text, markdownURL(href))
+	default:
+		// Blocks within inline elements are flattened into the line.
+		if !inlineElements[n.Data] {
+			return " " + markdownChildren(n) + " "
+		}
+		return markdownChildren(n)
+	}
+}
+
+// markdownChildren converts the children of a node to inline Markdown.
+func markdownChildren(n *html.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(markdownInline(c))
+	}
+
+	return b.String()
+}
+
+// markdownCode converts a preformatted element to a fenced code block, with the language of a language-*
+// class of its <code> element.
+func markdownCode(n *html.Node) string {
+	code := strings.TrimSuffix(nodeText(n), "\n")
+
+	language := ""
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "code" {
+			for _, class := range strings.Fields(attr(c, "class")) {
+				if strings.HasPrefix(class, "language-") {
+					language = strings.TrimPrefix(class, "language-")
+				}
+			}
+		}
+	}
+
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+
+	return fence + language + "\n" + code + "\n" + fence
+}
+
+// markdownList converts a list to Markdown, with the content of every item indented under its marker.
+func markdownList(n *html.Node) string {
+	var items []string
+
+	number := 1
+	if start := attr(n, "start"); start != "" {
+		fmt.Sscan(start, &number)
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Data != "li" {
+			continue
+		}
+
+		marker := "- "
+		if n.Data == "ol" {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+
+		content := markdownBlocks(c)
+		indent := strings.Repeat(" ", len(marker))
+		items = append(items, marker+strings.TrimPrefix(prefixLines(content, indent, ""), indent))
+	}
+
+	return strings.Join(items, "\n")
+}
+
+// markdownTable converts a table to a GitHub table, whose first row is the header.
+func markdownTable(n *html.Node) string {
+	var rows [][]string
+	columns := 0
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+
+			switch c.Data {
+			case "thead", "tbody", "tfoot":
+				walk(c)
+			case "tr":
+				var row []string
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+						text := strings.Join(strings.Fields(strings.Replace(markdownChildren(cell), "\\\n", " ", -1)), " ")
+						row = append(row, strings.Replace(text, "|", `\|`, -1))
+					}
+				}
+				if len(row) > columns {
+					columns = len(row)
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+	walk(n)
+
+	if columns == 0 {
+		return ""
+	}
+
+	line := func(cells []string) string {
+		for len(cells) < columns {
+			cells = append(cells, "")
+		}
+		return "| " + strings.Join(cells, " | ") + " |"
+	}
+
+	separator := make([]string, columns)
+	for i := range separator {
+		separator[i] = "---"
+	}
+
+	lines := []string{line(rows[0]), line(separator)}
+	for _, row := range rows[1:] {
+		lines = append(lines, line(row))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// wrapMarkdown wraps text in emphasis markers, which must touch the text: surrounding whitespace is moved
+// outside of them.
+func wrapMarkdown(text string, marker string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+
+	start := strings.Index(text, trimmed)
+	return text[:start] + marker + trimmed + marker + text[start+len(trimmed):]
+}
+
+// wrapMarkdownLink turns text into a link, with its surrounding whitespace moved outside of it.
+func wrapMarkdownLink(text string, url string) string {
+	trimmed := strings.TrimSpace(text)
+	start := strings.Index(text, trimmed)
+
+	return text[:start] + "[" + trimmed + "](" + url + ")" + text[start+len(trimmed):]
+}
+
+// markdownCodeSpan wraps code in enough backticks for those it contains.
+func markdownCodeSpan(code string) string {
+	if strings.TrimSpace(code) == "" {
+		return code
+	}
+
+	fence := "`"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+		code = " " + code + " "
+	}
+
+	return fence + code + fence
+}
+
+// markdownURL escapes the characters of a URL that would end a Markdown link destination.
+func markdownURL(url string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(url)
+}
+
+// trimMarkdownLines trims the whitespace around every line of inline Markdown, and collapses spaces left
+// between inline elements.
+func trimMarkdownLines(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// prefixLines prefixes every line of text, and empty lines with their own prefix.
+func prefixLines(text string, prefix string, emptyPrefix string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = emptyPrefix
+		} else {
+			lines[i] = prefix + line
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// nodeText returns the text of a node and its descendants, as is.
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(nodeText(c))
+	}
+
+	return b.String()
+}
+
+// attr returns the value of an attribute of a node, or an empty string.
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,273 @@
+package antidote
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// MediaEmulation object represents the media features emulated while curing stylesheets, see
+// Ingredients.Media. The zero value emulates nothing.
+type MediaEmulation struct {
+	// Type is the media type, "print" or "screen". With "print" the print styles of the page apply on screen
+	// and its screen styles are dropped, as when the page is printed. Empty leaves media types as they are.
+	Type string
+
+	// ColorScheme is the value of the prefers-color-scheme media feature, "dark" or "light". Empty leaves it as
+	// it is.
+	ColorScheme string
+}
+
+func (m MediaEmulation) validate() error {
+	switch m.Type {
+	case "", "print", "screen":
+	default:
+		return fmt.Errorf("unknown media type %q", m.Type)
+	}
+
+	switch m.ColorScheme {
+	case "", "dark", "light":
+	default:
+		return fmt.Errorf("unknown color scheme %q", m.ColorScheme)
+	}
+
+	return nil
+}
+
+func (m MediaEmulation) enabled() bool {
+	return m.Type != "" || m.ColorScheme != ""
+}
+
+// evaluate evaluates a media query list as far as the emulated features go. It returns whether the list may
+// match, and the list without the conditions known to be true, which is empty if the list always matches.
+// Queries that can not be parsed, e.g. those of Media Queries Level 4 combining conditions with "or", are
+// left as they are.
+func (m MediaEmulation) evaluate(list string) (bool, string) {
+	list = strings.TrimSpace(stripCSSComments(list))
+	if list == "" {
+		return true, ""
+	}
+
+	var kept []string
+	for _, query := range splitCSS(list, ',') {
+		matches, rest := m.evaluateQuery(strings.TrimSpace(query))
+		if !matches {
+			continue
+		}
+		if rest == "" {
+			return true, ""
+		}
+		kept = append(kept, rest)
+	}
+
+	if len(kept) == 0 {
+		return false, ""
+	}
+
+	return true, strings.Join(kept, ", ")
+}
+
+// evaluateQuery evaluates a single media query, see MediaEmulation.evaluate().
+func (m MediaEmulation) evaluateQuery(query string) (bool, string) {
+	var words, conditions []string
+
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case c == '(':
+			end := scanCSS(query, i+1, ")") + 1
+			if end > len(query) {
+				return true, query
+			}
+			conditions = append(conditions, query[i:end])
+			i = end
+		case isCSSNameChar(c):
+			start := i
+			for i < len(query) && isCSSNameChar(query[i]) {
+				i++
+			}
+			word := strings.ToLower(query[start:i])
+			if word != "and" {
+				words = append(words, word)
+			}
+		default:
+			return true, query
+		}
+	}
+
+	// [not | only] [type], followed by the conditions.
+	negated, only, mediaType := false, false, ""
+	if len(words) > 0 && (words[0] == "not" || words[0] == "only") {
+		negated, only = words[0] == "not", words[0] == "only"
+		words = words[1:]
+	}
+	if len(words) > 1 {
+		return true, query
+	}
+	if len(words) == 1 {
+		mediaType = words[0]
+	}
+
+	// known is false once a part is known not to match, and the parts whose outcome is unknown are kept.
+	known := true
+	var unknown []string
+
+	switch {
+	case mediaType == "" || mediaType == "all":
+	case m.Type != "":
+		known = mediaType == m.Type
+	default:
+		unknown = append(unknown, mediaType)
+	}
+
+	typeUnknown := len(unknown) == 1
+	for _, condition := range conditions {
+		feature := strings.SplitN(strings.Trim(condition, "()"), ":", 2)
+		name := strings.ToLower(strings.TrimSpace(feature[0]))
+
+		if name != "prefers-color-scheme" || m.ColorScheme == "" {
+			unknown = append(unknown, condition)
+			continue
+		}
+
+		// A boolean prefers-color-scheme matches any scheme.
+		if len(feature) == 2 && strings.ToLower(strings.TrimSpace(feature[1])) != m.ColorScheme {
+			known = false
+		}
+	}
+
+	if negated {
+		switch {
+		case !known:
+			return true, ""
+		case len(unknown) > 0:
+			return true, query
+		default:
+			return false, ""
+		}
+	}
+
+	if !known {
+		return false, ""
+	}
+
+	if typeUnknown && only {
+		unknown[0] = "only " + unknown[0]
+	}
+
+	return true, strings.Join(unknown, " and ")
+}
+
+// emulateMedia applies Ingredients.Media to a stylesheet: the @media rules that always match are replaced by
+// their rules, those that never match are dropped, and the conditions known to be true are removed from the
+// others. The media query lists of @import rules are evaluated the same way.
+func (a *Antidote) emulateMedia(css string) string {
+	media := a.ingredients.Media
+
+	var b strings.Builder
+
+	for i := 0; i < len(css); {
+		open := scanCSS(css, i, "{;")
+		if open == len(css) || css[open] == ';' {
+			end := open + 1
+			if end > len(css) {
+				end = len(css)
+			}
+			b.WriteString(emulateImportMedia(media, css[i:end]))
+			i = end
+			continue
+		}
+
+		close := scanCSS(css, open+1, "}")
+		if close == len(css) {
+			b.WriteString(css[i:])
+			break
+		}
+
+		prelude := strings.TrimSpace(stripCSSComments(css[i:open]))
+		name := ""
+		if strings.HasPrefix(prelude, "@") {
+			name = strings.ToLower(strings.FieldsFunc(prelude, func(r rune) bool {
+				return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' || r == '('
+			})[0])
+		}
+
+		// The whitespace ahead of the rule is kept, to keep the layout of the stylesheet.
+		space := css[i : i+len(css[i:open])-len(strings.TrimLeft(css[i:open], " \t\n\r\f"))]
+
+		switch {
+		case name == "@media":
+			matches, rest := media.evaluate(prelude[len("@media"):])
+			switch {
+			case !matches:
+			case rest == "":
+				b.WriteString(space)
+				b.WriteString(a.emulateMedia(css[open+1 : close]))
+			default:
+				b.WriteString(space + "@media " + rest + " {")
+				b.WriteString(a.emulateMedia(css[open+1 : close]))
+				b.WriteString("}")
+			}
+		case groupingRules[name]:
+			if rules := a.emulateMedia(css[open+1 : close]); strings.TrimSpace(rules) != "" {
+				b.WriteString(css[i : open+1])
+				b.WriteString(rules)
+				b.WriteString("}")
+			}
+		default:
+			b.WriteString(css[i : close+1])
+		}
+
+		i = close + 1
+	}
+
+	return b.String()
+}
+
+// emulateImportMedia evaluates the media query list of a statement if it is an @import rule, which is dropped
+// if the list never matches.
+func emulateImportMedia(media MediaEmulation, statement string) string {
+	if !strings.HasPrefix(strings.TrimSpace(statement), "@import") {
+		return statement
+	}
+
+	match := cssReferencePattern.FindStringSubmatchIndex(statement)
+	if match == nil || match[12] < 0 {
+		return statement
+	}
+
+	matches, rest := media.evaluate(statement[match[12]:match[13]])
+	if !matches {
+		return ""
+	}
+	if rest != "" {
+		rest = " " + rest
+	}
+
+	return statement[:match[12]] + rest + statement[match[13]:]
+}
+
+// emulateElementMedia evaluates the media attribute of a <link> or <style> element, and reports whether the
+// element is kept: it is removed if the attribute never matches, and the attribute removed if it always does.
+func (a *Antidote) emulateElementMedia(element *goquery.Selection) bool {
+	list, ok := element.Attr("media")
+	if !ok || !a.ingredients.Media.enabled() {
+		return true
+	}
+
+	matches, rest := a.ingredients.Media.evaluate(list)
+	switch {
+	case !matches:
+		a.log(LogDebug, "media does not match", "media", list)
+		element.Remove()
+		return false
+	case rest == "":
+		element.RemoveAttr("media")
+	default:
+		element.SetAttr("media", rest)
+	}
+
+	return true
+}
@@ -0,0 +1,203 @@
+package antidote
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// mergedPage is a chapter of a book being merged into a single document, see Book.WriteHTML().
+type mergedPage struct {
+	doc     *html.Node
+	base    *url.URL
+	anchor  string
+	title   string
+	renamed map[string]string
+}
+
+// WriteHTML writes the book as a single HTML document, e.g. to archive a multi-page article or a documentation
+// set as one file: a table of contents, then a section per chapter. The chapters are titled after their page
+// by default, and the book after its first chapter. The stylesheets of the chapters are
+// written once in the <head>, so those shared by every page of a site, along with their fonts and images, are
+// only included once. Links between chapters are rewritten to point to their section, and the ids of a chapter
+// already used by an earlier one are renamed. Scripts are dropped, as those of several pages can not run in the
+// same document.
+func (b *Book) WriteHTML(w io.Writer) error {
+	// The sections of the chapters take precedence over the ids of their elements.
+	ids := map[string]bool{"toc": true}
+	for i := range b.Chapters {
+		ids[fmt.Sprintf("page-%d", i+1)] = true
+	}
+	byURL := make(map[string]*mergedPage)
+
+	pages := make([]*mergedPage, len(b.Chapters))
+	for i, chapter := range b.Chapters {
+		page, err := parseMergedPage(chapter, fmt.Sprintf("page-%d", i+1), ids)
+		if err != nil {
+			return fmt.Errorf("%s: %v", chapter.Snapshot.URL, err)
+		}
+
+		pages[i] = page
+		if u, err := url.Parse(chapter.Snapshot.URL); err == nil {
+			u.Fragment = ""
+			byURL[u.String()] = page
+		}
+	}
+
+	title := b.Title
+	if title == "" && len(pages) > 0 {
+		title = pages[0].title
+	}
+
+	doc, err := html.Parse(strings.NewReader(fmt.Sprintf(
+		"<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>%[1]s</title></head><body><nav id=\"toc\"><h1>%[1]s</h1><ol></ol></nav></body></html>",
+		html.EscapeString(title))))
+	if err != nil {
+		return err
+	}
+
+	head := cascadia.MustCompile("head").MatchFirst(doc)
+	body := cascadia.MustCompile("body").MatchFirst(doc)
+	toc := cascadia.MustCompile("nav ol").MatchFirst(doc)
+
+	stylesheets := make(map[string]bool)
+	for _, page := range pages {
+		for _, style := range cascadia.MustCompile(`head style, head link[rel~="stylesheet"]`).MatchAll(page.doc) {
+			var key strings.Builder
+			if err := html.Render(&key, style); err != nil {
+				return err
+			}
+
+			if !stylesheets[key.String()] {
+				stylesheets[key.String()] = true
+				style.Parent.RemoveChild(style)
+				head.AppendChild(style)
+			}
+		}
+
+		for _, script := range cascadia.MustCompile("body script").MatchAll(page.doc) {
+			script.Parent.RemoveChild(script)
+		}
+
+		for _, link := range cascadia.MustCompile("body [href]").MatchAll(page.doc) {
+			page.rewriteLink(link, byURL)
+		}
+
+		section := &html.Node{Type: html.ElementNode, Data: "section", DataAtom: atom.Section, Attr: []html.Attribute{
+			{Key: "id", Val: page.anchor},
+			{Key: "class", Val: "antidote-page"},
+		}}
+		if pageBody := cascadia.MustCompile("body").MatchFirst(page.doc); pageBody != nil {
+			for pageBody.FirstChild != nil {
+				child := pageBody.FirstChild
+				pageBody.RemoveChild(child)
+				section.AppendChild(child)
+			}
+		}
+		body.AppendChild(section)
+
+		item, err := html.ParseFragment(strings.NewReader(fmt.Sprintf(`<li><a href="#%s">%s</a></li>`, page.anchor, html.EscapeString(page.title))), toc)
+		if err != nil {
+			return err
+		}
+		for _, n := range item {
+			toc.AppendChild(n)
+		}
+	}
+
+	_, err = io.WriteString(w, serialize(doc, OutputFormat{}, false))
+	return err
+}
+
+// parseMergedPage parses the complete HTML of the snapshot of a chapter, and renames the ids of its elements
+// already in use.
+func parseMergedPage(chapter Chapter, anchor string, ids map[string]bool) (*mergedPage, error) {
+	snapshot := chapter.Snapshot
+
+	var b strings.Builder
+	if err := snapshot.WriteHTML(&b); err != nil {
+		return nil, err
+	}
+
+	doc, err := html.Parse(strings.NewReader(b.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	page := &mergedPage{doc: doc, anchor: anchor, title: chapter.Title, renamed: make(map[string]string)}
+	if n := cascadia.MustCompile("title").MatchFirst(doc); page.title == "" && n != nil {
+		page.title = strings.TrimSpace(nodeText(n))
+	}
+	if page.title == "" {
+		page.title = snapshot.URL
+	}
+
+	page.base, err = url.Parse(snapshot.URL)
+	if err != nil {
+		return nil, err
+	}
+	if base := cascadia.MustCompile("head base[href]").MatchFirst(doc); base != nil {
+		if u, err := page.base.Parse(attr(base, "href")); err == nil {
+			page.base = u
+		}
+	}
+
+	for _, n := range cascadia.MustCompile("body [id]").MatchAll(doc) {
+		id := attr(n, "id")
+		if ids[id] {
+			renamed := anchor + "-" + id
+			page.renamed[id] = renamed
+			setAttr(n, "id", renamed)
+			id = renamed
+		}
+		ids[id] = true
+	}
+
+	return page, nil
+}
+
+// rewriteLink points a link to its own page, or to another chapter, to the section of the page in the merged
+// document, or to the element of its fragment.
+func (p *mergedPage) rewriteLink(n *html.Node, byURL map[string]*mergedPage) {
+	href := strings.TrimSpace(attr(n, "href"))
+
+	target, fragment := p, strings.TrimPrefix(href, "#")
+	if !strings.HasPrefix(href, "#") {
+		u, err := p.base.Parse(href)
+		if err != nil {
+			return
+		}
+		fragment = u.Fragment
+		u.Fragment = ""
+
+		if target = byURL[u.String()]; target == nil {
+			return
+		}
+	}
+
+	switch renamed, ok := target.renamed[fragment]; {
+	case fragment == "":
+		setAttr(n, "href", "#"+target.anchor)
+	case ok:
+		setAttr(n, "href", "#"+renamed)
+	default:
+		setAttr(n, "href", "#"+fragment)
+	}
+}
+
+// setAttr sets the value of an attribute of a node.
+func setAttr(n *html.Node, key string, value string) {
+	for i, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
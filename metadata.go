@@ -0,0 +1,251 @@
+package antidote
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Metadata object represents the metadata of a page, extracted from the page as it was fetched, so that indexes
+// do not need to parse the cured HTML. Every field is read from the first of its sources that has it: JSON-LD,
+// then OpenGraph and <meta> tags, then microdata, then the elements of the page such as <title>.
+type Metadata struct {
+	// Title is the title of the page, e.g. the headline of an article.
+	Title string `json:"title,omitempty"`
+
+	Description string `json:"description,omitempty"`
+
+	// CanonicalURL is the absolute URL of the <link rel="canonical"> of the page, or its og:url, as declared.
+	CanonicalURL string `json:"canonicalUrl,omitempty"`
+
+	// Language is the language the page declares, as a BCP 47 tag, e.g. "en-US".
+	Language string `json:"language,omitempty"`
+
+	// Author is the name of the author of the page, or the names of its authors separated by commas.
+	Author string `json:"author,omitempty"`
+
+	// PublishedAt is when the page, e.g. an article, was first published.
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+
+	// Tags are the tags the page was archived with, e.g. those of its bookmark, see ReadBookmarks(). They are not
+	// read from the page.
+	Tags []string `json:"tags,omitempty"`
+
+	// Folders are the folders the page was filed in, from the outermost one, e.g. those of its bookmark. They are
+	// not read from the page.
+	Folders []string `json:"folders,omitempty"`
+}
+
+// empty reports whether no metadata was found.
+func (m *Metadata) empty() bool {
+	return m.Title == "" && m.Description == "" && m.CanonicalURL == "" && m.Language == "" && m.Author == "" &&
+		m.PublishedAt == nil && len(m.Tags) == 0 && len(m.Folders) == 0
+}
+
+// metadataSource is what the metadata of a page can be read from.
+type metadataSource struct {
+	doc    *goquery.Document
+	base   *url.URL
+	jsonLD map[string]interface{}
+}
+
+// dateLayouts are the layouts of the dates of the metadata of pages.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// extractMetadata reads the metadata of the page fetched from pageURL. It must be called before the document is
+// modified, as scripts, which hold JSON-LD, may be stripped.
+func (a *Antidote) extractMetadata(pageURL string) Metadata {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		base = a.parsedUrl
+	}
+
+	s := &metadataSource{doc: a.website, base: base, jsonLD: findJSONLD(a.website)}
+	m := Metadata{
+		Title: first(s.ld("headline"), s.ld("name"), s.meta("og:title"), s.meta("twitter:title"),
+			s.microdata("headline"), s.text("title")),
+		Description: first(s.ld("description"), s.meta("og:description"), s.meta("description"),
+			s.meta("twitter:description"), s.microdata("description")),
+		CanonicalURL: first(s.resolve(s.attr(`link[rel~="canonical"]`, "href")), s.resolve(s.meta("og:url"))),
+		Language: first(s.attr("html", "lang"), s.attr("html", "xml:lang"), s.ld("inLanguage"),
+			strings.Replace(s.meta("og:locale"), "_", "-", -1), s.meta("content-language"),
+			s.microdata("inLanguage")),
+		Author: first(s.ld("author"), s.meta("author"), s.meta("article:author"), s.microdata("author")),
+	}
+
+	published := first(s.ld("datePublished"), s.meta("article:published_time"), s.meta("date"),
+		s.meta("pubdate"), s.microdata("datePublished"), s.attr("time[pubdate]", "datetime"))
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, published); err == nil {
+			m.PublishedAt = &t
+			break
+		}
+	}
+
+	// Pages commonly list several languages, e.g. "en, fr", of which the first is the main one.
+	m.Language = strings.TrimSpace(strings.Split(m.Language, ",")[0])
+
+	return m
+}
+
+// first returns the first of values that is not empty, with its whitespace collapsed.
+func first(values ...string) string {
+	for _, value := range values {
+		if value = strings.Join(strings.Fields(value), " "); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// attr returns an attribute of the first element matching a selector.
+func (s *metadataSource) attr(selector string, name string) string {
+	value, _ := s.doc.Find(selector).First().Attr(name)
+	return value
+}
+
+// text returns the text of the first element matching a selector.
+func (s *metadataSource) text(selector string) string {
+	return s.doc.Find(selector).First().Text()
+}
+
+// meta returns the content of the first <meta> tag of a name, a property or an http-equiv, e.g. "description",
+// "og:title" or "content-language", whose case is ignored.
+func (s *metadataSource) meta(name string) string {
+	var content string
+	s.doc.Find("meta[content]").EachWithBreak(func(i int, meta *goquery.Selection) bool {
+		for _, attribute := range []string{"property", "name", "http-equiv"} {
+			if value, _ := meta.Attr(attribute); strings.EqualFold(value, name) {
+				content, _ = meta.Attr("content")
+				return false
+			}
+		}
+		return true
+	})
+
+	return content
+}
+
+// microdata returns the value of the first element of an itemprop, e.g. "datePublished": its content or datetime
+// attribute, or else the text of its name property, or its text.
+func (s *metadataSource) microdata(prop string) string {
+	element := s.doc.Find(`[itemprop~="` + prop + `"]`).First()
+	if element.Length() == 0 {
+		return ""
+	}
+
+	for _, name := range []string{"content", "datetime"} {
+		if value, ok := element.Attr(name); ok {
+			return value
+		}
+	}
+
+	if name := element.Find(`[itemprop~="name"]`).First(); name.Length() > 0 {
+		return name.Text()
+	}
+
+	return element.Text()
+}
+
+// resolve returns a reference resolved against the URL of the page, or an empty string if it is invalid.
+func (s *metadataSource) resolve(ref string) string {
+	if ref = strings.TrimSpace(ref); ref == "" {
+		return ""
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+
+	return s.base.ResolveReference(u).String()
+}
+
+// ld returns a property of the JSON-LD object of the page. Authors are given as the names of their objects.
+func (s *metadataSource) ld(name string) string {
+	return jsonLDString(s.jsonLD[name])
+}
+
+// jsonLDString returns the text of a JSON-LD value: a string, the name of an object (or its @value), or those of
+// an array separated by commas.
+func jsonLDString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if name := jsonLDString(v["name"]); name != "" {
+			return name
+		}
+		return jsonLDString(v["@value"])
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s := jsonLDString(item); s != "" {
+				values = append(values, s)
+			}
+		}
+		return strings.Join(values, ", ")
+	default:
+		return ""
+	}
+}
+
+// jsonLDTypes are the types of JSON-LD objects describing the page itself, rather than e.g. its publisher or
+// breadcrumbs, in order of preference.
+var jsonLDTypes = []string{
+	"Article", "NewsArticle", "BlogPosting", "TechArticle", "ScholarlyArticle", "Report", "Recipe", "Review",
+	"Product", "Event", "VideoObject", "WebPage", "AboutPage", "ProfilePage", "CollectionPage",
+}
+
+// findJSONLD returns the JSON-LD object of the <script type="application/ld+json"> elements of a document that
+// describes the page, or nil if there is none. Objects may be nested in arrays and in @graph.
+func findJSONLD(doc *goquery.Document) map[string]interface{} {
+	var objects []map[string]interface{}
+	var collect func(value interface{})
+	collect = func(value interface{}) {
+		switch v := value.(type) {
+		case []interface{}:
+			for _, item := range v {
+				collect(item)
+			}
+		case map[string]interface{}:
+			objects = append(objects, v)
+			collect(v["@graph"])
+		}
+	}
+
+	doc.Find("script[type]").Each(func(i int, script *goquery.Selection) {
+		if t, _ := script.Attr("type"); !strings.EqualFold(strings.TrimSpace(t), "application/ld+json") {
+			return
+		}
+
+		var value interface{}
+		if err := json.Unmarshal([]byte(script.Text()), &value); err == nil {
+			collect(value)
+		}
+	})
+
+	for _, t := range jsonLDTypes {
+		for _, object := range objects {
+			for _, objectType := range strings.Split(jsonLDString(object["@type"]), ", ") {
+				if objectType == t {
+					return object
+				}
+			}
+		}
+	}
+
+	return nil
+}
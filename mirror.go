@@ -0,0 +1,222 @@
+package antidote
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// extractedNamePattern matches the references to extracted files in the cured page and its stylesheets: the
+// hex encoded SHA-256 hash of the file and its extension, in the assets directory or not.
+var extractedNamePattern = regexp.MustCompile(`(?:assets/)?[0-9a-f]{64}(?:\.[A-Za-z0-9]+)?`)
+
+// mirrorQueryEscaper escapes the separators of paths in the query of a mirrored URL, as wget does, so that it
+// stays in the name of the file.
+var mirrorQueryEscaper = strings.NewReplacer("/", "%2F", `\`, "%5C")
+
+// MirrorPath returns the path of the file wget mirrors a URL to with -p -k -E (--page-requisites
+// --convert-links --adjust-extension): the host, with the port if any, followed by the path, "index.html" for
+// directories, and the query after a "?", with its slashes and backslashes escaped. Pages and stylesheets end
+// with .html and .css, e.g. "www.website.com/blog/post.html" for https://www.website.com/blog/post.
+func MirrorPath(rawurl string, kind AssetKind) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("%q is not an absolute URL", rawurl)
+	}
+
+	p := u.Path
+	if p == "" || strings.HasSuffix(p, "/") {
+		p += "index.html"
+	}
+
+	name := strings.ToLower(u.Host) + path.Clean("/"+p)
+	if u.RawQuery != "" {
+		name += "?" + mirrorQueryEscaper.Replace(u.RawQuery)
+	}
+
+	lower := strings.ToLower(name)
+	switch {
+	case (kind == "" || kind == AssetFrame) && !strings.HasSuffix(lower, ".html") && !strings.HasSuffix(lower, ".htm"):
+		name += ".html"
+	case kind == AssetCSS && !strings.HasSuffix(lower, ".css"):
+		name += ".css"
+	}
+
+	return name, nil
+}
+
+// relativeLink returns the link from the file at the path from to the file at the path to, both relative to the
+// root of a mirror, with its segments escaped, e.g. "?" as "%3F".
+func relativeLink(from string, to string) string {
+	fromParts := strings.Split(path.Dir(from), "/")
+	toParts := strings.Split(to, "/")
+	if path.Dir(from) == "." {
+		fromParts = nil
+	}
+
+	common := 0
+	for common < len(fromParts) && common < len(toParts)-1 && fromParts[common] == toParts[common] {
+		common++
+	}
+
+	var parts []string
+	for range fromParts[common:] {
+		parts = append(parts, "..")
+	}
+	for _, part := range toParts[common:] {
+		parts = append(parts, url.PathEscape(part))
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// WriteMirror writes the snapshot to a directory the way wget -p -k -E mirrors a page, so that scripts built
+// around wget mirrors keep working: the page and every extracted asset are written to the path of their URL
+// from root, see MirrorPath(), and the references between them are relative. The links of the page to the
+// other pages mirrored to the same root are made relative as well, those to any other page absolute. Assets
+// must be extracted, see Ingredients.ExtractAssets.
+func (s *Snapshot) WriteMirror(root string, pages []string) error {
+	pagePath, err := MirrorPath(s.URL, "")
+	if err != nil {
+		return err
+	}
+
+	// The files whose URL is unknown, e.g. those of snapshots read from older manifests, are left in the assets
+	// directory next to the page.
+	paths := make(map[string]string)
+	for _, name := range s.fileNames() {
+		kind := AssetKind("")
+		switch strings.ToLower(path.Ext(name)) {
+		case ".css":
+			kind = AssetCSS
+		case ".html":
+			kind = AssetFrame
+		default:
+			kind = AssetImage
+		}
+
+		paths[name] = path.Join(path.Dir(pagePath), name)
+		if source := s.sources[name]; source != "" {
+			if p, err := MirrorPath(source, kind); err == nil {
+				paths[name] = p
+			}
+		}
+	}
+
+	// References to extracted files are rewritten relative to the file holding them.
+	relink := func(from string, content string) string {
+		return extractedNamePattern.ReplaceAllStringFunc(content, func(match string) string {
+			name := assetsDir + strings.TrimPrefix(match, assetsDir)
+			if to, ok := paths[name]; ok {
+				return relativeLink(from, to)
+			}
+			return match
+		})
+	}
+
+	var b bytes.Buffer
+	if err := s.WriteHTML(&b); err != nil {
+		return err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(relink(pagePath, b.String())))
+	if err != nil {
+		return err
+	}
+	s.convertLinks(doc, pagePath, pages)
+
+	if err := writeMirrorFile(root, pagePath, []byte(serialize(doc.Nodes[0], OutputFormat{}, s.XHTML))); err != nil {
+		return err
+	}
+
+	for _, name := range s.fileNames() {
+		content := s.Files[name]
+		if strings.EqualFold(path.Ext(name), ".css") {
+			content = []byte(relink(paths[name], string(content)))
+		}
+
+		if err := writeMirrorFile(root, paths[name], content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// convertLinks rewrites the links of the page at pagePath as wget -k does: those to the page itself and to the
+// pages mirrored to the same root are made relative, and the others absolute. Fragments are left as they are.
+func (s *Snapshot) convertLinks(doc *goquery.Document, pagePath string, pages []string) {
+	base, err := url.Parse(s.URL)
+	if err != nil {
+		return
+	}
+
+	mirrored := map[string]bool{s.URL: true}
+	for _, page := range pages {
+		if u, err := url.Parse(page); err == nil {
+			u.Fragment = ""
+			mirrored[u.String()] = true
+		}
+	}
+
+	doc.Find("a[href], area[href], form[action]").Each(func(index int, link *goquery.Selection) {
+		attr := "href"
+		if goquery.NodeName(link) == "form" {
+			attr = "action"
+		}
+
+		href, _ := link.Attr(attr)
+		href = strings.TrimSpace(href)
+		if href == "" || strings.HasPrefix(href, "#") || hasScheme(href, "data", "javascript", "mailto", "tel") {
+			return
+		}
+
+		u, err := base.Parse(href)
+		if err != nil {
+			return
+		}
+
+		fragment := u.Fragment
+		u.Fragment = ""
+		if !mirrored[u.String()] {
+			link.SetAttr(attr, u.String()+fragmentSuffix(fragment))
+			return
+		}
+
+		if p, err := MirrorPath(u.String(), ""); err == nil {
+			link.SetAttr(attr, relativeLink(pagePath, p)+fragmentSuffix(fragment))
+		}
+	})
+}
+
+// fragmentSuffix returns a fragment to append to a link, "#" included, or an empty string.
+func fragmentSuffix(fragment string) string {
+	if fragment == "" {
+		return ""
+	}
+
+	return "#" + fragment
+}
+
+// writeMirrorFile writes a file of a mirror to its path from root, which it must not be outside of.
+func writeMirrorFile(root string, name string, content []byte) error {
+	target := filepath.Join(root, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(root, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%q is outside of the mirror", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(target, content, 0644)
+}
@@ -0,0 +1,50 @@
+package antidote
+
+import (
+	"net/url"
+	"strings"
+)
+
+// secure reports whether the page is served over https, so that its assets are mixed content if they are
+// served over plaintext http.
+func (a *Antidote) secure() bool {
+	return a.parsedUrl != nil && a.parsedUrl.Scheme == "https"
+}
+
+// repairsMixedContent reports whether the http references of the page are upgraded to https, see
+// Ingredients.RepairMixedContent.
+func (a *Antidote) repairsMixedContent() bool {
+	return a.ingredients.RepairMixedContent && a.secure()
+}
+
+// insecureFallback returns the http URL of an asset upgraded to https by Ingredients.RepairMixedContent, to be
+// fetched instead if it is not reachable over https, or an empty string if the asset was not upgraded.
+func (a *Antidote) insecureFallback(base *url.URL, src string) string {
+	if a.ingredients.UpgradeInsecure || !a.repairsMixedContent() {
+		return ""
+	}
+
+	if base == nil {
+		base = a.parsedUrl
+	}
+
+	normalized, err := normalizeSourceUrl(src, base)
+	if err != nil || !strings.HasPrefix(normalized, "http://") {
+		return ""
+	}
+
+	return normalized
+}
+
+// MixedContent returns the URL's of the assets of a page served over https that were fetched over plaintext
+// http, see Asset.Insecure.
+func (s *Snapshot) MixedContent() []string {
+	var urls []string
+	for _, asset := range s.Assets {
+		if asset.Insecure {
+			urls = append(urls, asset.URL)
+		}
+	}
+
+	return urls
+}
@@ -0,0 +1,129 @@
+package antidote
+
+import (
+	"net/http"
+	"time"
+)
+
+// Option is a default of every cure of an Antidote, given to New(), e.g.
+// New(WithTimeout(10*time.Second), WithConcurrency(4)), so that an application configures its client once, and
+// options can be added without changing the signature of New(). An option only applies to the ingredients that
+// leave it unset, those given to Antidote.Mix() win.
+type Option func(a *Antidote)
+
+// Renderer renders a page, e.g. in a headless browser running its scripts, into the HTML that is cured instead of
+// the response of its URL, see Ingredients.Renderer.
+type Renderer interface {
+	// Render returns the HTML of the page at a URL once rendered.
+	Render(url string) (string, error)
+}
+
+// RendererFunc is a function used as a Renderer.
+type RendererFunc func(url string) (string, error)
+
+// Render calls f(url).
+func (f RendererFunc) Render(url string) (string, error) {
+	return f(url)
+}
+
+// WithHTTPClient makes every HTTP request with the transport, the cookie jar and the timeout of a client, see
+// Ingredients.Transport, Ingredients.Jar and Ingredients.Timeout. Redirects are followed by Antidote itself, so
+// the CheckRedirect of the client is not used.
+func WithHTTPClient(client *http.Client) Option {
+	return func(a *Antidote) {
+		a.defaults.Transport = client.Transport
+		a.defaults.Jar = client.Jar
+		a.defaults.Timeout = client.Timeout
+	}
+}
+
+// WithLogger sets the logger of every cure, see Ingredients.Logger.
+func WithLogger(logger *Logger) Option {
+	return func(a *Antidote) {
+		a.defaults.Logger = logger
+	}
+}
+
+// WithCache sets the cache of the assets of every cure, see Ingredients.Cache.
+func WithCache(cache Cache) Option {
+	return func(a *Antidote) {
+		a.defaults.Cache = cache
+	}
+}
+
+// WithConcurrency sets the maximum number of assets fetched at the same time, see Ingredients.Concurrency.
+func WithConcurrency(concurrency int) Option {
+	return func(a *Antidote) {
+		a.defaults.Concurrency = concurrency
+	}
+}
+
+// WithTimeout sets the time limit of each HTTP request, see Ingredients.Timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(a *Antidote) {
+		a.defaults.Timeout = timeout
+	}
+}
+
+// WithRenderer renders every page before it is cured, see Ingredients.Renderer.
+func WithRenderer(renderer Renderer) Option {
+	return func(a *Antidote) {
+		a.defaults.Renderer = renderer
+	}
+}
+
+// WithProfile applies a named preset of ingredients to every cure, see Ingredients.Profile.
+func WithProfile(profile Profile) Option {
+	return func(a *Antidote) {
+		a.defaults.Profile = profile
+	}
+}
+
+// withDefaults returns a copy of ingredients with the defaults of the options of New() in place of the unset
+// fields, or ingredients themselves if there are none, so that the ingredients shared by several Antidote are
+// left as they are.
+func (a *Antidote) withDefaults(ingredients *Ingredients) *Ingredients {
+	if ingredients == nil || a.defaults == nil {
+		return ingredients
+	}
+
+	mixed := *ingredients
+	if mixed.Transport == nil {
+		mixed.Transport = a.defaults.Transport
+	}
+	if mixed.Jar == nil {
+		mixed.Jar = a.defaults.Jar
+	}
+	if mixed.Timeout == 0 {
+		mixed.Timeout = a.defaults.Timeout
+	}
+	if mixed.Logger == nil {
+		mixed.Logger = a.defaults.Logger
+	}
+	if mixed.Cache == nil {
+		mixed.Cache = a.defaults.Cache
+	}
+	if mixed.Concurrency == 0 {
+		mixed.Concurrency = a.defaults.Concurrency
+	}
+	if mixed.Renderer == nil {
+		mixed.Renderer = a.defaults.Renderer
+	}
+	if mixed.Profile == "" {
+		mixed.Profile = a.defaults.Profile
+	}
+
+	return &mixed
+}
+
+// defaults are the fields of Ingredients set by the options of New().
+type defaults struct {
+	Transport   http.RoundTripper
+	Jar         http.CookieJar
+	Timeout     time.Duration
+	Logger      *Logger
+	Cache       Cache
+	Concurrency int
+	Renderer    Renderer
+	Profile     Profile
+}
@@ -0,0 +1,36 @@
+package antidote_test
+
+import (
+	"testing"
+
+	"github.com/lansana/antidote"
+	"github.com/lansana/antidote/antidotetest"
+)
+
+func TestScriptOrder(t *testing.T) {
+	tests := []struct {
+		name        string
+		ingredients antidote.Ingredients
+	}{
+		{"inlined", antidote.Ingredients{}},
+		{"extracted", antidote.Ingredients{ExtractAssets: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fixture := antidotetest.ScriptOrderFixture()
+			snapshot, err := antidotetest.Replay(fixture, test.ingredients)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer snapshot.Close()
+
+			if errs := snapshot.Errors(); len(errs) > 0 {
+				t.Errorf("assets failed to be cured: %q", errs)
+			}
+			if err := antidotetest.CheckScriptOrder(fixture, snapshot); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package antidote
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// assetsDir is the directory extracted assets are stored in, relative to the page.
+const assetsDir = "assets/"
+
+// FileIntegrity object represents the integrity of a single file of an extracted snapshot.
+type FileIntegrity struct {
+	// Path is the path of the file relative to the root of the snapshot.
+	Path string `json:"path"`
+
+	// URL is the URL the file was fetched from. Stylesheets are rewritten while curing, so they no longer
+	// match the content served at their URL.
+	URL string `json:"url,omitempty"`
+
+	// SHA256 is the hex encoded SHA-256 hash of the file.
+	SHA256 string `json:"sha256"`
+
+	// Integrity is the hash in the format of the HTML integrity attribute, e.g. "sha256-47DEQpj8...".
+	Integrity string `json:"integrity"`
+
+	// Size is the size of the file in bytes.
+	Size int `json:"size"`
+}
+
+// Manifest object represents the integrity report of an extracted snapshot. Partial is set when the budget of
+// the cure was exceeded, see Snapshot.Partial. The metadata of the page is included, see Snapshot.Metadata.
+type Manifest struct {
+	FormatHeader
+
+	URL        string          `json:"url"`
+	CapturedAt time.Time       `json:"capturedAt"`
+	Metadata   *Metadata       `json:"metadata,omitempty"`
+	Page       FileIntegrity   `json:"page"`
+	Assets     []FileIntegrity `json:"assets"`
+	Partial    bool            `json:"partial,omitempty"`
+	Signature  *Signature      `json:"signature,omitempty"`
+}
+
+// extract stores the content of an asset fetched from a URL as a file named by its hash and returns the name of
+// the file.
+func (a *Antidote) extract(content string, extension string, source string) string {
+	name := hashContent(content) + extension
+
+	a.mu.Lock()
+	a.snapshot.Files[assetsDir+name] = []byte(content)
+	if _, ok := a.snapshot.sources[assetsDir+name]; !ok && source != "" {
+		a.snapshot.sources[assetsDir+name] = source
+	}
+	a.mu.Unlock()
+
+	return name
+}
+
+// assetExtension returns the lowercased extension of the path of a URL, or the fallback if it has none.
+func assetExtension(src string, fallback string) string {
+	src = strings.SplitN(strings.SplitN(src, "#", 2)[0], "?", 2)[0]
+
+	if extension := strings.ToLower(path.Ext(src)); extension != "" {
+		return extension
+	}
+
+	return fallback
+}
+
+func integrity(filePath string, content []byte) FileIntegrity {
+	sum := sha256.Sum256(content)
+	return integrityOf(filePath, hex.EncodeToString(sum[:]), len(content))
+}
+
+// integrityOf returns the integrity of a file from its hex encoded SHA-256 hash.
+func integrityOf(filePath string, hash string, size int) FileIntegrity {
+	sum, _ := hex.DecodeString(hash)
+
+	return FileIntegrity{
+		Path:      filePath,
+		SHA256:    hash,
+		Integrity: "sha256-" + base64.StdEncoding.EncodeToString(sum),
+		Size:      size,
+	}
+}
+
+// Manifest returns the integrity report of the snapshot: the SHA-256 hash of the page and of every
+// file extracted by Ingredients.ExtractAssets.
+func (s *Snapshot) Manifest() (*Manifest, error) {
+	pageHash, pageSize, err := s.pageHash()
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make(map[string]string)
+	for _, asset := range s.Assets {
+		if asset.Hash != "" {
+			urls[asset.Hash] = asset.URL
+		}
+	}
+
+	m := &Manifest{
+		FormatHeader: s.FormatHeader,
+		URL:          s.URL,
+		CapturedAt:   s.StartedAt,
+		Page:         integrityOf("index.html", pageHash, pageSize),
+		Assets:       []FileIntegrity{},
+		Partial:      s.Partial,
+		Signature:    s.Signature,
+	}
+
+	if !s.Metadata.empty() {
+		metadata := s.Metadata
+		m.Metadata = &metadata
+	}
+
+	for _, name := range s.fileNames() {
+		file := integrity(name, s.Files[name])
+		file.URL = s.sources[name]
+		if file.URL == "" {
+			file.URL = urls[file.SHA256]
+		}
+		m.Assets = append(m.Assets, file)
+	}
+
+	return m, nil
+}
+
+// fileNames returns the paths of the extracted files in order.
+func (s *Snapshot) fileNames() []string {
+	names := make([]string, 0, len(s.Files))
+	for name := range s.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// walk calls fn with the path of every file of the snapshot and a function writing its content: the page
+// as index.html, the extracted assets, and the manifest as manifest.json.
+func (s *Snapshot) walk(fn func(name string, write func(w io.Writer) error) error) error {
+	if err := fn("index.html", s.WriteHTML); err != nil {
+		return err
+	}
+
+	content := func(b []byte) func(w io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := w.Write(b)
+			return err
+		}
+	}
+
+	for _, name := range s.fileNames() {
+		if err := fn(name, content(s.Files[name])); err != nil {
+			return err
+		}
+	}
+
+	m, err := s.Manifest()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fn("manifest.json", content(manifest))
+}
+
+// WriteDir writes the snapshot to a directory: the page as index.html, the extracted assets in the
+// assets directory, and the integrity report as manifest.json.
+func (s *Snapshot) WriteDir(dir string) error {
+	return s.walk(func(name string, write func(w io.Writer) error) error {
+		target := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		f, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+
+		if err := write(f); err != nil {
+			f.Close()
+			return err
+		}
+
+		return f.Close()
+	})
+}
+
+// ReadDir reads a snapshot written by Snapshot.WriteDir(). Only the page, the extracted files and the
+// metadata kept in the manifest are restored, and every file is checked against its hash in the manifest. The
+// manifest is migrated from the version of the format it was written in, see ReadManifest().
+func ReadDir(dir string) (*Snapshot, error) {
+	f, err := os.Open(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, err := ReadManifest(f)
+	if err != nil {
+		return nil, fmt.Errorf("manifest.json: %v", err)
+	}
+
+	read := func(file FileIntegrity) ([]byte, error) {
+		content, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(path.Clean("/"+file.Path))))
+		if err != nil {
+			return nil, err
+		}
+
+		if integrity(file.Path, content).SHA256 != file.SHA256 {
+			return nil, fmt.Errorf("%s does not match its hash in the manifest", file.Path)
+		}
+
+		return content, nil
+	}
+
+	page, err := read(m.Page)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Snapshot{
+		FormatHeader: m.FormatHeader,
+		URL:          m.URL,
+		HTML:         string(page),
+		Assets:       []*Asset{},
+		Files:        make(map[string][]byte),
+		StartedAt:    m.CapturedAt,
+		sources:      make(map[string]string),
+		Signature:    m.Signature,
+	}
+
+	for _, file := range m.Assets {
+		if s.Files[file.Path], err = read(file); err != nil {
+			return nil, err
+		}
+		if file.URL != "" {
+			s.sources[file.Path] = file.URL
+		}
+	}
+
+	return s, nil
+}
+
+// WriteZip writes the snapshot as a zip archive with the same layout as Snapshot.WriteDir().
+func (s *Snapshot) WriteZip(w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	err := s.walk(func(name string, write func(w io.Writer) error) error {
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: s.StartedAt})
+		if err != nil {
+			return err
+		}
+
+		return write(f)
+	})
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
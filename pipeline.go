@@ -0,0 +1,199 @@
+package antidote
+
+import (
+	"container/heap"
+	"math"
+	"sync"
+)
+
+// Priorities of the tasks in the cure pipeline. Tasks with a lower priority run first. They follow the critical
+// rendering path, so that the assets most needed to render the page are fetched before Ingredients.Deadline or
+// Ingredients.MaxTotalSize cut the cure short: stylesheets and the fonts they reference first, then scripts, and
+// images (those of stylesheets included) and frames last.
+const (
+	priorityStylesheet = iota
+	priorityFont
+	priorityScript
+	priorityImage
+	priorityFrame
+)
+
+// defaultConcurrency is the number of pipeline workers used when Ingredients.Concurrency is not set.
+const defaultConcurrency = 16
+
+// task is a unit of work in the cure pipeline. Tasks fetching an asset of the page hold it, so it can be probed
+// ahead of time, see Antidote.preflight().
+type task struct {
+	priority int
+	size     int64
+	seq      int
+	asset    *PreflightAsset
+	run      func()
+}
+
+// taskQueue implements heap.Interface, ordering tasks by priority, then by the size of their asset if it was
+// probed, smallest first, and then by the order they were scheduled in.
+type taskQueue []*task
+
+func (q taskQueue) Len() int { return len(q) }
+
+func (q taskQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority < q[j].priority
+	}
+	if q[i].size != q[j].size {
+		return q[i].size < q[j].size
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q taskQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *taskQueue) Push(x interface{}) { *q = append(*q, x.(*task)) }
+
+func (q *taskQueue) Pop() interface{} {
+	old := *q
+	t := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return t
+}
+
+// pipeline runs the tasks of a cure on a pool of workers, highest priority first. Tasks may schedule
+// more tasks while they run (e.g. a stylesheet scheduling the fonts it references), and the pipeline is
+// done once every scheduled task has run. Tasks must not touch the document, but hand their changes to
+// it over with pipeline.mutate().
+type pipeline struct {
+	tasks     taskQueue
+	pending   int
+	seq       int
+	mutations []func()
+	mu        sync.Mutex
+	cond      *sync.Cond
+}
+
+func newPipeline() *pipeline {
+	p := new(pipeline)
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// schedule adds a task to the pipeline. It is safe to call from within a running task.
+func (p *pipeline) schedule(priority int, run func()) {
+	p.push(&task{priority: priority, run: run})
+}
+
+// scheduleFetch adds a task fetching an asset of the page from src, see pipeline.schedule().
+func (p *pipeline) scheduleFetch(priority int, kind AssetKind, src string, run func()) {
+	p.push(&task{priority: priority, asset: &PreflightAsset{Kind: kind, Source: src}, run: run})
+}
+
+func (p *pipeline) push(t *task) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.seq++
+	p.pending++
+	t.seq = p.seq
+	heap.Push(&p.tasks, t)
+	p.cond.Signal()
+}
+
+// assets returns the assets of the tasks waiting to run.
+func (p *pipeline) assets() []*PreflightAsset {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var assets []*PreflightAsset
+	for _, t := range p.tasks {
+		if t.asset != nil {
+			assets = append(assets, t.asset)
+		}
+	}
+
+	return assets
+}
+
+// reorder orders the tasks waiting to run by the size of their asset once it has been probed. Assets of an
+// unknown size are fetched after the others of the same priority.
+func (p *pipeline) reorder() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, t := range p.tasks {
+		switch {
+		case t.asset == nil:
+		case t.asset.Size < 0:
+			t.size = math.MaxInt64
+		default:
+			t.size = t.asset.Size
+		}
+	}
+	heap.Init(&p.tasks)
+}
+
+// mutate queues a change to the document. It is safe to call from within a running task.
+func (p *pipeline) mutate(fn func()) {
+	p.mu.Lock()
+	p.mutations = append(p.mutations, fn)
+	p.mu.Unlock()
+}
+
+// run starts the workers and waits until every task, including the ones scheduled by other tasks, has run.
+// Then it applies the queued changes to the document one after the other, on the calling goroutine.
+func (p *pipeline) run(workers int) {
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go (func() {
+			defer wg.Done()
+			p.work()
+		})()
+	}
+
+	wg.Wait()
+
+	for _, fn := range p.mutations {
+		fn()
+	}
+}
+
+// work runs tasks until there are none left to run.
+func (p *pipeline) work() {
+	for {
+		t := p.next()
+		if t == nil {
+			return
+		}
+
+		t.run()
+		p.done()
+	}
+}
+
+// next waits for a task to run, and returns nil once every task has run.
+func (p *pipeline) next() *task {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.tasks) == 0 && p.pending > 0 {
+		p.cond.Wait()
+	}
+
+	if p.pending == 0 {
+		return nil
+	}
+
+	return heap.Pop(&p.tasks).(*task)
+}
+
+// done marks a task as run, waking up the idle workers once every task has run.
+func (p *pipeline) done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending--
+	if p.pending == 0 {
+		p.cond.Broadcast()
+	}
+}
@@ -0,0 +1,74 @@
+package antidote
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"strings"
+	"sync"
+)
+
+// maxPooledBuffer is the capacity above which a buffer is dropped instead of being put back in bufferPool, so
+// that a single huge body does not stay pinned in memory for the rest of the process.
+const maxPooledBuffer = 4 << 20
+
+// bufferPool holds the buffers the bodies of responses are read into. They are reused by every fetch, of every
+// cure, which spares the garbage collector the buffers grown by ioutil.ReadAll() for each asset.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// getBuffer returns an empty buffer of the pool, grown to read size bytes if it is known. bytes.Buffer.ReadFrom()
+// needs bytes.MinRead bytes of room for its last read, the one reaching EOF.
+func getBuffer(size int64) *bytes.Buffer {
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
+	if size > 0 && size <= maxPooledBuffer {
+		b.Grow(int(size) + bytes.MinRead)
+	}
+
+	return b
+}
+
+// putBuffer puts a buffer back in the pool. It must not be used afterwards.
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() <= maxPooledBuffer {
+		bufferPool.Put(b)
+	}
+}
+
+// readString reads r until EOF into a string, through a buffer of the pool. size is the expected length of the
+// content, e.g. the Content-Length of a response, or -1 if it is unknown.
+func readString(r io.Reader, size int64) (string, error) {
+	b := getBuffer(size)
+	defer putBuffer(b)
+
+	_, err := b.ReadFrom(r)
+	return b.String(), err
+}
+
+// dataURLChunk is the number of bytes of content base64 encoded at once by encodeDataURL(), a multiple of 3 so
+// that the chunks are encoded without padding.
+const dataURLChunk = 3 * 1024
+
+// encodeDataURL returns content as a base64 data URL of a MIME type. The data URL is written in place with a
+// single allocation, instead of the copies of []byte(content), base64.StdEncoding.EncodeToString() and the
+// concatenation, which add up to more than four times the size of the content for every image.
+func encodeDataURL(mimeType string, content string) string {
+	prefix := "data:" + mimeType + ";base64,"
+
+	var s strings.Builder
+	s.Grow(len(prefix) + base64.StdEncoding.EncodedLen(len(content)))
+	s.WriteString(prefix)
+
+	var src [dataURLChunk]byte
+	var dst [dataURLChunk / 3 * 4]byte
+	for len(content) > 0 {
+		n := copy(src[:], content)
+		base64.StdEncoding.Encode(dst[:], src[:n])
+		s.Write(dst[:base64.StdEncoding.EncodedLen(n)])
+		content = content[n:]
+	}
+
+	return s.String()
+}
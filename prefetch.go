@@ -0,0 +1,214 @@
+package antidote
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// prefetch is the fetch of an asset discovered while the page was downloading, see Ingredients.Prefetch.
+type prefetch struct {
+	spillable bool
+
+	// claimed is set once the prefetcher starts the fetch, or once the cure takes it over before it is started,
+	// in which case the cure fetches the asset itself.
+	claimed bool
+
+	done chan struct{}
+	resp *response
+	err  error
+}
+
+// prefetches reports whether the assets of the page are fetched while it downloads, see Ingredients.Prefetch.
+func (a *Antidote) prefetches() bool {
+	i := a.ingredients
+	return i.Prefetch && i.Source == "" && a.renderer() == nil && i.Selector == "" && !i.Preflight &&
+		i.Deadline <= 0 && i.MaxTotalSize <= 0 && a.prior == nil
+}
+
+// prefetchPage fetches the page, and starts fetching its assets as soon as their elements are read, or as soon
+// as its response starts for those it preloads, see Antidote.prefetchPreloads(). It returns once every element
+// has been read, so that every asset prefetched is known before the cure looks for it.
+func (a *Antidote) prefetchPage() (*response, error) {
+	a.mu.Lock()
+	a.prefetched = make(map[string]*prefetch)
+	a.mu.Unlock()
+
+	var tokenized chan struct{}
+	resp, err := a.download(a.parsedUrl.String(), nil, false, func(pageURL string, header http.Header) io.WriteCloser {
+		a.prefetchPreloads(pageURL, header)
+
+		r, w := io.Pipe()
+		tokenized = make(chan struct{})
+		go func() {
+			defer close(tokenized)
+			a.readPrefetches(pageURL, r)
+		}()
+		return w
+	})
+	if tokenized != nil {
+		<-tokenized
+	}
+
+	return resp, err
+}
+
+// readPrefetches tokenizes the body of the page served from pageURL while it downloads, and prefetches the
+// assets of the elements the cure would fetch: the stylesheets of <link> elements, the scripts of <script>
+// elements and the images of <img> elements. Those that may not be fetched in the end are not, e.g. the
+// scripts with StripJS or the stylesheets and scripts that Prune rules may remove.
+func (a *Antidote) readPrefetches(pageURL string, r io.Reader) {
+	// The rest of the body is read if tokenizing stops early, so that the download is never blocked.
+	defer io.Copy(ioutil.Discard, r)
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return
+	}
+
+	i := a.ingredients
+	hasBase := false
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		name, hasAttr := z.TagName()
+		attrs := make(map[string]string)
+		for hasAttr {
+			var key, val []byte
+			key, val, hasAttr = z.TagAttr()
+			if _, ok := attrs[string(key)]; !ok {
+				attrs[string(key)] = string(val)
+			}
+		}
+
+		switch string(name) {
+		case "base":
+			// Only the first <base> applies, see Antidote.resolveBase().
+			if href, ok := attrs["href"]; ok && !hasBase {
+				hasBase = true
+				if ref, err := url.Parse(strings.TrimSpace(href)); err == nil {
+					base = base.ResolveReference(ref)
+				}
+			}
+		case "link":
+			if ext, _ := hasExtension(attrs["href"], ".css"); ext == "" || len(i.Prune) > 0 {
+				continue
+			}
+			if media, ok := attrs["media"]; ok && i.Media.enabled() {
+				if matches, _ := i.Media.evaluate(media); !matches {
+					continue
+				}
+			}
+			a.startPrefetch(base, attrs["href"], AssetCSS, false)
+		case "script":
+			if ext, _ := hasExtension(attrs["src"], ".js"); ext == "" || i.StripJS || len(i.Prune) > 0 {
+				continue
+			}
+			a.startPrefetch(base, attrs["src"], AssetJS, false)
+		case "img":
+			if _, ok := imageType(attrs["src"]); !ok || i.SkipImages {
+				continue
+			}
+			a.startPrefetch(base, attrs["src"], AssetImage, i.spillsImages())
+		}
+	}
+}
+
+// startPrefetch fetches an asset of the page in the background, unless it already is. The prefetches wait for
+// one of Ingredients.Concurrency slots of their own.
+func (a *Antidote) startPrefetch(base *url.URL, src string, kind AssetKind, spillable bool) {
+	normalizedSrc, err := a.assetURL(base, src)
+	if err != nil {
+		return
+	}
+	if rule := a.hostRule(normalizedSrc); kind == AssetJS && rule != nil && rule.StripJS {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.prefetched[normalizedSrc]; ok {
+		return
+	}
+	if a.prefetchSlots == nil {
+		workers := a.ingredients.Concurrency
+		if workers <= 0 {
+			workers = defaultConcurrency
+		}
+		a.prefetchSlots = make(chan struct{}, workers)
+	}
+
+	f := &prefetch{spillable: spillable, done: make(chan struct{})}
+	a.prefetched[normalizedSrc] = f
+	slots := a.prefetchSlots
+
+	a.prefetching.Add(1)
+	go func() {
+		defer a.prefetching.Done()
+
+		slots <- struct{}{}
+		defer func() { <-slots }()
+
+		a.mu.Lock()
+		claimed := f.claimed
+		f.claimed = true
+		a.mu.Unlock()
+		if claimed {
+			return
+		}
+
+		a.log(LogDebug, "prefetching", "url", normalizedSrc)
+		f.resp, f.err = a.download(normalizedSrc, nil, spillable, nil)
+		close(f.done)
+	}()
+}
+
+// takePrefetch returns the response of an asset prefetched while the page was downloading, waiting for it if
+// its fetch is in progress. It reports false if the asset was not prefetched, or if its fetch has not started
+// yet, in which case the cure fetches it itself. A prefetch is only taken once, as every fetch of an asset is
+// recorded on its own.
+func (a *Antidote) takePrefetch(url string, spillable bool) (*response, error, bool) {
+	a.mu.Lock()
+	f, ok := a.prefetched[url]
+	if !ok || f.spillable != spillable {
+		a.mu.Unlock()
+		return nil, nil, false
+	}
+	delete(a.prefetched, url)
+	claimed := f.claimed
+	f.claimed = true
+	a.mu.Unlock()
+
+	if !claimed {
+		return nil, nil, false
+	}
+
+	<-f.done
+	return f.resp, f.err, true
+}
+
+// stopPrefetching cancels the prefetches the cure has not taken, e.g. those of elements removed while curing,
+// and waits for those in progress, so that none outlives the cure.
+func (a *Antidote) stopPrefetching() {
+	a.mu.Lock()
+	for _, f := range a.prefetched {
+		f.claimed = true
+	}
+	a.prefetched = nil
+	a.prefetchSlots = nil
+	a.mu.Unlock()
+
+	a.prefetching.Wait()
+}
@@ -0,0 +1,120 @@
+package antidote
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// PreflightAsset object represents an asset of the page probed with a HEAD request before its body is fetched,
+// see Ingredients.Preflight.
+type PreflightAsset struct {
+	// Kind is the type of the asset.
+	Kind AssetKind `json:"kind"`
+
+	// Source is the asset reference as it appeared in the original HTML.
+	Source string `json:"source"`
+
+	// URL is the normalized URL the asset was probed at.
+	URL string `json:"url,omitempty"`
+
+	// Size is the Content-Length of the asset, or -1 if it is unknown, e.g. because the server does not answer
+	// HEAD requests.
+	Size int64 `json:"size"`
+
+	// ContentType is the Content-Type the asset is served with.
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// preflight probes the assets of the page scheduled on the pipeline with HEAD requests, workers of them at a
+// time, which also resolves their hosts and opens connections to them ahead of the fetches. The tasks are then
+// reordered so the smallest assets of every priority are fetched first, and Ingredients.OnPreflight is called
+// with the assets. The resources referenced by stylesheets are only discovered while the pipeline runs, so
+// they are not probed.
+func (a *Antidote) preflight(p *pipeline, workers int) {
+	assets := p.assets()
+	if len(assets) == 0 {
+		return
+	}
+
+	// An asset referenced several times is only probed once.
+	byURL := make(map[string][]*PreflightAsset)
+	for _, asset := range assets {
+		asset.Size = -1
+		if normalizedSrc, err := a.assetURL(nil, asset.Source); err == nil {
+			asset.URL = normalizedSrc
+			byURL[normalizedSrc] = append(byURL[normalizedSrc], asset)
+		}
+	}
+
+	urls := make(chan string)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go (func() {
+			defer wg.Done()
+			for u := range urls {
+				size, contentType := a.head(context.Background(), u)
+				for _, asset := range byURL[u] {
+					asset.Size, asset.ContentType = size, contentType
+				}
+
+				a.mu.Lock()
+				a.probed[u] = size
+				a.mu.Unlock()
+			}
+		})()
+	}
+	for u := range byURL {
+		urls <- u
+	}
+	close(urls)
+	wg.Wait()
+
+	p.reorder()
+
+	var total int64
+	unknown := 0
+	for _, asset := range assets {
+		if asset.Size < 0 {
+			unknown++
+			continue
+		}
+		total += asset.Size
+	}
+	a.log(LogInfo, "preflight", "assets", len(assets), "size", total, "unknown", unknown)
+
+	if a.ingredients.OnPreflight != nil {
+		a.ingredients.OnPreflight(assets)
+	}
+}
+
+// head returns the Content-Length and the Content-Type of a URL from a HEAD request made until ctx is done, or
+// -1 and an empty Content-Type if they can not be learned.
+func (a *Antidote) head(ctx context.Context, url string) (int64, string) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return -1, ""
+	}
+	req = req.WithContext(ctx)
+
+	release := a.applyHostRule(req)
+	defer release()
+
+	// The probes share the slots of the scheduler with the fetches, like any other request of the cure.
+	unschedule := a.schedule()
+	defer unschedule()
+
+	resp, err := a.do(req)
+	if err != nil {
+		a.log(LogDebug, "preflight failed", "url", url, "error", err)
+		return -1, ""
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return -1, ""
+	}
+
+	return resp.ContentLength, resp.Header.Get("Content-Type")
+}
@@ -0,0 +1,135 @@
+package antidote
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// preloadLink object represents a resource a response preloads with a Link header, e.g.
+// `Link: </fonts/main.woff2>; rel=preload; as=font; crossorigin`.
+type preloadLink struct {
+	url string
+
+	// as is the destination of the resource, e.g. "style", "script", "image" or "font".
+	as string
+}
+
+// parseLinkHeaders returns the resources preloaded by the Link headers of a response (RFC 8288), in order. The
+// links of other types, and the preloads without a destination, are ignored.
+func parseLinkHeaders(header http.Header) []preloadLink {
+	var links []preloadLink
+	for _, value := range header.Values("Link") {
+		for value != "" {
+			value = strings.TrimLeft(value, " \t,")
+			if !strings.HasPrefix(value, "<") {
+				break
+			}
+
+			end := strings.IndexByte(value, '>')
+			if end < 0 {
+				break
+			}
+			target := value[1:end]
+			value = value[end+1:]
+
+			var rel, as string
+			for {
+				value = strings.TrimLeft(value, " \t")
+				if !strings.HasPrefix(value, ";") {
+					break
+				}
+
+				var name, param string
+				name, param, value = parseLinkParam(value[1:])
+				switch name {
+				case "rel":
+					rel = param
+				case "as":
+					as = strings.ToLower(param)
+				}
+			}
+
+			for _, linkType := range strings.Fields(strings.ToLower(rel)) {
+				if linkType == "preload" && as != "" {
+					links = append(links, preloadLink{url: target, as: as})
+					break
+				}
+			}
+		}
+	}
+
+	return links
+}
+
+// parseLinkParam parses a parameter of a link, a name with an optional value that may be quoted, and returns
+// its lowercase name, its value and the rest of the header.
+func parseLinkParam(s string) (string, string, string) {
+	s = strings.TrimLeft(s, " \t")
+	end := strings.IndexAny(s, "=;,")
+	if end < 0 {
+		return strings.ToLower(strings.TrimSpace(s)), "", ""
+	}
+
+	name := strings.ToLower(strings.TrimSpace(s[:end]))
+	if s[end] != '=' {
+		return name, "", s[end:]
+	}
+
+	s = strings.TrimLeft(s[end+1:], " \t")
+	if !strings.HasPrefix(s, `"`) {
+		end = strings.IndexAny(s, ";,")
+		if end < 0 {
+			return name, strings.TrimSpace(s), ""
+		}
+		return name, strings.TrimSpace(s[:end]), s[end:]
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				value.WriteByte(s[i])
+			}
+		case '"':
+			return name, value.String(), s[i+1:]
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+
+	return name, value.String(), ""
+}
+
+// prefetchPreloads prefetches the resources the page preloads with Link headers, see Ingredients.Prefetch.
+// They are known as soon as the response of the page starts, before any element of its body, and may be
+// resources its elements do not reference, e.g. the fonts and background images of its stylesheets.
+// Relative URL's are resolved against the URL the page was served from, as <base> does not apply to headers.
+func (a *Antidote) prefetchPreloads(pageURL string, header http.Header) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return
+	}
+
+	i := a.ingredients
+	for _, link := range parseLinkHeaders(header) {
+		switch link.as {
+		case "style":
+			if ext, _ := hasExtension(link.url, ".css"); ext != "" && len(i.Prune) == 0 {
+				a.startPrefetch(base, link.url, AssetCSS, false)
+			}
+		case "script":
+			if ext, _ := hasExtension(link.url, ".js"); ext != "" && !i.StripJS && len(i.Prune) == 0 {
+				a.startPrefetch(base, link.url, AssetJS, false)
+			}
+		case "image":
+			if !i.SkipImages {
+				a.startPrefetch(base, link.url, AssetImage, i.spillsImages())
+			}
+		case "font":
+			a.startPrefetch(base, link.url, AssetFont, !i.ExtractAssets)
+		}
+	}
+}
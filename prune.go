@@ -0,0 +1,213 @@
+package antidote
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// PruneRule object represents assets that can not affect a static snapshot, such as analytics or A/B testing
+// scripts, which are removed from the website instead of being cured.
+type PruneRule struct {
+	// Name identifies the rule in Snapshot.Pruned, e.g. "google-analytics".
+	Name string
+
+	// Kind restricts the rule to scripts (AssetJS) or stylesheets (AssetCSS). Empty applies it to both.
+	Kind AssetKind
+
+	// Hosts are the hosts the assets are served from. A leading "*." matches every subdomain.
+	Hosts []string
+
+	// List prunes the assets served from the hosts of a filter list as well, e.g. of trackers, see
+	// ParseFilterList().
+	List *FilterList
+
+	// Patterns are matched against the URL of external assets and the content of inline scripts. An asset
+	// containing any of them is pruned.
+	Patterns []string
+
+	// WhenFontsInlined only prunes the assets once at least one font has been inlined by the cure, which
+	// makes font loaders useless. Otherwise they are cured as usual.
+	WhenFontsInlined bool
+}
+
+// PrunedAsset object represents an asset removed from the website by a PruneRule.
+type PrunedAsset struct {
+	// Rule is the name of the rule that pruned the asset.
+	Rule string `json:"rule"`
+
+	// Kind is the type of the asset.
+	Kind AssetKind `json:"kind"`
+
+	// Source is the asset reference as it appeared in the original HTML. It is empty for inline scripts.
+	Source string `json:"source,omitempty"`
+}
+
+// DefaultPruneRules are rules pruning common analytics, A/B testing and font loading scripts.
+var DefaultPruneRules = []PruneRule{
+	{
+		Name:     "google-analytics",
+		Hosts:    []string{"www.google-analytics.com", "ssl.google-analytics.com", "www.googletagmanager.com"},
+		Patterns: []string{"google-analytics.com/analytics.js", "google-analytics.com/ga.js", "googletagmanager.com/gtag/js", "googletagmanager.com/gtm.js"},
+	},
+	{
+		Name:     "facebook-pixel",
+		Hosts:    []string{"connect.facebook.net"},
+		Patterns: []string{"connect.facebook.net/en_US/fbevents.js"},
+	},
+	{
+		Name:     "hotjar",
+		Hosts:    []string{"static.hotjar.com", "script.hotjar.com"},
+		Patterns: []string{"static.hotjar.com/c/hotjar-"},
+	},
+	{
+		Name:     "segment",
+		Hosts:    []string{"cdn.segment.com"},
+		Patterns: []string{"cdn.segment.com/analytics.js"},
+	},
+	{
+		Name:     "mixpanel",
+		Hosts:    []string{"cdn.mxpnl.com"},
+		Patterns: []string{"cdn.mxpnl.com/libs/mixpanel"},
+	},
+	{
+		Name:     "optimizely",
+		Hosts:    []string{"cdn.optimizely.com", "*.optimizely.com"},
+		Patterns: []string{"cdn.optimizely.com/js/"},
+	},
+	{
+		Name:     "google-optimize",
+		Hosts:    []string{"www.googleoptimize.com"},
+		Patterns: []string{"googleoptimize.com/optimize.js"},
+	},
+	{
+		Name:     "vwo",
+		Hosts:    []string{"dev.visualwebsiteoptimizer.com"},
+		Patterns: []string{"dev.visualwebsiteoptimizer.com/j.php"},
+	},
+	{
+		Name:             "webfont-loader",
+		Kind:             AssetJS,
+		Patterns:         []string{"webfont.js", "webfontloader", "WebFont.load("},
+		WhenFontsInlined: true,
+	},
+}
+
+// matches reports whether the rule applies to an asset of a kind, given the URL of an external asset or the
+// content of an inline script.
+func (r *PruneRule) matches(kind AssetKind, assetURL string, content string) bool {
+	if r.Kind != "" && r.Kind != kind {
+		return false
+	}
+
+	if assetURL != "" {
+		if u, err := url.Parse(assetURL); err == nil {
+			if r.List != nil && r.List.Match(u.Hostname()) {
+				return true
+			}
+			for _, host := range r.Hosts {
+				if matchHost(host, u.Hostname()) {
+					return true
+				}
+			}
+		}
+		content = assetURL
+	}
+
+	for _, pattern := range r.Patterns {
+		if pattern != "" && strings.Contains(content, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pruneRule returns the first rule of Ingredients.Prune that applies to an asset, or nil if none do.
+func (a *Antidote) pruneRule(kind AssetKind, src string, content string) *PruneRule {
+	assetURL := ""
+	if src != "" {
+		normalizedSrc, err := a.assetURL(nil, src)
+		if err != nil {
+			return nil
+		}
+		assetURL = normalizedSrc
+	}
+
+	for i := range a.ingredients.Prune {
+		if rule := &a.ingredients.Prune[i]; rule.matches(kind, assetURL, content) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// prune removes an element referencing an asset (src, or the content of an inline script) if a rule of
+// Ingredients.Prune applies to it, and reports whether it did. The decision on rules that depend on the
+// outcome of the cure is deferred until every other asset has been cured: the element is then either
+// removed, or cured by calling cure, which may be nil if there is nothing to cure. Either way the element
+// must not be cured by the caller.
+func (a *Antidote) prune(element *goquery.Selection, kind AssetKind, src string, content string, cure func(p *pipeline)) bool {
+	rule := a.pruneRule(kind, src, content)
+	if rule == nil {
+		return false
+	}
+
+	if !rule.WhenFontsInlined {
+		a.removePruned(rule, element, kind, src)
+		return true
+	}
+
+	a.deferred = append(a.deferred, func(p *pipeline) {
+		if a.fontsInlined() {
+			a.removePruned(rule, element, kind, src)
+		} else if cure != nil {
+			cure(p)
+		}
+	})
+
+	return true
+}
+
+// removePruned removes an element pruned by a rule and records it in the snapshot.
+func (a *Antidote) removePruned(rule *PruneRule, element *goquery.Selection, kind AssetKind, src string) {
+	element.Remove()
+
+	a.mu.Lock()
+	a.snapshot.Pruned = append(a.snapshot.Pruned, PrunedAsset{Rule: rule.Name, Kind: kind, Source: src})
+	a.mu.Unlock()
+}
+
+// fontsInlined reports whether at least one font has been inlined (or extracted) so far.
+func (a *Antidote) fontsInlined() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, asset := range a.snapshot.Assets {
+		if asset.Kind == AssetFont && asset.Error == "" && !asset.Remote {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cureDeferred settles the assets whose pruning was deferred by Antidote.prune(), curing the ones that are
+// kept on a pipeline of their own.
+func (a *Antidote) cureDeferred(workers int) {
+	deferred := a.deferred
+	a.deferred = nil
+
+	if len(deferred) == 0 {
+		return
+	}
+
+	p := newPipeline()
+	for _, settle := range deferred {
+		settle(p)
+	}
+
+	p.run(workers)
+}
@@ -0,0 +1,54 @@
+package antidote
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// quarantinePolicy is the Content-Security-Policy of quarantined pages. It blocks the requests scripts make
+// (fetch, XMLHttpRequest, WebSocket, EventSource and navigator.sendBeacon()) and the submission of forms, and
+// leaves the assets of the page as they are.
+const quarantinePolicy = "connect-src 'none'; form-action 'none'"
+
+// quarantineRels are the link types making browsers connect to a host, or fetch a resource, ahead of time.
+var quarantineRels = []string{"preconnect", "dns-prefetch", "prefetch", "prerender", "preload", "modulepreload"}
+
+// quarantine neutralizes the elements of the cured page that would contact the original site once the page is
+// opened, see Ingredients.Quarantine.
+func (a *Antidote) quarantine() {
+	if !a.ingredients.Quarantine {
+		return
+	}
+
+	// Forms submit to the page itself, which is the archive.
+	a.website.Find("form[action]").SetAttr("action", "#")
+	a.website.Find("[formaction]").RemoveAttr("formaction")
+
+	// Hyperlink auditing pings the listed URL's when a link is followed.
+	a.website.Find("a[ping], area[ping]").RemoveAttr("ping")
+
+	a.website.Find("link[rel]").Each(func(index int, link *goquery.Selection) {
+		rel, _ := link.Attr("rel")
+		for _, linkType := range strings.Fields(strings.ToLower(rel)) {
+			for _, quarantined := range quarantineRels {
+				if linkType == quarantined {
+					link.Remove()
+					return
+				}
+			}
+		}
+	})
+
+	// Refreshes may redirect to the original site.
+	a.website.Find("meta[http-equiv]").Each(func(index int, meta *goquery.Selection) {
+		if equiv, _ := meta.Attr("http-equiv"); strings.EqualFold(strings.TrimSpace(equiv), "refresh") {
+			meta.Remove()
+		}
+	})
+
+	// The policy applies to the scripts after it, so it goes first, and the referrer is not sent by the links
+	// followed from the archive.
+	a.website.Find("head").First().PrependHtml(`<meta http-equiv="Content-Security-Policy" content="` + quarantinePolicy + `">` +
+		`<meta name="referrer" content="no-referrer">`)
+}
@@ -0,0 +1,171 @@
+package antidote
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRetryAfter is the longest a request answered with 429 Too Many Requests or 503 Service Unavailable waits
+// before being retried. Responses asking to wait longer are not retried.
+const maxRetryAfter = time.Minute
+
+// throttle limits the requests made to a host once it answered with 429 Too Many Requests or 503 Service
+// Unavailable, see Ingredients.RateLimitRetries: every throttled response halves the number of requests made to
+// the host at the same time, and pauses them for the delay of its Retry-After header.
+type throttle struct {
+	// limit is the number of requests that may be made to the host at the same time, or zero until the host
+	// throttles the cure.
+	limit  int
+	active int
+	until  time.Time
+	mu     sync.Mutex
+	cond   *sync.Cond
+}
+
+func newThrottle() *throttle {
+	t := new(throttle)
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// acquire waits until a request may be made to the host. The returned function must be called once the
+// request is done.
+func (t *throttle) acquire() func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for {
+		if wait := time.Until(t.until); wait > 0 {
+			t.mu.Unlock()
+			time.Sleep(wait)
+			t.mu.Lock()
+			continue
+		}
+		if t.limit > 0 && t.active >= t.limit {
+			t.cond.Wait()
+			continue
+		}
+		break
+	}
+
+	t.active++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			t.active--
+			t.mu.Unlock()
+			t.cond.Signal()
+		})
+	}
+}
+
+// backoff halves the number of requests made to the host at the same time, down to one, and pauses them for
+// delay. The requests already made when the host was paused are throttled as well, so their responses only
+// extend the pause. It returns the new limit.
+func (t *throttle) backoff(delay time.Duration) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.limit == 0 {
+		t.limit = t.active
+	}
+	if !time.Now().Before(t.until) {
+		if t.limit /= 2; t.limit < 1 {
+			t.limit = 1
+		}
+	}
+
+	if until := time.Now().Add(delay); until.After(t.until) {
+		t.until = until
+	}
+
+	return t.limit
+}
+
+// throttle returns the throttle of a host.
+func (a *Antidote) throttle(host string) *throttle {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.throttles == nil {
+		a.throttles = make(map[string]*throttle)
+	}
+
+	t, ok := a.throttles[host]
+	if !ok {
+		t = newThrottle()
+		a.throttles[host] = t
+	}
+
+	return t
+}
+
+// do sends a request with the client of the cure in progress, within the throttle of its host. Requests
+// answered with 429 Too Many Requests or 503 Service Unavailable are retried up to Ingredients.RateLimitRetries
+// times, after the delay of their Retry-After header, or an exponential backoff from one second without one.
+// A StatusError is returned if the host keeps throttling the cure.
+func (a *Antidote) do(req *http.Request) (*http.Response, error) {
+	t := a.throttle(req.URL.Host)
+
+	for attempt := 0; ; attempt++ {
+		release := t.acquire()
+		resp, err := a.send(req)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+			return resp, nil
+		}
+
+		delay, ok := retryAfter(resp.Header.Get("Retry-After"), attempt)
+		if deadline := a.ingredients.Deadline; deadline > 0 && a.snapshot != nil && time.Since(a.snapshot.StartedAt)+delay > deadline {
+			ok = false
+		}
+		if attempt >= a.ingredients.RateLimitRetries || !ok {
+			resp.Body.Close()
+			a.log(LogWarn, "still throttled", "url", req.URL, "status", resp.StatusCode, "attempts", attempt+1)
+			return nil, &StatusError{URL: req.URL.String(), Code: resp.StatusCode}
+		}
+
+		limit := t.backoff(delay)
+		resp.Body.Close()
+		a.log(LogWarn, "throttled", "url", req.URL, "status", resp.StatusCode, "retryAfter", delay, "hostConcurrency", limit)
+	}
+}
+
+// retryAfter returns the delay of a Retry-After header, either a number of seconds or an HTTP date, or an
+// exponential backoff from one second for the attempt if the header is missing or invalid. It reports false
+// if the delay is longer than maxRetryAfter.
+func retryAfter(header string, attempt int) (time.Duration, bool) {
+	delay := time.Second << uint(attempt)
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		if delay = time.Until(date); delay < 0 {
+			delay = 0
+		}
+	}
+
+	return delay, delay <= maxRetryAfter
+}
+
+// releasingBody releases the slot of a request in the throttle of its host once its body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
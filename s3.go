@@ -0,0 +1,288 @@
+package antidote
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Store is a Store backed by an S3-compatible object storage bucket, e.g. Amazon S3, MinIO, Cloudflare R2,
+// or Google Cloud Storage through its XML API with HMAC keys. Requests are signed with AWS Signature
+// Version 4. It is safe for concurrent use.
+type S3Store struct {
+	// Bucket is the name of the bucket.
+	Bucket string
+
+	// Prefix is prepended to every key, e.g. "antidote/".
+	Prefix string
+
+	// Region is the region of the bucket, e.g. us-east-1. Defaults to us-east-1.
+	Region string
+
+	// Endpoint is the host of the storage service, e.g. storage.googleapis.com. Defaults to the Amazon S3
+	// endpoint of the region.
+	Endpoint string
+
+	// Insecure connects to the endpoint over http instead of https, e.g. for a local MinIO.
+	Insecure bool
+
+	// PathStyle addresses the bucket in the path of requests rather than in the hostname, which most
+	// S3-compatible services other than Amazon S3 require.
+	PathStyle bool
+
+	// AccessKey, SecretKey and SessionToken are the credentials requests are signed with. SessionToken is
+	// only set for temporary credentials.
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	// Client makes the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Get returns the object stored under a key, or ErrNotStored.
+func (s *S3Store) Get(key string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotStored
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.error(resp, key)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Put stores an object under a key.
+func (s *S3Store) Put(key string, value []byte) error {
+	resp, err := s.do(http.MethodPut, key, value)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.error(resp, key)
+	}
+
+	return nil
+}
+
+// List returns the objects whose key starts with a prefix, e.g. "jobs/", without Prefix, in the order of their
+// keys.
+func (s *S3Store) List(prefix string) ([]StoredBlob, error) {
+	var blobs []StoredBlob
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.Prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.request(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListResult
+		if resp.StatusCode != http.StatusOK {
+			err = s.error(resp, prefix)
+		} else {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			blobs = append(blobs, StoredBlob{
+				Key:      strings.TrimPrefix(object.Key, s.Prefix),
+				Size:     object.Size,
+				Modified: object.LastModified,
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return blobs, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// s3ListResult is the response of a ListObjectsV2 request.
+type s3ListResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// Delete removes the object stored under a key. Deleting a key that stores nothing succeeds.
+func (s *S3Store) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s.error(resp, key)
+	}
+
+	return nil
+}
+
+// do makes a signed request for the object of a key.
+func (s *S3Store) do(method string, key string, body []byte) (*http.Response, error) {
+	return s.request(method, s3Escape(s.Prefix+key), nil, body)
+}
+
+// request makes a signed request for the escaped path of an object in the bucket, or for the bucket itself if it
+// is empty, with a query.
+func (s *S3Store) request(method string, objectPath string, query url.Values, body []byte) (*http.Response, error) {
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	host := s.Endpoint
+	if host == "" {
+		host = "s3." + region + ".amazonaws.com"
+	}
+
+	path := "/" + objectPath
+	if s.PathStyle {
+		path = "/" + s3Escape(s.Bucket) + path
+	} else {
+		host = s.Bucket + "." + host
+	}
+
+	scheme := "https"
+	if s.Insecure {
+		scheme = "http"
+	}
+
+	req, err := http.NewRequest(method, scheme+"://"+host+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	// The path is already escaped as it was signed.
+	req.URL.RawPath = path
+	req.URL.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+
+	s.sign(req, region, body, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return client.Do(req)
+}
+
+// sign adds the headers of AWS Signature Version 4 to a request, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html.
+func (s *S3Store) sign(req *http.Request, region string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := hashContent(string(body))
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n"
+
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+		signedHeaders += ";x-amz-security-token"
+		canonicalHeaders += "x-amz-security-token:" + s.SessionToken + "\n"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.RawPath,
+		strings.Replace(req.URL.Query().Encode(), "+", "%20", -1),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashContent(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// error returns the error of a failed request, with the message of the service if it has one.
+func (s *S3Store) error(resp *http.Response, key string) error {
+	body, _ := ioutil.ReadAll(resp.Body)
+	if message := xmlElementText(string(body), "Message"); message != "" {
+		return fmt.Errorf("%s: %s: %s", key, resp.Status, message)
+	}
+
+	return fmt.Errorf("%s: %s", key, resp.Status)
+}
+
+// s3Escape escapes a key for the path of a request: every byte but the unreserved characters and slashes is
+// percent-encoded, as the signature requires.
+func s3Escape(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with a key.
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
+
+// xmlElementText returns the text of the first element of a name in an XML document, or an empty string.
+func xmlElementText(document string, name string) string {
+	start := strings.Index(document, "<"+name+">")
+	if start < 0 {
+		return ""
+	}
+	start += len(name) + 2
+
+	end := strings.Index(document[start:], "</"+name+">")
+	if end < 0 {
+		return ""
+	}
+
+	return document[start : start+end]
+}
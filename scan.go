@@ -0,0 +1,49 @@
+package antidote
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ScanError is returned by assets flagged by Ingredients.ScanAsset.
+type ScanError struct {
+	// URL is the URL of the asset.
+	URL string
+
+	// Err is the error returned by Ingredients.ScanAsset.
+	Err error
+}
+
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("%s: flagged by scanner: %v", e.URL, e.Err)
+}
+
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
+
+// scanAsset passes the body of an asset to Ingredients.ScanAsset, reading it from its temporary file if it was
+// spilled to disk.
+func (a *Antidote) scanAsset(asset *Asset, resp *response) error {
+	if a.ingredients.ScanAsset == nil {
+		return nil
+	}
+
+	var body io.Reader = strings.NewReader(resp.body)
+	if resp.spill != nil {
+		f, err := os.Open(resp.spill.path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		body = f
+	}
+
+	if err := a.ingredients.ScanAsset(asset.URL, resp.contentType, body); err != nil {
+		return &ScanError{URL: asset.URL, Err: err}
+	}
+
+	return nil
+}
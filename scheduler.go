@@ -0,0 +1,153 @@
+package antidote
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// FetchScheduler caps the fetches of every cure sharing it, e.g. the jobs of the daemon through its default
+// ingredients (see Ingredients.Scheduler): the number of fetches in progress at the same time, and the bandwidth
+// of their bodies. Every cure is a job of its own, and the slots freed are handed to the jobs waiting for one in
+// turn, so that a page with thousands of assets can not starve the cures started after it. It is safe for
+// concurrent use.
+type FetchScheduler struct {
+	maxFetches int
+	bandwidth  int64
+
+	active  int
+	waiting []*fetchJob
+	next    int
+
+	// tokens are the bytes that may be read without waiting, refilled at the rate of the bandwidth.
+	tokens   float64
+	refilled time.Time
+
+	mu sync.Mutex
+}
+
+// fetchJob is a cure scheduled by a FetchScheduler, with its fetches waiting for a slot in order.
+type fetchJob struct {
+	queue []chan struct{}
+}
+
+// NewFetchScheduler creates a new instance of a FetchScheduler pointer allowing maxFetches fetches at the same
+// time, and bandwidth bytes per second for all of their bodies. Zero means no limit.
+func NewFetchScheduler(maxFetches int, bandwidth int64) *FetchScheduler {
+	return &FetchScheduler{maxFetches: maxFetches, bandwidth: bandwidth, tokens: float64(bandwidth), refilled: time.Now()}
+}
+
+// acquire waits for a slot for a fetch of a job. The returned function must be called once the fetch is done.
+func (s *FetchScheduler) acquire(job *fetchJob) func() {
+	if s.maxFetches <= 0 {
+		return func() {}
+	}
+
+	s.mu.Lock()
+	if s.active < s.maxFetches && len(s.waiting) == 0 {
+		s.active++
+		s.mu.Unlock()
+		return s.release
+	}
+
+	ready := make(chan struct{})
+	if len(job.queue) == 0 {
+		s.waiting = append(s.waiting, job)
+	}
+	job.queue = append(job.queue, ready)
+	s.mu.Unlock()
+
+	<-ready
+	return s.release
+}
+
+// release frees the slot of a fetch, and hands it to the next job waiting for one.
+func (s *FetchScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active--
+	for s.active < s.maxFetches && len(s.waiting) > 0 {
+		if s.next >= len(s.waiting) {
+			s.next = 0
+		}
+
+		job := s.waiting[s.next]
+		close(job.queue[0])
+		job.queue = job.queue[1:]
+		s.active++
+
+		// The job keeps its turn in the rotation while it has fetches waiting.
+		if len(job.queue) == 0 {
+			s.waiting = append(s.waiting[:s.next], s.waiting[s.next+1:]...)
+		} else {
+			s.next++
+		}
+	}
+}
+
+// throttle waits until n more bytes may be read within the bandwidth.
+func (s *FetchScheduler) throttle(n int) {
+	if s.bandwidth <= 0 || n <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	s.tokens += now.Sub(s.refilled).Seconds() * float64(s.bandwidth)
+	if s.tokens > float64(s.bandwidth) {
+		s.tokens = float64(s.bandwidth)
+	}
+	s.refilled = now
+	s.tokens -= float64(n)
+	wait := time.Duration(-s.tokens / float64(s.bandwidth) * float64(time.Second))
+	s.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// reader returns a reader of a body within the bandwidth of the scheduler.
+func (s *FetchScheduler) reader(r io.Reader) io.Reader {
+	if s.bandwidth <= 0 {
+		return r
+	}
+
+	return &throttledReader{r: r, s: s}
+}
+
+// schedule waits for a slot of Ingredients.Scheduler for a fetch of the cure, if it is set. The returned
+// function must be called once the fetch is done.
+func (a *Antidote) schedule() func() {
+	if a.ingredients.Scheduler == nil {
+		return func() {}
+	}
+
+	a.mu.Lock()
+	if a.fetchJob == nil {
+		a.fetchJob = new(fetchJob)
+	}
+	job := a.fetchJob
+	a.mu.Unlock()
+
+	return a.ingredients.Scheduler.acquire(job)
+}
+
+// throttledReader reads a body within the bandwidth of a FetchScheduler.
+type throttledReader struct {
+	r io.Reader
+	s *FetchScheduler
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// Reads are no larger than what the bandwidth allows in a second, so that the bodies read at the same time
+	// share it evenly.
+	if max := int(t.s.bandwidth); len(p) > max {
+		p = p[:max]
+	}
+
+	n, err := t.r.Read(p)
+	t.s.throttle(n)
+	return n, err
+}
@@ -0,0 +1,340 @@
+package antidote
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// ScreenshotFormat is the image format of a screenshot.
+type ScreenshotFormat string
+
+const (
+	ScreenshotPNG  ScreenshotFormat = "png"
+	ScreenshotWebP ScreenshotFormat = "webp"
+)
+
+// browsers are the executables of Chrome and Chromium looked up by Snapshot.CaptureScreenshot().
+var browsers = []string{
+	"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome", "headless_shell",
+	"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+	"/Applications/Chromium.app/Contents/MacOS/Chromium",
+}
+
+// ScreenshotOptions object represents options for Snapshot.CaptureScreenshot().
+type ScreenshotOptions struct {
+	// Browser is the path of the Chrome or Chromium executable the page is rendered with. Defaults to the first
+	// one found in PATH.
+	Browser string
+
+	// Format is the image format of the screenshot. Defaults to ScreenshotPNG.
+	Format ScreenshotFormat
+
+	// FullPage captures the whole height of the page instead of the viewport.
+	FullPage bool
+
+	// Width and Height are the size of the viewport in CSS pixels. Default to 1280x800.
+	Width  int
+	Height int
+
+	// Timeout is the time limit of the whole capture. Defaults to 30 seconds.
+	Timeout time.Duration
+
+	// NoSandbox runs the browser without its sandbox, which Chrome can not start as root, nor where user
+	// namespaces are disabled, e.g. in most containers. The scripts inlined in the page, including those of
+	// third parties, then run unconfined, so only set it where the browser is isolated otherwise.
+	NoSandbox bool
+}
+
+// CaptureScreenshot renders the cured page in a headless Chrome or Chromium and returns a screenshot of it, as
+// a visual ground truth of the snapshot. As every asset of the page is inlined, it renders the same without
+// network access, apart from the assets left remote. It is experimental: it depends on the version of the
+// browser, and is not tested against every one of them.
+func (s *Snapshot) CaptureScreenshot(options ScreenshotOptions) ([]byte, error) {
+	if options.Format == "" {
+		options.Format = ScreenshotPNG
+	}
+	if options.Format != ScreenshotPNG && options.Format != ScreenshotWebP {
+		return nil, fmt.Errorf("unknown screenshot format %q", options.Format)
+	}
+	if options.Width <= 0 {
+		options.Width = 1280
+	}
+	if options.Height <= 0 {
+		options.Height = 800
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 30 * time.Second
+	}
+
+	browser := options.Browser
+	if browser == "" {
+		for _, name := range browsers {
+			if path, err := exec.LookPath(name); err == nil {
+				browser = path
+				break
+			}
+		}
+		if browser == "" {
+			return nil, errors.New("no Chrome or Chromium executable was found, set ScreenshotOptions.Browser")
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "antidote-screenshot-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	page := filepath.Join(dir, "index.html")
+	f, err := os.Create(page)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.WriteHTML(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout)
+	defer cancel()
+
+	cmd, endpoint, err := launchBrowser(ctx, browser, dir, options)
+	if err != nil {
+		return nil, err
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	// The browser only accepts the DevTools connections of the origin it is started with, see launchBrowser().
+	conn, err := websocket.Dial(endpoint, "", devtoolsOrigin)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	// Full page screenshots of long pages are large messages.
+	conn.MaxPayloadBytes = 256 << 20
+
+	d := &devtools{conn: conn}
+	return d.screenshot((&url.URL{Scheme: "file", Path: filepath.ToSlash(page)}).String(), options)
+}
+
+// devtoolsOrigin is the origin of the DevTools connection to the browser.
+const devtoolsOrigin = "http://localhost"
+
+// launchBrowser starts a headless browser with its profile in dir, and returns it along with the address of its
+// DevTools endpoint once it is ready.
+func launchBrowser(ctx context.Context, browser string, dir string, options ScreenshotOptions) (*exec.Cmd, string, error) {
+	args := []string{
+		"--headless=new", "--disable-gpu", "--hide-scrollbars", "--no-first-run", "--no-default-browser-check",
+		"--user-data-dir=" + filepath.Join(dir, "profile"), "--remote-debugging-port=0",
+		"--remote-allow-origins=" + devtoolsOrigin, fmt.Sprintf("--window-size=%d,%d", options.Width, options.Height),
+	}
+	if options.NoSandbox {
+		args = append(args, "--no-sandbox")
+	}
+	cmd := exec.CommandContext(ctx, browser, append(args, "about:blank")...)
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, "", err
+	}
+
+	// The browser prints the address of its DevTools endpoint once it is ready.
+	var output []string
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "DevTools listening on ") {
+			go ioutil.ReadAll(stderr)
+			return cmd, strings.TrimPrefix(line, "DevTools listening on "), nil
+		}
+		if output = append(output, line); len(output) > 5 {
+			output = output[1:]
+		}
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	if ctx.Err() != nil {
+		return nil, "", errors.New("the browser did not start in time")
+	}
+
+	message := "the browser exited without a DevTools endpoint"
+	if last := strings.TrimSpace(strings.Join(output, "\n")); last != "" {
+		message += ": " + last
+	}
+	if strings.Contains(strings.ToLower(message), "sandbox") && !options.NoSandbox {
+		message += " (see ScreenshotOptions.NoSandbox)"
+	}
+
+	return nil, "", errors.New(message)
+}
+
+// devtools is a client of the Chrome DevTools protocol, see https://chromedevtools.github.io/devtools-protocol/.
+type devtools struct {
+	conn    *websocket.Conn
+	session string
+	id      int
+	events  map[string]bool
+}
+
+// devtoolsMessage is a response or an event of the DevTools protocol.
+type devtoolsMessage struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// screenshot opens a page in a new tab, waits for it to load and captures it.
+func (d *devtools) screenshot(pageURL string, options ScreenshotOptions) ([]byte, error) {
+	var target struct {
+		TargetID string `json:"targetId"`
+	}
+	if err := d.call("Target.createTarget", map[string]interface{}{"url": "about:blank"}, &target); err != nil {
+		return nil, err
+	}
+
+	var attached struct {
+		SessionID string `json:"sessionId"`
+	}
+	if err := d.call("Target.attachToTarget", map[string]interface{}{"targetId": target.TargetID, "flatten": true}, &attached); err != nil {
+		return nil, err
+	}
+	d.session = attached.SessionID
+
+	if err := d.call("Page.enable", nil, nil); err != nil {
+		return nil, err
+	}
+
+	viewport := map[string]interface{}{"width": options.Width, "height": options.Height, "deviceScaleFactor": 1, "mobile": false}
+	if err := d.call("Emulation.setDeviceMetricsOverride", viewport, nil); err != nil {
+		return nil, err
+	}
+
+	// The load of the blank page may have been seen already.
+	d.events = nil
+	if err := d.call("Page.navigate", map[string]interface{}{"url": pageURL}, nil); err != nil {
+		return nil, err
+	}
+	if err := d.wait("Page.loadEventFired"); err != nil {
+		return nil, err
+	}
+
+	params := map[string]interface{}{"format": string(options.Format)}
+	if options.FullPage {
+		var metrics struct {
+			CSSContentSize struct {
+				Width  float64 `json:"width"`
+				Height float64 `json:"height"`
+			} `json:"cssContentSize"`
+		}
+		if err := d.call("Page.getLayoutMetrics", nil, &metrics); err != nil {
+			return nil, err
+		}
+
+		params["captureBeyondViewport"] = true
+		params["clip"] = map[string]interface{}{
+			"x": 0, "y": 0, "scale": 1,
+			"width":  metrics.CSSContentSize.Width,
+			"height": metrics.CSSContentSize.Height,
+		}
+	}
+
+	var captured struct {
+		Data string `json:"data"`
+	}
+	if err := d.call("Page.captureScreenshot", params, &captured); err != nil {
+		return nil, err
+	}
+
+	d.session = ""
+	d.call("Browser.close", nil, nil)
+
+	return base64.StdEncoding.DecodeString(captured.Data)
+}
+
+// call sends a command to the browser, or to the attached page, and decodes its result. The events received in
+// the meantime are remembered for wait().
+func (d *devtools) call(method string, params interface{}, result interface{}) error {
+	d.id++
+	message := map[string]interface{}{"id": d.id, "method": method}
+	if params != nil {
+		message["params"] = params
+	}
+	if d.session != "" {
+		message["sessionId"] = d.session
+	}
+
+	if err := websocket.JSON.Send(d.conn, message); err != nil {
+		return err
+	}
+
+	for {
+		var response devtoolsMessage
+		if err := websocket.JSON.Receive(d.conn, &response); err != nil {
+			return err
+		}
+
+		if response.ID != d.id {
+			d.remember(response.Method)
+			continue
+		}
+
+		if response.Error != nil {
+			return fmt.Errorf("%s: %s", method, response.Error.Message)
+		}
+		if result != nil {
+			return json.Unmarshal(response.Result, result)
+		}
+		return nil
+	}
+}
+
+// wait waits for an event, unless it was already received.
+func (d *devtools) wait(event string) error {
+	for !d.events[event] {
+		var message devtoolsMessage
+		if err := websocket.JSON.Receive(d.conn, &message); err != nil {
+			return err
+		}
+		d.remember(message.Method)
+	}
+
+	return nil
+}
+
+func (d *devtools) remember(event string) {
+	if event == "" {
+		return
+	}
+	if d.events == nil {
+		d.events = make(map[string]bool)
+	}
+
+	d.events[event] = true
+}
@@ -0,0 +1,242 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.21.12
+// source: antidote.proto
+
+package antidotepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// AntidoteClient is the client API for Antidote service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AntidoteClient interface {
+	// Cure cures a URL, streaming the progress of its job, then its result once it has finished.
+	Cure(ctx context.Context, in *CureRequest, opts ...grpc.CallOption) (Antidote_CureClient, error)
+	// Analyze cures a URL and returns the origins its assets are pulled from: first-party, CDNs, other third
+	// parties and trackers.
+	Analyze(ctx context.Context, in *CureRequest, opts ...grpc.CallOption) (*AnalyzeResponse, error)
+	// BatchCure cures several URLs at once, streaming the progress of their jobs, and the result of every job as
+	// soon as it has finished.
+	BatchCure(ctx context.Context, in *BatchCureRequest, opts ...grpc.CallOption) (Antidote_BatchCureClient, error)
+}
+
+type antidoteClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAntidoteClient(cc grpc.ClientConnInterface) AntidoteClient {
+	return &antidoteClient{cc}
+}
+
+func (c *antidoteClient) Cure(ctx context.Context, in *CureRequest, opts ...grpc.CallOption) (Antidote_CureClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Antidote_ServiceDesc.Streams[0], "/antidote.v1.Antidote/Cure", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &antidoteCureClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Antidote_CureClient interface {
+	Recv() (*CureUpdate, error)
+	grpc.ClientStream
+}
+
+type antidoteCureClient struct {
+	grpc.ClientStream
+}
+
+func (x *antidoteCureClient) Recv() (*CureUpdate, error) {
+	m := new(CureUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *antidoteClient) Analyze(ctx context.Context, in *CureRequest, opts ...grpc.CallOption) (*AnalyzeResponse, error) {
+	out := new(AnalyzeResponse)
+	err := c.cc.Invoke(ctx, "/antidote.v1.Antidote/Analyze", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *antidoteClient) BatchCure(ctx context.Context, in *BatchCureRequest, opts ...grpc.CallOption) (Antidote_BatchCureClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Antidote_ServiceDesc.Streams[1], "/antidote.v1.Antidote/BatchCure", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &antidoteBatchCureClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Antidote_BatchCureClient interface {
+	Recv() (*CureUpdate, error)
+	grpc.ClientStream
+}
+
+type antidoteBatchCureClient struct {
+	grpc.ClientStream
+}
+
+func (x *antidoteBatchCureClient) Recv() (*CureUpdate, error) {
+	m := new(CureUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AntidoteServer is the server API for Antidote service.
+// All implementations must embed UnimplementedAntidoteServer
+// for forward compatibility
+type AntidoteServer interface {
+	// Cure cures a URL, streaming the progress of its job, then its result once it has finished.
+	Cure(*CureRequest, Antidote_CureServer) error
+	// Analyze cures a URL and returns the origins its assets are pulled from: first-party, CDNs, other third
+	// parties and trackers.
+	Analyze(context.Context, *CureRequest) (*AnalyzeResponse, error)
+	// BatchCure cures several URLs at once, streaming the progress of their jobs, and the result of every job as
+	// soon as it has finished.
+	BatchCure(*BatchCureRequest, Antidote_BatchCureServer) error
+	mustEmbedUnimplementedAntidoteServer()
+}
+
+// UnimplementedAntidoteServer must be embedded to have forward compatible implementations.
+type UnimplementedAntidoteServer struct {
+}
+
+func (UnimplementedAntidoteServer) Cure(*CureRequest, Antidote_CureServer) error {
+	return status.Errorf(codes.Unimplemented, "method Cure not implemented")
+}
+func (UnimplementedAntidoteServer) Analyze(context.Context, *CureRequest) (*AnalyzeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Analyze not implemented")
+}
+func (UnimplementedAntidoteServer) BatchCure(*BatchCureRequest, Antidote_BatchCureServer) error {
+	return status.Errorf(codes.Unimplemented, "method BatchCure not implemented")
+}
+func (UnimplementedAntidoteServer) mustEmbedUnimplementedAntidoteServer() {}
+
+// UnsafeAntidoteServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AntidoteServer will
+// result in compilation errors.
+type UnsafeAntidoteServer interface {
+	mustEmbedUnimplementedAntidoteServer()
+}
+
+func RegisterAntidoteServer(s grpc.ServiceRegistrar, srv AntidoteServer) {
+	s.RegisterService(&Antidote_ServiceDesc, srv)
+}
+
+func _Antidote_Cure_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(CureRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AntidoteServer).Cure(m, &antidoteCureServer{stream})
+}
+
+type Antidote_CureServer interface {
+	Send(*CureUpdate) error
+	grpc.ServerStream
+}
+
+type antidoteCureServer struct {
+	grpc.ServerStream
+}
+
+func (x *antidoteCureServer) Send(m *CureUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Antidote_Analyze_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CureRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AntidoteServer).Analyze(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/antidote.v1.Antidote/Analyze",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AntidoteServer).Analyze(ctx, req.(*CureRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Antidote_BatchCure_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BatchCureRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AntidoteServer).BatchCure(m, &antidoteBatchCureServer{stream})
+}
+
+type Antidote_BatchCureServer interface {
+	Send(*CureUpdate) error
+	grpc.ServerStream
+}
+
+type antidoteBatchCureServer struct {
+	grpc.ServerStream
+}
+
+func (x *antidoteBatchCureServer) Send(m *CureUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Antidote_ServiceDesc is the grpc.ServiceDesc for Antidote service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Antidote_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "antidote.v1.Antidote",
+	HandlerType: (*AntidoteServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Analyze",
+			Handler:    _Antidote_Analyze_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Cure",
+			Handler:       _Antidote_Cure_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "BatchCure",
+			Handler:       _Antidote_BatchCure_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "antidote.proto",
+}
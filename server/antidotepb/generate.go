@@ -0,0 +1,5 @@
+// Package antidotepb provides the Go stubs of the gRPC API of the antidote daemon, generated from antidote.proto.
+// The service is implemented by server.GRPCServer.
+package antidotepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative antidote.proto
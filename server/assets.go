@@ -0,0 +1,161 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lansana/antidote"
+)
+
+// sharedAssetThreshold is the size of the smallest data URL or inlined script or stylesheet kept as a shared asset,
+// see QueueOptions.ShareAssets. Smaller ones are not worth a blob of their own.
+const sharedAssetThreshold = 8 << 10
+
+// referenceGrace is how long the shared assets and the references written along with a job are kept without it,
+// as they are written before the job itself.
+const referenceGrace = time.Hour
+
+// Placeholders taking the place of a shared asset in the HTML of a stored snapshot, followed by the hex encoded
+// SHA-256 hash of its bytes, which are inserted as they are or base64 encoded.
+const (
+	sharedTextPrefix   = "antidote-asset:"
+	sharedBase64Prefix = "antidote-asset-base64:"
+)
+
+var (
+	dataURLPattern       = regexp.MustCompile(`data:[^,"'\s)]*;base64,([A-Za-z0-9+/]+={0,2})`)
+	inlinedScriptPattern = regexp.MustCompile(`(?is)<script\b[^>]*>(.*?)</script`)
+	inlinedStylePattern  = regexp.MustCompile(`(?is)<style\b[^>]*>(.*?)</style`)
+)
+
+// sharedAssetKey returns the key the bytes of a shared asset are kept under in the store, by their hash.
+func sharedAssetKey(tenant string, hash string) string {
+	return tenantPrefix(tenant) + "assets/" + hash
+}
+
+// referenceKey returns the key of the reference of a job to a shared asset, an empty blob counted by
+// CollectGarbage(), which deletes the asset once no job references it.
+func referenceKey(tenant string, hash string, id string) string {
+	return tenantPrefix(tenant) + "refs/" + hash + "/" + id
+}
+
+// shareAssets returns a copy of the snapshot of a job whose data URL's, inlined scripts and inlined stylesheets
+// are replaced with placeholders, along with the hashes of the shared assets they reference, which are written to
+// the store of the queue once for every job referencing them.
+func (q *Queue) shareAssets(job Job, snapshot *antidote.Snapshot) (*antidote.Snapshot, []string, error) {
+	assets := make(map[string][]byte)
+	share := func(prefix string, data []byte) string {
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		assets[hash] = data
+		return prefix + hash
+	}
+
+	// The data URL's are shared first, so that the same image or font is shared by the stylesheets embedding it.
+	html := replaceSubmatches(snapshot.HTML, dataURLPattern, func(encoded string) string {
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || base64.StdEncoding.EncodeToString(data) != encoded {
+			return encoded
+		}
+		return share(sharedBase64Prefix, data)
+	})
+	for _, pattern := range []*regexp.Regexp{inlinedScriptPattern, inlinedStylePattern} {
+		html = replaceSubmatches(html, pattern, func(text string) string {
+			return share(sharedTextPrefix, []byte(text))
+		})
+	}
+
+	if len(assets) == 0 {
+		return snapshot, nil, nil
+	}
+
+	var hashes []string
+	for hash := range assets {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	// The assets are written before their references, and both before the job, so that a job stored never
+	// references a missing asset.
+	for _, hash := range hashes {
+		if err := q.options.Store.Put(sharedAssetKey(job.Tenant, hash), assets[hash]); err != nil {
+			return nil, nil, err
+		}
+		if err := q.options.Store.Put(referenceKey(job.Tenant, hash, job.ID), nil); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	shared := *snapshot
+	shared.HTML = html
+	return &shared, hashes, nil
+}
+
+// replaceSubmatches replaces the first submatch of every match of a pattern at least sharedAssetThreshold long
+// with the result of fn.
+func replaceSubmatches(s string, pattern *regexp.Regexp, fn func(string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, match := range pattern.FindAllStringSubmatchIndex(s, -1) {
+		start, end := match[2], match[3]
+		if end-start < sharedAssetThreshold {
+			continue
+		}
+
+		b.WriteString(s[last:start])
+		b.WriteString(fn(s[start:end]))
+		last = end
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+
+	return b.String()
+}
+
+// restoreAssets replaces the placeholders of the shared assets in the HTML of a stored snapshot with their bytes,
+// read from a store under the prefix of its tenant. Only the placeholders of the hashes the job references are
+// replaced, so that a page containing the text of one is left as it is.
+func restoreAssets(store antidote.Store, prefix string, html string, hashes []string) (string, error) {
+	assets := make(map[string][]byte)
+	for _, hash := range hashes {
+		data, err := store.Get(prefix + "assets/" + hash)
+		if err != nil {
+			return "", fmt.Errorf("reading the shared asset %s: %v", hash, err)
+		}
+		assets[hash] = data
+	}
+
+	// The scripts and stylesheets are restored first, as they may contain the placeholders of data URL's.
+	html = restorePlaceholders(html, sharedTextPrefix, func(data []byte) string { return string(data) }, assets)
+	return restorePlaceholders(html, sharedBase64Prefix, base64.StdEncoding.EncodeToString, assets), nil
+}
+
+// restorePlaceholders replaces the placeholders starting with a prefix with the encoding of the bytes of their
+// asset.
+func restorePlaceholders(html string, prefix string, encode func([]byte) string, assets map[string][]byte) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(html, prefix)
+		end := i + len(prefix) + sha256.Size*2
+		if i < 0 || end > len(html) {
+			b.WriteString(html)
+			return b.String()
+		}
+
+		b.WriteString(html[:i])
+		if data, ok := assets[html[i+len(prefix):end]]; ok {
+			b.WriteString(encode(data))
+		} else {
+			b.WriteString(html[i:end])
+		}
+		html = html[end:]
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lansana/antidote"
+)
+
+// AuditRecord object represents a cure request in the audit log, see QueueOptions.AuditLog. Requests rejected
+// before a job was queued, e.g. by the rate limit of a tenant, are recorded as failed, without a job ID.
+type AuditRecord struct {
+	// Time is when the request was made.
+	Time time.Time `json:"time"`
+
+	// JobID is the ID of the job of the request.
+	JobID string `json:"jobId,omitempty"`
+
+	// Tenant and RemoteAddr identify the requester: the tenant whose key authenticated the request, and the
+	// address the request was made from. Jobs submitted through the library have neither.
+	Tenant     string `json:"tenant,omitempty"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+
+	URL     string     `json:"url"`
+	Options JobOptions `json:"options"`
+
+	// Saved is set when the page was posted by a browser extension, see Server.SaveToken.
+	Saved bool `json:"saved,omitempty"`
+
+	// Status is either JobDone or JobFailed, in which case Error is its error.
+	Status JobStatus `json:"status"`
+	Error  string    `json:"error,omitempty"`
+
+	// Duration is the time from the submission of the job until it finished, in nanoseconds when marshaled.
+	Duration time.Duration `json:"duration"`
+
+	// Size is the size in bytes of the snapshot, its extracted files included.
+	Size int64 `json:"size"`
+
+	// Assets is the number of assets of the snapshot, and FailedAssets the number of those that could not be
+	// cured.
+	Assets       int `json:"assets"`
+	FailedAssets int `json:"failedAssets,omitempty"`
+
+	// VisualChange is how the page compares with its previous screenshot, see QueueOptions.VisualDiff. Records
+	// with a changed page can be alerted on, e.g. by the collector of HTTPAuditSink.
+	VisualChange *VisualChange `json:"visualChange,omitempty"`
+}
+
+// AuditSink receives the records of the audit log. FileAuditSink, SyslogAuditSink (except on Windows) and
+// HTTPAuditSink are provided; implement it to send the records anywhere else. It may be called concurrently
+// from multiple goroutines.
+type AuditSink interface {
+	Write(record *AuditRecord) error
+}
+
+// FileAuditSink is an AuditSink appending the records to a file, one JSON object per line.
+type FileAuditSink struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+// NewFileAuditSink creates a new instance of a FileAuditSink pointer appending to the file at path, which is
+// created if it does not exist.
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileAuditSink{file: file}, nil
+}
+
+// Write appends a record to the file.
+func (s *FileAuditSink) Write(record *AuditRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.file.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the file.
+func (s *FileAuditSink) Close() error {
+	return s.file.Close()
+}
+
+// HTTPAuditSink is an AuditSink posting every record as JSON to a URL, e.g. the HTTP event collector of a SIEM.
+type HTTPAuditSink struct {
+	// URL is where the records are posted.
+	URL string
+
+	// Headers are added to every request, e.g. an Authorization header.
+	Headers map[string]string
+
+	// Client makes the requests. Defaults to a client with a timeout of ten seconds.
+	Client *http.Client
+}
+
+// Write posts a record to the URL.
+func (s *HTTPAuditSink) Write(record *AuditRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("audit log %s: unexpected status %s", s.URL, resp.Status)
+	}
+
+	return nil
+}
+
+// snapshotSize returns the size in bytes of a snapshot, its extracted files included.
+func snapshotSize(snapshot *antidote.Snapshot) int64 {
+	size := int64(len(snapshot.HTML))
+	for _, file := range snapshot.Files {
+		size += int64(len(file))
+	}
+
+	return size
+}
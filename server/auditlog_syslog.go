@@ -0,0 +1,41 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package server
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// SyslogAuditSink is an AuditSink sending every record as JSON to the system logger, with the info severity of
+// the auth facility.
+type SyslogAuditSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogAuditSink creates a new instance of a SyslogAuditSink pointer logging with a tag, e.g. "antidote".
+// An empty network and address log to the local syslog server, otherwise see syslog.Dial().
+func NewSyslogAuditSink(network, address, tag string) (*SyslogAuditSink, error) {
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SyslogAuditSink{writer: writer}, nil
+}
+
+// Write sends a record to the system logger.
+func (s *SyslogAuditSink) Write(record *AuditRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.writer.Info(string(b))
+}
+
+// Close closes the connection to the system logger.
+func (s *SyslogAuditSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,435 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lansana/antidote"
+)
+
+// ErrNoTask is returned by Broker.Pull() when no task became available in time.
+var ErrNoTask = errors.New("no task is available")
+
+// Task object represents a job handed to the workers of a cluster.
+type Task struct {
+	JobID   string     `json:"jobId"`
+	URL     string     `json:"url"`
+	Page    *Page      `json:"page,omitempty"`
+	Options JobOptions `json:"options"`
+
+	// Config overrides the default ingredients of the worker for the tenant of the job, see Tenant.Config.
+	Config *TenantConfig `json:"config,omitempty"`
+}
+
+// TaskResult object represents the outcome of a task, reported by the worker that ran it.
+type TaskResult struct {
+	JobID    string             `json:"jobId"`
+	Worker   string             `json:"worker"`
+	Snapshot *antidote.Snapshot `json:"snapshot,omitempty"`
+	Error    string             `json:"error,omitempty"`
+}
+
+// Broker is the shared queue of a cluster of daemons. The coordinator pushes the tasks of its jobs, the
+// workers pull and run them, then report their results, which the coordinator collects. MemoryBroker is the
+// queue of a single coordinator and HTTPBroker gives workers access to it over its HTTP API. Implementations
+// backed by Redis, NATS or any other message queue plug in the same way.
+type Broker interface {
+	// Push adds a task to the queue.
+	Push(task Task) error
+
+	// Pull removes the next task from the queue, waiting until one is available or ctx is done.
+	Pull(ctx context.Context) (Task, error)
+
+	// Report sends the result of a task to the coordinator.
+	Report(result TaskResult) error
+
+	// Results returns the channel the results are delivered to the coordinator on.
+	Results() <-chan TaskResult
+}
+
+// MemoryBroker is a Broker held in the memory of the coordinator. A task that is not reported within its
+// lease, e.g. because its worker died, is pushed back to the queue for another worker to run. It is safe
+// for concurrent use.
+type MemoryBroker struct {
+	lease   time.Duration
+	tasks   []Task
+	leased  map[string]*time.Timer
+	ready   chan struct{}
+	results chan TaskResult
+	mu      sync.Mutex
+}
+
+// NewMemoryBroker creates a new instance of a MemoryBroker pointer whose tasks must be reported within the
+// lease. Defaults to ten minutes.
+func NewMemoryBroker(lease time.Duration) *MemoryBroker {
+	if lease <= 0 {
+		lease = 10 * time.Minute
+	}
+
+	return &MemoryBroker{
+		lease:   lease,
+		leased:  make(map[string]*time.Timer),
+		ready:   make(chan struct{}, 1),
+		results: make(chan TaskResult, 64),
+	}
+}
+
+// Push adds a task to the queue.
+func (b *MemoryBroker) Push(task Task) error {
+	b.mu.Lock()
+	b.tasks = append(b.tasks, task)
+	b.mu.Unlock()
+
+	b.signal()
+	return nil
+}
+
+// Pull removes the next task from the queue and leases it, waiting until one is available or ctx is done.
+func (b *MemoryBroker) Pull(ctx context.Context) (Task, error) {
+	for {
+		b.mu.Lock()
+		if len(b.tasks) > 0 {
+			task := b.tasks[0]
+			b.tasks = b.tasks[1:]
+			b.leased[task.JobID] = time.AfterFunc(b.lease, func() { b.expire(task) })
+			remaining := len(b.tasks)
+			b.mu.Unlock()
+
+			// Other pullers may be waiting for the tasks left.
+			if remaining > 0 {
+				b.signal()
+			}
+			return task, nil
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-b.ready:
+		case <-ctx.Done():
+			return Task{}, ErrNoTask
+		}
+	}
+}
+
+// Report ends the lease of a task and delivers its result to the coordinator.
+func (b *MemoryBroker) Report(result TaskResult) error {
+	b.mu.Lock()
+	if timer, ok := b.leased[result.JobID]; ok {
+		timer.Stop()
+		delete(b.leased, result.JobID)
+	}
+	b.mu.Unlock()
+
+	b.results <- result
+	return nil
+}
+
+// Results returns the channel the results are delivered to the coordinator on.
+func (b *MemoryBroker) Results() <-chan TaskResult {
+	return b.results
+}
+
+// expire pushes a task back to the queue once its lease has run out.
+func (b *MemoryBroker) expire(task Task) {
+	b.mu.Lock()
+	if _, ok := b.leased[task.JobID]; !ok {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.leased, task.JobID)
+	b.mu.Unlock()
+
+	log.Printf("the lease of job %s has expired, pushing it back to the queue", task.JobID)
+	b.Push(task)
+}
+
+// signal wakes up one of the pullers waiting for a task.
+func (b *MemoryBroker) signal() {
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+}
+
+// HTTPBroker is the Broker of a worker pulling its tasks from a coordinator daemon, through the /cluster
+// endpoints of its HTTP API. Only the coordinator pushes tasks and collects results.
+type HTTPBroker struct {
+	// Coordinator is the base URL of the coordinator, e.g. http://coordinator:8080.
+	Coordinator string
+
+	// Secret is the Server.ClusterSecret of the coordinator.
+	Secret string
+
+	// Client makes the requests to the coordinator. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Push is not supported by workers.
+func (b *HTTPBroker) Push(task Task) error {
+	return errors.New("tasks can only be pushed by the coordinator")
+}
+
+// Pull asks the coordinator for the next task, which waits for one for a while before responding.
+func (b *HTTPBroker) Pull(ctx context.Context) (Task, error) {
+	req, err := http.NewRequest(http.MethodGet, b.endpoint("tasks"), nil)
+	if err != nil {
+		return Task{}, err
+	}
+
+	resp, err := b.do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return Task{}, ErrNoTask
+		}
+		return Task{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var task Task
+		err := json.NewDecoder(resp.Body).Decode(&task)
+		return task, err
+	case http.StatusNoContent:
+		return Task{}, ErrNoTask
+	default:
+		return Task{}, fmt.Errorf("the coordinator responded to a pull with %s", resp.Status)
+	}
+}
+
+// Report sends the result of a task to the coordinator.
+func (b *HTTPBroker) Report(result TaskResult) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, b.endpoint("results"), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("the coordinator responded to a report with %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Results returns nil, as workers do not collect results.
+func (b *HTTPBroker) Results() <-chan TaskResult {
+	return nil
+}
+
+func (b *HTTPBroker) endpoint(name string) string {
+	return strings.TrimSuffix(b.Coordinator, "/") + "/cluster/" + name
+}
+
+// do sends a request to the coordinator, authenticated with the secret of the cluster.
+func (b *HTTPBroker) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Authorization", "Bearer "+b.Secret)
+
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return client.Do(req)
+}
+
+// HTTPCache is an antidote.Cache stored by a coordinator daemon, through the /cluster/cache endpoints of its
+// HTTP API, so that the workers of a cluster share the assets they fetch. Entries that can not be read or
+// written are treated as missing.
+type HTTPCache struct {
+	// Coordinator is the base URL of the coordinator, e.g. http://coordinator:8080.
+	Coordinator string
+
+	// Secret is the Server.ClusterSecret of the coordinator.
+	Secret string
+
+	// Client makes the requests to the coordinator. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Get returns the bytes stored under a key.
+func (c *HTTPCache) Get(key string) ([]byte, bool) {
+	req, err := http.NewRequest(http.MethodGet, c.endpoint(key), nil)
+	if err != nil {
+		return nil, false
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, false
+	}
+
+	value, err := ioutil.ReadAll(resp.Body)
+	return value, err == nil
+}
+
+// Set stores bytes under a key.
+func (c *HTTPCache) Set(key string, value []byte) {
+	req, err := http.NewRequest(http.MethodPut, c.endpoint(key), bytes.NewReader(value))
+	if err != nil {
+		return
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
+
+func (c *HTTPCache) endpoint(key string) string {
+	return strings.TrimSuffix(c.Coordinator, "/") + "/cluster/cache/" + url.PathEscape(key)
+}
+
+// do sends a request to the coordinator, authenticated with the secret of the cluster.
+func (c *HTTPCache) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Authorization", "Bearer "+c.Secret)
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return client.Do(req)
+}
+
+// WorkerOptions object represents options for a Worker.
+type WorkerOptions struct {
+	// Name identifies the worker in the jobs it ran. Defaults to the hostname and the process ID.
+	Name string
+
+	// Concurrency is the number of tasks run at the same time. Defaults to 4.
+	Concurrency int
+
+	// Defaults are the ingredients every task is cured with. The URL and the job options are set per task.
+	Defaults antidote.Ingredients
+}
+
+// Worker runs the tasks of a cluster, pulled from a broker, and reports their results.
+type Worker struct {
+	broker  Broker
+	options WorkerOptions
+}
+
+// NewWorker creates a new instance of a Worker pointer that pulls its tasks from the broker.
+func NewWorker(broker Broker, options WorkerOptions) *Worker {
+	if options.Concurrency <= 0 {
+		options.Concurrency = 4
+	}
+	if options.Name == "" {
+		hostname, _ := os.Hostname()
+		options.Name = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	return &Worker{broker: broker, options: options}
+}
+
+// Run pulls and runs tasks until ctx is done, then waits for the tasks in progress to be reported.
+func (w *Worker) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(w.options.Concurrency)
+	for i := 0; i < w.options.Concurrency; i++ {
+		go (func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				task, err := w.broker.Pull(ctx)
+				if err == ErrNoTask {
+					continue
+				}
+				if err != nil {
+					log.Println(err)
+					// Let the coordinator come back before pulling again.
+					select {
+					case <-time.After(5 * time.Second):
+					case <-ctx.Done():
+					}
+					continue
+				}
+
+				if err := w.broker.Report(w.run(task)); err != nil {
+					log.Println(err)
+				}
+			}
+		})()
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+// run cures the URL of a task. The snapshot is expanded, as the files of its spilled assets stay on the worker.
+func (w *Worker) run(task Task) TaskResult {
+	result := TaskResult{JobID: task.JobID, Worker: w.options.Name}
+
+	defaults := w.options.Defaults
+	if task.Config != nil {
+		task.Config.apply(&defaults)
+	}
+
+	snapshot, err := cure(defaults, task.URL, task.Page, task.Options, nil)
+	if err == nil {
+		if err = snapshot.Expand(); err != nil {
+			snapshot.Close()
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Snapshot = snapshot
+	return result
+}
+
+// cure cures a URL, or a page captured by a browser if it is not nil, with the default ingredients and the
+// options of a job.
+func cure(defaults antidote.Ingredients, url string, page *Page, options JobOptions, onAsset func(asset *antidote.Asset)) (*antidote.Snapshot, error) {
+	ingredients := defaults
+	ingredients.URL = url
+	if page != nil {
+		jar, err := page.jar()
+		if err != nil {
+			return nil, err
+		}
+		ingredients.Source = page.HTML
+		ingredients.Jar = jar
+	}
+	ingredients.StripJS = options.StripJS
+	ingredients.SkipImages = options.SkipImages
+	ingredients.Selector = options.Selector
+	ingredients.OnAsset = onAsset
+
+	a := antidote.New()
+	a.Mix(&ingredients)
+
+	return a.CureToSnapshot()
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/lansana/antidote"
+)
+
+// deltaBlockSize is the length of the blocks of a base matched in the target of a delta. Shorter blocks find more
+// matches, at the cost of a larger index and of more copies.
+const deltaBlockSize = 32
+
+// deltaPrime is the multiplier of the rolling hash of the blocks.
+const deltaPrime = 16777619
+
+// Operations of a delta, see diff().
+const (
+	deltaCopy   = 'c'
+	deltaInsert = 'i'
+)
+
+// errCorruptDelta is returned by patch() for a delta that does not apply to its base.
+var errCorruptDelta = errors.New("corrupt delta")
+
+// snapshotDelta is the snapshot of a stored job kept as a delta against the snapshot of a previous version of its
+// URL, see QueueOptions.StoreDeltas.
+type snapshotDelta struct {
+	// Base is the ID of the job the delta applies to.
+	Base string `json:"base"`
+
+	// Depth is the number of deltas applied to the last full snapshot of the URL, this one included.
+	Depth int `json:"depth"`
+
+	// Patch turns the JSON of the snapshot of Base into the JSON of this one, see diff().
+	Patch []byte `json:"patch"`
+}
+
+// latestKey returns the key of the ID of the job of the latest version of a URL, which the snapshot of the next
+// one is a delta against.
+func latestKey(tenant string, url string) string {
+	return tenantPrefix(tenant) + "latest/" + urlHash(url)
+}
+
+// delta returns the snapshot of a job as a delta against the latest version of its URL, or nil if it is kept in
+// full: the URL has no previous version that can be read, QueueOptions.StoreDeltas deltas were kept in a row, or
+// the delta is not smaller than the snapshot.
+func (q *Queue) delta(job Job, snapshot []byte) *snapshotDelta {
+	latest, err := q.options.Store.Get(latestKey(job.Tenant, job.URL))
+	if err != nil {
+		return nil
+	}
+
+	prefix := tenantPrefix(job.Tenant)
+	base, err := loadJob(q.options.Store, prefix, string(latest))
+	if err != nil || base.Job.URL != job.URL {
+		return nil
+	}
+
+	depth := 1
+	if base.Delta != nil {
+		depth = base.Delta.Depth + 1
+	}
+	if depth > q.options.StoreDeltas {
+		return nil
+	}
+
+	baseSnapshot, err := expandSnapshot(q.options.Store, prefix, base)
+	if err != nil || len(baseSnapshot) == 0 {
+		return nil
+	}
+
+	changes := diff(baseSnapshot, snapshot)
+	if len(changes) >= len(snapshot) {
+		return nil
+	}
+
+	return &snapshotDelta{Base: base.Job.ID, Depth: depth, Patch: changes}
+}
+
+// expandSnapshot returns the JSON of the snapshot of a stored job, applying its delta to the snapshots of the
+// versions it is based on, stored under the same prefix.
+func expandSnapshot(store antidote.Store, prefix string, stored storedJob) ([]byte, error) {
+	var deltas []*snapshotDelta
+	for stored.Delta != nil {
+		// Every base is one delta closer to a full snapshot, so that a corrupt chain can not loop.
+		if len(deltas) > 0 && stored.Delta.Depth >= deltas[len(deltas)-1].Depth {
+			return nil, fmt.Errorf("job %s: %v", stored.Job.ID, errCorruptDelta)
+		}
+		deltas = append(deltas, stored.Delta)
+
+		id, base := stored.Job.ID, stored.Delta.Base
+		var err error
+		if stored, err = loadJob(store, prefix, base); err != nil {
+			return nil, fmt.Errorf("job %s: reading the version it is a delta of: %v", id, err)
+		}
+	}
+
+	snapshot := []byte(stored.Snapshot)
+	for i := len(deltas) - 1; i >= 0; i-- {
+		var err error
+		if snapshot, err = patch(snapshot, deltas[i].Patch); err != nil {
+			return nil, err
+		}
+	}
+
+	return snapshot, nil
+}
+
+// diff returns a delta turning base into target, e.g. two versions of the same snapshot: the length and the
+// CRC-32 of target, then the copies of ranges of base and the insertions of new bytes that make it up. Blocks of
+// target found in base are copied, and extended byte by byte to the whole range they match, so the delta of
+// similar versions is a fraction of their size, whether they are text or binary.
+func diff(base []byte, target []byte) []byte {
+	delta := appendUvarint(nil, uint64(len(target)))
+	var checksum [4]byte
+	binary.BigEndian.PutUint32(checksum[:], crc32.ChecksumIEEE(target))
+	delta = append(delta, checksum[:]...)
+
+	// The blocks of base are indexed by their hash, the first one of a hash only.
+	index := make(map[uint32]int)
+	for offset := 0; offset+deltaBlockSize <= len(base); offset += deltaBlockSize {
+		hash := blockHash(base[offset : offset+deltaBlockSize])
+		if _, ok := index[hash]; !ok {
+			index[hash] = offset
+		}
+	}
+
+	// power is deltaPrime^(deltaBlockSize-1), the weight of the byte leaving the rolling hash.
+	power := uint32(1)
+	for i := 1; i < deltaBlockSize; i++ {
+		power *= deltaPrime
+	}
+
+	inserted := 0
+	i := 0
+	var hash uint32
+	if len(target) >= deltaBlockSize {
+		hash = blockHash(target[:deltaBlockSize])
+	}
+	for i+deltaBlockSize <= len(target) {
+		offset, ok := index[hash]
+		if !ok || !bytes.Equal(base[offset:offset+deltaBlockSize], target[i:i+deltaBlockSize]) {
+			if i+deltaBlockSize < len(target) {
+				hash = (hash-uint32(target[i])*power)*deltaPrime + uint32(target[i+deltaBlockSize])
+			}
+			i++
+			continue
+		}
+
+		start, end := i, i+deltaBlockSize
+		for start > inserted && offset > 0 && target[start-1] == base[offset-1] {
+			start--
+			offset--
+		}
+		for end < len(target) && offset+end-start < len(base) && target[end] == base[offset+end-start] {
+			end++
+		}
+
+		delta = appendInsert(delta, target[inserted:start])
+		delta = append(delta, deltaCopy)
+		delta = appendUvarint(delta, uint64(offset))
+		delta = appendUvarint(delta, uint64(end-start))
+
+		inserted, i = end, end
+		if i+deltaBlockSize <= len(target) {
+			hash = blockHash(target[i : i+deltaBlockSize])
+		}
+	}
+
+	return appendInsert(delta, target[inserted:])
+}
+
+// appendInsert appends the insertion of bytes to a delta, unless there are none.
+func appendInsert(delta []byte, inserted []byte) []byte {
+	if len(inserted) == 0 {
+		return delta
+	}
+
+	delta = append(delta, deltaInsert)
+	delta = appendUvarint(delta, uint64(len(inserted)))
+	return append(delta, inserted...)
+}
+
+// appendUvarint appends the varint encoding of an unsigned integer.
+func appendUvarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], x)]...)
+}
+
+// blockHash returns the rolling hash of a block.
+func blockHash(block []byte) uint32 {
+	var hash uint32
+	for _, b := range block {
+		hash = hash*deltaPrime + uint32(b)
+	}
+
+	return hash
+}
+
+// patch applies a delta returned by diff() to its base, and returns the target.
+func patch(base []byte, delta []byte) ([]byte, error) {
+	length, n := binary.Uvarint(delta)
+	if n <= 0 || len(delta) < n+4 {
+		return nil, errCorruptDelta
+	}
+	checksum := binary.BigEndian.Uint32(delta[n:])
+	delta = delta[n+4:]
+
+	target := make([]byte, 0, len(base))
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		switch op {
+		case deltaCopy:
+			offset, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, errCorruptDelta
+			}
+			delta = delta[n:]
+			size, n := binary.Uvarint(delta)
+			if n <= 0 || offset > uint64(len(base)) || size > uint64(len(base))-offset {
+				return nil, errCorruptDelta
+			}
+			delta = delta[n:]
+			target = append(target, base[offset:offset+size]...)
+		case deltaInsert:
+			size, n := binary.Uvarint(delta)
+			if n <= 0 || size > uint64(len(delta)-n) {
+				return nil, errCorruptDelta
+			}
+			target = append(target, delta[n:n+int(size)]...)
+			delta = delta[n+int(size):]
+		default:
+			return nil, errCorruptDelta
+		}
+	}
+
+	if uint64(len(target)) != length || crc32.ChecksumIEEE(target) != checksum {
+		return nil, errCorruptDelta
+	}
+
+	return target, nil
+}
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/lansana/antidote"
+	"github.com/lansana/antidote/server/antidotepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCServer object provides the gRPC API of the daemon, defined in antidotepb/antidote.proto, on top of the
+// queue of its HTTP API: the jobs it submits are listed by GET /jobs like any other, within the limits of their
+// tenant. Register it on a grpc.Server with antidotepb.RegisterAntidoteServer().
+type GRPCServer struct {
+	antidotepb.UnimplementedAntidoteServer
+
+	queue *Queue
+}
+
+// NewGRPCServer creates a new instance of a GRPCServer pointer that runs its jobs on the given queue.
+func NewGRPCServer(queue *Queue) *GRPCServer {
+	return &GRPCServer{queue: queue}
+}
+
+// Cure cures a URL, streaming the progress of its job, then its result once it has finished.
+func (s *GRPCServer) Cure(req *antidotepb.CureRequest, stream antidotepb.Antidote_CureServer) error {
+	tenant, err := s.tenant(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	job, err := s.submit(stream.Context(), tenant, req.GetUrl(), req.GetOptions())
+	if err != nil {
+		return err
+	}
+
+	return s.stream(stream.Context(), tenant, []string{job.ID}, stream.Send)
+}
+
+// Analyze cures a URL and returns the origins its assets are pulled from, see antidote.Snapshot.Audit().
+func (s *GRPCServer) Analyze(ctx context.Context, req *antidotepb.CureRequest) (*antidotepb.AnalyzeResponse, error) {
+	tenant, err := s.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := s.submit(ctx, tenant, req.GetUrl(), req.GetOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *antidotepb.AnalyzeResponse
+	ok := s.wait(ctx, tenant, job.ID, nil, func(job Job, snapshot *antidote.Snapshot) {
+		resp = analyzeResponse(job, snapshot)
+	})
+	if !ok {
+		return nil, waitError(ctx)
+	}
+
+	return resp, nil
+}
+
+// analyzeResponse returns the origins of the assets of a finished job.
+func analyzeResponse(job Job, snapshot *antidote.Snapshot) *antidotepb.AnalyzeResponse {
+	resp := &antidotepb.AnalyzeResponse{Job: jobMessage(job)}
+	if snapshot == nil {
+		return resp
+	}
+
+	for _, origin := range snapshot.Audit(antidote.AuditOptions{}).Origins {
+		message := &antidotepb.Origin{
+			Host:   origin.Host,
+			Origin: string(origin.Origin),
+			List:   origin.List,
+			Assets: int32(origin.Assets),
+			Bytes:  int64(origin.Bytes),
+			Pruned: int32(origin.Pruned),
+			Urls:   origin.URLs,
+		}
+		for _, kind := range origin.Kinds {
+			message.Kinds = append(message.Kinds, string(kind))
+		}
+		resp.Origins = append(resp.Origins, message)
+	}
+
+	return resp
+}
+
+// BatchCure cures several URLs at once, streaming the progress of their jobs, and the result of every job as
+// soon as it has finished. No job is streamed if one of the URLs can not be submitted.
+func (s *GRPCServer) BatchCure(req *antidotepb.BatchCureRequest, stream antidotepb.Antidote_BatchCureServer) error {
+	tenant, err := s.tenant(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	if len(req.GetUrls()) == 0 {
+		return status.Error(codes.InvalidArgument, "urls are required")
+	}
+
+	var ids []string
+	for _, url := range req.GetUrls() {
+		job, err := s.submit(stream.Context(), tenant, url, req.GetOptions())
+		if err != nil {
+			return err
+		}
+		ids = append(ids, job.ID)
+	}
+
+	return s.stream(stream.Context(), tenant, ids, stream.Send)
+}
+
+// tenant authenticates a call against QueueOptions.Tenants with the bearer token of its authorization metadata.
+// It returns a nil tenant when the daemon has none.
+func (s *GRPCServer) tenant(ctx context.Context) (*Tenant, error) {
+	tenants := s.queue.tenants()
+	if len(tenants) == 0 {
+		return nil, nil
+	}
+
+	var key string
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimPrefix(auth, "Bearer ")
+		}
+	}
+
+	tenant := matchTenant(tenants, key)
+	if tenant == nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid or missing API key")
+	}
+
+	return tenant, nil
+}
+
+// submit adds a cure of a URL to the queue on behalf of a tenant, with the address of the peer of the call.
+func (s *GRPCServer) submit(ctx context.Context, tenant *Tenant, url string, options *antidotepb.JobOptions) (Job, error) {
+	if url == "" {
+		return Job{}, status.Error(codes.InvalidArgument, "url is required")
+	}
+
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+	}
+
+	job, err := s.queue.submit(tenant, remoteAddr, url, nil, JobOptions{
+		StripJS:    options.GetStripJs(),
+		SkipImages: options.GetSkipImages(),
+		Selector:   options.GetSelector(),
+	})
+	switch err {
+	case nil:
+		return job, nil
+	case ErrQueueFull, ErrQueueClosed:
+		return Job{}, status.Error(codes.Unavailable, err.Error())
+	case ErrRateLimited, ErrQuotaExceeded:
+		return Job{}, status.Error(codes.ResourceExhausted, err.Error())
+	case ErrHostNotAllowed:
+		return Job{}, status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return Job{}, status.Error(codes.Internal, err.Error())
+	}
+}
+
+// stream sends the progress events of jobs of a tenant, and the result of every job once it has finished, until
+// all of them have.
+func (s *GRPCServer) stream(ctx context.Context, tenant *Tenant, ids []string, send func(*antidotepb.CureUpdate) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The updates of every job are sent from this goroutine, as a stream is not safe for concurrent sends.
+	updates := make(chan *antidotepb.CureUpdate)
+	push := func(update *antidotepb.CureUpdate) {
+		select {
+		case updates <- update:
+		case <-ctx.Done():
+		}
+	}
+
+	for _, id := range ids {
+		go (func(id string) {
+			var result *antidotepb.Result
+			ok := s.wait(ctx, tenant, id, func(event Event) {
+				push(&antidotepb.CureUpdate{Update: &antidotepb.CureUpdate_Event{Event: eventMessage(event)}})
+			}, func(job Job, snapshot *antidote.Snapshot) {
+				var err error
+				if result, err = resultMessage(job, snapshot); err != nil {
+					result = &antidotepb.Result{Job: jobMessage(job)}
+					result.Job.Status, result.Job.Error = antidotepb.JobStatus_JOB_STATUS_FAILED, err.Error()
+				}
+			})
+			if !ok && ctx.Err() != nil {
+				return
+			}
+			if !ok {
+				job := Job{ID: id, Status: JobFailed, Error: "the job is no longer held by the queue"}
+				result = &antidotepb.Result{Job: jobMessage(job)}
+			}
+
+			push(&antidotepb.CureUpdate{Update: &antidotepb.CureUpdate_Result{Result: result}})
+		})(id)
+	}
+
+	for finished := 0; finished < len(ids); {
+		select {
+		case <-ctx.Done():
+			return waitError(ctx)
+		case update := <-updates:
+			if err := send(update); err != nil {
+				return err
+			}
+			if update.GetResult() != nil {
+				finished++
+			}
+		}
+	}
+
+	return nil
+}
+
+// wait calls onEvent, if set, with the progress events of a job of a tenant until it has finished, then calls fn
+// with its final state along with its snapshot. It reports false if the job is not found, or once ctx is done.
+func (s *GRPCServer) wait(ctx context.Context, tenant *Tenant, id string, onEvent func(event Event), fn func(job Job, snapshot *antidote.Snapshot)) bool {
+	// A job that has already left the queue is read from QueueOptions.Store.
+	if events, unsubscribe, ok := s.queue.Subscribe(id); ok {
+		defer unsubscribe()
+
+		for finished := false; !finished; {
+			select {
+			case <-ctx.Done():
+				return false
+			case event, ok := <-events:
+				finished = !ok
+				if ok && onEvent != nil {
+					onEvent(event)
+				}
+			}
+		}
+	}
+
+	return s.queue.view(tenantName(tenant), id, fn)
+}
+
+// waitError returns the status of a call that stopped waiting for its jobs.
+func waitError(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+
+	return status.Error(codes.NotFound, "job not found")
+}
+
+var jobStatuses = map[JobStatus]antidotepb.JobStatus{
+	JobQueued:  antidotepb.JobStatus_JOB_STATUS_QUEUED,
+	JobRunning: antidotepb.JobStatus_JOB_STATUS_RUNNING,
+	JobDone:    antidotepb.JobStatus_JOB_STATUS_DONE,
+	JobFailed:  antidotepb.JobStatus_JOB_STATUS_FAILED,
+}
+
+var eventTypes = map[EventType]antidotepb.EventType{
+	EventAsset:      antidotepb.EventType_EVENT_TYPE_ASSET,
+	EventStatus:     antidotepb.EventType_EVENT_TYPE_STATUS,
+	EventDiscovered: antidotepb.EventType_EVENT_TYPE_DISCOVERED,
+	EventWarning:    antidotepb.EventType_EVENT_TYPE_WARNING,
+}
+
+// jobMessage returns the message of a job.
+func jobMessage(job Job) *antidotepb.Job {
+	return &antidotepb.Job{
+		Id:  job.ID,
+		Url: job.URL,
+		Options: &antidotepb.JobOptions{
+			StripJs:    job.Options.StripJS,
+			SkipImages: job.Options.SkipImages,
+			Selector:   job.Options.Selector,
+		},
+		Status:      jobStatuses[job.Status],
+		Error:       job.Error,
+		AssetsCured: int32(job.AssetsCured),
+		BytesCured:  job.BytesCured,
+		AssetsTotal: int32(job.AssetsTotal),
+		BytesTotal:  job.BytesTotal,
+		Warnings:    int32(job.Warnings),
+		Worker:      job.Worker,
+		SubmittedAt: timestamppb.New(job.SubmittedAt),
+		StartedAt:   timestampMessage(job.StartedAt),
+		FinishedAt:  timestampMessage(job.FinishedAt),
+	}
+}
+
+// timestampMessage returns the message of an optional time, which is nil if it is not set.
+func timestampMessage(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+
+	return timestamppb.New(*t)
+}
+
+// assetMessage returns the message of an asset.
+func assetMessage(asset *antidote.Asset) *antidotepb.Asset {
+	return &antidotepb.Asset{
+		Kind:        string(asset.Kind),
+		Source:      asset.Source,
+		Url:         asset.URL,
+		Size:        int64(asset.Size),
+		ContentType: asset.ContentType,
+		Remote:      asset.Remote,
+		Duration:    durationpb.New(asset.Duration),
+		Error:       asset.Error,
+	}
+}
+
+// eventMessage returns the message of a progress event.
+func eventMessage(event Event) *antidotepb.Event {
+	message := &antidotepb.Event{Type: eventTypes[event.Type], Job: jobMessage(event.Job), Warning: event.Warning}
+	if event.Asset != nil {
+		message.Asset = assetMessage(event.Asset)
+	}
+
+	return message
+}
+
+// resultMessage returns the result of a finished job, with the HTML of its snapshot if it is done. The data of
+// the assets spilled to disk is read from their temporary files.
+func resultMessage(job Job, snapshot *antidote.Snapshot) (*antidotepb.Result, error) {
+	result := &antidotepb.Result{Job: jobMessage(job)}
+	if snapshot == nil {
+		return result, nil
+	}
+
+	var html bytes.Buffer
+	if err := snapshot.WriteHTML(&html); err != nil {
+		return nil, err
+	}
+
+	result.Html, result.Title, result.Xhtml = html.String(), snapshot.Metadata.Title, snapshot.XHTML
+	for _, asset := range snapshot.Assets {
+		result.Assets = append(result.Assets, assetMessage(asset))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+
+	"github.com/lansana/antidote"
+)
+
+// ProxyOptions object represents options for a Proxy.
+type ProxyOptions struct {
+	// Defaults are the ingredients every page is cured with. The URL is set per request.
+	Defaults antidote.Ingredients
+
+	// Cache stores the cured pages and the other responses. Defaults to an antidote.MemoryCache.
+	Cache antidote.Cache
+
+	// MaxAge is how long a cached response is served before it is fetched again. Zero keeps them forever.
+	MaxAge time.Duration
+
+	// UpgradeInsecure fetches the http URLs requested over https instead. Browsers tunnel the https URLs they
+	// request through a proxy (CONNECT), which can not be cured nor cached, so https sites are browsed through
+	// the proxy with their http URL instead.
+	UpgradeInsecure bool
+}
+
+// Proxy object provides a caching forward proxy for development: a browser configured to use it as its HTTP
+// proxy gets the pages it navigates to cured, with their assets inlined, and every other GET response, e.g. of
+// the API of the site, cached. Every response allows any origin, so a frontend in development can call a
+// third-party site restricting CORS through the proxy. Other requests are forwarded as they are.
+type Proxy struct {
+	options ProxyOptions
+	forward *httputil.ReverseProxy
+}
+
+// hopHeaders are the headers of a connection, which are not passed on by the proxy.
+var hopHeaders = map[string]bool{
+	"Connection":          true,
+	"Proxy-Connection":    true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Keep-Alive":          true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// proxyEntry is a response stored in ProxyOptions.Cache.
+type proxyEntry struct {
+	ContentType string    `json:"contentType"`
+	Body        []byte    `json:"body"`
+	StoredAt    time.Time `json:"storedAt"`
+}
+
+// NewProxy creates a new instance of a Proxy pointer.
+func NewProxy(options ProxyOptions) *Proxy {
+	if options.Cache == nil {
+		options.Cache = antidote.NewMemoryCache()
+	}
+
+	p := &Proxy{options: options}
+	p.forward = &httputil.ReverseProxy{
+		Director:  func(r *http.Request) { p.upgrade(r) },
+		Transport: options.Defaults.Transport,
+		ModifyResponse: func(resp *http.Response) error {
+			allowOrigin(resp.Header)
+			return nil
+		},
+	}
+
+	return p
+}
+
+// ServeHTTP cures, fetches or forwards a request made to the proxy.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !r.URL.IsAbs() {
+		writeError(w, http.StatusBadRequest, "antidote is running as a proxy: configure it as the HTTP proxy of your browser")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodConnect:
+		writeError(w, http.StatusMethodNotAllowed, "https can not be proxied, request the http URL instead (see -proxy-upgrade)")
+	case http.MethodOptions:
+		// CORS preflight requests are answered by the proxy, as the site may not allow the origin.
+		allowOrigin(w.Header())
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+		if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		p.get(w, r)
+	default:
+		p.forward.ServeHTTP(w, r)
+	}
+}
+
+// get serves a GET request from the cache, or else cures the page if the browser navigates to it, or fetches
+// the response and caches it if it succeeds.
+func (p *Proxy) get(w http.ResponseWriter, r *http.Request) {
+	p.upgrade(r)
+	key := "proxy-" + p.key(r.URL.String())
+
+	if value, ok := p.options.Cache.Get(key); ok {
+		var entry proxyEntry
+		if err := json.Unmarshal(value, &entry); err == nil && (p.options.MaxAge <= 0 || time.Since(entry.StoredAt) < p.options.MaxAge) {
+			writeProxyEntry(w, &entry, "HIT")
+			return
+		}
+	}
+
+	var entry *proxyEntry
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		snapshot, err := p.cure(r.URL.String())
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+
+		entry = &proxyEntry{ContentType: "text/html; charset=utf-8", Body: []byte(snapshot.HTML)}
+		if snapshot.XHTML {
+			entry.ContentType = "application/xhtml+xml; charset=utf-8"
+		}
+	} else {
+		resp, err := p.fetch(r)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		defer resp.Body.Close()
+
+		// Only successful responses are cached, the others are passed on as they are.
+		if resp.StatusCode != http.StatusOK {
+			allowOrigin(resp.Header)
+			for name, values := range resp.Header {
+				if !hopHeaders[name] {
+					w.Header()[name] = values
+				}
+			}
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+			return
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		entry = &proxyEntry{ContentType: resp.Header.Get("Content-Type"), Body: body}
+	}
+
+	entry.StoredAt = time.Now()
+	if value, err := json.Marshal(entry); err == nil {
+		p.options.Cache.Set(key, value)
+	} else {
+		log.Println(err)
+	}
+
+	writeProxyEntry(w, entry, "MISS")
+}
+
+// cure cures a page with the default ingredients.
+func (p *Proxy) cure(rawurl string) (*antidote.Snapshot, error) {
+	ingredients := p.options.Defaults
+	ingredients.URL = rawurl
+
+	a := antidote.New()
+	a.Mix(&ingredients)
+
+	snapshot, err := a.CureToSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	// The snapshot is expanded, as the cached page outlives the files of its spilled assets.
+	if err := snapshot.Expand(); err != nil {
+		snapshot.Close()
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// fetch requests a URL with the headers of the browser, except those of its connection to the proxy and those
+// that would make the response unfit for caching.
+func (p *Proxy) fetch(r *http.Request) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, r.URL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range r.Header {
+		switch name {
+		case "Accept-Encoding", "If-None-Match", "If-Modified-Since":
+		default:
+			if !hopHeaders[name] {
+				req.Header[name] = values
+			}
+		}
+	}
+
+	client := &http.Client{Timeout: p.options.Defaults.Timeout, Transport: p.options.Defaults.Transport}
+	return client.Do(req)
+}
+
+// upgrade fetches an http URL over https with ProxyOptions.UpgradeInsecure.
+func (p *Proxy) upgrade(r *http.Request) {
+	if p.options.UpgradeInsecure && r.URL.Scheme == "http" {
+		r.URL.Scheme = "https"
+	}
+}
+
+// key returns the key the response of a URL is cached under, which is the same for every URL of the same
+// canonical form with the Canonicalizer of the default ingredients.
+func (p *Proxy) key(rawurl string) string {
+	if c := p.options.Defaults.Canonicalizer; c != nil {
+		if canonical, err := c.Canonicalize(rawurl); err == nil {
+			rawurl = canonical
+		}
+	}
+
+	sum := sha256.Sum256([]byte(rawurl))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeProxyEntry writes a cached response, telling whether it was served from the cache in X-Antidote-Cache.
+func writeProxyEntry(w http.ResponseWriter, entry *proxyEntry, cache string) {
+	allowOrigin(w.Header())
+	if entry.ContentType != "" {
+		w.Header().Set("Content-Type", entry.ContentType)
+	}
+	w.Header().Set("X-Antidote-Cache", cache)
+	w.WriteHeader(http.StatusOK)
+	w.Write(entry.Body)
+}
+
+// allowOrigin lets any origin read a response.
+func allowOrigin(header http.Header) {
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Del("Access-Control-Allow-Credentials")
+}
@@ -0,0 +1,884 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lansana/antidote"
+)
+
+// ErrQueueFull is returned by Queue.Submit() when no more jobs can be accepted.
+var ErrQueueFull = errors.New("the job queue is full")
+
+// ErrQueueClosed is returned by Queue.Submit() after Queue.Close() has been called.
+var ErrQueueClosed = errors.New("the job queue is closed")
+
+// JobStatus is the state of a job in the queue.
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// JobOptions object represents the options a job is cured with.
+type JobOptions struct {
+	StripJS    bool `json:"stripJS"`
+	SkipImages bool `json:"skipImages"`
+
+	// Selector only keeps the matching elements of the page, see antidote.Ingredients.Selector.
+	Selector string `json:"selector,omitempty"`
+}
+
+// Job object represents the state of an asynchronous cure.
+type Job struct {
+	ID          string     `json:"id"`
+	URL         string     `json:"url"`
+	Options     JobOptions `json:"options"`
+	Tenant      string     `json:"tenant,omitempty"`
+	Status      JobStatus  `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	AssetsCured int        `json:"assetsCured"`
+	BytesCured  int64      `json:"bytesCured"`
+	AssetsTotal int        `json:"assetsTotal,omitempty"`
+	BytesTotal  int64      `json:"bytesTotal,omitempty"`
+	Warnings    int        `json:"warnings,omitempty"`
+	Worker      string     `json:"worker,omitempty"`
+	SubmittedAt time.Time  `json:"submittedAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+
+	// VisualChange is how the page compares with its previous screenshot, see QueueOptions.VisualDiff.
+	VisualChange *VisualChange `json:"visualChange,omitempty"`
+}
+
+// Finished reports whether the job has either succeeded or failed.
+func (j Job) Finished() bool {
+	return j.Status == JobDone || j.Status == JobFailed
+}
+
+// EventType identifies the kind of progress event published for a job.
+type EventType string
+
+const (
+	// EventAsset is published every time an asset of the job has been cured.
+	EventAsset EventType = "asset"
+
+	// EventStatus is published every time the status of the job changes.
+	EventStatus EventType = "status"
+
+	// EventDiscovered is published once the assets of the job, or of one of its frames, have been probed with
+	// QueueOptions.Defaults.Preflight, which adds them to Job.AssetsTotal and Job.BytesTotal.
+	EventDiscovered EventType = "discovered"
+
+	// EventWarning is published every time the cure of the job logs a warning, e.g. an asset that could not be
+	// cured or a host throttling the cure.
+	EventWarning EventType = "warning"
+)
+
+// Event object represents a single progress update of a job.
+type Event struct {
+	Type    EventType       `json:"type"`
+	Job     Job             `json:"job"`
+	Asset   *antidote.Asset `json:"asset,omitempty"`
+	Warning string          `json:"warning,omitempty"`
+}
+
+// QueueOptions object represents options for a Queue.
+type QueueOptions struct {
+	// Workers is the number of cures that may run at the same time. Defaults to 4.
+	Workers int
+
+	// Capacity is the number of jobs that may wait to be run. Defaults to 1024.
+	Capacity int
+
+	// Retention is how long a finished job and its result are kept. Defaults to one hour.
+	Retention time.Duration
+
+	// Defaults are the ingredients every job is cured with. The URL and the job options are set per job.
+	Defaults antidote.Ingredients
+
+	// Store keeps every finished job along with its snapshot, so that results can still be fetched after
+	// the retention period or a restart of the daemon, without a local persistent disk.
+	Store antidote.Store
+
+	// StoreRetention is how long the jobs and the asset cache kept in Store are retained, so that the store of a
+	// long-running daemon does not grow without bound. Store must be an antidote.ListableStore, whose garbage is
+	// collected every CollectInterval, see CollectGarbage().
+	StoreRetention RetentionPolicy
+
+	// CollectInterval is how often the garbage of Store is collected with StoreRetention. Defaults to
+	// DefaultCollectInterval.
+	CollectInterval time.Duration
+
+	// StoreDeltas keeps the snapshot of a job in Store as a delta against the previous version of its URL, up to
+	// this many deltas in a row before a full snapshot again, which cuts the storage of the URLs cured over and
+	// over, e.g. to monitor their changes, by an order of magnitude. Reading a snapshot reads the versions it is
+	// based on as well. 0 keeps every snapshot in full.
+	StoreDeltas int
+
+	// ShareAssets keeps the data URL's, scripts and stylesheets inlined into the snapshots of jobs in Store once,
+	// by the hash of their bytes, with the snapshots referencing them, so that the pages of the same site do not
+	// each keep a copy of the same bundles. An asset is deleted by CollectGarbage() along with the last job
+	// referencing it.
+	ShareAssets bool
+
+	// Broker makes the queue the coordinator of a cluster: jobs are pushed to the broker, to be run by the
+	// workers pulling from it (see Worker), instead of being cured by the queue itself. The progress of the
+	// assets of its jobs is not published.
+	Broker Broker
+
+	// Tenants are the teams sharing the daemon. When set, every request to the API but those of the web UI and
+	// of the workers of a cluster must be authenticated with the key of a tenant, which only sees its own jobs,
+	// within its limits and allowed hosts. The jobs and the asset cache of every tenant are kept under a prefix
+	// of its own in Store.
+	Tenants []*Tenant
+
+	// AuditLog are the sinks every cure request is recorded to once its job has finished, or once it has been
+	// rejected, with its requester, options, duration and outcome.
+	AuditLog []AuditSink
+
+	// VisualDiff captures a screenshot of the snapshot of every job done, and compares it with the previous one of
+	// its URL, so that purely visual changes of the pages cured over and over, e.g. of their stylesheets only, are
+	// detected as well: see Job.VisualChange, which is recorded to the audit log. The latest screenshot of every URL
+	// is kept under screenshots/ in Store, or in memory without one.
+	VisualDiff *VisualDiffOptions
+}
+
+// entry holds a job along with its result and subscribers. It is guarded by the queue mutex.
+type entry struct {
+	job         Job
+	page        *Page
+	saved       bool
+	tenant      *Tenant
+	remoteAddr  string
+	snapshot    *antidote.Snapshot
+	subscribers map[chan Event]bool
+
+	// reading is held by the readers of the snapshot, which is closed once the entry leaves the queue.
+	reading sync.RWMutex
+}
+
+// Queue runs cures asynchronously on a fixed pool of workers.
+type Queue struct {
+	options QueueOptions
+	pending chan *entry
+	entries map[string]*entry
+	closed  bool
+	stopped chan struct{}
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+
+	// screenshots are the latest screenshots of the URL's without a Store, see QueueOptions.VisualDiff.
+	screenshots map[string][]byte
+}
+
+// NewQueue creates a new Queue and starts its workers.
+func NewQueue(options QueueOptions) *Queue {
+	if options.Workers <= 0 {
+		options.Workers = 4
+	}
+	if options.Capacity <= 0 {
+		options.Capacity = 1024
+	}
+	if options.Retention <= 0 {
+		options.Retention = time.Hour
+	}
+
+	q := &Queue{
+		options: options,
+		pending: make(chan *entry, options.Capacity),
+		entries: make(map[string]*entry),
+		stopped: make(chan struct{}),
+	}
+
+	if store, ok := options.Store.(antidote.ListableStore); ok && options.StoreRetention.enabled() {
+		go q.collectGarbage(store)
+	}
+
+	if options.Broker != nil {
+		q.wg.Add(1)
+		go (func() {
+			defer q.wg.Done()
+			for e := range q.pending {
+				q.dispatch(e)
+			}
+		})()
+		go q.collect()
+
+		return q
+	}
+
+	q.wg.Add(options.Workers)
+	for i := 0; i < options.Workers; i++ {
+		go (func() {
+			defer q.wg.Done()
+			for e := range q.pending {
+				q.run(e)
+			}
+		})()
+	}
+
+	return q
+}
+
+// Submit adds a cure of the URL to the queue and returns the queued job.
+func (q *Queue) Submit(url string, options JobOptions) (Job, error) {
+	return q.submit(nil, "", url, nil, options)
+}
+
+// SubmitPage adds a cure of a page captured by a browser to the queue and returns the queued job. The page is
+// only held until the job has run, and is not part of the job.
+func (q *Queue) SubmitPage(page *Page, options JobOptions) (Job, error) {
+	return q.submit(nil, "", page.URL, page, options)
+}
+
+// submit adds a cure of the URL, or of the page if it is not nil, to the queue on behalf of a tenant if it is
+// not nil, within its limits. The request is recorded in the audit log if it is rejected, with the address it
+// was made from.
+func (q *Queue) submit(tenant *Tenant, remoteAddr string, url string, page *Page, options JobOptions) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	e := &entry{
+		job:         Job{ID: id, URL: url, Options: options, Tenant: tenantName(tenant), Status: JobQueued, SubmittedAt: time.Now()},
+		page:        page,
+		saved:       page != nil,
+		tenant:      tenant,
+		remoteAddr:  remoteAddr,
+		subscribers: make(map[chan Event]bool),
+	}
+
+	job, err := q.enqueue(e)
+	if err != nil {
+		record := q.auditRecord(e, nil, err)
+		record.JobID = ""
+		q.audit(record)
+
+		return Job{}, err
+	}
+
+	return job, nil
+}
+
+// enqueue adds an entry to the queue, unless its tenant exceeds its limits, and returns a copy of its job taken
+// before a worker may update it. The submissions rejected as the queue is closed or full do not count against
+// the rate limit of the tenant.
+func (q *Queue) enqueue(e *entry) (Job, error) {
+	if tenant := e.tenant; tenant != nil && !tenant.allows(e.job.URL) {
+		return Job{}, ErrHostNotAllowed
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Only enqueue sends to the pending channel, with the mutex held, so it can not fill up in the meantime.
+	if q.closed {
+		return Job{}, ErrQueueClosed
+	}
+	if len(q.pending) == cap(q.pending) {
+		return Job{}, ErrQueueFull
+	}
+
+	if tenant := e.tenant; tenant != nil {
+		if err := tenant.admit(); err != nil {
+			return Job{}, err
+		}
+	}
+
+	q.pending <- e
+	q.entries[e.job.ID] = e
+
+	return e.job, nil
+}
+
+// Job returns the current state of a job. Jobs no longer held by the queue are read from QueueOptions.Store.
+func (q *Queue) Job(id string) (Job, bool) {
+	job, _, ok := q.Result(id)
+	return job, ok
+}
+
+// Jobs returns the current state of every job, most recently submitted first.
+func (q *Queue) Jobs() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs := make([]Job, 0, len(q.entries))
+	for _, e := range q.entries {
+		jobs = append(jobs, e.job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].SubmittedAt.After(jobs[j].SubmittedAt)
+	})
+
+	return jobs
+}
+
+// Result returns the snapshot of a job. The snapshot is nil until the job is done. Jobs no longer held by
+// the queue are read from QueueOptions.Store. Without a Store, the assets of the snapshot spilled to disk are
+// removed once the job leaves the queue, after which Snapshot.WriteHTML() can no longer expand them.
+func (q *Queue) Result(id string) (Job, *antidote.Snapshot, bool) {
+	return q.result("", id)
+}
+
+// result returns the snapshot of a job of a tenant, or of a job submitted without a tenant if its name is empty.
+func (q *Queue) result(tenant string, id string) (Job, *antidote.Snapshot, bool) {
+	q.mu.Lock()
+	e, ok := q.entries[id]
+	if ok && e.job.Tenant == tenant {
+		defer q.mu.Unlock()
+		return e.job, e.snapshot, true
+	}
+	q.mu.Unlock()
+
+	if q.options.Store == nil {
+		return Job{}, nil, false
+	}
+
+	job, snapshot, err := q.load(tenant, id)
+	if err != nil {
+		if err != antidote.ErrNotStored {
+			log.Println(err)
+		}
+		return Job{}, nil, false
+	}
+
+	return job, snapshot, true
+}
+
+// view calls fn with a job of a tenant and its snapshot, which is not closed before fn returns, so that its
+// assets spilled to disk can be read. It reports false if the job is not found.
+func (q *Queue) view(tenant string, id string, fn func(job Job, snapshot *antidote.Snapshot)) bool {
+	q.mu.Lock()
+	e, ok := q.entries[id]
+	if ok && e.job.Tenant == tenant {
+		job, snapshot := e.job, e.snapshot
+		e.reading.RLock()
+		q.mu.Unlock()
+		defer e.reading.RUnlock()
+
+		fn(job, snapshot)
+		return true
+	}
+	q.mu.Unlock()
+
+	job, snapshot, ok := q.result(tenant, id)
+	if ok {
+		fn(job, snapshot)
+	}
+
+	return ok
+}
+
+// Subscribe returns a channel of progress events for a job, which is closed once the job has finished.
+// Events are dropped rather than blocking the cure if the subscriber falls behind. The returned function
+// must be called to unsubscribe when the caller is no longer reading from the channel.
+func (q *Queue) Subscribe(id string) (<-chan Event, func(), bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.entries[id]
+	if !ok {
+		return nil, nil, false
+	}
+
+	events := make(chan Event, 64)
+	events <- Event{Type: EventStatus, Job: e.job}
+
+	if e.job.Finished() {
+		close(events)
+		return events, func() {}, true
+	}
+
+	e.subscribers[events] = true
+
+	unsubscribe := func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		if e.subscribers[events] {
+			delete(e.subscribers, events)
+			close(events)
+		}
+	}
+
+	return events, unsubscribe, true
+}
+
+// Reload replaces the default ingredients and the tenants of the queue, e.g. once the configuration of the
+// daemon changed, without restarting it. The jobs already running keep the ingredients they started with, the
+// cache of the defaults is kept, as the workers of a cluster share it, and the tenants keep their usage of their
+// rate limit and quota if their name is unchanged.
+func (q *Queue) Reload(defaults antidote.Ingredients, tenants []*Tenant) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, tenant := range tenants {
+		for _, old := range q.options.Tenants {
+			if old.Name == tenant.Name && old != tenant {
+				tenant.inherit(old)
+			}
+		}
+	}
+
+	defaults.Cache = q.options.Defaults.Cache
+	q.options.Defaults = defaults
+	q.options.Tenants = tenants
+}
+
+// tenants returns the tenants of the queue.
+func (q *Queue) tenants() []*Tenant {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.options.Tenants
+}
+
+// cache returns the asset cache of the default ingredients.
+func (q *Queue) cache() antidote.Cache {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.options.Defaults.Cache
+}
+
+// tenant returns the tenant of a name, or nil if the queue has none of that name.
+func (q *Queue) tenant(name string) *Tenant {
+	for _, tenant := range q.tenants() {
+		if tenant.Name == name && name != "" {
+			return tenant
+		}
+	}
+
+	return nil
+}
+
+// ready returns ErrQueueClosed once the queue stops accepting jobs, or ErrQueueFull while it can not accept
+// any more of them.
+func (q *Queue) ready() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	switch {
+	case q.closed:
+		return ErrQueueClosed
+	case len(q.pending) == cap(q.pending):
+		return ErrQueueFull
+	}
+
+	return nil
+}
+
+// Close stops accepting jobs and waits for every queued job to finish.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.pending)
+		close(q.stopped)
+	}
+	q.mu.Unlock()
+
+	q.wg.Wait()
+}
+
+// run cures the URL of a job, publishing its progress to the subscribers.
+func (q *Queue) run(e *entry) {
+	q.update(e, func(job *Job) {
+		job.Status = JobRunning
+		now := time.Now()
+		job.StartedAt = &now
+	})
+
+	q.mu.Lock()
+	defaults := q.options.Defaults
+	q.mu.Unlock()
+
+	if defaults.Preflight {
+		// Frames are probed on their own, so their assets add up to those of the page.
+		defaults.OnPreflight = func(assets []*antidote.PreflightAsset) {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+
+			e.job.AssetsTotal += len(assets)
+			for _, asset := range assets {
+				if asset.Size > 0 {
+					e.job.BytesTotal += asset.Size
+				}
+			}
+			q.publish(e, Event{Type: EventDiscovered, Job: e.job})
+		}
+	}
+
+	defaults.Logger = q.warningLogger(e, defaults.Logger)
+
+	if e.tenant != nil {
+		e.tenant.Config.apply(&defaults)
+		if q.options.Store != nil {
+			defaults.Cache = &antidote.StoreCache{Store: q.options.Store, Prefix: tenantPrefix(e.tenant.Name) + "cache/"}
+		}
+	}
+
+	snapshot, err := cure(defaults, e.job.URL, e.page, e.job.Options, func(asset *antidote.Asset) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		e.job.AssetsCured++
+		e.job.BytesCured += int64(asset.Size)
+		q.publish(e, Event{Type: EventAsset, Job: e.job, Asset: asset})
+	})
+
+	q.finish(e, snapshot, err)
+}
+
+// warningLogger returns a logger publishing the warnings of the cure of a job, which passes every message on
+// to the logger of the defaults.
+func (q *Queue) warningLogger(e *entry, logger *antidote.Logger) *antidote.Logger {
+	if logger == nil {
+		logger = &antidote.Logger{Level: antidote.LogWarn}
+	}
+
+	enabled := logger.Level
+	if enabled < antidote.LogWarn {
+		enabled = antidote.LogWarn
+	}
+
+	return &antidote.Logger{Level: enabled, Handler: func(level antidote.LogLevel, msg string, keyvals ...interface{}) {
+		logger.Log(level, msg, keyvals...)
+		if level != antidote.LogWarn {
+			return
+		}
+
+		warning := msg
+		for i := 0; i+1 < len(keyvals); i += 2 {
+			warning += fmt.Sprintf(" %v=%v", keyvals[i], keyvals[i+1])
+		}
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		e.job.Warnings++
+		q.publish(e, Event{Type: EventWarning, Job: e.job, Warning: warning})
+	}}
+}
+
+// auditRecord returns the record of the request of a job in the audit log, once it has finished with a snapshot
+// or an error. The queue mutex must be held if the job may be updated concurrently.
+func (q *Queue) auditRecord(e *entry, snapshot *antidote.Snapshot, err error) *AuditRecord {
+	record := &AuditRecord{
+		Time:         e.job.SubmittedAt,
+		JobID:        e.job.ID,
+		Tenant:       e.job.Tenant,
+		RemoteAddr:   e.remoteAddr,
+		URL:          e.job.URL,
+		Options:      e.job.Options,
+		Saved:        e.saved,
+		Status:       JobDone,
+		Duration:     time.Since(e.job.SubmittedAt),
+		VisualChange: e.job.VisualChange,
+	}
+
+	if err != nil {
+		record.Status = JobFailed
+		record.Error = err.Error()
+	}
+
+	if snapshot != nil {
+		record.Size = snapshotSize(snapshot)
+		record.Assets = len(snapshot.Assets)
+		for _, asset := range snapshot.Assets {
+			if asset.Error != "" {
+				record.FailedAssets++
+			}
+		}
+	}
+
+	return record
+}
+
+// audit writes a record to every sink of the audit log.
+func (q *Queue) audit(record *AuditRecord) {
+	for _, sink := range q.options.AuditLog {
+		if err := sink.Write(record); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// dispatch pushes a job to the broker of the cluster. The job is marked as running once it has been pushed, as
+// the broker does not tell when a worker pulls it.
+func (q *Queue) dispatch(e *entry) {
+	task := Task{JobID: e.job.ID, URL: e.job.URL, Page: e.page, Options: e.job.Options}
+	if e.tenant != nil {
+		task.Config = &e.tenant.Config
+	}
+
+	err := q.options.Broker.Push(task)
+	if err != nil {
+		q.finish(e, nil, err)
+		return
+	}
+
+	q.update(e, func(job *Job) {
+		job.Status = JobRunning
+		now := time.Now()
+		job.StartedAt = &now
+	})
+}
+
+// collect finishes the jobs whose results are reported by the workers of the cluster. Results of jobs that
+// have already finished, e.g. because their lease expired while they were being run, are dropped.
+func (q *Queue) collect() {
+	for result := range q.options.Broker.Results() {
+		q.mu.Lock()
+		e, ok := q.entries[result.JobID]
+		finished := ok && e.job.Finished()
+		q.mu.Unlock()
+
+		if !ok || finished {
+			continue
+		}
+
+		var err error
+		if result.Error != "" {
+			err = errors.New(result.Error)
+		}
+
+		q.update(e, func(job *Job) {
+			job.Worker = result.Worker
+			if result.Snapshot != nil {
+				job.AssetsCured = len(result.Snapshot.Assets)
+				for _, asset := range result.Snapshot.Assets {
+					job.BytesCured += int64(asset.Size)
+				}
+			}
+		})
+		q.finish(e, result.Snapshot, err)
+	}
+}
+
+// finish records the outcome of a job, and removes it once it has been kept for the retention period.
+func (q *Queue) finish(e *entry, snapshot *antidote.Snapshot, err error) {
+	// The snapshot is expanded, as the files of its spilled assets do not outlive the daemon.
+	if err == nil && q.options.Store != nil {
+		if err = snapshot.Expand(); err != nil {
+			snapshot.Close()
+		}
+	}
+
+	var change *VisualChange
+	if err == nil && q.options.VisualDiff != nil {
+		change = q.compareScreenshot(e, snapshot)
+	}
+
+	q.update(e, func(job *Job) {
+		now := time.Now()
+		job.FinishedAt = &now
+		e.page = nil
+		if err != nil {
+			job.Status = JobFailed
+			job.Error = err.Error()
+			return
+		}
+
+		job.Status = JobDone
+		job.VisualChange = change
+		e.snapshot = snapshot
+	})
+
+	// The usage is added to the tenant of the same name, which may have been reloaded since the job was submitted.
+	if tenant := q.tenant(e.job.Tenant); tenant != nil && snapshot != nil && err == nil {
+		tenant.use(snapshot)
+	}
+
+	q.mu.Lock()
+	record := q.auditRecord(e, snapshot, err)
+	q.mu.Unlock()
+	q.audit(record)
+
+	if q.options.Store != nil {
+		q.mu.Lock()
+		job, snapshot := e.job, e.snapshot
+		q.mu.Unlock()
+
+		if err := q.store(job, snapshot); err != nil {
+			log.Println(err)
+		}
+	}
+
+	time.AfterFunc(q.options.Retention, func() {
+		q.mu.Lock()
+		delete(q.entries, e.job.ID)
+		snapshot := e.snapshot
+		q.mu.Unlock()
+
+		// Without a Store, the files of the spilled assets are removed once no request is reading them.
+		if snapshot != nil {
+			e.reading.Lock()
+			snapshot.Close()
+			e.reading.Unlock()
+		}
+	})
+}
+
+// update modifies the job of an entry and publishes the new status to the subscribers. Once the job
+// has finished the subscriber channels are closed.
+func (q *Queue) update(e *entry, fn func(job *Job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	fn(&e.job)
+	q.publish(e, Event{Type: EventStatus, Job: e.job})
+
+	if e.job.Finished() {
+		for events := range e.subscribers {
+			close(events)
+		}
+		e.subscribers = make(map[chan Event]bool)
+	}
+}
+
+// publish sends an event to every subscriber of an entry. The queue mutex must be held.
+func (q *Queue) publish(e *entry, event Event) {
+	for events := range e.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// storedJob is a finished job as kept in QueueOptions.Store.
+type storedJob struct {
+	Job Job `json:"job"`
+
+	// Snapshot is the JSON of the snapshot of the job, unless it is kept as a Delta.
+	Snapshot json.RawMessage `json:"snapshot,omitempty"`
+
+	// Delta is the snapshot of the job as a delta against a previous version of its URL, see
+	// QueueOptions.StoreDeltas.
+	Delta *snapshotDelta `json:"delta,omitempty"`
+
+	// Shared are the hashes of the shared assets the snapshot references, see QueueOptions.ShareAssets.
+	Shared []string `json:"shared,omitempty"`
+}
+
+// store writes a finished job to the store of the queue, along with its snapshot if it succeeded.
+func (q *Queue) store(job Job, snapshot *antidote.Snapshot) error {
+	stored := storedJob{Job: job}
+	if snapshot != nil {
+		var err error
+		if q.options.ShareAssets {
+			if snapshot, stored.Shared, err = q.shareAssets(job, snapshot); err != nil {
+				return err
+			}
+		}
+		if stored.Snapshot, err = json.Marshal(snapshot); err != nil {
+			return err
+		}
+	}
+
+	done := job.Status == JobDone && job.FinishedAt != nil
+	if done && q.options.StoreDeltas > 0 {
+		if stored.Delta = q.delta(job, stored.Snapshot); stored.Delta != nil {
+			stored.Snapshot = nil
+		}
+	}
+
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	if err := q.options.Store.Put(tenantPrefix(job.Tenant)+jobKey(job.ID), b); err != nil {
+		return err
+	}
+
+	// The versions of a URL are recorded once their job is stored, so that a marker never outlives its job.
+	if !done {
+		return nil
+	}
+
+	base := ""
+	if stored.Delta != nil {
+		base = stored.Delta.Base
+	}
+	if err := q.options.Store.Put(versionKey(job.Tenant, job.URL, *job.FinishedAt, job.ID, base), nil); err != nil {
+		return err
+	}
+
+	if q.options.StoreDeltas > 0 {
+		return q.options.Store.Put(latestKey(job.Tenant, job.URL), []byte(job.ID))
+	}
+
+	return nil
+}
+
+// load reads a finished job of a tenant from the store of the queue, along with its snapshot if it succeeded.
+func (q *Queue) load(tenant string, id string) (Job, *antidote.Snapshot, error) {
+	stored, err := loadJob(q.options.Store, tenantPrefix(tenant), id)
+	if err != nil {
+		return Job{}, nil, err
+	}
+
+	raw, err := expandSnapshot(q.options.Store, tenantPrefix(tenant), stored)
+	if err != nil || len(raw) == 0 {
+		return stored.Job, nil, err
+	}
+
+	var snapshot *antidote.Snapshot
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return stored.Job, nil, err
+	}
+	if len(stored.Shared) > 0 {
+		if snapshot.HTML, err = restoreAssets(q.options.Store, tenantPrefix(tenant), snapshot.HTML, stored.Shared); err != nil {
+			return stored.Job, nil, err
+		}
+	}
+
+	return stored.Job, snapshot, nil
+}
+
+// loadJob reads a finished job from a store, under the prefix of its tenant.
+func loadJob(store antidote.Store, prefix string, id string) (storedJob, error) {
+	var stored storedJob
+
+	// Job IDs are hex encoded, anything else can not have been stored.
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return stored, antidote.ErrNotStored
+	}
+
+	b, err := store.Get(prefix + jobKey(id))
+	if err != nil {
+		return stored, err
+	}
+
+	err = json.Unmarshal(b, &stored)
+	return stored, err
+}
+
+// jobKey returns the key a job is kept under in the store.
+func jobKey(id string) string {
+	return "jobs/" + id + ".json"
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
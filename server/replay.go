@@ -0,0 +1,456 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lansana/antidote"
+	"golang.org/x/net/html"
+)
+
+// replayPrefix starts the paths the Replay serves itself rather than the snapshots, as those may have any path.
+const replayPrefix = "/_antidote/"
+
+// replayAssetsDir is the directory the assets of a snapshot written as a directory are extracted to.
+const replayAssetsDir = "assets/"
+
+// Replay object serves a local archive of snapshots at their original paths, e.g. the snapshot of
+// https://www.website.com/blog/post at /blog/post, so that the archive is browsed as the sites were, with an
+// index of the snapshots at /_antidote/. The snapshots are those written by antidote cure to a directory:
+//
+//	dir    the directories of a manifest.json, with their assets
+//	json   the .json files of a snapshot
+//	html   the .html files, named {{.Host}}/{{.Path}}.html as by default, whose URL is read from their name
+//
+// When several hosts have a snapshot of a path, the snapshot of the host of the request is served, e.g. through a
+// hosts file, or else the one captured last, and any of them is served at /_antidote/web/{url}. The latest capture
+// of a URL is served.
+//
+//	GET  /_antidote/            the index of the snapshots, rescanning the directory
+//	GET  /_antidote/web/{url}   the snapshot of a URL
+//	GET  /{path}                the snapshot of a path, or an extracted asset of a snapshot
+type Replay struct {
+	dir string
+
+	mu     sync.RWMutex
+	pages  map[string][]*replayPage
+	assets map[string]string
+}
+
+// replayPage is a snapshot of the archive of a Replay.
+type replayPage struct {
+	URL        string
+	Title      string
+	CapturedAt time.Time
+
+	host string
+
+	// file is the HTML of the page, or its snapshot in JSON.
+	file     string
+	snapshot bool
+}
+
+// replayIndexTemplate renders the index of the snapshots of a Replay.
+var replayIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>antidote replay</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; }
+table { border-collapse: collapse; width: 100%; }
+td, th { padding: .3em .6em; text-align: left; border-bottom: 1px solid #ddd; }
+td.time { white-space: nowrap; color: #666; }
+</style>
+</head>
+<body>
+<h1>{{len .}} snapshots</h1>
+<table>
+<tr><th>Page</th><th>URL</th><th>Captured</th></tr>
+{{range .}}<tr><td><a href="{{.Link}}">{{if .Title}}{{.Title}}{{else}}{{.URL}}{{end}}</a></td><td>{{.URL}}</td><td class="time">{{.CapturedAt.Format "2006-01-02 15:04"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// replayEntry is a row of the index of a Replay.
+type replayEntry struct {
+	*replayPage
+	Link string
+}
+
+// NewReplay creates a new instance of a Replay pointer serving the snapshots of a directory, which is scanned.
+func NewReplay(dir string) (*Replay, error) {
+	r := &Replay{dir: dir}
+	if err := r.Scan(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Scan reads the snapshots of the directory again, e.g. once new pages were cured to it.
+func (r *Replay) Scan() error {
+	pages := make(map[string][]*replayPage)
+	assets := make(map[string]string)
+
+	add := func(page *replayPage) {
+		u, err := url.Parse(page.URL)
+		if err != nil || u.Host == "" {
+			return
+		}
+		page.host = strings.ToLower(u.Hostname())
+
+		key := replayKey(u.EscapedPath(), u.RawQuery)
+		pages[key] = append(pages[key], page)
+	}
+
+	err := filepath.Walk(r.dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			manifest, err := readManifest(filepath.Join(name, "manifest.json"))
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				log.Printf("antidote replay: %s: %v", name, err)
+				return filepath.SkipDir
+			}
+
+			page := &replayPage{URL: manifest.URL, CapturedAt: manifest.CapturedAt, file: filepath.Join(name, filepath.FromSlash(manifest.Page.Path))}
+			if manifest.Metadata != nil {
+				page.Title = manifest.Metadata.Title
+			}
+			add(page)
+
+			// The assets are named by their hash, so the assets of every snapshot share a single namespace.
+			for _, asset := range manifest.Assets {
+				assets[path.Base(asset.Path)] = filepath.Join(name, filepath.FromSlash(asset.Path))
+			}
+
+			return filepath.SkipDir
+		}
+
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".json":
+			if page, ok := readSnapshotPage(name); ok {
+				add(page)
+			}
+		case ".html", ".htm":
+			rel, err := filepath.Rel(r.dir, name)
+			if err != nil {
+				return nil
+			}
+			if page, ok := readHTMLPage(name, filepath.ToSlash(rel), info.ModTime()); ok {
+				add(page)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The latest capture of a URL comes first.
+	for _, captures := range pages {
+		sort.SliceStable(captures, func(i, j int) bool {
+			return captures[i].CapturedAt.After(captures[j].CapturedAt)
+		})
+	}
+
+	r.mu.Lock()
+	r.pages, r.assets = pages, assets
+	r.mu.Unlock()
+
+	return nil
+}
+
+// replayKey returns the key of the pages of a path and a query.
+func replayKey(escapedPath string, rawQuery string) string {
+	if escapedPath == "" {
+		escapedPath = "/"
+	}
+	if rawQuery != "" {
+		return escapedPath + "?" + rawQuery
+	}
+
+	return escapedPath
+}
+
+// readManifest reads the manifest of a snapshot written as a directory.
+func readManifest(name string) (*antidote.Manifest, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return antidote.ReadManifest(f)
+}
+
+// readSnapshotPage reads a snapshot written in JSON, reporting false for other JSON files.
+func readSnapshotPage(name string) (*replayPage, bool) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	snapshot, err := antidote.ReadSnapshot(f)
+	if err != nil || snapshot.URL == "" || snapshot.HTML == "" {
+		return nil, false
+	}
+
+	return &replayPage{URL: snapshot.URL, Title: snapshot.Metadata.Title, CapturedAt: snapshot.StartedAt, file: name, snapshot: true}, true
+}
+
+// readHTMLPage reads the title of an HTML page, whose URL is read from its path relative to the directory, named
+// {{.Host}}/{{.Path}}.html, e.g. "www.website.com/blog/post.html" for https://www.website.com/blog/post and
+// "www.website.com/index.html" for the root of the site. It reports false for the pages of other names.
+func readHTMLPage(name string, rel string, modTime time.Time) (*replayPage, bool) {
+	parts := strings.SplitN(strings.TrimSuffix(rel, path.Ext(rel)), "/", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, false
+	}
+
+	p := "/" + parts[1]
+	if p == "/index" {
+		p = "/"
+	}
+
+	page := &replayPage{URL: "https://" + parts[0] + p, CapturedAt: modTime, file: name}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	// Only the start of the page is read, up to the end of its title.
+	tokenizer := html.NewTokenizer(f)
+	for inTitle := false; ; {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return page, true
+		case html.StartTagToken:
+			tag, _ := tokenizer.TagName()
+			inTitle = string(tag) == "title"
+			if string(tag) == "body" {
+				return page, true
+			}
+		case html.TextToken:
+			if inTitle {
+				page.Title = strings.Join(strings.Fields(string(tokenizer.Text())), " ")
+				return page, true
+			}
+		}
+	}
+}
+
+// ServeHTTP serves the index, a snapshot or an asset.
+func (r *Replay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if req.URL.Path == strings.TrimSuffix(replayPrefix, "/") {
+		http.Redirect(w, req, replayPrefix, http.StatusFound)
+		return
+	}
+
+	if req.URL.Path == replayPrefix {
+		r.index(w, req)
+		return
+	}
+
+	if strings.HasPrefix(req.URL.Path, replayPrefix+"web/") {
+		u, err := url.Parse(strings.TrimPrefix(req.URL.RequestURI(), replayPrefix+"web/"))
+		if err != nil {
+			http.NotFound(w, req)
+			return
+		}
+
+		if page := r.page(strings.ToLower(u.Hostname()), u.EscapedPath(), u.RawQuery); page != nil {
+			r.serve(w, req, page)
+			return
+		}
+
+		r.asset(w, req)
+		return
+	}
+
+	host, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host = req.Host
+	}
+
+	if page := r.page(strings.ToLower(host), req.URL.EscapedPath(), req.URL.RawQuery); page != nil {
+		r.serve(w, req, page)
+		return
+	}
+
+	if req.URL.Path == "/" {
+		http.Redirect(w, req, replayPrefix, http.StatusFound)
+		return
+	}
+
+	r.asset(w, req)
+}
+
+// page returns the latest capture of a path and a query, of the host if it has one, ignoring the trailing slash
+// of the path if no capture matches it exactly. It returns nil if there is none.
+func (r *Replay) page(host string, escapedPath string, rawQuery string) *replayPage {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := []string{replayKey(escapedPath, rawQuery)}
+	if trimmed := strings.TrimSuffix(escapedPath, "/"); trimmed != escapedPath && trimmed != "" {
+		keys = append(keys, replayKey(trimmed, rawQuery))
+	} else if trimmed == escapedPath {
+		keys = append(keys, replayKey(escapedPath+"/", rawQuery))
+	}
+
+	for _, key := range keys {
+		captures := r.pages[key]
+		for _, page := range captures {
+			if page.host == host {
+				return page
+			}
+		}
+
+		// Without a capture of the host, the latest capture of any host is served.
+		var latest *replayPage
+		for _, page := range captures {
+			if latest == nil || page.CapturedAt.After(latest.CapturedAt) {
+				latest = page
+			}
+		}
+		if latest != nil {
+			return latest
+		}
+	}
+
+	return nil
+}
+
+// serve writes the HTML of a snapshot.
+func (r *Replay) serve(w http.ResponseWriter, req *http.Request, page *replayPage) {
+	b, err := ioutil.ReadFile(page.file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if page.snapshot {
+		snapshot, err := antidote.ReadSnapshot(bytes.NewReader(b))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b = []byte(snapshot.HTML)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, req, "", page.CapturedAt, bytes.NewReader(b))
+}
+
+// asset writes an asset extracted with a snapshot, requested relative to its page, e.g. /blog/assets/{hash}.png.
+func (r *Replay) asset(w http.ResponseWriter, req *http.Request) {
+	name := path.Base(req.URL.Path)
+	if path.Base(path.Dir(req.URL.Path)) != strings.TrimSuffix(replayAssetsDir, "/") {
+		http.NotFound(w, req)
+		return
+	}
+
+	r.mu.RLock()
+	file, ok := r.assets[name]
+	r.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	// The name of an asset is the hash of its content, which never changes.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, req, name, info.ModTime(), f)
+}
+
+// index writes the index of the snapshots, latest first, after scanning the directory again.
+func (r *Replay) index(w http.ResponseWriter, req *http.Request) {
+	if err := r.Scan(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	r.mu.RLock()
+	var entries []replayEntry
+	for key, captures := range r.pages {
+		// The latest capture of every host is listed, and the paths shared by several hosts are linked by URL.
+		var latest []*replayPage
+		hosts := make(map[string]bool)
+		for _, page := range captures {
+			if !hosts[page.host] {
+				hosts[page.host] = true
+				latest = append(latest, page)
+			}
+		}
+
+		for _, page := range latest {
+			entry := replayEntry{replayPage: page, Link: key}
+			if len(latest) > 1 {
+				entry.Link = replayPrefix + "web/" + page.URL
+			}
+			entries = append(entries, entry)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CapturedAt.After(entries[j].CapturedAt)
+	})
+
+	var b bytes.Buffer
+	if err := replayIndexTemplate.Execute(&b, entries); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.Copy(w, &b)
+}
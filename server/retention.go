@@ -0,0 +1,370 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lansana/antidote"
+)
+
+// DefaultCollectInterval is how often the garbage of QueueOptions.Store is collected when
+// QueueOptions.CollectInterval is not set.
+const DefaultCollectInterval = time.Hour
+
+// RetentionPolicy object represents how long the jobs and the asset cache kept in a store are retained, see
+// CollectGarbage(). Zero fields do not limit anything.
+type RetentionPolicy struct {
+	// MaxVersions is the number of finished jobs of the same URL kept per tenant, the most recent ones.
+	MaxVersions int
+
+	// MaxAge is how long the jobs and the cache entries are kept once stored.
+	MaxAge time.Duration
+
+	// MaxBytes is the total size of the jobs and the cache entries, the oldest of which are removed until they fit.
+	MaxBytes int64
+}
+
+// enabled reports whether the policy limits anything.
+func (p RetentionPolicy) enabled() bool {
+	return p.MaxVersions > 0 || p.MaxAge > 0 || p.MaxBytes > 0
+}
+
+// GCReport object represents the outcome of a garbage collection of a store, see CollectGarbage().
+type GCReport struct {
+	// Deleted are the keys removed, or which would have been with a dry run.
+	Deleted []string `json:"deleted"`
+
+	// DeletedBytes is the total size of the blobs removed.
+	DeletedBytes int64 `json:"deletedBytes"`
+
+	// Kept is the number of blobs left, and KeptBytes their total size.
+	Kept      int   `json:"kept"`
+	KeptBytes int64 `json:"keptBytes"`
+
+	// Rewritten are the keys of the jobs whose snapshot was a delta against a version deleted, see
+	// QueueOptions.StoreDeltas, which are kept in full instead, or would have been with a dry run.
+	Rewritten []string `json:"rewritten,omitempty"`
+}
+
+// versionKey returns the key of the marker of a version of the snapshots of a URL, an empty blob listed next to the
+// other versions of the URL, so that they can be counted without reading the jobs. Markers sort from the oldest to
+// the most recent, and end with the ID of the job the snapshot is a delta against, if any.
+func versionKey(tenant string, url string, finishedAt time.Time, id string, base string) string {
+	key := fmt.Sprintf("%sversions/%s/%020d-%s", tenantPrefix(tenant), urlHash(url), finishedAt.UnixNano(), id)
+	if base != "" {
+		key += "-" + base
+	}
+
+	return key
+}
+
+// urlHash returns the hash identifying a URL in the keys of the store.
+func urlHash(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:16])
+}
+
+// storedBlob is a blob of the store of the daemon, as classified by CollectGarbage().
+type storedBlob struct {
+	antidote.StoredBlob
+
+	// job is the key of the job of a version marker, or of the job itself.
+	job string
+
+	// base is the key of the job the snapshot of the job of a version marker is a delta against, if any.
+	base string
+
+	// group is the prefix shared by the markers of the versions of the same URL, and the group of the versions
+	// of the URL of a latest version pointer, see latestKey().
+	group string
+
+	// asset is the key of a shared asset, or of the shared asset of a reference, see referenceKey().
+	asset string
+}
+
+// CollectGarbage removes the jobs and the cache entries of the store of a queue, see QueueOptions.Store, that the
+// policy does not retain: the versions of a URL beyond RetentionPolicy.MaxVersions, then the blobs older than
+// RetentionPolicy.MaxAge, then the oldest blobs until RetentionPolicy.MaxBytes is met. Jobs stored before versions
+// were recorded are not counted as versions of their URL. The snapshots kept as deltas against a version deleted
+// are rewritten in full first, and the shared assets are deleted along with the last job referencing them. With
+// dryRun, nothing is deleted nor rewritten.
+func CollectGarbage(store antidote.ListableStore, policy RetentionPolicy, now time.Time, dryRun bool) (*GCReport, error) {
+	listed, err := store.List("")
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs []*storedBlob
+	markers := make(map[string][]*storedBlob)
+	jobs := make(map[string]*storedBlob)
+	var pointers, references, assets []*storedBlob
+	for _, listedBlob := range listed {
+		blob := &storedBlob{StoredBlob: listedBlob}
+		prefix, rest := splitTenantPrefix(blob.Key)
+
+		switch {
+		case strings.HasPrefix(rest, "versions/"):
+			slash := strings.LastIndexByte(rest, '/')
+			fields := strings.Split(rest[slash+1:], "-")
+			if len(fields) < 2 || len(fields) > 3 {
+				continue
+			}
+			blob.group = prefix + rest[:slash]
+			blob.job = prefix + jobKey(fields[1])
+			if len(fields) == 3 {
+				blob.base = prefix + jobKey(fields[2])
+			}
+			markers[blob.group] = append(markers[blob.group], blob)
+		case strings.HasPrefix(rest, "latest/"):
+			blob.group = prefix + "versions/" + strings.TrimPrefix(rest, "latest/")
+			pointers = append(pointers, blob)
+		case strings.HasPrefix(rest, "refs/"):
+			fields := strings.Split(strings.TrimPrefix(rest, "refs/"), "/")
+			if len(fields) != 2 {
+				continue
+			}
+			blob.asset = prefix + "assets/" + fields[0]
+			blob.job = prefix + jobKey(fields[1])
+			references = append(references, blob)
+		case strings.HasPrefix(rest, "assets/"):
+			blob.asset = blob.Key
+			assets = append(assets, blob)
+		case strings.HasPrefix(rest, "jobs/"):
+			blob.job = blob.Key
+			jobs[blob.Key] = blob
+		case !strings.HasPrefix(rest, "cache/"):
+			// The latest screenshots of QueueOptions.VisualDiff, one per URL, are not versions, so they are left
+			// alone, as is anything not written by the daemon.
+			continue
+		}
+
+		blobs = append(blobs, blob)
+	}
+
+	deleted := make(map[string]bool)
+	remove := func(blob *storedBlob) {
+		deleted[blob.Key] = true
+		if blob.job != "" {
+			deleted[blob.job] = true
+		}
+	}
+
+	for _, versions := range markers {
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Key > versions[j].Key })
+		for i, marker := range versions {
+			if _, ok := jobs[marker.job]; !ok || policy.MaxVersions > 0 && i >= policy.MaxVersions {
+				remove(marker)
+			}
+		}
+	}
+
+	// The shared assets and their references are deleted along with the jobs, not by themselves.
+	if policy.MaxAge > 0 {
+		for _, blob := range blobs {
+			if blob.asset == "" && now.Sub(blob.Modified) > policy.MaxAge {
+				remove(blob)
+			}
+		}
+	}
+
+	// The markers of deleted jobs are deleted along with them.
+	for _, versions := range markers {
+		for _, marker := range versions {
+			if deleted[marker.job] {
+				deleted[marker.Key] = true
+			}
+		}
+	}
+
+	// The references of the jobs left are counted, so that the size of a shared asset is only freed along with
+	// the last job referencing it.
+	counts := make(map[string]int)
+	jobReferences := make(map[string][]*storedBlob)
+	for _, reference := range references {
+		if !deleted[reference.job] {
+			counts[reference.asset]++
+			jobReferences[reference.job] = append(jobReferences[reference.job], reference)
+		}
+	}
+
+	if policy.MaxBytes > 0 {
+		sizes := make(map[string]int64)
+		var total int64
+		for _, blob := range blobs {
+			if blob.asset == blob.Key {
+				sizes[blob.Key] = blob.Size
+			}
+			if !deleted[blob.Key] {
+				total += blob.Size
+			}
+		}
+
+		sort.SliceStable(blobs, func(i, j int) bool { return blobs[i].Modified.Before(blobs[j].Modified) })
+		for _, blob := range blobs {
+			if total <= policy.MaxBytes {
+				break
+			}
+			if deleted[blob.Key] || blob.asset != "" || blob.job != "" && blob.job != blob.Key {
+				continue
+			}
+
+			remove(blob)
+			total -= blob.Size
+			for _, reference := range jobReferences[blob.Key] {
+				if counts[reference.asset]--; counts[reference.asset] == 0 {
+					total -= sizes[reference.asset]
+				}
+			}
+			for _, versions := range markers {
+				for _, marker := range versions {
+					if marker.job == blob.Key {
+						deleted[marker.Key] = true
+					}
+				}
+			}
+		}
+	}
+
+	// A pointer to the latest version of a URL is deleted along with the last of its versions.
+	for _, pointer := range pointers {
+		kept := false
+		for _, marker := range markers[pointer.group] {
+			kept = kept || !deleted[marker.Key]
+		}
+		if !kept {
+			deleted[pointer.Key] = true
+		}
+	}
+
+	// The references of the jobs deleted are deleted with them, and those written for a job that was never stored
+	// once they are older than referenceGrace, and the same goes for the shared assets they reference.
+	counts = make(map[string]int)
+	for _, reference := range references {
+		_, stored := jobs[reference.job]
+		if deleted[reference.job] || !stored && now.Sub(reference.Modified) > referenceGrace {
+			deleted[reference.Key] = true
+			continue
+		}
+		counts[reference.asset]++
+	}
+	for _, asset := range assets {
+		if counts[asset.Key] == 0 && now.Sub(asset.Modified) > referenceGrace {
+			deleted[asset.Key] = true
+		}
+	}
+
+	report := &GCReport{Deleted: []string{}}
+
+	// The deltas kept against a version deleted are rewritten before anything is deleted, so that they can still be
+	// read if the collection fails halfway.
+	for _, versions := range markers {
+		for _, marker := range versions {
+			if marker.base == "" || deleted[marker.job] || !deleted[marker.base] {
+				continue
+			}
+
+			if !dryRun {
+				if err := storeInFull(store, marker); err != nil {
+					return report, err
+				}
+			}
+			report.Rewritten = append(report.Rewritten, marker.job)
+			deleted[marker.Key] = true
+		}
+	}
+	sort.Strings(report.Rewritten)
+
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Key < blobs[j].Key })
+	for _, blob := range blobs {
+		if !deleted[blob.Key] {
+			report.Kept++
+			report.KeptBytes += blob.Size
+			continue
+		}
+
+		if !dryRun {
+			if err := store.Delete(blob.Key); err != nil {
+				return report, err
+			}
+		}
+		report.Deleted = append(report.Deleted, blob.Key)
+		report.DeletedBytes += blob.Size
+	}
+
+	return report, nil
+}
+
+// storeInFull rewrites the job of a version marker whose snapshot is a delta with its full snapshot, and replaces
+// the marker with one without the base of the delta.
+func storeInFull(store antidote.Store, marker *storedBlob) error {
+	prefix, _ := splitTenantPrefix(marker.Key)
+	id := strings.TrimSuffix(strings.TrimPrefix(marker.job, prefix+"jobs/"), ".json")
+
+	stored, err := loadJob(store, prefix, id)
+	if err != nil {
+		return err
+	}
+	if stored.Snapshot, err = expandSnapshot(store, prefix, stored); err != nil {
+		return err
+	}
+	stored.Delta = nil
+
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	if err := store.Put(marker.job, b); err != nil {
+		return err
+	}
+
+	return store.Put(marker.Key[:strings.LastIndexByte(marker.Key, '-')], nil)
+}
+
+// splitTenantPrefix splits a key of the store into the prefix of its tenant, see tenantPrefix(), and the rest.
+func splitTenantPrefix(key string) (string, string) {
+	if !strings.HasPrefix(key, "tenants/") {
+		return "", key
+	}
+
+	end := strings.IndexByte(key[len("tenants/"):], '/')
+	if end < 0 {
+		return "", key
+	}
+	end += len("tenants/") + 1
+
+	return key[:end], key[end:]
+}
+
+// collectGarbage collects the garbage of the store of the queue every QueueOptions.CollectInterval, until the
+// queue is closed.
+func (q *Queue) collectGarbage(store antidote.ListableStore) {
+	interval := q.options.CollectInterval
+	if interval <= 0 {
+		interval = DefaultCollectInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.stopped:
+			return
+		case <-ticker.C:
+		}
+
+		report, err := CollectGarbage(store, q.options.StoreRetention, time.Now(), false)
+		if err != nil {
+			log.Printf("collecting the garbage of the store: %v", err)
+		}
+		if report != nil && len(report.Deleted) > 0 {
+			log.Printf("collected %d blobs (%d bytes) of the store", len(report.Deleted), report.DeletedBytes)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+)
+
+// maxSaveSize is the maximum size in bytes of the body of POST /save.
+const maxSaveSize = 64 << 20
+
+// Page object represents a page captured by a browser, e.g. by an extension saving the page being viewed, which
+// is cured instead of fetching its URL. The cookies of the browser are sent with the requests for its assets,
+// so those behind a login are cured as well.
+type Page struct {
+	// URL is the URL of the page, which its relative references are resolved against.
+	URL string `json:"url"`
+
+	// HTML is the DOM of the page, e.g. document.documentElement.outerHTML.
+	HTML string `json:"html"`
+
+	// Cookies are the cookies of the browser for the page and its assets.
+	Cookies []Cookie `json:"cookies,omitempty"`
+}
+
+// Cookie object represents a cookie of a browser, with the fields of the cookies API of browser extensions.
+type Cookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+
+	// Domain is the domain the cookie is sent to, with a leading "." if it is sent to its subdomains as well.
+	// Empty means the host of the page.
+	Domain string `json:"domain,omitempty"`
+
+	// HostOnly only sends the cookie to Domain itself, not to its subdomains, whether it has a leading "." or not.
+	HostOnly bool `json:"hostOnly,omitempty"`
+
+	Path   string `json:"path,omitempty"`
+	Secure bool   `json:"secure,omitempty"`
+}
+
+// jar returns a cookie jar holding the cookies of the page.
+func (p *Page) jar() (http.CookieJar, error) {
+	page, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cookie := range p.Cookies {
+		host := strings.TrimPrefix(cookie.Domain, ".")
+		if host == "" {
+			host = page.Hostname()
+		}
+
+		path := cookie.Path
+		if path == "" {
+			path = "/"
+		}
+
+		c := &http.Cookie{Name: cookie.Name, Value: cookie.Value, Path: path, Secure: cookie.Secure}
+		if !cookie.HostOnly && cookie.Domain != "" {
+			c.Domain = host
+		}
+
+		// The cookies are set from https, so that secure cookies are kept.
+		jar.SetCookies(&url.URL{Scheme: "https", Host: host, Path: path}, []*http.Cookie{c})
+	}
+
+	return jar, nil
+}
+
+// saveRequest is the body accepted by POST /save.
+type saveRequest struct {
+	Page
+	JobOptions
+}
+
+// savePreflight answers the CORS preflight requests of POST /save. Extensions may call it from any origin, as it
+// is authenticated by its token rather than by cookies.
+func (s *Server) savePreflight(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", http.MethodPost)
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// save cures a page sent by a browser extension as a job, see Server.SaveToken. When the daemon has tenants, the
+// request is authenticated with the key of a tenant instead.
+func (s *Server) save(w http.ResponseWriter, r *http.Request, tenant *Tenant) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if tenant == nil {
+		if s.SaveToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.SaveToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+	}
+
+	var req saveRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSaveSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	if req.URL == "" || req.HTML == "" {
+		writeError(w, http.StatusBadRequest, "url and html are required")
+		return
+	}
+
+	if _, err := req.Page.jar(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid url: "+err.Error())
+		return
+	}
+
+	job, err := s.queue.submit(tenant, r.RemoteAddr, req.URL, &req.Page, req.JobOptions)
+	if err != nil {
+		writeSubmitError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
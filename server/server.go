@@ -0,0 +1,464 @@
+// Package server provides the HTTP API of the antidote daemon, which cures websites asynchronously
+// through a job queue, the caching forward proxy it runs as for development, and the server replaying a
+// local archive of snapshots.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lansana/antidote"
+	"golang.org/x/net/websocket"
+)
+
+//go:embed ui/index.html
+var uiHTML []byte
+
+// Server object provides the HTTP API of the daemon, along with a web UI served at the root.
+//
+//	GET  /                   the web UI
+//	GET  /jobs               list every job, most recently submitted first
+//	POST /jobs               submit a URL, responds with the queued job
+//	GET  /jobs/{id}          poll the status of a job
+//	GET  /jobs/{id}/events   subscribe to the progress of a job (server-sent events, or a WebSocket)
+//	GET  /jobs/{id}/result   fetch the snapshot of a finished job (?format=html for the raw HTML)
+//	POST /jobs/{id}/share    create an expiring link to the snapshot of a finished job, see Server.ShareKey
+//	GET  /shared/{id}        open the snapshot of a job with a link created by POST /jobs/{id}/share
+//	POST /save               submit a page captured by a browser extension, see Server.SaveToken
+//	GET  /healthz            liveness probe, 200 as long as the daemon serves requests
+//	GET  /readyz             readiness probe, 503 while the queue is full or draining (see Queue.Close())
+//
+// When the queue has tenants (see QueueOptions.Tenants), the requests to /jobs and /save are authenticated with
+// their keys, and every tenant only sees its own jobs.
+//
+// When the queue is the coordinator of a cluster (see QueueOptions.Broker), the workers use, authenticated with
+// Server.ClusterSecret:
+//
+//	GET  /cluster/tasks        pull the next task, waiting for one for up to 30 seconds (204 if none)
+//	POST /cluster/results      report the result of a task
+//	GET  /cluster/cache/{key}  read an entry of the shared asset cache (QueueOptions.Defaults.Cache)
+//	PUT  /cluster/cache/{key}  write an entry of the shared asset cache
+type Server struct {
+	// SaveToken is the bearer token POST /save requires, which lets a browser extension save the page being
+	// viewed in one click: it posts the DOM of the page along with the cookies of the browser, and the page is
+	// cured as a job, with the cookies sent for its assets. POST /save is disabled while it is empty.
+	SaveToken string
+
+	// ShareKey is the secret the links created by POST /jobs/{id}/share are signed with, which let anyone open
+	// the snapshot of a single job until they expire, without an API key, e.g. to share it outside of the team.
+	// The snapshot must still be held by the queue, or by QueueOptions.Store. Sharing is disabled while it is
+	// empty, and changing it revokes every link.
+	ShareKey []byte
+
+	// MaxShareTTL is the longest a share link may be valid. Zero means no limit.
+	MaxShareTTL time.Duration
+
+	// ClusterSecret is the bearer token the /cluster endpoints require, shared by the coordinator and its workers
+	// (see HTTPBroker.Secret and HTTPCache.Secret), as they hand out the jobs of every tenant and their
+	// snapshots. The endpoints are disabled while it is empty.
+	ClusterSecret string
+
+	queue *Queue
+}
+
+// New creates a new instance of a Server pointer that runs its jobs on the given queue.
+func New(queue *Queue) *Server {
+	return &Server{queue: queue}
+}
+
+// submitRequest is the body accepted by POST /jobs.
+type submitRequest struct {
+	URL string `json:"url"`
+	JobOptions
+}
+
+// ServeHTTP routes a request to the matching endpoint.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+
+	if path == "" {
+		s.allow(w, r, http.MethodGet, s.ui)
+		return
+	}
+
+	if path == "healthz" {
+		s.allow(w, r, http.MethodGet, s.healthz)
+		return
+	}
+
+	if path == "readyz" {
+		s.allow(w, r, http.MethodGet, s.readyz)
+		return
+	}
+
+	if path == "save" && r.Method == http.MethodOptions {
+		s.savePreflight(w, r)
+		return
+	}
+
+	if parts[0] == "shared" && len(parts) == 2 {
+		s.allow(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) { s.shared(w, r, parts[1]) })
+		return
+	}
+
+	if parts[0] == "cluster" && s.queue.options.Broker != nil && s.ClusterSecret != "" {
+		s.cluster(w, r, parts[1:])
+		return
+	}
+
+	if path != "save" && (parts[0] != "jobs" || len(parts) > 3) {
+		http.NotFound(w, r)
+		return
+	}
+
+	tenant, ok := s.tenant(w, r)
+	if !ok {
+		return
+	}
+
+	switch {
+	case path == "save":
+		s.allow(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) { s.save(w, r, tenant) })
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.list(w, r, tenant)
+	case len(parts) == 1:
+		s.allow(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) { s.submit(w, r, tenant) })
+	case len(parts) == 2:
+		s.allow(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) { s.status(w, r, tenant, parts[1]) })
+	case parts[2] == "events":
+		s.allow(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) { s.events(w, r, tenant, parts[1]) })
+	case parts[2] == "result":
+		s.allow(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) { s.result(w, r, tenant, parts[1]) })
+	case parts[2] == "share":
+		s.allow(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) { s.share(w, r, tenant, parts[1]) })
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// allow only calls the handler if the request uses the given method.
+func (s *Server) allow(w http.ResponseWriter, r *http.Request, method string, handler http.HandlerFunc) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s is not allowed", r.Method))
+		return
+	}
+
+	handler(w, r)
+}
+
+func (s *Server) ui(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(uiHTML)
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if err := s.queue.ready(); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, tenant *Tenant) {
+	jobs := []Job{}
+	for _, job := range s.queue.Jobs() {
+		if job.Tenant == tenantName(tenant) {
+			jobs = append(jobs, job)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *Server) submit(w http.ResponseWriter, r *http.Request, tenant *Tenant) {
+	var req submitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	if req.URL == "" {
+		writeError(w, http.StatusBadRequest, "url is required")
+		return
+	}
+
+	job, err := s.queue.submit(tenant, r.RemoteAddr, req.URL, nil, req.JobOptions)
+	if err != nil {
+		writeSubmitError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// writeSubmitError writes the error of a job that could not be submitted.
+func writeSubmitError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrQueueFull, ErrQueueClosed:
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case ErrRateLimited:
+		w.Header().Set("Retry-After", "60")
+		writeError(w, http.StatusTooManyRequests, err.Error())
+	case ErrQuotaExceeded:
+		writeError(w, http.StatusTooManyRequests, err.Error())
+	case ErrHostNotAllowed:
+		writeError(w, http.StatusForbidden, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request, tenant *Tenant, id string) {
+	job, _, ok := s.queue.result(tenantName(tenant), id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) events(w http.ResponseWriter, r *http.Request, tenant *Tenant, id string) {
+	if _, _, ok := s.queue.result(tenantName(tenant), id); !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		s.eventsWebSocket(w, r, id)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	events, unsubscribe, ok := s.queue.Subscribe(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			b, err := json.Marshal(event)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, b)
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *Server) result(w http.ResponseWriter, r *http.Request, tenant *Tenant, id string) {
+	ok := s.queue.view(tenantName(tenant), id, func(job Job, snapshot *antidote.Snapshot) {
+		switch job.Status {
+		case JobFailed:
+			writeError(w, http.StatusUnprocessableEntity, job.Error)
+			return
+		case JobQueued, JobRunning:
+			writeError(w, http.StatusConflict, "job is not finished")
+			return
+		}
+
+		if r.URL.Query().Get("format") == "html" {
+			writeSnapshot(w, snapshot)
+			return
+		}
+
+		var html strings.Builder
+		if err := snapshot.WriteHTML(&html); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		expanded := *snapshot
+		expanded.HTML = html.String()
+
+		writeJSON(w, http.StatusOK, &expanded)
+	})
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+	}
+}
+
+// eventsWebSocket streams the progress of a job over a WebSocket, one JSON encoded Event per message, until the
+// job has finished or the client closes the connection. The events are not secret, so any origin may subscribe.
+func (s *Server) eventsWebSocket(w http.ResponseWriter, r *http.Request, id string) {
+	events, unsubscribe, ok := s.queue.Subscribe(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	defer unsubscribe()
+
+	handler := func(ws *websocket.Conn) {
+		// The client sends nothing but close frames, which end the read.
+		closed := make(chan struct{})
+		go (func() {
+			io.Copy(ioutil.Discard, ws)
+			close(closed)
+		})()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+
+				if err := websocket.JSON.Send(ws, event); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	websocket.Server{Handler: handler}.ServeHTTP(w, r)
+}
+
+// pullTimeout is how long GET /cluster/tasks waits for a task before responding with no content.
+const pullTimeout = 30 * time.Second
+
+// cluster routes a request of a worker to the matching endpoint, once authenticated with Server.ClusterSecret.
+func (s *Server) cluster(w http.ResponseWriter, r *http.Request, parts []string) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.ClusterSecret)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "invalid or missing cluster secret")
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "tasks":
+		s.allow(w, r, http.MethodGet, s.pull)
+	case len(parts) == 1 && parts[0] == "results":
+		s.allow(w, r, http.MethodPost, s.report)
+	case len(parts) == 2 && parts[0] == "cache" && s.queue.cache() != nil:
+		if r.Method == http.MethodPut {
+			s.cacheSet(w, r, parts[1])
+			return
+		}
+		s.allow(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) { s.cacheGet(w, r, parts[1]) })
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) pull(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), pullTimeout)
+	defer cancel()
+
+	task, err := s.queue.options.Broker.Pull(ctx)
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, task)
+	case ErrNoTask:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (s *Server) report(w http.ResponseWriter, r *http.Request) {
+	var result TaskResult
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	if err := s.queue.options.Broker.Report(result); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) cacheGet(w http.ResponseWriter, r *http.Request, key string) {
+	value, ok := s.queue.cache().Get(key)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(value)
+}
+
+func (s *Server) cacheSet(w http.ResponseWriter, r *http.Request, key string) {
+	value, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.queue.cache().Set(key, value)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeSnapshot writes the HTML of a snapshot, streaming the data of its assets spilled to disk from their
+// temporary files. The page is sandboxed by snapshotPolicy, and its requests do not leak the URL it is served at.
+func writeSnapshot(w http.ResponseWriter, snapshot *antidote.Snapshot) {
+	w.Header().Set("Content-Security-Policy", snapshotPolicy)
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	if snapshot.XHTML {
+		w.Header().Set("Content-Type", "application/xhtml+xml; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+
+	if err := snapshot.WriteHTML(w); err != nil {
+		log.Println(err)
+	}
+}
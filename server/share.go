@@ -0,0 +1,133 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/lansana/antidote"
+)
+
+// DefaultShareTTL is how long a share link is valid when its request does not say, see Server.ShareKey.
+const DefaultShareTTL = 24 * time.Hour
+
+// snapshotPolicy is the Content-Security-Policy of the snapshots served by the daemon, which runs them in a
+// unique origin, so that their scripts can not reach the API of the daemon.
+const snapshotPolicy = "sandbox allow-scripts allow-popups allow-forms"
+
+// shareRequest is the body accepted by POST /jobs/{id}/share. It may be empty.
+type shareRequest struct {
+	// TTL is how long the link is valid, e.g. "1h". Defaults to DefaultShareTTL, and is capped at
+	// Server.MaxShareTTL.
+	TTL string `json:"ttl"`
+}
+
+// ShareLink object represents a link to the snapshot of a job that can be opened without an API key.
+type ShareLink struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// share creates a link to the snapshot of a finished job, signed with Server.ShareKey.
+func (s *Server) share(w http.ResponseWriter, r *http.Request, tenant *Tenant, id string) {
+	if len(s.ShareKey) == 0 {
+		writeError(w, http.StatusNotFound, "sharing is disabled")
+		return
+	}
+
+	var req shareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	ttl := DefaultShareTTL
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid ttl %q", req.TTL))
+			return
+		}
+	}
+	if s.MaxShareTTL > 0 && ttl > s.MaxShareTTL {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("ttl is longer than the maximum of %s", s.MaxShareTTL))
+		return
+	}
+
+	job, _, ok := s.queue.result(tenantName(tenant), id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	if job.Status != JobDone {
+		writeError(w, http.StatusConflict, "job is not finished")
+		return
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	query := url.Values{}
+	if tenant != nil {
+		query.Set("tenant", tenant.Name)
+	}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", s.shareSignature(tenantName(tenant), id, expires.Unix()))
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	link := &url.URL{Scheme: scheme, Host: r.Host, Path: "/shared/" + id, RawQuery: query.Encode()}
+
+	writeJSON(w, http.StatusCreated, ShareLink{URL: link.String(), Expires: expires})
+}
+
+// shareSignature returns the signature of a link to the snapshot of a job of a tenant, valid until expires.
+func (s *Server) shareSignature(tenant string, id string, expires int64) string {
+	mac := hmac.New(sha256.New, s.ShareKey)
+	fmt.Fprintf(mac, "%s\n%s\n%d", tenant, id, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// shared serves the snapshot of a job as HTML to anyone with a valid link created by POST /jobs/{id}/share.
+func (s *Server) shared(w http.ResponseWriter, r *http.Request, id string) {
+	if len(s.ShareKey) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	tenant := query.Get("tenant")
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	signature := []byte(s.shareSignature(tenant, id, expires))
+	if err != nil || !hmac.Equal(signature, []byte(query.Get("signature"))) {
+		writeError(w, http.StatusForbidden, "invalid link")
+		return
+	}
+
+	remaining := time.Until(time.Unix(expires, 0))
+	if remaining <= 0 {
+		writeError(w, http.StatusGone, "the link has expired")
+		return
+	}
+
+	found := s.queue.view(tenant, id, func(job Job, snapshot *antidote.Snapshot) {
+		if job.Status != JobDone {
+			writeError(w, http.StatusNotFound, "job not found")
+			return
+		}
+
+		// The link must not outlive its expiry in caches.
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(remaining.Seconds())))
+		writeSnapshot(w, snapshot)
+	})
+	if !found {
+		writeError(w, http.StatusNotFound, "job not found")
+	}
+}
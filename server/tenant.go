@@ -0,0 +1,222 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lansana/antidote"
+)
+
+// ErrRateLimited is returned when a tenant submits more jobs than its Tenant.RateLimit allows.
+var ErrRateLimited = errors.New("too many jobs submitted, retry in a minute")
+
+// ErrQuotaExceeded is returned when a tenant submits a job after its snapshots used up its Tenant.Quota.
+var ErrQuotaExceeded = errors.New("the quota of the day has been used up")
+
+// ErrHostNotAllowed is returned when a tenant submits a URL whose host is not one of its Tenant.Hosts.
+var ErrHostNotAllowed = errors.New("the host of the URL is not allowed")
+
+// Tenant object represents a team sharing the daemon with others, see QueueOptions.Tenants. Its requests are
+// authenticated with its key, and it only sees its own jobs.
+type Tenant struct {
+	// Name identifies the tenant in its jobs, see Job.Tenant.
+	Name string
+
+	// Key is the API key the requests of the tenant are authenticated with, given as a bearer token in the
+	// Authorization header, or in the key query parameter for clients that can not set headers, e.g. EventSource.
+	Key string
+
+	// RateLimit is the number of jobs the tenant may submit per minute. Zero means no limit.
+	RateLimit int
+
+	// Quota is the total size in bytes of the snapshots of the jobs the tenant may run per day (UTC). Jobs
+	// running when the quota is used up still finish. Zero means no limit.
+	Quota int64
+
+	// Hosts are the hosts of the URLs the tenant may submit. A leading "*." matches every subdomain, e.g.
+	// "*.example.com". Empty allows every host.
+	Hosts []string
+
+	// Config overrides the default ingredients the jobs of the tenant are cured with.
+	Config TenantConfig
+
+	submitted []time.Time
+	used      int64
+	day       string
+	mu        sync.Mutex
+}
+
+// TenantConfig object represents the ingredients a tenant overrides. Zero values keep the defaults.
+type TenantConfig struct {
+	Concurrency  int           `json:"concurrency,omitempty"`
+	Timeout      time.Duration `json:"timeout,omitempty"`
+	Deadline     time.Duration `json:"deadline,omitempty"`
+	MaxAssetSize int64         `json:"maxAssetSize,omitempty"`
+	MaxTotalSize int64         `json:"maxTotalSize,omitempty"`
+}
+
+// apply overrides the ingredients with the configuration.
+func (c *TenantConfig) apply(ingredients *antidote.Ingredients) {
+	if c.Concurrency > 0 {
+		ingredients.Concurrency = c.Concurrency
+	}
+	if c.Timeout > 0 {
+		ingredients.Timeout = c.Timeout
+	}
+	if c.Deadline > 0 {
+		ingredients.Deadline = c.Deadline
+	}
+	if c.MaxAssetSize > 0 {
+		ingredients.MaxAssetSize = c.MaxAssetSize
+	}
+	if c.MaxTotalSize > 0 {
+		ingredients.MaxTotalSize = c.MaxTotalSize
+	}
+}
+
+// tenantPrefix returns the prefix the jobs and the asset cache of a tenant are kept under in QueueOptions.Store,
+// which is empty without a tenant.
+func tenantPrefix(name string) string {
+	if name == "" {
+		return ""
+	}
+
+	return "tenants/" + name + "/"
+}
+
+// allows reports whether the tenant may submit a URL.
+func (t *Tenant) allows(rawurl string) bool {
+	if len(t.Hosts) == 0 {
+		return true
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+
+	for _, pattern := range t.Hosts {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) || host == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+// admit records the submission of a job, unless it exceeds the rate limit or the quota of the tenant.
+func (t *Tenant) admit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.Quota > 0 && t.today() >= t.Quota {
+		return ErrQuotaExceeded
+	}
+
+	if t.RateLimit > 0 {
+		now := time.Now()
+		recent := t.submitted[:0]
+		for _, at := range t.submitted {
+			if now.Sub(at) < time.Minute {
+				recent = append(recent, at)
+			}
+		}
+		t.submitted = recent
+
+		if len(t.submitted) >= t.RateLimit {
+			return ErrRateLimited
+		}
+		t.submitted = append(t.submitted, now)
+	}
+
+	return nil
+}
+
+// use adds the size of a snapshot to the quota used today.
+func (t *Tenant) use(snapshot *antidote.Snapshot) {
+	size := snapshotSize(snapshot)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.used = t.today() + size
+}
+
+// inherit takes over the usage of the rate limit and of the quota of a tenant replaced by a reload.
+func (t *Tenant) inherit(old *Tenant) {
+	old.mu.Lock()
+	defer old.mu.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.submitted = append([]time.Time{}, old.submitted...)
+	t.used = old.used
+	t.day = old.day
+}
+
+// today returns the quota used today. The mutex of the tenant must be held.
+func (t *Tenant) today() int64 {
+	if day := time.Now().UTC().Format("2006-01-02"); day != t.day {
+		t.day = day
+		t.used = 0
+	}
+
+	return t.used
+}
+
+// tenant authenticates a request against QueueOptions.Tenants. It returns a nil tenant when the daemon has none,
+// and writes an error and reports false when the key of the request is not the one of a tenant.
+func (s *Server) tenant(w http.ResponseWriter, r *http.Request) (*Tenant, bool) {
+	tenants := s.queue.tenants()
+	if len(tenants) == 0 {
+		return nil, true
+	}
+
+	key := r.URL.Query().Get("key")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	match := matchTenant(tenants, key)
+	if match == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "invalid or missing API key")
+		return nil, false
+	}
+
+	return match, true
+}
+
+// matchTenant returns the tenant whose key is the given one, or nil if there is none.
+func matchTenant(tenants []*Tenant, key string) *Tenant {
+	if key == "" {
+		return nil
+	}
+
+	// Every key is compared, so the time taken does not tell which tenant a key is close to.
+	var match *Tenant
+	for _, tenant := range tenants {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(tenant.Key)) == 1 {
+			match = tenant
+		}
+	}
+
+	return match
+}
+
+// tenantName returns the name of a tenant, which is empty without one.
+func tenantName(tenant *Tenant) string {
+	if tenant == nil {
+		return ""
+	}
+
+	return tenant.Name
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/lansana/antidote"
+)
+
+// DefaultVisualThreshold is the structural similarity under which the page of a job changed visually, unless
+// VisualDiffOptions.Threshold is set.
+const DefaultVisualThreshold = 0.99
+
+// captureScreenshot captures the screenshot of a snapshot, see antidote.Snapshot.CaptureScreenshot().
+var captureScreenshot = (*antidote.Snapshot).CaptureScreenshot
+
+// VisualDiffOptions object represents options for the visual change detection of a queue, see
+// QueueOptions.VisualDiff.
+type VisualDiffOptions struct {
+	// Screenshot are the options the snapshots are captured with. They are always captured as PNG.
+	Screenshot antidote.ScreenshotOptions
+
+	// Threshold is the structural similarity under which the page changed visually, see
+	// antidote.VisualDiff.Changed(). Defaults to DefaultVisualThreshold.
+	Threshold float64
+}
+
+// VisualChange object represents how the screenshot of the snapshot of a job compares with the previous one of
+// its URL, see QueueOptions.VisualDiff.
+type VisualChange struct {
+	// Changed is set when the structural similarity of the screenshots is under VisualDiffOptions.Threshold, even
+	// if the HTML of the page did not change, e.g. when only its stylesheets did.
+	Changed bool `json:"changed"`
+
+	// SSIM and ChangedRatio are those of antidote.VisualDiff.
+	SSIM         float64 `json:"ssim"`
+	ChangedRatio float64 `json:"changedRatio"`
+}
+
+// compareScreenshot captures the screenshot of the snapshot of a job and compares it with the previous one of its
+// URL, which it then replaces. It returns nil for the first screenshot of the URL, or if it could not be captured
+// or compared, which is published as a warning of the job.
+func (q *Queue) compareScreenshot(e *entry, snapshot *antidote.Snapshot) *VisualChange {
+	options := q.options.VisualDiff.Screenshot
+	options.Format = antidote.ScreenshotPNG
+
+	screenshot, err := captureScreenshot(snapshot, options)
+	if err != nil {
+		q.warn(e, fmt.Sprintf("could not capture the screenshot of the page: %v", err))
+		return nil
+	}
+
+	key := screenshotKey(e.job.Tenant, e.job.URL)
+	previous, err := q.previousScreenshot(key)
+	if err != nil && !errors.Is(err, antidote.ErrNotStored) {
+		q.warn(e, fmt.Sprintf("could not read the previous screenshot of the page: %v", err))
+	}
+	if err := q.saveScreenshot(key, screenshot); err != nil {
+		q.warn(e, fmt.Sprintf("could not save the screenshot of the page: %v", err))
+	}
+	if previous == nil {
+		return nil
+	}
+
+	diff, err := antidote.CompareScreenshots(previous, screenshot)
+	if err != nil {
+		q.warn(e, fmt.Sprintf("could not compare the screenshot of the page: %v", err))
+		return nil
+	}
+
+	threshold := q.options.VisualDiff.Threshold
+	if threshold <= 0 {
+		threshold = DefaultVisualThreshold
+	}
+
+	change := &VisualChange{Changed: diff.Changed(threshold), SSIM: diff.SSIM, ChangedRatio: diff.ChangedRatio}
+	if change.Changed {
+		log.Printf("job %s: %s changed visually (SSIM %.4f, %.2f%% of the pixels)", e.job.ID, e.job.URL, diff.SSIM, 100*diff.ChangedRatio)
+	}
+
+	return change
+}
+
+// previousScreenshot returns the screenshot kept under a key, from QueueOptions.Store if it is set.
+func (q *Queue) previousScreenshot(key string) ([]byte, error) {
+	if q.options.Store != nil {
+		return q.options.Store.Get(key)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	screenshot, ok := q.screenshots[key]
+	if !ok {
+		return nil, antidote.ErrNotStored
+	}
+
+	return screenshot, nil
+}
+
+// saveScreenshot keeps a screenshot under a key, in QueueOptions.Store if it is set, or in memory otherwise.
+func (q *Queue) saveScreenshot(key string, screenshot []byte) error {
+	if q.options.Store != nil {
+		return q.options.Store.Put(key, screenshot)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.screenshots == nil {
+		q.screenshots = make(map[string][]byte)
+	}
+	q.screenshots[key] = screenshot
+
+	return nil
+}
+
+// warn publishes a warning of a job once its cure has run, and logs it.
+func (q *Queue) warn(e *entry, warning string) {
+	log.Printf("job %s: %s", e.job.ID, warning)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e.job.Warnings++
+	q.publish(e, Event{Type: EventWarning, Job: e.job, Warning: warning})
+}
+
+// screenshotKey returns the key the latest screenshot of a URL is kept under, which the next one is compared with.
+func screenshotKey(tenant string, url string) string {
+	return tenantPrefix(tenant) + "screenshots/" + urlHash(url) + ".png"
+}
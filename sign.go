@@ -0,0 +1,136 @@
+package antidote
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SignatureAlgorithm is the only algorithm snapshots are signed with.
+const SignatureAlgorithm = "ed25519"
+
+// Signature object represents the provenance of a snapshot: who signed it, what was captured and when.
+type Signature struct {
+	// Algorithm is the signature algorithm, always SignatureAlgorithm.
+	Algorithm string `json:"algorithm"`
+
+	// PublicKey is the base64 encoded public key of the signer.
+	PublicKey string `json:"publicKey"`
+
+	// URL and CapturedAt are the capture metadata covered by the signature.
+	URL        string    `json:"url"`
+	CapturedAt time.Time `json:"capturedAt"`
+
+	// SignedAt is when the snapshot was signed. It is not covered by the signature.
+	SignedAt time.Time `json:"signedAt"`
+
+	// Digest is the hex encoded SHA-256 hash of the canonical form of the snapshot.
+	Digest string `json:"digest"`
+
+	// Value is the base64 encoded signature of the digest.
+	Value string `json:"value"`
+}
+
+// canonical returns the canonical form of a snapshot that is signed: the capture metadata followed by the
+// SHA-256 hash of the page and of every extracted file, in order.
+func (s *Snapshot) canonical() ([]byte, error) {
+	pageHash, _, err := s.pageHash()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "antidote-snapshot-v1\n")
+	fmt.Fprintf(&b, "url %s\n", s.URL)
+	fmt.Fprintf(&b, "captured %s\n", s.StartedAt.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "page %s\n", pageHash)
+
+	for _, name := range s.fileNames() {
+		fmt.Fprintf(&b, "file %s %s\n", hashContent(string(s.Files[name])), name)
+	}
+
+	return b.Bytes(), nil
+}
+
+// digest returns the SHA-256 hash of the canonical form of the snapshot.
+func (s *Snapshot) digest() ([]byte, error) {
+	canonical, err := s.canonical()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(canonical)
+	return sum[:], nil
+}
+
+// Sign computes the digest of the snapshot, signs it with the private key and embeds the resulting
+// Signature in the snapshot, replacing any previous one.
+func (s *Snapshot) Sign(key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return errors.New("invalid ed25519 private key")
+	}
+
+	digest, err := s.digest()
+	if err != nil {
+		return err
+	}
+
+	s.Signature = &Signature{
+		Algorithm:  SignatureAlgorithm,
+		PublicKey:  base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+		URL:        s.URL,
+		CapturedAt: s.StartedAt,
+		SignedAt:   time.Now(),
+		Digest:     hex.EncodeToString(digest),
+		Value:      base64.StdEncoding.EncodeToString(ed25519.Sign(key, digest)),
+	}
+
+	return nil
+}
+
+// Verify checks that a snapshot carries a valid signature made with the private key of the public key,
+// and that neither its content nor its capture metadata changed since it was signed.
+func Verify(s *Snapshot, key ed25519.PublicKey) error {
+	sig := s.Signature
+	if sig == nil {
+		return errors.New("snapshot is not signed")
+	}
+
+	if sig.Algorithm != SignatureAlgorithm {
+		return fmt.Errorf("unsupported signature algorithm %q", sig.Algorithm)
+	}
+
+	if sig.PublicKey != base64.StdEncoding.EncodeToString(key) {
+		return errors.New("snapshot is signed by a different key")
+	}
+
+	if sig.URL != s.URL || !sig.CapturedAt.Equal(s.StartedAt) {
+		return errors.New("capture metadata does not match the signature")
+	}
+
+	digest, err := s.digest()
+	if err != nil {
+		return err
+	}
+
+	if sig.Digest != hex.EncodeToString(digest) {
+		return errors.New("snapshot content does not match the signature")
+	}
+
+	value, err := base64.StdEncoding.DecodeString(sig.Value)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+
+	if !ed25519.Verify(key, digest, value) {
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
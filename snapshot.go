@@ -0,0 +1,327 @@
+package antidote
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// AssetKind identifies the type of an external asset referenced by a website.
+type AssetKind string
+
+const (
+	AssetCSS   AssetKind = "css"
+	AssetJS    AssetKind = "js"
+	AssetImage AssetKind = "image"
+	AssetFont  AssetKind = "font"
+	AssetFrame AssetKind = "frame"
+)
+
+// Asset object represents a single external asset Antidote attempted to cure.
+type Asset struct {
+	// Kind is the type of the asset.
+	Kind AssetKind `json:"kind"`
+
+	// Source is the asset reference as it appeared in the original HTML.
+	Source string `json:"source"`
+
+	// URL is the normalized URL the asset was fetched from.
+	URL string `json:"url,omitempty"`
+
+	// Parent is the URL of the stylesheet or frame that referenced the asset, or empty if the page did.
+	Parent string `json:"parent,omitempty"`
+
+	// Size is the number of bytes fetched for the asset.
+	Size int `json:"size"`
+
+	// Hash is the hex encoded SHA-256 hash of the bytes of the asset.
+	Hash string `json:"hash,omitempty"`
+
+	// ContentType is the Content-Type the asset was served with.
+	ContentType string `json:"contentType,omitempty"`
+
+	// ETag and LastModified are the validators the asset was served with, used by Antidote.Recure().
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+
+	// Remote is set when the asset was larger than Ingredients.InlineLimit, or was not fetched before
+	// Ingredients.Deadline or Ingredients.MaxTotalSize were exceeded, so it was left as a reference to its URL.
+	Remote bool `json:"remote,omitempty"`
+
+	// Reused is set when the asset had not changed since a prior cure, so its bytes were reused from the cache.
+	Reused bool `json:"reused,omitempty"`
+
+	// Insecure is set when the page is served over https but the asset was fetched over plaintext http, e.g.
+	// because it was only reachable over http with Ingredients.RepairMixedContent.
+	Insecure bool `json:"insecure,omitempty"`
+
+	// Resumed is the number of times the download of the asset was interrupted and resumed, see
+	// Ingredients.ResumeAttempts.
+	Resumed int `json:"resumed,omitempty"`
+
+	// IntegrityMismatch is set when the asset did not match the integrity attribute of the element referencing
+	// it, but was inlined anyway with IntegrityReport.
+	IntegrityMismatch bool `json:"integrityMismatch,omitempty"`
+
+	// Duration is how long fetching the asset took, in nanoseconds when marshaled.
+	Duration time.Duration `json:"duration"`
+
+	// Error is the reason the asset could not be cured, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Snapshot object represents the structured result of curing a website.
+type Snapshot struct {
+	// FormatHeader is the version of the format of the snapshot, the release of Antidote that cured it and the
+	// ingredients it was cured with.
+	FormatHeader
+
+	// URL is the URL of the website that was cured, in its canonical form with Ingredients.Canonicalizer.
+	URL string `json:"url"`
+
+	// HTML is the cured HTML. If assets were spilled to disk (see Ingredients.SpillThreshold), their data
+	// is replaced by placeholders, and Snapshot.WriteHTML() or Snapshot.Expand() give the complete HTML.
+	HTML string `json:"html"`
+
+	// Metadata is the metadata of the page: its title, description, language, author...
+	Metadata Metadata `json:"metadata"`
+
+	// XHTML is set when the website is an XHTML document, in which case the cured HTML is valid XHTML as well.
+	XHTML bool `json:"xhtml,omitempty"`
+
+	// Assets are all of the external assets Antidote attempted to cure.
+	Assets []*Asset `json:"assets"`
+
+	// Pruned are the assets removed by Ingredients.Prune.
+	Pruned []PrunedAsset `json:"pruned,omitempty"`
+
+	// Files are the assets extracted by Ingredients.ExtractAssets, by their path relative to the page.
+	Files map[string][]byte `json:"-"`
+
+	// StartedAt is when the cure began.
+	StartedAt time.Time `json:"startedAt"`
+
+	// Duration is how long the whole cure took, in nanoseconds when marshaled.
+	Duration time.Duration `json:"duration"`
+
+	// Hosts are the statistics of the requests made to every host, sorted by host.
+	Hosts []*HostStats `json:"hosts,omitempty"`
+
+	// Partial is set when Ingredients.Deadline or Ingredients.MaxTotalSize was exceeded, so some assets were
+	// left as references to their URL or dropped, see Ingredients.Degradation.
+	Partial bool `json:"partial,omitempty"`
+
+	// Signature is set once the snapshot has been signed with Snapshot.Sign().
+	Signature *Signature `json:"signature,omitempty"`
+
+	// spills are the assets streamed to temporary files by their hash, see Ingredients.SpillThreshold.
+	spills map[string]*spillFile
+
+	// sources are the URL's the files of Files were fetched from, by their path.
+	sources map[string]string
+}
+
+// Errors returns the error messages of every asset that failed to be cured.
+func (s *Snapshot) Errors() []string {
+	var errs []string
+	for _, asset := range s.Assets {
+		if asset.Error != "" {
+			errs = append(errs, asset.Error)
+		}
+	}
+
+	return errs
+}
+
+// record adds an asset to the snapshot of the cure in progress, and counts its size against
+// Ingredients.MaxTotalSize. It is safe for concurrent use.
+func (a *Antidote) record(asset *Asset) {
+	a.mu.Lock()
+	a.snapshot.Assets = append(a.snapshot.Assets, asset)
+	a.fetchedSize += int64(asset.Size)
+	a.mu.Unlock()
+
+	if a.ingredients.OnAsset != nil {
+		a.ingredients.OnAsset(asset)
+	}
+}
+
+// errKeepRemote is returned when fetching an asset larger than Ingredients.InlineLimit, which must be left as
+// a reference to its URL, see Antidote.remoteURL().
+var errKeepRemote = errors.New("asset is larger than the inline limit")
+
+// errNotImage is returned when fetching an image served with a type other than an image, e.g. the HTML error page
+// of a missing image, which must be left as a reference to its URL.
+var errNotImage = errors.New("asset is not an image")
+
+// fetchDataURL fetches an asset the same way as Antidote.fetchAssetResponse(), and returns it as a base64 data
+// URL of the MIME type it is served with, or of the fallback MIME type if it is served without a specific one.
+// Images served with a type other than an image return errNotImage. Assets larger than
+// Ingredients.SpillThreshold are streamed to a temporary file, and the data of the returned URL is a
+// placeholder expanded by Snapshot.WriteHTML().
+func (a *Antidote) fetchDataURL(kind AssetKind, base *url.URL, src string, fallback string) (string, error) {
+	resp, err := a.fetchAssetResponse(kind, base, src, true, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.remote {
+		return "", errKeepRemote
+	}
+
+	mimeType := resp.mediaType()
+	if mimeType == "" {
+		mimeType = fallback
+	}
+	if kind == AssetImage {
+		if mimeType = a.servedImageType(resp, fallback); mimeType == "" {
+			return "", errNotImage
+		}
+	}
+
+	return resp.dataURL(mimeType), nil
+}
+
+// dataURL returns the body of the response as a base64 data URL of the MIME type, see Antidote.fetchDataURL().
+func (r *response) dataURL(mimeType string) string {
+	if r.spill != nil {
+		return fmt.Sprintf("data:%s;base64,%s", mimeType, r.spill.placeholder())
+	}
+
+	return encodeDataURL(mimeType, r.body)
+}
+
+// fetchAssetResponse normalizes the source of an asset against base (see Antidote.assetURL()) and fetches it,
+// recording the outcome in the snapshot. When recuring, unchanged assets are read from the cache rather than
+// downloaded again. The body is checked against integrity, the integrity attribute of the element referencing
+// the asset if any, according to Ingredients.Integrity.
+func (a *Antidote) fetchAssetResponse(kind AssetKind, base *url.URL, src string, spillable bool, integrity string) (*response, error) {
+	asset := &Asset{Kind: kind, Source: src}
+	if base != nil {
+		asset.Parent = base.String()
+	}
+	defer a.record(asset)
+
+	normalizedSrc, err := a.assetURL(base, src)
+	if err != nil {
+		asset.Error = err.Error()
+		return nil, err
+	}
+	asset.URL = normalizedSrc
+
+	if a.exceedsBudget() {
+		a.log(LogDebug, "budget exceeded", "url", normalizedSrc)
+		asset.Remote = true
+		return &response{url: normalizedSrc, remote: true, overBudget: true}, nil
+	}
+
+	prior := a.prior[a.canonicalKey(normalizedSrc)]
+	if prior != nil {
+		if _, ok := a.ingredients.Cache.Get(prior.Hash); !ok {
+			prior = nil
+		}
+	}
+
+	start := time.Now()
+	resp, err := a.fetch(normalizedSrc, prior, spillable)
+	if fallback := a.insecureFallback(base, src); err != nil && fallback != "" {
+		a.log(LogWarn, "only reachable over plaintext http", "url", fallback, "error", err)
+		asset.URL = fallback
+		resp, err = a.fetch(fallback, nil, spillable)
+	}
+	asset.Duration = time.Since(start)
+	if err != nil {
+		asset.Error = err.Error()
+		return nil, err
+	}
+
+	asset.Insecure = a.secure() && strings.HasPrefix(resp.url, "http://")
+	asset.ContentType, asset.ETag, asset.LastModified = resp.contentType, resp.etag, resp.lastModified
+	asset.Resumed = resp.resumed
+
+	// Spilled assets are not cached, as that would hold them in memory after all.
+	if resp.spill != nil {
+		asset.Size = int(resp.spill.size)
+		asset.Hash = resp.spill.hash
+		if err := a.scanAsset(asset, resp); err != nil {
+			asset.Error = err.Error()
+			return nil, err
+		}
+		a.applyInlineLimit(asset, resp)
+		return resp, nil
+	}
+
+	if resp.notModified {
+		cached, ok := a.ingredients.Cache.Get(prior.Hash)
+		if !ok {
+			err := fmt.Errorf("cached bytes of %s disappeared", normalizedSrc)
+			asset.Error = err.Error()
+			return nil, err
+		}
+
+		resp.body = string(cached)
+		asset.Reused = true
+
+		// A 304 response may omit the validators, which are still those of the prior version.
+		if asset.ETag == "" && asset.LastModified == "" {
+			asset.ETag, asset.LastModified = prior.ETag, prior.LastModified
+		}
+		if resp.contentType == "" {
+			resp.contentType, asset.ContentType = prior.ContentType, prior.ContentType
+		}
+	}
+
+	asset.Size = len(resp.body)
+	asset.Hash = hashContent(resp.body)
+
+	if err := a.verifyIntegrity(asset, resp.body, integrity); err != nil {
+		asset.Error = err.Error()
+		return nil, err
+	}
+	if err := a.scanAsset(asset, resp); err != nil {
+		asset.Error = err.Error()
+		return nil, err
+	}
+
+	if a.ingredients.Cache != nil && !asset.Reused {
+		a.ingredients.Cache.Set(asset.Hash, []byte(resp.body))
+	}
+
+	a.applyInlineLimit(asset, resp)
+
+	return resp, nil
+}
+
+// applyInlineLimit marks an asset larger than Ingredients.InlineLimit to be left as a reference to its URL.
+func (a *Antidote) applyInlineLimit(asset *Asset, resp *response) {
+	if a.ingredients.InlineLimit > 0 && int64(asset.Size) > a.ingredients.InlineLimit {
+		asset.Remote = true
+		resp.remote = true
+	}
+}
+
+// remoteURL returns the absolute URL of an asset left as a remote reference, see Antidote.assetURL().
+func (a *Antidote) remoteURL(base *url.URL, src string) string {
+	normalizedSrc, err := a.assetURL(base, src)
+	if err != nil {
+		return src
+	}
+
+	return normalizedSrc
+}
+
+// keepRemote leaves an element referencing an asset too large to be inlined pointing at the absolute URL of
+// the asset in its attr attribute, with the crossorigin attribute of Ingredients.CrossOrigin.
+func (a *Antidote) keepRemote(p *pipeline, element *goquery.Selection, attr string, src string) {
+	remoteURL := a.remoteURL(nil, src)
+
+	p.mutate(func() {
+		element.SetAttr(attr, remoteURL)
+		if a.ingredients.CrossOrigin != "" {
+			element.SetAttr("crossorigin", a.ingredients.CrossOrigin)
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package antidote
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// spillPrefix starts the placeholder that takes the place of the data of a spilled asset in the HTML,
+// followed by the hex encoded SHA-256 hash of the asset.
+const spillPrefix = "antidote-spill:"
+
+// spillFile object represents the body of an asset stored in a temporary file instead of memory.
+type spillFile struct {
+	path string
+	size int64
+	hash string
+}
+
+// placeholder returns the text that takes the place of the base64 data of the file in the HTML.
+func (f *spillFile) placeholder() string {
+	return spillPrefix + f.hash
+}
+
+// readBody reads a response body of an expected size, or -1 if it is unknown, into memory through a buffer of
+// the pool. If spillable is set and the body is larger than Ingredients.SpillThreshold, it is streamed to a
+// temporary file instead.
+func (a *Antidote) readBody(body io.Reader, size int64, spillable bool) (string, *spillFile, error) {
+	threshold := a.ingredients.SpillThreshold
+	if !spillable || threshold <= 0 {
+		content, err := readString(body, size)
+		return content, nil, err
+	}
+
+	if size > threshold {
+		size = threshold + 1
+	}
+	buffer := getBuffer(size)
+	defer putBuffer(buffer)
+
+	if _, err := buffer.ReadFrom(io.LimitReader(body, threshold+1)); err != nil {
+		return "", nil, err
+	}
+	head := buffer.Bytes()
+
+	if int64(len(head)) <= threshold {
+		return string(head), nil, nil
+	}
+
+	f, err := ioutil.TempFile(a.ingredients.SpillDir, "antidote-spill-")
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), io.MultiReader(bytes.NewReader(head), body))
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+
+	spill := &spillFile{path: f.Name(), size: n, hash: hex.EncodeToString(h.Sum(nil))}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// The same asset may be referenced many times, in which case a single file is kept.
+	if existing, ok := a.snapshot.spills[spill.hash]; ok {
+		os.Remove(spill.path)
+		return "", existing, nil
+	}
+	a.snapshot.spills[spill.hash] = spill
+
+	return "", spill, nil
+}
+
+// WriteHTML writes the cured HTML to w. The data of assets spilled to disk (see Ingredients.SpillThreshold)
+// is streamed from their temporary files as it is written, so the complete HTML is never held in memory.
+func (s *Snapshot) WriteHTML(w io.Writer) error {
+	html := s.HTML
+
+	for {
+		i := strings.Index(html, spillPrefix)
+		if i < 0 {
+			_, err := io.WriteString(w, html)
+			return err
+		}
+
+		end := i + len(spillPrefix) + sha256.Size*2
+		if end > len(html) {
+			end = len(html)
+		}
+
+		if _, err := io.WriteString(w, html[:i]); err != nil {
+			return err
+		}
+
+		spill, ok := s.spills[html[i+len(spillPrefix):end]]
+		if !ok {
+			// Not a placeholder of this snapshot, so it is written as is.
+			if _, err := io.WriteString(w, html[i:end]); err != nil {
+				return err
+			}
+		} else if err := spill.writeBase64(w); err != nil {
+			return err
+		}
+
+		html = html[end:]
+	}
+}
+
+// writeBase64 streams the base64 encoded content of the file to w.
+func (f *spillFile) writeBase64(w io.Writer) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := base64.NewEncoder(base64.StdEncoding, w)
+	if _, err := io.Copy(encoder, file); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+// Expand replaces the placeholders of the assets spilled to disk in Snapshot.HTML with their data, which
+// holds the complete HTML in memory, and removes the temporary files.
+func (s *Snapshot) Expand() error {
+	if len(s.spills) == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	if err := s.WriteHTML(&b); err != nil {
+		return err
+	}
+	s.HTML = b.String()
+
+	return s.Close()
+}
+
+// Close removes the temporary files of the assets spilled to disk. Snapshot.WriteHTML() can not expand
+// their placeholders afterwards.
+func (s *Snapshot) Close() error {
+	var err error
+	for hash, spill := range s.spills {
+		if removeErr := os.Remove(spill.path); removeErr != nil && err == nil {
+			err = removeErr
+		}
+		delete(s.spills, hash)
+	}
+
+	return err
+}
+
+// pageHash returns the hex encoded SHA-256 hash and the size of the complete HTML of the snapshot.
+func (s *Snapshot) pageHash() (string, int, error) {
+	h := sha256.New()
+	counter := &countingWriter{w: h}
+
+	if err := s.WriteHTML(counter); err != nil {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), counter.n, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,106 @@
+package antidote
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Profile is a named preset of ingredients for a common configuration, see Ingredients.Profile.
+type Profile string
+
+const (
+	// ProfileNone applies no preset.
+	ProfileNone Profile = ""
+
+	// ProfileStatic cures a static snapshot of the page without JavaScript, the most common configuration of
+	// archives: every script is stripped, see Ingredients.StripJS, along with the event handler attributes, see
+	// Ingredients.StripEventHandlers, and the <noscript> fallbacks are rendered in their place, see
+	// Ingredients.UnwrapNoscript. Stylesheets, images and fonts are inlined as usual.
+	ProfileStatic Profile = "static"
+
+	// ProfileEmail cures HTML that can be embedded in an email: on top of ProfileStatic, the iframes are
+	// stripped, see Ingredients.StripIframes, and the stylesheets are inlined into style attributes, see
+	// Ingredients.InlineStyles. Images are inlined as data URL's, or attached with Ingredients.ImageAttachments.
+	ProfileEmail Profile = "email"
+)
+
+// profiles are the presets of every Profile, which turn on their ingredients.
+var profiles = map[Profile]func(i *Ingredients){
+	ProfileNone:   func(i *Ingredients) {},
+	ProfileStatic: staticProfile,
+	ProfileEmail: func(i *Ingredients) {
+		staticProfile(i)
+		i.StripIframes = true
+		i.InlineStyles = true
+	},
+}
+
+// staticProfile is the preset of ProfileStatic.
+func staticProfile(i *Ingredients) {
+	i.StripJS = true
+	i.StripEventHandlers = true
+	i.UnwrapNoscript = true
+}
+
+func (p Profile) validate() error {
+	if _, ok := profiles[p]; !ok {
+		return fmt.Errorf("unknown profile %q", p)
+	}
+
+	return nil
+}
+
+// withProfile returns a copy of the ingredients with the preset of their Profile applied, or the ingredients
+// themselves if they have none, so that the ingredients shared by several Antidote are left as they are. An
+// unknown profile is reported by Antidote.CureToSnapshot().
+func (i *Ingredients) withProfile() *Ingredients {
+	if i == nil || i.Profile == ProfileNone {
+		return i
+	}
+
+	preset, ok := profiles[i.Profile]
+	if !ok {
+		return i
+	}
+
+	mixed := *i
+	preset(&mixed)
+
+	return &mixed
+}
+
+// stripEventHandlers removes the event handler attributes of every element, e.g. onclick, see
+// Ingredients.StripEventHandlers.
+func (a *Antidote) stripEventHandlers() {
+	if !a.ingredients.StripEventHandlers {
+		return
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			attrs := n.Attr[:0]
+			for _, attr := range n.Attr {
+				if attr.Namespace != "" || !strings.HasPrefix(strings.ToLower(attr.Key), "on") {
+					attrs = append(attrs, attr)
+				}
+			}
+			n.Attr = attrs
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(a.website.Nodes[0])
+}
+
+// unwrapNoscript replaces every <noscript> element with its content before the assets are cured, see
+// Ingredients.UnwrapNoscript and Antidote.promoteNoscript().
+func (a *Antidote) unwrapNoscript() {
+	if a.ingredients.UnwrapNoscript {
+		a.promoteNoscript(false)
+	}
+}
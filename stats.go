@@ -0,0 +1,142 @@
+package antidote
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"time"
+)
+
+// HostStats object represents the requests a cure made to a single host, to diagnose slow origins and tune
+// Ingredients.Concurrency or HostRule.Concurrency, see Snapshot.Hosts.
+type HostStats struct {
+	// Host is the host the requests were made to, with its port if the URL had one.
+	Host string `json:"host"`
+
+	// Requests is the number of requests made, HEAD requests and resumed downloads included, and Errors the
+	// number of those that failed or were answered with an HTTP error status.
+	Requests int `json:"requests"`
+	Errors   int `json:"errors,omitempty"`
+
+	// Bytes is the number of bytes of the bodies read from the host.
+	Bytes int64 `json:"bytes"`
+
+	// AverageLatency is the average time until the headers of the responses were received, in nanoseconds when
+	// marshaled.
+	AverageLatency time.Duration `json:"averageLatency"`
+
+	// NewConnections and ReusedConnections are the number of requests made on a new connection, and on one kept
+	// alive from an earlier request. TLSHandshakes is the number of TLS handshakes made by the new connections,
+	// and TLSResumed the number of those that resumed an earlier TLS session.
+	NewConnections    int `json:"newConnections"`
+	ReusedConnections int `json:"reusedConnections"`
+	TLSHandshakes     int `json:"tlsHandshakes,omitempty"`
+	TLSResumed        int `json:"tlsResumed,omitempty"`
+
+	// latency is the sum of the latencies of the requests, which AverageLatency is computed from.
+	latency time.Duration
+}
+
+// send sends a request with the client of the cure in progress, and adds it to the statistics of its host.
+func (a *Antidote) send(req *http.Request) (*http.Response, error) {
+	var reused, handshake, resumed bool
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused = info.Reused
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			handshake = true
+			resumed = err == nil && state.DidResume
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start := time.Now()
+	resp, err := a.client.Do(req)
+	latency := time.Since(start)
+
+	a.updateHostStats(req.URL.Host, func(stats *HostStats) {
+		stats.Requests++
+		stats.latency += latency
+		if err != nil || resp.StatusCode >= 400 {
+			stats.Errors++
+		}
+
+		switch {
+		case reused:
+			stats.ReusedConnections++
+		case err == nil:
+			stats.NewConnections++
+		}
+		if handshake {
+			stats.TLSHandshakes++
+		}
+		if resumed {
+			stats.TLSResumed++
+		}
+	})
+
+	return resp, err
+}
+
+// countBytes adds the bytes of a body read from a host to its statistics.
+func (a *Antidote) countBytes(host string, n int64) {
+	a.updateHostStats(host, func(stats *HostStats) {
+		stats.Bytes += n
+	})
+}
+
+// updateHostStats updates the statistics of a host, unless no cure is in progress.
+func (a *Antidote) updateHostStats(host string, update func(stats *HostStats)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.hostStats == nil {
+		return
+	}
+
+	stats, ok := a.hostStats[host]
+	if !ok {
+		stats = &HostStats{Host: host}
+		a.hostStats[host] = stats
+	}
+	update(stats)
+}
+
+// mergeHostStats adds the statistics of the hosts of another cure, e.g. of a frame, to those of the cure in
+// progress.
+func (a *Antidote) mergeHostStats(hosts []*HostStats) {
+	for _, other := range hosts {
+		a.updateHostStats(other.Host, func(stats *HostStats) {
+			stats.Requests += other.Requests
+			stats.Errors += other.Errors
+			stats.Bytes += other.Bytes
+			stats.latency += other.AverageLatency * time.Duration(other.Requests)
+			stats.NewConnections += other.NewConnections
+			stats.ReusedConnections += other.ReusedConnections
+			stats.TLSHandshakes += other.TLSHandshakes
+			stats.TLSResumed += other.TLSResumed
+		})
+	}
+}
+
+// hostStatsList returns the statistics of every host the cure made requests to, sorted by host.
+func (a *Antidote) hostStatsList() []*HostStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	hosts := make([]*HostStats, 0, len(a.hostStats))
+	for _, stats := range a.hostStats {
+		if stats.Requests > 0 {
+			stats.AverageLatency = stats.latency / time.Duration(stats.Requests)
+		}
+		hosts = append(hosts, stats)
+	}
+
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].Host < hosts[j].Host
+	})
+
+	return hosts
+}
@@ -0,0 +1,59 @@
+package antidote
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotStored is returned by Store.Get() when nothing is stored under a key.
+var ErrNotStored = errors.New("nothing is stored under this key")
+
+// Store keeps blobs by key in persistent storage, e.g. object storage, so that snapshots and cached assets
+// outlive the process that produced them. Keys are slash-separated paths.
+type Store interface {
+	// Get returns the blob stored under a key, or ErrNotStored.
+	Get(key string) ([]byte, error)
+
+	// Put stores a blob under a key, replacing the one stored before.
+	Put(key string, value []byte) error
+}
+
+// StoredBlob object represents a blob kept in a Store, as listed by ListableStore.List().
+type StoredBlob struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// ListableStore is a Store whose blobs can be listed and deleted, which the retention of a store requires, e.g. to
+// remove the snapshots of the daemon older than a given age.
+type ListableStore interface {
+	Store
+
+	// List returns the blobs whose key starts with a prefix, e.g. "jobs/".
+	List(prefix string) ([]StoredBlob, error)
+
+	// Delete removes the blob stored under a key, if any.
+	Delete(key string) error
+}
+
+// StoreCache is a Cache kept in a Store, with every key prefixed. Entries that can not be read or written are
+// treated as missing, as the bytes are fetched again when they are.
+type StoreCache struct {
+	// Store is where the entries are kept.
+	Store Store
+
+	// Prefix is prepended to every key, e.g. "cache/".
+	Prefix string
+}
+
+// Get returns the bytes stored under a key.
+func (c *StoreCache) Get(key string) ([]byte, bool) {
+	value, err := c.Store.Get(c.Prefix + key)
+	return value, err == nil
+}
+
+// Set stores bytes under a key.
+func (c *StoreCache) Set(key string, value []byte) {
+	c.Store.Put(c.Prefix+key, value)
+}
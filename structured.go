@@ -0,0 +1,206 @@
+package antidote
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// StructuredData is how the URL's referenced by the structured data of a page are rewritten: those of its
+// <script type="application/ld+json"> elements, and the content of its microdata <meta itemprop> and the href
+// of its microdata <link itemprop> elements. Left as they are, relative URL's break once the page is cured, and
+// images, e.g. logos, stay remote.
+type StructuredData string
+
+const (
+	// StructuredDataKeep leaves the URL's of structured data as they are.
+	StructuredDataKeep StructuredData = ""
+
+	// StructuredDataAbsolutize resolves the URL's of structured data against the URL of the page.
+	StructuredDataAbsolutize StructuredData = "absolutize"
+
+	// StructuredDataInline inlines the images of structured data as data URL's, or extracts them with
+	// Ingredients.ExtractAssets, and resolves the other URL's against the URL of the page. Images that can not be
+	// fetched are left as their absolute URL.
+	StructuredDataInline StructuredData = "inline"
+)
+
+func (s StructuredData) validate() error {
+	switch s {
+	case StructuredDataKeep, StructuredDataAbsolutize, StructuredDataInline:
+		return nil
+	default:
+		return fmt.Errorf("unknown structured data rewriting %q", s)
+	}
+}
+
+// structuredURLProperties are the properties of structured data (schema.org) whose values are URL's.
+var structuredURLProperties = map[string]bool{
+	"@id": true, "url": true, "sameAs": true, "mainEntityOfPage": true, "image": true, "logo": true, "photo": true,
+	"thumbnail": true, "thumbnailUrl": true, "contentUrl": true, "embedUrl": true, "downloadUrl": true,
+}
+
+// structuredImageProperties are the properties of structured data whose values are images, either URL's or
+// ImageObjects, whose url and contentUrl are the image.
+var structuredImageProperties = map[string]bool{
+	"image": true, "logo": true, "photo": true, "thumbnail": true, "thumbnailUrl": true,
+}
+
+// structuredURL is a URL of the structured data of a page, which is rewritten by setting it.
+type structuredURL struct {
+	src   string
+	image bool
+	set   func(value string)
+}
+
+// cureStructuredData will rewrite the URL's of the structured data of the page according to
+// Ingredients.StructuredData, scheduling the fetch of its images with StructuredDataInline.
+func (a *Antidote) cureStructuredData(p *pipeline) {
+	if a.ingredients.StructuredData == StructuredDataKeep {
+		return
+	}
+
+	a.website.Find("script[type]").Each(func(index int, script *goquery.Selection) {
+		if t, _ := script.Attr("type"); !strings.EqualFold(strings.TrimSpace(t), "application/ld+json") {
+			return
+		}
+
+		var value interface{}
+		if err := json.Unmarshal([]byte(script.Text()), &value); err != nil {
+			a.log(LogDebug, "invalid JSON-LD", "error", err)
+			return
+		}
+
+		var urls []*structuredURL
+		collectJSONLDURLs(value, "", false, &urls)
+		if len(urls) == 0 {
+			return
+		}
+
+		// The URL's are set by the fetches of the pipeline, and the script is written once all of them ran.
+		var mu sync.Mutex
+		for _, u := range urls {
+			u := u
+			set := u.set
+			u.set = func(value string) {
+				mu.Lock()
+				set(value)
+				mu.Unlock()
+			}
+			a.rewriteStructuredURL(p, u)
+		}
+
+		p.mutate(func() {
+			var b bytes.Buffer
+			encoder := json.NewEncoder(&b)
+			encoder.SetEscapeHTML(true)
+			if err := encoder.Encode(value); err != nil {
+				a.warn(err)
+				return
+			}
+			script.SetText(strings.TrimSpace(b.String()))
+		})
+	})
+
+	a.website.Find("meta[itemprop][content], link[itemprop][href]").Each(func(index int, element *goquery.Selection) {
+		attr := "content"
+		if goquery.NodeName(element) == "link" {
+			attr = "href"
+		}
+
+		u := &structuredURL{set: func(value string) {
+			p.mutate(func() {
+				element.SetAttr(attr, value)
+			})
+		}}
+		u.src, _ = element.Attr(attr)
+
+		itemprop, _ := element.Attr("itemprop")
+		isURL := false
+		for _, prop := range strings.Fields(itemprop) {
+			isURL = isURL || structuredURLProperties[prop]
+			u.image = u.image || structuredImageProperties[prop]
+		}
+
+		if isURL {
+			a.rewriteStructuredURL(p, u)
+		}
+	})
+}
+
+// collectJSONLDURLs appends the URL's of a JSON-LD value to urls. property is the property the value is of, and
+// inImage is set when the value is part of an image, e.g. of an ImageObject.
+func collectJSONLDURLs(value interface{}, property string, inImage bool, urls *[]*structuredURL) {
+	switch v := value.(type) {
+	case []interface{}:
+		image := structuredImageProperties[property] || inImage && (property == "url" || property == "contentUrl")
+		for i, item := range v {
+			if s, ok := item.(string); ok {
+				if structuredURLProperties[property] {
+					i := i
+					*urls = append(*urls, &structuredURL{src: s, image: image, set: func(value string) { v[i] = value }})
+				}
+				continue
+			}
+			collectJSONLDURLs(item, property, inImage, urls)
+		}
+	case map[string]interface{}:
+		// An object of an image property is the image, as is an ImageObject.
+		imageObject := structuredImageProperties[property]
+		for _, t := range strings.Split(jsonLDString(v["@type"]), ", ") {
+			imageObject = imageObject || t == "ImageObject"
+		}
+
+		for key, item := range v {
+			if s, ok := item.(string); ok {
+				if structuredURLProperties[key] {
+					key := key
+					image := structuredImageProperties[key] || imageObject && (key == "url" || key == "contentUrl")
+					*urls = append(*urls, &structuredURL{src: s, image: image, set: func(value string) { v[key] = value }})
+				}
+				continue
+			}
+			collectJSONLDURLs(item, key, imageObject, urls)
+		}
+	}
+}
+
+// rewriteStructuredURL resolves a URL of structured data against the URL of the page, or schedules the fetch of
+// an image with StructuredDataInline, which is inlined, or extracted with Ingredients.ExtractAssets.
+func (a *Antidote) rewriteStructuredURL(p *pipeline, u *structuredURL) {
+	src := strings.TrimSpace(u.src)
+	if src == "" || strings.HasPrefix(strings.ToLower(src), "data:") {
+		return
+	}
+
+	mimeType, ok := imageType(src)
+	if !u.image || !ok || a.ingredients.StructuredData != StructuredDataInline || a.ingredients.SkipImages {
+		u.set(a.remoteURL(nil, src))
+		return
+	}
+
+	p.scheduleFetch(priorityImage, AssetImage, src, func() {
+		resp, err := a.fetchAssetResponse(AssetImage, nil, src, !a.ingredients.ExtractAssets, "")
+		if err != nil {
+			a.warn(err)
+			u.set(a.remoteURL(nil, src))
+			return
+		}
+
+		if mimeType = resp.servedImageType(mimeType); resp.remote || mimeType == "" {
+			u.set(a.remoteURL(nil, src))
+			return
+		}
+
+		if a.ingredients.ExtractAssets {
+			u.set(assetsDir + a.extract(resp.body, assetExtension(src, imageExtension(mimeType)), resp.url))
+			return
+		}
+
+		u.set(resp.dataURL(mimeType))
+	})
+}
@@ -0,0 +1,209 @@
+package antidote
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// TextOptions object represents options for Snapshot.Text().
+type TextOptions struct {
+	// BlockSeparator is written between blocks of text, e.g. paragraphs and headings. Defaults to a blank line.
+	BlockSeparator string
+
+	// LineSeparator is written between the lines of a block, e.g. at <br> elements, and between list items, table
+	// rows and definitions. Defaults to a line feed.
+	LineSeparator string
+
+	// RemoveBoilerplate only keeps the main content of the page: the text of <main> (or of the only <article>)
+	// if there is one, without navigation, headers, footers, sidebars, forms and hidden elements.
+	RemoveBoilerplate bool
+}
+
+// skippedElements are the elements whose content is not text read by a human.
+var skippedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "noscript": true, "template": true, "svg": true, "math": true,
+	"canvas": true, "iframe": true, "object": true, "video": true, "audio": true, "select": true, "textarea": true,
+}
+
+// lineElements are the blocks only separated by TextOptions.LineSeparator from their siblings.
+var lineElements = map[string]bool{
+	"li": true, "tr": true, "dt": true, "dd": true, "option": true,
+}
+
+// boilerplateSelector matches the elements dropped by TextOptions.RemoveBoilerplate.
+const boilerplateSelector = `nav, header, footer, aside, form, dialog, [hidden], [aria-hidden="true"],
+	[role="navigation"], [role="banner"], [role="contentinfo"], [role="complementary"], [role="search"]`
+
+// Text returns the readable plain text of the page: the text of its body, with whitespace collapsed as a
+// browser would, and blocks separated as set in the options. Table cells are separated by tabs.
+func (s *Snapshot) Text(options TextOptions) (string, error) {
+	content, err := s.content(options.RemoveBoilerplate)
+	if err != nil {
+		return "", err
+	}
+
+	w := &textWriter{options: options}
+	if w.options.BlockSeparator == "" {
+		w.options.BlockSeparator = "\n\n"
+	}
+	if w.options.LineSeparator == "" {
+		w.options.LineSeparator = "\n"
+	}
+
+	for _, n := range content.Nodes {
+		w.walk(n, false)
+	}
+
+	return w.b.String(), nil
+}
+
+// content returns the body of the page, or only its main content if boilerplate is removed, see
+// TextOptions.RemoveBoilerplate.
+func (s *Snapshot) content(removeBoilerplate bool) (*goquery.Selection, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(s.HTML))
+	if err != nil {
+		return nil, err
+	}
+
+	content := doc.Find("body")
+	if !removeBoilerplate {
+		return content, nil
+	}
+
+	content.Find(boilerplateSelector).Remove()
+
+	if main := content.Find(`main, [role="main"]`); main.Length() > 0 {
+		return main.First(), nil
+	}
+	if article := content.Find("article"); article.Length() == 1 {
+		return article, nil
+	}
+
+	return content, nil
+}
+
+// ExtractText returns the readable plain text of the page cured last, see Snapshot.Text().
+func (a *Antidote) ExtractText(options TextOptions) (string, error) {
+	if a.snapshot == nil || a.snapshot.HTML == "" {
+		return "", errors.New("Antidote.Cure() must be called before Antidote.ExtractText().")
+	}
+
+	return a.snapshot.Text(options)
+}
+
+// textBreak is the separator owed before the next text written by a textWriter, the strongest one winning.
+type textBreak int
+
+const (
+	noBreak textBreak = iota
+	cellBreak
+	lineBreak
+	blockBreak
+)
+
+// textWriter writes the text of nodes, collapsing whitespace and separating blocks.
+type textWriter struct {
+	options TextOptions
+	b       strings.Builder
+	pending textBreak
+	space   bool
+}
+
+// walk writes the text of a node and its descendants. Whitespace is kept as is within preformatted elements.
+func (w *textWriter) walk(n *html.Node, preformatted bool) {
+	if n.Type == html.TextNode {
+		w.text(n.Data, preformatted)
+		return
+	}
+	if n.Type != html.ElementNode {
+		return
+	}
+
+	if skippedElements[n.Data] {
+		return
+	}
+
+	if n.Data == "br" {
+		// Consecutive line breaks leave empty lines.
+		if w.pending == lineBreak && w.b.Len() > 0 {
+			w.b.WriteString(w.options.LineSeparator)
+		}
+		w.separate(lineBreak)
+		return
+	}
+
+	separator := noBreak
+	switch {
+	case inlineElements[n.Data]:
+	case n.Data == "td" || n.Data == "th":
+		separator = cellBreak
+	case lineElements[n.Data]:
+		separator = lineBreak
+	default:
+		separator = blockBreak
+	}
+
+	w.separate(separator)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.walk(c, preformatted || n.Data == "pre")
+	}
+	w.separate(separator)
+}
+
+// text writes a text node, preceded by the separator owed.
+func (w *textWriter) text(text string, preformatted bool) {
+	if preformatted {
+		if text != "" {
+			w.flush()
+			w.b.WriteString(text)
+		}
+		return
+	}
+
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		w.space = w.space || text != ""
+		return
+	}
+
+	first, _ := utf8.DecodeRuneInString(text)
+	last, _ := utf8.DecodeLastRuneInString(text)
+
+	w.space = w.space || unicode.IsSpace(first)
+	w.flush()
+	w.b.WriteString(strings.Join(words, " "))
+	w.space = unicode.IsSpace(last)
+}
+
+// separate owes a separator before the next text, unless a stronger one is already owed.
+func (w *textWriter) separate(separator textBreak) {
+	if separator > w.pending {
+		w.pending = separator
+	}
+}
+
+// flush writes the separator owed, or the whitespace collapsed to a space, unless nothing was written yet.
+func (w *textWriter) flush() {
+	if w.b.Len() > 0 {
+		switch w.pending {
+		case blockBreak:
+			w.b.WriteString(w.options.BlockSeparator)
+		case lineBreak:
+			w.b.WriteString(w.options.LineSeparator)
+		case cellBreak:
+			w.b.WriteString("\t")
+		default:
+			if w.space {
+				w.b.WriteString(" ")
+			}
+		}
+	}
+
+	w.pending = noBreak
+	w.space = false
+}
@@ -0,0 +1,104 @@
+package antidote
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// cachedStylesheet object represents a cured stylesheet stored in Ingredients.TransformCache, along with the
+// assets recorded while curing it.
+type cachedStylesheet struct {
+	CSS    string   `json:"css"`
+	Assets []*Asset `json:"assets"`
+}
+
+// transformKey returns the key a stylesheet served from base is cured under in Ingredients.TransformCache:
+// the hash of its CSS, its URL, the imports it was reached through and the ingredients the result depends on.
+// It is empty if the result can not be cached: inline stylesheets and those cured with Ingredients.CriticalCSS
+// or Ingredients.Selector depend on the page, those of Ingredients.ExtractAssets on its extracted files, and
+// those of Ingredients.Deadline or Ingredients.MaxTotalSize on how far the cure got.
+func (a *Antidote) transformKey(css string, base *url.URL, chain map[string]bool) string {
+	i := a.ingredients
+	if i.TransformCache == nil || base == nil || a.matcher != nil || i.ExtractAssets || i.Deadline > 0 || i.MaxTotalSize > 0 {
+		return ""
+	}
+
+	imports := make([]string, 0, len(chain))
+	for u := range chain {
+		imports = append(imports, u)
+	}
+	sort.Strings(imports)
+
+	options := fmt.Sprintf("%v %v %d %d %t %t %v %v", a.parsedUrl.Scheme, i.Media, i.InlineLimit, i.MaxAssetSize,
+		i.UpgradeInsecure, i.RepairMixedContent, i.Hosts, i.HostRewrites)
+
+	return "stylesheet-" + hashContent(strings.Join([]string{css, base.String(), strings.Join(imports, " "), options}, "\x00"))
+}
+
+// reuseStylesheet returns the cured stylesheet stored under a key, and records its assets again, as reused.
+func (a *Antidote) reuseStylesheet(key string) (string, bool) {
+	value, ok := a.ingredients.TransformCache.Get(key)
+	if !ok {
+		return "", false
+	}
+
+	var cached cachedStylesheet
+	if err := json.Unmarshal(value, &cached); err != nil {
+		return "", false
+	}
+
+	a.log(LogDebug, "reused cured stylesheet", "key", key)
+	for _, asset := range cached.Assets {
+		asset.Reused = true
+		asset.Duration = 0
+		a.record(asset)
+	}
+
+	return cached.CSS, true
+}
+
+// cacheStylesheet wraps the done callback of Antidote.cureStylesheet() to store the cured stylesheet under a
+// key, along with the assets recorded while curing it: those referenced by the stylesheet served from base, or
+// by the stylesheets it imports. Stylesheets holding the placeholders of spilled assets are not stored, as
+// their temporary files belong to the snapshot.
+func (a *Antidote) cacheStylesheet(key string, base *url.URL, done func(string)) func(string) {
+	return func(cured string) {
+		if !strings.Contains(cured, spillPrefix) {
+			if value, err := json.Marshal(&cachedStylesheet{CSS: cured, Assets: a.stylesheetAssets(base.String())}); err == nil {
+				a.ingredients.TransformCache.Set(key, value)
+			}
+		}
+
+		done(cured)
+	}
+}
+
+// stylesheetAssets returns the assets recorded so far whose parent is the stylesheet at a URL, or one of the
+// stylesheets it imports.
+func (a *Antidote) stylesheetAssets(stylesheet string) []*Asset {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	parents := map[string]bool{stylesheet: true}
+	for added := true; added; {
+		added = false
+		for _, asset := range a.snapshot.Assets {
+			if asset.Kind == AssetCSS && parents[asset.Parent] && asset.URL != "" && !parents[asset.URL] {
+				parents[asset.URL] = true
+				added = true
+			}
+		}
+	}
+
+	var assets []*Asset
+	for _, asset := range a.snapshot.Assets {
+		if parents[asset.Parent] {
+			assets = append(assets, asset)
+		}
+	}
+
+	return assets
+}
@@ -0,0 +1,279 @@
+package antidote
+
+import (
+	"encoding/base64"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// maxValidateDepth is the number of levels of data URL's, e.g. of frames holding SVG images, scanned by
+// Snapshot.Validate().
+const maxValidateDepth = 4
+
+// scriptURLPattern matches the http(s) URL's of the string literals of scripts.
+var scriptURLPattern = regexp.MustCompile("[\"'`](https?:(?:\\\\?/){2}[^\"'`\\s]+)")
+
+// urlAttributes are the attributes of elements holding URL's, and srcsetAttributes those holding lists of
+// image candidates.
+var (
+	urlAttributes = map[string]bool{
+		"src": true, "href": true, "xlink:href": true, "poster": true, "data": true, "background": true,
+		"manifest": true, "action": true, "formaction": true, "ping": true,
+	}
+	srcsetAttributes = map[string]bool{"srcset": true, "imagesrcset": true}
+)
+
+// loadedRels are the link types making browsers load the href of a <link>, or connect to its host. The others,
+// e.g. canonical or alternate, only describe the page.
+var loadedRels = map[string]bool{
+	"stylesheet": true, "icon": true, "apple-touch-icon": true, "apple-touch-icon-precomposed": true,
+	"mask-icon": true, "manifest": true, "preconnect": true, "dns-prefetch": true, "prefetch": true,
+	"prerender": true, "preload": true, "modulepreload": true,
+}
+
+// ExternalReference object represents a reference of a snapshot to a resource outside of it.
+type ExternalReference struct {
+	// URL is the reference as it is written, e.g. "https://cdn.example.com/app.js" or "//fonts.example.com/a.woff2".
+	URL string `json:"url"`
+
+	// Location is where the reference is: an attribute, e.g. "img[src]", "style" for the CSS of <style> elements,
+	// or "script" for the string literals of scripts. It starts with the name of the extracted file holding the
+	// reference, if any, e.g. "assets/9f86d0.css: style", and references of data URL's follow the attribute of
+	// the data URL, e.g. "frame[src] > img[src]".
+	Location string `json:"location"`
+
+	// Navigation is set for the references only followed by the user, e.g. the href of links and the action of
+	// forms, which leave the snapshot working offline.
+	Navigation bool `json:"navigation,omitempty"`
+}
+
+// ValidationReport object represents the external references of a snapshot, see Snapshot.Validate().
+type ValidationReport struct {
+	URL string `json:"url"`
+
+	// References are the external references, by location, each reported once.
+	References []ExternalReference `json:"references"`
+}
+
+// SelfContained reports whether the snapshot loads nothing from the network once opened, that is whether its
+// only external references are navigations.
+func (r *ValidationReport) SelfContained() bool {
+	return len(r.Loaded()) == 0
+}
+
+// Loaded returns the external references the browser loads when the snapshot is opened, or connects to.
+func (r *ValidationReport) Loaded() []ExternalReference {
+	var loaded []ExternalReference
+	for _, ref := range r.References {
+		if !ref.Navigation {
+			loaded = append(loaded, ref)
+		}
+	}
+
+	return loaded
+}
+
+// validator collects the external references of a snapshot.
+type validator struct {
+	report *ValidationReport
+	seen   map[ExternalReference]bool
+}
+
+// Validate scans the cured HTML, and the files extracted with Ingredients.ExtractAssets, for the references to
+// resources outside of the snapshot that remain: the http(s) and protocol-relative URL's of attributes and CSS,
+// and the http(s) URL's of the string literals of scripts, so that e.g. CI jobs can assert that a snapshot is
+// self-contained, see ValidationReport.SelfContained(). The data URL's of documents, stylesheets, SVG images and
+// scripts are scanned as well. URL's merely mentioned in the text of the page or in JSON-LD are not references.
+func (s *Snapshot) Validate() (*ValidationReport, error) {
+	v := &validator{
+		report: &ValidationReport{URL: s.URL, References: []ExternalReference{}},
+		seen:   make(map[ExternalReference]bool),
+	}
+
+	if err := v.html(s.HTML, "", 0); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(s.Files))
+	for name := range s.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		content := string(s.Files[name])
+		switch strings.ToLower(path.Ext(name)) {
+		case ".css":
+			v.css(content, name+": style", 0)
+		case ".js", ".mjs":
+			v.script(content, name+": script")
+		case ".html", ".htm", ".svg":
+			if err := v.html(content, name+": ", 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return v.report, nil
+}
+
+// add records a reference if it is external and was not recorded at the same location yet.
+func (v *validator) add(rawurl string, location string, navigation bool) {
+	rawurl = strings.TrimSpace(rawurl)
+	if !externalURL(rawurl) {
+		return
+	}
+
+	ref := ExternalReference{URL: rawurl, Location: location, Navigation: navigation}
+	if !v.seen[ref] {
+		v.seen[ref] = true
+		v.report.References = append(v.report.References, ref)
+	}
+}
+
+// externalURL reports whether a URL is absolute over http(s), or protocol-relative.
+func externalURL(rawurl string) bool {
+	return hasScheme(rawurl, "http", "https") || strings.HasPrefix(rawurl, "//")
+}
+
+// html scans a document. prefix starts the locations of its references.
+func (v *validator) html(content string, prefix string, depth int) error {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return err
+	}
+
+	doc.Find("*").Each(func(index int, element *goquery.Selection) {
+		name := goquery.NodeName(element)
+
+		switch name {
+		case "style":
+			v.css(element.Text(), prefix+"style", depth)
+		case "script":
+			if executableScript(element) {
+				v.script(element.Text(), prefix+"script")
+			}
+		case "meta":
+			if equiv, _ := element.Attr("http-equiv"); strings.EqualFold(strings.TrimSpace(equiv), "refresh") {
+				content, _ := element.Attr("content")
+				if i := strings.Index(strings.ToLower(content), "url="); i >= 0 {
+					v.add(strings.Trim(content[i+len("url="):], ` "'`), prefix+"meta[content]", false)
+				}
+			}
+		}
+
+		for _, attr := range element.Nodes[0].Attr {
+			key := strings.ToLower(attr.Key)
+			if attr.Namespace != "" {
+				key = attr.Namespace + ":" + key
+			}
+			location := prefix + name + "[" + key + "]"
+
+			switch {
+			case key == "style":
+				v.css(attr.Val, location, depth)
+			case srcsetAttributes[key]:
+				for _, candidate := range strings.Split(attr.Val, ",") {
+					if fields := strings.Fields(candidate); len(fields) > 0 {
+						v.add(fields[0], location, false)
+					}
+				}
+			case urlAttributes[key]:
+				v.add(attr.Val, location, navigates(element, name, key))
+				v.dataURL(attr.Val, location, depth)
+			}
+		}
+	})
+
+	return nil
+}
+
+// navigates reports whether the URL of an attribute is only followed by the user.
+func navigates(element *goquery.Selection, name string, key string) bool {
+	switch {
+	case key == "ping" || key == "action" || key == "formaction":
+		return true
+	case key != "href" && key != "xlink:href":
+		return false
+	case name == "a" || name == "area" || name == "base":
+		return true
+	case name == "link":
+		rel, _ := element.Attr("rel")
+		for _, linkType := range strings.Fields(strings.ToLower(rel)) {
+			if loadedRels[linkType] {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// executableScript reports whether a <script> element holds a script, rather than e.g. JSON-LD or a template.
+func executableScript(script *goquery.Selection) bool {
+	t, _ := script.Attr("type")
+	switch strings.ToLower(strings.TrimSpace(strings.Split(t, ";")[0])) {
+	case "", "module", "text/javascript", "application/javascript", "application/ecmascript", "text/ecmascript":
+		return true
+	default:
+		return false
+	}
+}
+
+// css scans a stylesheet, or the declarations of a style attribute.
+func (v *validator) css(content string, location string, depth int) {
+	for _, match := range cssReferencePattern.FindAllStringSubmatch(content, -1) {
+		ref := parseCSSReference(match)
+		v.add(ref.url, location, false)
+		v.dataURL(ref.url, location, depth)
+	}
+}
+
+// script scans the string literals of a script.
+func (v *validator) script(content string, location string) {
+	for _, match := range scriptURLPattern.FindAllStringSubmatch(content, -1) {
+		v.add(strings.Replace(match[1], `\/`, "/", -1), location, false)
+	}
+}
+
+// dataURL scans the content of a data URL holding a document, a stylesheet, an SVG image or a script.
+func (v *validator) dataURL(src string, location string, depth int) {
+	src = strings.TrimSpace(src)
+	comma := strings.IndexByte(src, ',')
+	if depth >= maxValidateDepth || !hasScheme(src, "data") || comma < 0 {
+		return
+	}
+
+	params := strings.Split(src[len("data:"):comma], ";")
+	mimeType := strings.ToLower(strings.TrimSpace(params[0]))
+	if mimeType != "text/html" && mimeType != "image/svg+xml" && mimeType != "text/css" &&
+		mimeType != "text/javascript" && mimeType != "application/javascript" {
+		return
+	}
+
+	content := src[comma+1:]
+	if strings.EqualFold(params[len(params)-1], "base64") {
+		b, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			return
+		}
+		content = string(b)
+	} else if unescaped, err := url.PathUnescape(content); err == nil {
+		content = unescaped
+	}
+
+	switch mimeType {
+	case "text/css":
+		v.css(content, location+" > style", depth+1)
+	case "text/javascript", "application/javascript":
+		v.script(content, location+" > script")
+	default:
+		v.html(content, location+" > ", depth+1)
+	}
+}
@@ -0,0 +1,73 @@
+package antidote
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// isCustomProperty reports whether a declaration of a declaration list declares a custom property, e.g.
+// "--hero: url(/img/hero.jpg)".
+func isCustomProperty(declaration string) bool {
+	return strings.HasPrefix(strings.TrimSpace(stripCSSComments(declaration)), "--")
+}
+
+// cureCustomProperties will schedule inlining the resources referenced by the custom properties declared in the
+// style attributes of the elements, see Ingredients.CustomProperties. The stylesheets consuming them with var()
+// then resolve to the inlined resources, instead of the URL's of the original site. The declarations of other
+// properties are left as they are.
+func (a *Antidote) cureCustomProperties(p *pipeline) {
+	if !a.ingredients.CustomProperties {
+		return
+	}
+
+	a.website.Find("[style]").Each(func(index int, element *goquery.Selection) {
+		style, _ := element.Attr("style")
+		if !strings.Contains(style, "--") {
+			return
+		}
+
+		var refs []cssReference
+		for _, declaration := range splitCSS(style, ';') {
+			if isCustomProperty(declaration) {
+				refs = append(refs, cssReferences(normalizeImageFunctions(declaration))...)
+			}
+		}
+
+		for _, ref := range refs {
+			ref := ref
+
+			priority := priorityImage
+			if styleResourceKind(ref.url) == AssetFont {
+				priority = priorityFont
+			}
+
+			p.scheduleFetch(priority, styleResourceKind(ref.url), ref.url, func() {
+				if cured := a.cureStyleResource(nil, ref.url, assetsDir); cured != "" {
+					p.mutate(func() {
+						style, _ := element.Attr("style")
+						element.SetAttr("style", rewriteCustomProperties(style, ref.url, cured))
+					})
+				}
+			})
+		}
+	})
+}
+
+// rewriteCustomProperties replaces the references to src of the custom properties declared by a declaration
+// list with url(cured), see normalizeImageFunctions().
+func rewriteCustomProperties(style string, src string, cured string) string {
+	declarations := splitCSS(style, ';')
+	for i, declaration := range declarations {
+		if !isCustomProperty(declaration) {
+			continue
+		}
+
+		declarations[i] = rewriteCSS(normalizeImageFunctions(declaration), func(ref cssReference) (string, bool) {
+			return fmt.Sprintf(`url("%s")`, cured), !ref.isImport && ref.url == src
+		})
+	}
+
+	return strings.Join(declarations, ";")
+}
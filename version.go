@@ -0,0 +1,186 @@
+package antidote
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime/debug"
+	"strconv"
+)
+
+// modulePath is the path of the module of Antidote, whose version is read from the build information.
+const modulePath = "github.com/lansana/antidote"
+
+// FormatVersion is the version of the format snapshots are written in: their JSON and the manifest.json of
+// Snapshot.WriteDir(). It is increased whenever a change of the format would make older snapshots read wrong,
+// and the snapshots of older versions are migrated when read by ReadSnapshot(), ReadManifest() and ReadDir(),
+// so that archives remain readable by later releases.
+const FormatVersion = 1
+
+// FormatHeader object represents how a snapshot was written, at the top of its JSON and of its manifest.
+type FormatHeader struct {
+	// FormatVersion is the version of the format the snapshot was written in. Snapshots written before the
+	// format was versioned have none, which is version 0.
+	FormatVersion int `json:"formatVersion"`
+
+	// Generator is the release of Antidote that cured the snapshot, e.g. "antidote v1.4.0", or "antidote (devel)"
+	// when built from a checkout.
+	Generator string `json:"generator,omitempty"`
+
+	// Options are the ingredients the snapshot was cured with that shape its content, by the name of their field
+	// in camel case, e.g. "selector" or "stripJS". The ingredients left unset are not listed.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// UnsupportedFormatError is returned when reading a snapshot written in a later version of the format than
+// this release of Antidote supports.
+type UnsupportedFormatError struct {
+	Version int
+}
+
+func (e *UnsupportedFormatError) Error() string {
+	return fmt.Sprintf("snapshot format version %d is newer than the supported version %d, upgrade antidote", e.Version, FormatVersion)
+}
+
+// migrations upgrade the JSON of a snapshot or of a manifest, by the version they upgrade from to the next one.
+var migrations = []func(doc map[string]json.RawMessage) error{
+	// Version 0 had no header, and the snapshots without any asset may have null for their list.
+	func(doc map[string]json.RawMessage) error {
+		if assets, ok := doc["assets"]; !ok || string(assets) == "null" {
+			doc["assets"] = json.RawMessage("[]")
+		}
+		return nil
+	},
+}
+
+// migrate upgrades the JSON of a snapshot or of a manifest to FormatVersion.
+func migrate(b []byte) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	version := 0
+	if raw, ok := doc["formatVersion"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, fmt.Errorf("invalid formatVersion: %v", err)
+		}
+	}
+
+	if version > FormatVersion {
+		return nil, &UnsupportedFormatError{Version: version}
+	}
+	if version == FormatVersion {
+		return b, nil
+	}
+
+	for ; version < FormatVersion; version++ {
+		if err := migrations[version](doc); err != nil {
+			return nil, fmt.Errorf("migrating from format version %d: %v", version, err)
+		}
+	}
+	doc["formatVersion"] = json.RawMessage(strconv.Itoa(FormatVersion))
+
+	return json.Marshal(doc)
+}
+
+// ReadSnapshot reads a snapshot written in JSON, migrating it from the version of the format it was written in,
+// see FormatVersion.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if b, err = migrate(b); err != nil {
+		return nil, err
+	}
+
+	s := new(Snapshot)
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// ReadManifest reads the manifest.json of a snapshot written by Snapshot.WriteDir(), migrating it from the
+// version of the format it was written in, see FormatVersion.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if b, err = migrate(b); err != nil {
+		return nil, err
+	}
+
+	m := new(Manifest)
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// generator returns the release of Antidote the program was built with, see FormatHeader.Generator.
+func generator() string {
+	version := "(devel)"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == modulePath {
+			version = info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				version = dep.Version
+			}
+		}
+	}
+
+	return "antidote " + version
+}
+
+// header returns the header of the snapshot of a cure, with the ingredients shaping its content.
+func (i *Ingredients) header() FormatHeader {
+	options := make(map[string]string)
+	set := func(name string, value string, unset string) {
+		if value != unset {
+			options[name] = value
+		}
+	}
+
+	set("selector", i.Selector, "")
+	set("profile", string(i.Profile), "")
+	set("stripJS", strconv.FormatBool(i.StripJS), "false")
+	set("stripEventHandlers", strconv.FormatBool(i.StripEventHandlers), "false")
+	set("unwrapNoscript", strconv.FormatBool(i.UnwrapNoscript), "false")
+	set("stripIframes", strconv.FormatBool(i.StripIframes), "false")
+	set("inlineStyles", strconv.FormatBool(i.InlineStyles), "false")
+	set("dropStyles", strconv.FormatBool(i.DropStyles), "false")
+	set("imageAttachments", strconv.FormatBool(i.ImageAttachments), "false")
+	set("criticalCSS", strconv.FormatBool(i.CriticalCSS), "false")
+	set("customProperties", strconv.FormatBool(i.CustomProperties), "false")
+	set("skipImages", strconv.FormatBool(i.SkipImages), "false")
+	set("quarantine", strconv.FormatBool(i.Quarantine), "false")
+	set("extractAssets", strconv.FormatBool(i.ExtractAssets), "false")
+	set("inlineLimit", strconv.FormatInt(i.InlineLimit, 10), "0")
+	set("upgradeInsecure", strconv.FormatBool(i.UpgradeInsecure), "false")
+	set("repairMixedContent", strconv.FormatBool(i.RepairMixedContent), "false")
+	set("structuredData", string(i.StructuredData), "")
+	set("integrity", string(i.Integrity), "")
+	set("conditionalComments", string(i.ConditionalComments), "")
+	set("media", i.Media.Type, "")
+	set("colorScheme", i.Media.ColorScheme, "")
+	set("layout", string(i.Output.Layout), "")
+	set("deadline", i.Deadline.String(), "0s")
+	set("maxTotalSize", strconv.FormatInt(i.MaxTotalSize, 10), "0")
+
+	header := FormatHeader{FormatVersion: FormatVersion, Generator: generator()}
+	if len(options) > 0 {
+		header.Options = options
+	}
+
+	return header
+}
@@ -0,0 +1,197 @@
+package antidote
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+)
+
+// pixelTolerance is the difference of a color channel, out of 255, under which pixels are considered equal, so
+// that antialiasing and compression noise are not reported as changes.
+const pixelTolerance = 16
+
+// ssimWindow is the size of the square windows the structural similarity is computed over.
+const ssimWindow = 8
+
+// VisualDiff object represents the differences between two screenshots of the same page, see
+// CompareScreenshots().
+type VisualDiff struct {
+	// Width and Height are the size compared: the largest of both screenshots. The pixels that only one of them
+	// has are changed.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	// ChangedPixels is the number of pixels whose color changed, and ChangedRatio their share of the pixels.
+	ChangedPixels int     `json:"changedPixels"`
+	ChangedRatio  float64 `json:"changedRatio"`
+
+	// SSIM is the mean structural similarity of the luminance of the screenshots, from 1 for identical images
+	// down to 0 (or below) for unrelated ones. It is far less sensitive than ChangedRatio to shifts of a few
+	// pixels and to noise.
+	SSIM float64 `json:"ssim"`
+
+	// Image is the later screenshot, faded, with the changed pixels in red.
+	Image *image.RGBA `json:"-"`
+}
+
+// Changed reports whether the screenshots differ more than a threshold of structural similarity, e.g. 0.99.
+func (d *VisualDiff) Changed(threshold float64) bool {
+	return d.SSIM < threshold
+}
+
+// CompareScreenshots compares two PNG screenshots, e.g. captured by Snapshot.CaptureScreenshot() from successive
+// cures of the same URL, so that purely visual changes, e.g. of stylesheets only, can be detected. WebP
+// screenshots can not be decoded by the standard library, capture them as PNG to compare them.
+func CompareScreenshots(before []byte, after []byte) (*VisualDiff, error) {
+	a, err := decodeScreenshot(before)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := decodeScreenshot(after)
+	if err != nil {
+		return nil, err
+	}
+
+	return CompareImages(a, b), nil
+}
+
+// CompareImages compares two images, see CompareScreenshots().
+func CompareImages(before image.Image, after image.Image) *VisualDiff {
+	// Both images are compared from their top left corner.
+	a := toRGBA(before)
+	b := toRGBA(after)
+
+	width, height := a.Rect.Dx(), a.Rect.Dy()
+	if b.Rect.Dx() > width {
+		width = b.Rect.Dx()
+	}
+	if b.Rect.Dy() > height {
+		height = b.Rect.Dy()
+	}
+
+	d := &VisualDiff{Width: width, Height: height, Image: image.NewRGBA(image.Rect(0, 0, width, height))}
+	if width == 0 || height == 0 {
+		d.SSIM = 1
+		return d
+	}
+
+	red := color.RGBA{R: 255, A: 255}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			ca, inA := pixel(a, x, y)
+			cb, inB := pixel(b, x, y)
+
+			if !inA || !inB || !similar(ca, cb) {
+				d.ChangedPixels++
+				d.Image.SetRGBA(x, y, red)
+				continue
+			}
+
+			// Unchanged pixels are faded, so that the changes stand out.
+			d.Image.SetRGBA(x, y, color.RGBA{
+				R: uint8(255 - (255-int(cb.R))/4),
+				G: uint8(255 - (255-int(cb.G))/4),
+				B: uint8(255 - (255-int(cb.B))/4),
+				A: 255,
+			})
+		}
+	}
+
+	d.ChangedRatio = float64(d.ChangedPixels) / float64(width*height)
+	d.SSIM = ssim(a, b, width, height)
+
+	return d
+}
+
+// ssim returns the mean structural similarity of the luminance of two images over windows of ssimWindow pixels,
+// see https://en.wikipedia.org/wiki/Structural_similarity. Missing pixels are white.
+func ssim(a *image.RGBA, b *image.RGBA, width int, height int) float64 {
+	const c1 = (0.01 * 255) * (0.01 * 255)
+	const c2 = (0.03 * 255) * (0.03 * 255)
+
+	total, windows := 0.0, 0
+	for wy := 0; wy < height; wy += ssimWindow {
+		for wx := 0; wx < width; wx += ssimWindow {
+			var sumA, sumB, sumAA, sumBB, sumAB, n float64
+
+			for y := wy; y < wy+ssimWindow && y < height; y++ {
+				for x := wx; x < wx+ssimWindow && x < width; x++ {
+					la, lb := luminance(a, x, y), luminance(b, x, y)
+					sumA += la
+					sumB += lb
+					sumAA += la * la
+					sumBB += lb * lb
+					sumAB += la * lb
+					n++
+				}
+			}
+
+			meanA, meanB := sumA/n, sumB/n
+			varianceA := sumAA/n - meanA*meanA
+			varianceB := sumBB/n - meanB*meanB
+			covariance := sumAB/n - meanA*meanB
+
+			total += (2*meanA*meanB + c1) * (2*covariance + c2) /
+				((meanA*meanA + meanB*meanB + c1) * (varianceA + varianceB + c2))
+			windows++
+		}
+	}
+
+	return math.Round(total/float64(windows)*1e6) / 1e6
+}
+
+// decodeScreenshot decodes a PNG screenshot.
+func decodeScreenshot(b []byte) (image.Image, error) {
+	if !bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")) {
+		return nil, errors.New("only PNG screenshots can be compared")
+	}
+
+	return png.Decode(bytes.NewReader(b))
+}
+
+// toRGBA returns an image as RGBA, with its bounds starting at the origin.
+func toRGBA(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	if rgba, ok := img.(*image.RGBA); ok && bounds.Min == (image.Point{}) {
+		return rgba
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, bounds.Min, draw.Src)
+
+	return rgba
+}
+
+// pixel returns the color of a pixel of an image, and whether the image has it.
+func pixel(img *image.RGBA, x int, y int) (color.RGBA, bool) {
+	if x >= img.Rect.Dx() || y >= img.Rect.Dy() {
+		return color.RGBA{}, false
+	}
+
+	return img.RGBAAt(x, y), true
+}
+
+// luminance returns the luma of a pixel of an image, from 0 to 255. Missing pixels are white.
+func luminance(img *image.RGBA, x int, y int) float64 {
+	c, ok := pixel(img, x, y)
+	if !ok {
+		return 255
+	}
+
+	return 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+}
+
+// similar reports whether two colors only differ within pixelTolerance.
+func similar(a color.RGBA, b color.RGBA) bool {
+	within := func(x, y uint8) bool {
+		d := int(x) - int(y)
+		return d <= pixelTolerance && d >= -pixelTolerance
+	}
+
+	return within(a.R, b.R) && within(a.G, b.G) && within(a.B, b.B) && within(a.A, b.A)
+}
@@ -0,0 +1,74 @@
+package antidote
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// WrapData object represents the data a template wrapping a cured page is executed with, see Snapshot.Wrap().
+// The fields of the snapshot are available as well, e.g. {{.URL}}, {{.StartedAt}} or {{len .Assets}}.
+type WrapData struct {
+	*Snapshot
+
+	// Title is the title of the page.
+	Title string
+
+	// Head is the content of the <head> of the cured page: its <meta> tags, stylesheets and scripts.
+	Head template.HTML
+
+	// Body is the content of the <body> of the cured page, e.g. the fragment kept by Ingredients.Selector.
+	Body template.HTML
+
+	// BodyAttributes are the attributes of the <body> of the cured page, e.g. its class, which the rules of
+	// its stylesheets may depend on: <body {{.BodyAttributes}}>.
+	BodyAttributes template.HTMLAttr
+}
+
+// Wrap replaces the cured page with the output of an html/template executed with WrapData, e.g. to add a
+// header, a footer or branding around a web clipping. The template writes the whole page, so it must insert
+// {{.Head}} in its <head> and {{.Body}} in its <body>. The placeholders of spilled assets are kept, see
+// Snapshot.WriteHTML().
+func (s *Snapshot) Wrap(t *template.Template) error {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(s.HTML))
+	if err != nil {
+		return err
+	}
+
+	head, err := doc.Find("head").Html()
+	if err != nil {
+		return err
+	}
+
+	body := doc.Find("body")
+	content, err := body.Html()
+	if err != nil {
+		return err
+	}
+
+	var attributes []string
+	if body.Length() > 0 {
+		for _, a := range body.Nodes[0].Attr {
+			attributes = append(attributes, fmt.Sprintf(`%s="%s"`, a.Key, html.EscapeString(a.Val)))
+		}
+	}
+
+	data := &WrapData{
+		Snapshot:       s,
+		Title:          strings.TrimSpace(doc.Find("title").First().Text()),
+		Head:           template.HTML(head),
+		Body:           template.HTML(content),
+		BodyAttributes: template.HTMLAttr(strings.Join(attributes, " ")),
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return err
+	}
+	s.HTML = b.String()
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package antidote
+
+import (
+	"regexp"
+	"strings"
+)
+
+// xmlDeclarationPattern matches the XML declaration at the start of a document, e.g.
+// `<?xml version="1.0" encoding="UTF-8"?>`, preceded by an optional byte order mark and whitespace.
+var xmlDeclarationPattern = regexp.MustCompile(`^(?:\x{FEFF})?\s*<\?xml\s[^>]*\?>`)
+
+// selfClosingPattern matches the self-closing start tags of XHTML, e.g. `<div class="clear"/>` (group 1 holds
+// the name of the element, group 2 its attributes).
+var selfClosingPattern = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9:-]*)((?:\s+[^\s/>"'=]+(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'>]+))?)*)\s*/>`)
+
+// xhtmlTypes are the MIME types XHTML documents are served with.
+var xhtmlTypes = map[string]bool{
+	"application/xhtml+xml": true,
+	"application/xml":       true,
+	"text/xml":              true,
+}
+
+// isXHTML reports whether the page is an XHTML document, either served with an XML type or starting with an
+// XML declaration.
+func (r *response) isXHTML() bool {
+	return xhtmlTypes[r.mediaType()] || xmlDeclarationPattern.MatchString(r.body)
+}
+
+// prepareXHTML converts an XHTML document so that the HTML parser reads it the same way as an XML parser. The
+// XML declaration, which the HTML parser would turn into a comment, is removed and returned to be written
+// back with the cured HTML. Self-closing tags of elements that are not void, which the HTML parser would
+// leave open, are closed.
+func prepareXHTML(document string) (declaration string, body string) {
+	if declaration = xmlDeclarationPattern.FindString(document); declaration != "" {
+		document = document[len(declaration):]
+		declaration = strings.TrimLeft(strings.TrimPrefix(declaration, "\uFEFF"), " \t\n\r\f")
+	}
+
+	body = selfClosingPattern.ReplaceAllStringFunc(document, func(tag string) string {
+		match := selfClosingPattern.FindStringSubmatch(tag)
+		if voidElements[strings.ToLower(match[1])] {
+			return tag
+		}
+
+		return "<" + match[1] + match[2] + "></" + match[1] + ">"
+	})
+
+	return declaration, body
+}
+
+// xhtmlRawText returns the text of a <script> or <style> element as well-formed XML. Text containing markup
+// characters is wrapped in a CDATA section, commented out to be ignored by HTML parsers, unless it is wrapped
+// in one already. The text of other elements whose text is not escaped in HTML, e.g. <noscript>, is markup
+// in XHTML, so it is returned as is.
+func xhtmlRawText(element string, text string) string {
+	if element != "script" && element != "style" || !strings.ContainsAny(text, "<&") || strings.Contains(text, "<![CDATA[") {
+		return text
+	}
+
+	// A CDATA section can not contain its own end, which is split across two sections.
+	text = strings.Replace(text, "]]>", "]]]]><![CDATA[>", -1)
+
+	if element == "script" {
+		return "//<![CDATA[\n" + text + "\n//]]>"
+	}
+
+	return "/*<![CDATA[*/\n" + text + "\n/*]]>*/"
+}